@@ -0,0 +1,125 @@
+package chanrpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+//批量调用中的一个调用
+type CallSpec struct {
+	ID   interface{}   //函数id
+	Args []interface{} //参数
+}
+
+//批量调用中一个调用的结果
+//Ret为返回值,无返回值的函数为nil,多个返回值的函数为[]interface{}
+type CallResult struct {
+	Ret interface{}
+	Err error
+}
+
+//批量调用因为前面的调用出错而停止时,未执行的调用返回的错误
+var ErrBatchStopped = errors.New("chanrpc batch stopped")
+
+//批量调用,作为CallInfo的f传给服务器
+type batch struct {
+	ids         []interface{} //id是别名时为原来的id
+	fs          []interface{}
+	calls       []CallSpec
+	results     []CallResult
+	stopOnError bool
+}
+
+//在服务器的goroutine中按顺序执行所有调用,每个调用都经过中间件和统计
+func (b *batch) exec(s *Server, trace uint64) []CallResult {
+	for i := range b.calls {
+		if b.results[i].Err == nil { //未注册或参数个数不符的不执行
+			b.results[i].Ret, b.results[i].Err = s.call(&CallInfo{
+				id:    b.ids[i],
+				f:     b.fs[i],
+				args:  b.calls[i].Args,
+				trace: trace,
+			})
+		}
+		if b.results[i].Err != nil && b.stopOnError {
+			for j := i + 1; j < len(b.results); j++ {
+				b.results[j].Err = ErrBatchStopped
+			}
+			break
+		}
+	}
+	return b.results
+}
+
+//取得批量调用的所有f,未注册或参数个数不符的调用在结果中记录错误
+func (c *Client) batch(calls []CallSpec, stopOnError bool) *batch {
+	b := &batch{
+		ids:         make([]interface{}, len(calls)),
+		fs:          make([]interface{}, len(calls)),
+		calls:       calls,
+		results:     make([]CallResult, len(calls)),
+		stopOnError: stopOnError,
+	}
+
+	for i := range calls {
+		b.ids[i], b.fs[i] = c.s.lookup(calls[i].ID) //根据id取得对应的f
+		if b.fs[i] == nil {                         //f未注册
+			b.results[i].Err = fmt.Errorf("function id %v: function not registered", calls[i].ID)
+		} else {
+			b.results[i].Err = c.s.checkArgs(b.ids[i], calls[i].Args) //参数个数不符的不执行
+		}
+	}
+	return b
+}
+
+//批量调用,所有调用通过一次ChanCall传给服务器,在服务器的goroutine中按顺序执行
+//每个调用有自己的错误,一个调用出错不影响后面的调用,除非stopOnError为true
+func (c *Client) CallBatch(calls []CallSpec, stopOnError bool) []CallResult {
+	b := c.batch(calls, stopOnError)
+	if err := c.beginWait("batch"); err != nil { //检查死锁
+		return batchFailed(len(calls), err)
+	}
+	defer c.endWait()
+
+	err := c.call(&CallInfo{ //发起调用
+		f:       b,
+		chanRet: c.chanSyncRet, //同步返回管道
+		trace:   c.traceID(),
+	}, true)
+	if err != nil {
+		return batchFailed(len(calls), err)
+	}
+
+	ri := <-c.chanSyncRet //读取结果
+	return ri.ret.([]CallResult)
+}
+
+//异步批量调用,需要自己写c.Cb(<-c.ChanAsynRet)执行回调,cb接收所有调用的结果
+func (c *Client) AsynCallBatch(calls []CallSpec, stopOnError bool, cb func([]CallResult)) *AsynHandle {
+	h := new(AsynHandle)
+	b := c.batch(calls, stopOnError)
+	err := c.call(&CallInfo{ //发起调用
+		f:       b,
+		chanRet: c.ChanAsynRet, //异步返回管道
+		cb:      cb,
+		handle:  h,
+		trace:   c.traceID(),
+	}, false)
+	if err != nil { //调用失败,执行回调
+		h.state = asynDone
+		cb(batchFailed(len(calls), err))
+		return h
+	}
+
+	c.pendingAsynCall++ //增加待处理的异步调用计数器
+	return h
+}
+
+//所有调用都返回err
+func batchFailed(n int, err error) []CallResult {
+	results := make([]CallResult, n)
+	for i := range results {
+		results[i].Err = err
+	}
+	return results
+}
@@ -1,10 +1,12 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/name5566/leaf/conf"
-	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // one server per goroutine (goroutine not safe)
@@ -12,23 +14,60 @@ import (
 
 //rpc服务器
 type Server struct {
-	functions map[interface{}]interface{} //id->func映射
-	ChanCall  chan *CallInfo              //用于传递调用信息的管道
+	functions  map[interface{}]interface{} //id->func映射
+	arity      map[interface{}]int         //id->参数个数,只记录用RegisterN注册的函数
+	aliases    map[interface{}]interface{} //别名->id
+	fmu        sync.RWMutex                //保护functions、arity和aliases
+	queues     map[interface{}]*queue      //id->独立的调用队列,只记录用RegisterWithOptions注册的函数
+	lanes      [3]chan *CallInfo           //低、普通、高优先级的有独立缓冲的函数的调用管道
+	limiters   map[interface{}]*limiter    //id->调用频率限制
+	queueLimit int                         //ChanCall中的调用达到这个数量时拒绝新的投递(Go),为0表示不限制
+	ChanCall   chan *CallInfo              //用于传递调用信息的管道
+	middleware []Middleware                //中间件,按注册顺序执行
+	metrics    *metrics                    //每个函数id的调用统计,为nil表示未启用
+	mu         sync.RWMutex                //保护closed
+	closed     bool                        //是否已关闭
+	closing    chan struct{}               //关闭时close,让阻塞在ChanCall上的发送者返回
+	senders    sync.WaitGroup              //正在向ChanCall发送的调用者
+	waiting    atomic.Pointer[waiting]     //服务器的goroutine正在等待的同步调用,用于检查死锁
+	trace      uint64                      //正在执行的调用的跟踪id
+	remote     *remote                     //远程服务器的传输层,为nil表示本地服务器
+	onDrop     DropHandler                 //Go丢弃调用时执行,为nil表示不处理
+	overload   *overload                   //ChanCall使用率超过阈值时的回调,为nil表示未设置
 }
 
+//服务器关闭后发起调用返回的错误,关闭时仍在ChanCall中的调用也返回这个错误
+var ErrServerClosed = errors.New("chanrpc server closed")
+
+//客户端关闭后发起调用返回的错误
+var ErrClientClosed = errors.New("chanrpc client closed")
+
+//非阻塞调用(异步调用)时管道已满返回的错误
+var ErrChannelFull = errors.New("chanrpc channel full")
+
 //调用信息
 type CallInfo struct {
-	f       interface{}   //函数
-	args    []interface{} //参数
-	chanRet chan *RetInfo //返回值管道,用于传输返回值,可能是同步返回值管道,也可能是异步返回值管道
-	cb      interface{}   //回调
+	id       interface{}   //函数id
+	f        interface{}   //函数
+	args     []interface{} //参数
+	chanRet  chan *RetInfo //返回值管道,用于传输返回值,可能是同步返回值管道,也可能是异步返回值管道
+	cb       interface{}   //回调
+	handle   *AsynHandle   //异步调用的句柄
+	queue    *queue        //独立的调用队列,为nil表示通过ChanCall传输
+	trace    uint64        //跟踪id
+	reliable bool          //可靠投递,不受SetQueueLimit限制
+	stream   *Stream       //流式调用的结果,为nil表示不是流式调用
+	ret      interface{}   //返回值,执行后由中间件读取
+	err      error         //错误,执行后由中间件读取
+	enqueued time.Time     //开始入队的时刻,用于统计排队时间
 }
 
 //返回信息
 type RetInfo struct {
-	ret interface{} //返回值
-	err error       //错误
-	cb  interface{} //回调
+	ret    interface{} //返回值
+	err    error       //错误
+	cb     interface{} //回调
+	handle *AsynHandle //异步调用的句柄
 }
 
 //rpc客户端
@@ -37,13 +76,22 @@ type Client struct {
 	chanSyncRet     chan *RetInfo //同步返回信息
 	ChanAsynRet     chan *RetInfo //异步返回信息
 	pendingAsynCall int           //待处理的异步调用计算器
+	closed          bool          //是否已关闭
+	owner           *Server       //使用客户端的goroutine所服务的服务器,用于检查死锁和延续跟踪id
+	trace           uint64        //发起的调用的跟踪id,为0表示自动选择
+	failFast        float64       //服务器ChanCall使用率超过这个值时调用立即返回ErrOverloaded,为0表示不检查
 }
 
 //创建rpc服务器
 func NewServer(l int) *Server {
 	s := new(Server)                                //创建服务器
 	s.functions = make(map[interface{}]interface{}) //创建id->func映射
-	s.ChanCall = make(chan *CallInfo, l)            //创建用于传递调用信息的管道
+	s.arity = make(map[interface{}]int)
+	s.aliases = make(map[interface{}]interface{})
+	s.queues = make(map[interface{}]*queue)
+	s.limiters = make(map[interface{}]*limiter)
+	s.ChanCall = make(chan *CallInfo, l) //创建用于传递调用信息的管道
+	s.closing = make(chan struct{})
 	return s
 }
 
@@ -53,73 +101,223 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	case func([]interface{}): //参数是切片,值任意,无返回值
 	case func([]interface{}) interface{}: //参数是切片,值任意,返回一个任意值
 	case func([]interface{}) []interface{}: //参数是切片,返回值也是切片,值均为任意
+	case func([]interface{}, Push) error: //流式函数,用Push发送多个结果
 	default:
 		panic(fmt.Sprintf("function id %v: definition of function is invalid", id)) //id对应的函数定义非法
 	}
 
-	if _, ok := s.functions[id]; ok { //判断映射是否存在
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	if s.registered(id) { //判断映射是否存在
 		panic(fmt.Sprintf("function id %v: already registered", id))
 	}
 
 	s.functions[id] = f //存储映射
 }
 
-//执行RPC调用
-func (s *Server) Exec(ci *CallInfo) (err error) {
+//注册id->func的映射,并指定参数个数,调用时参数个数不符返回错误而不会执行f
+func (s *Server) RegisterN(id interface{}, n int, f interface{}) {
+	s.Register(id, f)
+	s.fmu.Lock()
+	s.arity[id] = n
+	s.fmu.Unlock()
+}
+
+//根据id取得对应的f,id是别名时返回原来的id,goroutine safe
+func (s *Server) lookup(id interface{}) (interface{}, interface{}) {
+	s.fmu.RLock()
+	defer s.fmu.RUnlock()
+	if to, ok := s.aliases[id]; ok {
+		id = to
+	}
+	return id, s.functions[id]
+}
+
+//检查参数个数
+func (s *Server) checkArgs(id interface{}, args []interface{}) error {
+	s.fmu.RLock()
+	n, ok := s.arity[id]
+	s.fmu.RUnlock()
+	if ok && len(args) != n {
+		return fmt.Errorf("function id %v: expected %v arguments, got %v", id, n, len(args))
+	}
+	return nil
+}
+
+//执行RPC调用,先执行优先级更高的调用
+func (s *Server) Exec(ci *CallInfo) error {
+	if ci.queue != nil {
+		<-ci.queue.slots //让出缓冲中的位置
+	}
+	s.execAbove(ci)
+	s.checkOverload()
+
+	prev := s.trace
+	s.trace = ci.trace //执行期间发起的调用延续跟踪id
+	defer func() {
+		s.trace = prev
+	}()
+
+	if b, ok := ci.f.(*batch); ok { //批量调用
+		return s.ret(ci, &RetInfo{ret: b.exec(s, ci.trace)})
+	}
+
+	ret, err := s.call(ci) //执行调用
+	if err != nil {
+		s.ret(ci, &RetInfo{err: err}) //返回一个错误
+		return err
+	}
+
+	return s.ret(ci, &RetInfo{ret: ret})
+}
+
+//经过中间件执行调用,启用统计时记录耗时和结果
+func (s *Server) call(ci *CallInfo) (ret interface{}, err error) {
+	var start time.Time
+	if s.metrics != nil {
+		start = time.Now()
+	}
+
+	ci.f = s.current(ci)
+	if ci.f == nil { //入队后被注销
+		err = fmt.Errorf("function id %v: function not registered", ci.id)
+	} else if len(s.middleware) == 0 {
+		ret, err = exec(ci.id, ci.f, ci.args) //执行调用
+	} else {
+		ret, err = s.intercept(ci) //经过中间件执行调用
+	}
+
+	if s.metrics != nil {
+		s.metrics.record(ci.id, time.Since(start), err)
+	}
+	return
+}
+
+//执行f,f中的异常转化为错误
+func exec(id interface{}, f interface{}, args []interface{}) (ret interface{}, err error) {
 	//延迟处理异常
 	defer func() {
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				err = fmt.Errorf("%v: %s", r, buf[:l])
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-			s.ret(ci, &RetInfo{err: err}) //返回一个错误
+			err = panicError(id, r)
 		}
 	}()
 
-	switch ci.f.(type) { //判断f类型
+	switch f.(type) { //判断f类型
 	case func([]interface{}): //无返回值
-		ci.f.(func([]interface{}))(ci.args) //执行调用
-		return s.ret(ci, &RetInfo{})        //返回值为空
+		f.(func([]interface{}))(args) //执行调用
+		return nil, nil               //返回值为空
 	case func([]interface{}) interface{}: //一个返回值
-		ret := ci.f.(func([]interface{}) interface{})(ci.args) //执行调用
-		return s.ret(ci, &RetInfo{ret: ret})                   //一个返回值
+		return f.(func([]interface{}) interface{})(args), nil
 	case func([]interface{}) []interface{}: //n个返回值
-		ret := ci.f.(func([]interface{}) []interface{})(ci.args) //执行调用
-		return s.ret(ci, &RetInfo{ret: ret})                     //多个返回值
+		return f.(func([]interface{}) []interface{})(args), nil
+	case streamCall: //流式函数,结果已经用Push发送
+		return nil, f.(streamCall)(args)
 	}
 
 	panic("bug")
 }
 
-//rpc服务器调用自己
-func (s *Server) Go(id interface{}, args ...interface{}) {
-	f := s.functions[id] //根据id取得对应的f
+//rpc服务器调用自己,服务器已关闭时返回ErrServerClosed
+//调用有新的跟踪id,适合作为外部请求的入口,比如网关转发消息
+//被频率限制、过载保护拒绝或服务器已关闭时调用被丢弃,执行SetDropHandler设置的函数
+func (s *Server) Go(id interface{}, args ...interface{}) error {
+	id, f := s.lookup(id) //根据id取得对应的f
+	if s.remote != nil {  //远程服务器,由远程节点检查函数是否注册
+		f = remoteCast
+	}
 	if f == nil {
-		return
+		return nil
+	}
+	if err := s.checkArgs(id, args); err != nil {
+		return err
 	}
 
-	defer func() { //ChanCall有可能被关闭,导致传输调用消息时出现异常
-		recover() //只是为了让程序不中断,不做多余的错误处理
-	}()
+	err := s.send(context.Background(), &CallInfo{ //将调用消息通过管道传输到rpc服务器
+		id:    id,
+		f:     f,
+		args:  args,
+		trace: NewTraceID(),
+	}, true)
+	if err != nil {
+		s.drop(id, args, err)
+	}
+	return err
+}
+
+//将调用消息通过管道传输到rpc服务器,与Close互斥,关闭后总是返回ErrServerClosed
+//block为false时管道满返回错误,否则阻塞到入队、服务器关闭或ctx结束
+func (s *Server) send(ctx context.Context, ci *CallInfo, block bool) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrServerClosed
+	}
+	s.senders.Add(1) //Close等待所有发送者返回后才关闭ChanCall
+	s.mu.RUnlock()
+	defer s.senders.Done()
+
+	if err := s.admit(ci); err != nil { //频率限制和过载保护
+		return err
+	}
+	ci.enqueued = time.Now()
+
+	if q := s.queues[ci.id]; q != nil { //有独立的调用队列
+		return s.sendQueued(ctx, q, ci, block)
+	}
+
+	if !block { //非阻塞
+		select {
+		case s.ChanCall <- ci:
+			return nil
+		case <-s.closing:
+			return ErrServerClosed
+		default: //当管道满时,返回管道已满错误
+			return ErrChannelFull
+		}
+	}
 
-	s.ChanCall <- &CallInfo{ //将调用消息通过管道传输到rpc服务器
-		f:    f,
-		args: args,
+	select {
+	case s.ChanCall <- ci: //当管道满时阻塞
+		return nil
+	case <-s.closing:
+		return ErrServerClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-//关闭rpc服务器
+//关闭rpc服务器,之后发起的调用都返回ErrServerClosed,已经入队的调用返回ErrServerClosed
 func (s *Server) Close() {
-	close(s.ChanCall) //关闭用于传递调用信息的管道
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.closing) //让阻塞的发送者返回
+	s.mu.Unlock()
+
+	s.senders.Wait()        //等待所有发送者返回,之后不会再有调用入队
+	close(s.ChanCall)       //关闭用于传递调用信息的管道
+	s.closeChan(s.ChanCall) //遍历所有未处理完的消息,返回错误消息
+	for _, lane := range s.lanes {
+		if lane != nil {
+			close(lane)
+			s.closeChan(lane)
+		}
+	}
+}
 
-	for ci := range s.ChanCall { //遍历所有未处理完的消息,返回错误消息
-		s.ret(ci, &RetInfo{
-			err: errors.New("chanrpc server closed"),
-		})
+//遍历管道中所有未处理完的消息,返回错误消息
+func (s *Server) closeChan(c chan *CallInfo) {
+	for ci := range c {
+		ri := &RetInfo{
+			err: ErrServerClosed,
+		}
+		if b, ok := ci.f.(*batch); ok { //批量调用的每个调用都返回错误
+			ri.ret = batchFailed(len(b.calls), ri.err)
+		}
+		s.ret(ci, ri)
 	}
 }
 
@@ -134,6 +332,10 @@ func (s *Server) Open(l int) *Client {
 
 //返回
 func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
+	if ci.stream != nil { //流式调用发送最后一项
+		ci.stream.finish(ri.err)
+		return
+	}
 	if ci.chanRet == nil { //调用信息的返回值管道不存在
 		return
 	}
@@ -144,16 +346,22 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 		}
 	}()
 
-	ri.cb = ci.cb    //将调用信息中的回调函数保存到返回信息中(只有异步调用才有回调函数)
+	ri.cb = ci.cb //将调用信息中的回调函数保存到返回信息中(只有异步调用才有回调函数)
+	ri.handle = ci.handle
 	ci.chanRet <- ri //将返回信息发送到返回值管道中
 	return
 }
 
-//获取f
-func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
-	f = c.s.functions[id] //根据id取得对应的f
-	if f == nil {         //f未注册
-		err = fmt.Errorf("function id %v: function not registered", id)
+//获取f,并检查参数个数
+//id是别名时返回原来的id
+func (c *Client) f(_id interface{}, n int, args []interface{}) (id interface{}, f interface{}, err error) {
+	if c.s.remote != nil { //远程服务器,由远程节点检查函数是否注册和参数个数
+		return _id, remoteFunc(n), nil
+	}
+
+	id, f = c.s.lookup(_id) //根据id取得对应的f
+	if f == nil {           //f未注册
+		err = fmt.Errorf("function id %v: function not registered", _id)
 		return
 	}
 
@@ -170,113 +378,136 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	}
 
 	if !ok { //类型不匹配
-		err = fmt.Errorf("function id %v: return type mismatch", id)
+		err = fmt.Errorf("function id %v: return type mismatch", _id)
+	} else {
+		err = c.s.checkArgs(id, args)
 	}
 
 	return
 }
 
 //发起调用
-func (c *Client) call(ci *CallInfo, block bool) (err error) {
-	defer func() { //延迟捕获异常
-		if r := recover(); r != nil {
-			err = r.(error)
-		}
-	}()
+func (c *Client) call(ci *CallInfo, block bool) error {
+	return c.callCtx(context.Background(), ci, block)
+}
 
-	if block { //阻塞
-		c.s.ChanCall <- ci //将调用消息通过管道传输到rpc服务器,当管道满时阻塞
-	} else { //非阻塞
-		select {
-		case c.s.ChanCall <- ci: //将调用消息通过管道传输到rpc服务器
-		default: //当管道满时,返回管道已满错误
-			err = errors.New("chanrpc channel full")
+//发起调用,ctx结束时放弃入队
+func (c *Client) callCtx(ctx context.Context, ci *CallInfo, block bool) error {
+	if c.closed {
+		return ErrClientClosed
+	}
+	if c.failFast > 0 && ci.queue == nil && c.s.utilization() > c.failFast {
+		if c.s.metrics != nil {
+			c.s.metrics.reject(ci.id)
 		}
+		return ErrOverloaded
 	}
 
-	return
+	return c.s.send(ctx, ci, block)
 }
 
-//call0 call1 calln 可以将0 1 n记作0个返回值,1个返回值,n个返回值
-
-//调用0
-//适合参数是切片,值任意,无返回值
-func (c *Client) Call0(id interface{}, args ...interface{}) error {
-	f, err := c.f(id, 0) //获取f
+//发起同步调用并等待返回,ctx结束时放弃等待
+//入队和等待返回两个阶段都受ctx控制
+func (c *Client) callSync(ctx context.Context, id interface{}, n int, args []interface{}) (*RetInfo, error) {
+	id, f, err := c.f(id, n, args) //获取f
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = c.call(&CallInfo{ //发起调用
+	ci := &CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet, //同步返回管道
-	}, true)
+		trace:   c.traceID(),
+	}
 
+	if err := c.beginWait(id); err != nil { //检查死锁
+		return nil, err
+	}
+	defer c.endWait()
+
+	err = c.callCtx(ctx, ci, true) //发起调用
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("function id %v: %w", id, err)
 	}
 
-	ri := <-c.chanSyncRet //读取结果
-	return ri.err         //返回错误字段,代表是否有错
+	select {
+	case ri := <-c.chanSyncRet: //读取结果
+		return ri, nil
+	case <-ctx.Done():
+		//调用已经入队,服务器可能稍后返回,换一个新的同步返回管道,迟到的返回值落入旧管道被丢弃
+		c.chanSyncRet = make(chan *RetInfo, 1)
+		return nil, fmt.Errorf("function id %v: %w", id, ctx.Err())
+	}
+}
+
+//call0 call1 calln 可以将0 1 n记作0个返回值,1个返回值,n个返回值
+
+//调用0
+//适合参数是切片,值任意,无返回值
+func (c *Client) Call0(id interface{}, args ...interface{}) error {
+	return c.Call0Ctx(context.Background(), id, args...)
 }
 
 //调用1
 //适合参数是切片,值任意,返回值为一个任意值
 func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
-	f, err := c.f(id, 1) //获取f
-	if err != nil {
-		return nil, err
-	}
+	return c.Call1Ctx(context.Background(), id, args...)
+}
 
-	err = c.call(&CallInfo{ //发起调用
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet, //同步返回管道
-	}, true)
+//调用N
+//适合参数是切片,返回值也是切片,值均为任意
+func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
+	return c.CallNCtx(context.Background(), id, args...)
+}
 
+//带ctx的调用0,ctx结束时返回包装了ctx.Err()的错误
+func (c *Client) Call0Ctx(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.callSync(ctx, id, 0, args)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	ri := <-c.chanSyncRet //读取结果
-	return ri.ret, ri.err //返回返回值字段和错误字段
+	return ri.err //返回错误字段,代表是否有错
 }
 
-//调用N
-//适合参数是切片,返回值也是切片,值均为任意
-func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
-	f, err := c.f(id, 2) //获取f
+//带ctx的调用1,ctx结束时返回包装了ctx.Err()的错误
+func (c *Client) Call1Ctx(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.callSync(ctx, id, 1, args)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.call(&CallInfo{ //发起调用
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet, //同步返回管道
-	}, true)
+	return ri.ret, ri.err //返回返回值字段和错误字段
+}
 
+//带ctx的调用N,ctx结束时返回包装了ctx.Err()的错误
+func (c *Client) CallNCtx(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.callSync(ctx, id, 2, args)
 	if err != nil {
 		return nil, err
 	}
 
-	ri := <-c.chanSyncRet                 //读取结果
-	return ri.ret.([]interface{}), ri.err //返回返回值字段(先转化类型)和错误字段
+	ret, _ := ri.ret.([]interface{}) //出错时返回值为空
+	return ret, ri.err               //返回返回值字段(先转化类型)和错误字段
 }
 
 //发起异步调用(内部的)
-func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int) error {
-	f, err := c.f(id, n) //获得f
+func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int, h *AsynHandle) error {
+	id, f, err := c.f(id, n, args) //获得f
 	if err != nil {
 		return err
 	}
 
 	err = c.call(&CallInfo{ //发起调用
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.ChanAsynRet, //异步返回管道
 		cb:      cb,
+		handle:  h,
+		trace:   c.traceID(),
 	}, false)
 
 	if err != nil {
@@ -288,8 +519,8 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 }
 
 //发起异步调用(导出的)
-//需要自己写c.Cb(<-c.ChanAsynRet)执行回调
-func (c *Client) AsynCall(id interface{}, _args ...interface{}) { //_args最后一个是回调函数,前面的是rpc调用的参数
+//需要自己写c.Cb(<-c.ChanAsynRet)执行回调,返回的句柄可以取消执行回调
+func (c *Client) AsynCall(id interface{}, _args ...interface{}) *AsynHandle { //_args最后一个是回调函数,前面的是rpc调用的参数
 	if len(_args) < 1 { //检查是否提供了回调函数参数
 		panic("callback function not found")
 	}
@@ -299,30 +530,64 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) { //_args最后
 		args = _args[:len(_args)-1] //取出rpc调用的参数
 	}
 
-	cb := _args[len(_args)-1] //取出回调函数
-	switch cb.(type) {        //判断回调函数的类型
+	return c.AsynCallFunc(id, _args[len(_args)-1], args...) //取出回调函数
+}
+
+//发起异步调用,回调函数在参数之前,已有参数切片时可以直接用args...传入,不需要把回调追加到切片中
+//需要自己写c.Cb(<-c.ChanAsynRet)执行回调
+func (c *Client) AsynCallFunc(id interface{}, cb interface{}, args ...interface{}) *AsynHandle {
+	h := new(AsynHandle)
+	switch cb.(type) { //判断回调函数的类型
 	case func(error): //只接收一个错误
-		err := c.asynCall(id, args, cb, 0) //发起异步调用(内部)
-		if err != nil {                    //调用失败,执行回调
+		err := c.asynCall(id, args, cb, 0, h) //发起异步调用(内部)
+		if err != nil {                       //调用失败,执行回调
+			h.state = asynDone
 			cb.(func(error))(err)
 		}
 	case func(interface{}, error): //接收一个返回值和一个错误
-		err := c.asynCall(id, args, cb, 1) //发起异步调用(内部)
-		if err != nil {                    //调用失败,执行回调
+		err := c.asynCall(id, args, cb, 1, h) //发起异步调用(内部)
+		if err != nil {                       //调用失败,执行回调
+			h.state = asynDone
 			cb.(func(interface{}, error))(nil, err)
 		}
 	case func([]interface{}, error): //接收多个返回值和一个错误
-		err := c.asynCall(id, args, cb, 2) //发起异步调用(内部)
-		if err != nil {                    //调用失败,执行回调
+		err := c.asynCall(id, args, cb, 2, h) //发起异步调用(内部)
+		if err != nil {                       //调用失败,执行回调
+			h.state = asynDone
 			cb.(func([]interface{}, error))(nil, err)
 		}
 	default:
 		panic("definition of callback function is invalid")
 	}
+	return h
 }
 
-//执行回调
+//异步调用的状态
+const (
+	asynPending  int32 = iota //等待返回
+	asynDone                  //回调已执行或正在执行
+	asynCanceled              //已取消
+)
+
+//异步调用的句柄
+type AsynHandle struct {
+	state int32
+}
+
+//取消异步调用,回调还未执行时不再执行,返回是否取消成功
+//服务器仍会执行函数,返回值照常由Cb读取,pendingAsynCall照常减少
+//goroutine safe,与Cb竞争时回调要么执行一次,要么不执行
+func (h *AsynHandle) Cancel() bool {
+	return atomic.CompareAndSwapInt32(&h.state, asynPending, asynCanceled)
+}
+
+//执行回调,已取消的异步调用不执行回调
 func (c *Client) Cb(ri *RetInfo) {
+	if ri.handle != nil && !atomic.CompareAndSwapInt32(&ri.handle.state, asynPending, asynDone) {
+		c.pendingAsynCall-- //已取消
+		return
+	}
+
 	switch ri.cb.(type) { //判断回调类型
 	case func(error): //无返回值,只接收一个错误
 		ri.cb.(func(error))(ri.err) //执行回调
@@ -330,6 +595,8 @@ func (c *Client) Cb(ri *RetInfo) {
 		ri.cb.(func(interface{}, error))(ri.ret, ri.err) //执行回调
 	case func([]interface{}, error): //多个返回值,一个错误
 		ri.cb.(func([]interface{}, error))(ri.ret.([]interface{}), ri.err) //执行回调
+	case func([]CallResult): //批量调用
+		ri.cb.(func([]CallResult))(ri.ret.([]CallResult))
 	default:
 		panic("bug")
 	}
@@ -337,8 +604,10 @@ func (c *Client) Cb(ri *RetInfo) {
 	c.pendingAsynCall-- //减少计数器
 }
 
-//关闭rpc客户端
+//关闭rpc客户端,之后发起的调用都返回ErrClientClosed
+//等待所有未处理的异步调用返回并执行回调,服务器关闭时未执行的调用返回ErrServerClosed
 func (c *Client) Close() {
+	c.closed = true
 	for c.pendingAsynCall > 0 { //还存在未处理的异步调用,等待异步调用处理完毕,取出异步返回值,执行回调
 		c.Cb(<-c.ChanAsynRet)
 	}
@@ -0,0 +1,1481 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/timer"
+	"math/bits"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCallCtxNeverAnswered(t *testing.T) {
+	s := NewServer(1)
+	s.Register("f", func(args []interface{}) interface{} {
+		return 1
+	})
+	c := s.Open(0)
+
+	// nobody runs Exec, the first call waits for its return
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call1Ctx(ctx, "f"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for the return: got %v", err)
+	}
+
+	// ChanCall is full now, the second waits to enqueue
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Call1Ctx(ctx, "f"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting to enqueue: got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("enqueue did not honor the deadline")
+	}
+}
+
+func TestCallCtxLateReply(t *testing.T) {
+	s := NewServer(10)
+	release := make(chan struct{})
+	s.Register("slow", func(args []interface{}) interface{} {
+		<-release
+		return "late"
+	})
+	s.Register("fast", func(args []interface{}) interface{} {
+		return "fast"
+	})
+	done := make(chan struct{})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+		close(done)
+	}()
+	c := s.Open(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := c.Call1Ctx(ctx, "slow"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+
+	// the server answers after the cancellation, the next call must not
+	// see that reply
+	close(release)
+	ret, err := c.Call1("fast")
+	if err != nil || ret != "fast" {
+		t.Errorf("got %v, %v", ret, err)
+	}
+
+	s.Close()
+	<-done
+}
+
+func serve(s *Server) {
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+}
+
+func TestTyped(t *testing.T) {
+	s := NewServer(10)
+	var got string
+	Register1(s, "set", func(name string) {
+		got = name
+	})
+	Register1R(s, "double", func(n int) int {
+		return 2 * n
+	})
+	Register1R(s, "error", func(err error) error {
+		return err
+	})
+	s.Register("untyped", func(args []interface{}) interface{} {
+		return "two"
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	if err := Call1Void(c, "set", "leaf"); err != nil || got != "leaf" {
+		t.Errorf("Call1Void: %v, %v", got, err)
+	}
+	if n, err := Call1[int, int](c, "double", 21); err != nil || n != 42 {
+		t.Errorf("Call1: %v, %v", n, err)
+	}
+	if err, cerr := Call1[error, error](c, "error", nil); err != nil || cerr != nil {
+		t.Errorf("Call1 of a nil interface: %v, %v", err, cerr)
+	}
+
+	// mismatches are errors, not panics
+	_, err := Call1[string, int](c, "double", "21")
+	if err == nil || err.Error() != "function id double: argument type mismatch: expected int, got string" {
+		t.Errorf("argument mismatch: %v", err)
+	}
+	if _, err := c.Call1("double"); err == nil {
+		t.Error("missing argument succeeded")
+	}
+	_, err = Call1[int, int](c, "untyped", 2)
+	if err == nil || err.Error() != "function id untyped: return value type mismatch: expected int, got string" {
+		t.Errorf("return mismatch: %v", err)
+	}
+}
+
+func TestRegister2(t *testing.T) {
+	s := NewServer(10)
+	type login struct{ name string }
+	var got string
+	Register2(s, reflect.TypeOf(&login{}), func(m *login, agent string) {
+		got = m.name + "@" + agent
+	})
+	if !s.Registered(reflect.TypeOf(&login{})) || s.Registered("login") {
+		t.Error("Registered")
+	}
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	if err := c.Call0(reflect.TypeOf(&login{}), &login{"leaf"}, "a1"); err != nil || got != "leaf@a1" {
+		t.Errorf("Call0: %v, %v", got, err)
+	}
+	err := c.Call0(reflect.TypeOf(&login{}), &login{"leaf"}, 1)
+	if err == nil || !strings.Contains(err.Error(), "argument type mismatch: expected string, got int") {
+		t.Errorf("argument mismatch: %v", err)
+	}
+}
+
+func BenchmarkCall1(b *testing.B) {
+	s := NewServer(10)
+	s.Register("double", func(args []interface{}) interface{} {
+		return 2 * args[0].(int)
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ret, _ := c.Call1("double", 1000+i)
+		_ = ret.(int)
+	}
+}
+
+func BenchmarkCall1Typed(b *testing.B) {
+	s := NewServer(10)
+	Register1R(s, "double", func(n int) int {
+		return 2 * n
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Call1[int, int](c, "double", 1000+i)
+	}
+}
+
+func TestCallBatch(t *testing.T) {
+	s := NewServer(10)
+	var order []interface{}
+	s.Register("add", func(args []interface{}) interface{} {
+		order = append(order, "add")
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("swap", func(args []interface{}) []interface{} {
+		order = append(order, "swap")
+		return []interface{}{args[1], args[0]}
+	})
+	s.Register("fail", func(args []interface{}) {
+		order = append(order, "fail")
+		panic("failed")
+	})
+	s.Register("nop", func(args []interface{}) {
+		order = append(order, "nop")
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(1)
+
+	calls := []CallSpec{
+		{ID: "add", Args: []interface{}{1, 2}},
+		{ID: "fail"},
+		{ID: "missing"},
+		{ID: "swap", Args: []interface{}{1, 2}},
+		{ID: "nop"},
+	}
+	results := c.CallBatch(calls, false)
+	if len(results) != 5 || results[0].Ret != 3 || results[1].Err == nil || results[2].Err == nil ||
+		results[3].Err != nil || results[3].Ret.([]interface{})[0] != 2 || results[4].Err != nil {
+		t.Fatalf("unexpected %v", results)
+	}
+	if fmt.Sprint(order) != "[add fail swap nop]" {
+		t.Errorf("ran %v", order)
+	}
+
+	order = nil
+	results = c.CallBatch(calls, true)
+	if results[0].Ret != 3 || results[1].Err == nil || results[1].Err == ErrBatchStopped ||
+		results[3].Err != ErrBatchStopped || results[4].Err != ErrBatchStopped {
+		t.Errorf("unexpected %v", results)
+	}
+	if fmt.Sprint(order) != "[add fail]" {
+		t.Errorf("ran %v", order)
+	}
+
+	// so does an unregistered id
+	order = nil
+	results = c.CallBatch(calls[2:], true)
+	if results[0].Err == nil || results[1].Err != ErrBatchStopped || order != nil {
+		t.Errorf("unexpected %v, ran %v", results, order)
+	}
+
+	var asyn []CallResult
+	c.AsynCallBatch(calls[3:], false, func(results []CallResult) {
+		asyn = results
+	})
+	c.Cb(<-c.ChanAsynRet)
+	if len(asyn) != 2 || asyn[0].Err != nil || asyn[1].Err != nil {
+		t.Errorf("unexpected %v", asyn)
+	}
+}
+
+func TestCallBatchClosed(t *testing.T) {
+	s := NewServer(10)
+	s.Register("nop", func(args []interface{}) {})
+	c := s.Open(1)
+
+	var results []CallResult
+	c.AsynCallBatch([]CallSpec{{ID: "nop"}, {ID: "nop"}}, false, func(r []CallResult) {
+		results = r
+	})
+	s.Close()
+	c.Close()
+	if len(results) != 2 || results[0].Err == nil || results[1].Err == nil {
+		t.Errorf("unexpected %v", results)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	s := NewServer(10)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("admin", func(args []interface{}) {})
+	s.Register("fail", func(args []interface{}) {
+		panic("failed")
+	})
+
+	var trace []string
+	s.Use(Recover)
+	s.Use(LogCalls)
+	s.Use(func(ci *CallInfo, next func()) {
+		trace = append(trace, fmt.Sprint("first ", ci.ID(), ci.Args()))
+		next()
+		trace = append(trace, fmt.Sprint("first done ", ci.Ret(), ci.Err() != nil))
+	})
+	s.Use(func(ci *CallInfo, next func()) {
+		switch ci.ID() {
+		case "admin": // short-circuit
+			ci.SetErr(errors.New("denied"))
+		case "add": // annotate
+			next()
+			ci.SetRet(ci.Ret().(int) * 10)
+		case "boom":
+			panic("middleware failed")
+		default:
+			next()
+		}
+	})
+	s.Register("boom", func(args []interface{}) {})
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	if ret, err := c.Call1("add", 1, 2); err != nil || ret != 30 {
+		t.Errorf("add: %v, %v", ret, err)
+	}
+	if err := c.Call0("admin"); err == nil || err.Error() != "denied" {
+		t.Errorf("admin: %v", err)
+	}
+	if err := c.Call0("fail"); err == nil {
+		t.Error("fail succeeded")
+	}
+	want := "[first add[1 2] first done 30 false first admin[] first done <nil> true first fail[] first done <nil> true]"
+	if fmt.Sprint(trace) != want {
+		t.Errorf("trace %v", trace)
+	}
+
+	// Recover turns a panic in a middleware into the call error
+	if err := c.Call0("boom"); err == nil {
+		t.Error("boom succeeded")
+	}
+
+	// batched calls go through the middleware one by one
+	results := c.CallBatch([]CallSpec{{ID: "add", Args: []interface{}{2, 3}}, {ID: "admin"}}, false)
+	if results[0].Ret != 50 || results[1].Err == nil {
+		t.Errorf("unexpected %v", results)
+	}
+}
+
+func TestMiddlewareNoRecover(t *testing.T) {
+	s := NewServer(10)
+	s.Register("f", func(args []interface{}) {})
+	s.Use(func(ci *CallInfo, next func()) {
+		panic("middleware failed")
+	})
+	c := s.Open(0)
+	serve(s)
+	defer s.Close()
+
+	// still an error of the call rather than a crash of the server
+	if err := c.Call0("f"); err == nil {
+		t.Error("f succeeded")
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	s := NewServer(10)
+	s.Register("convert", func(args []interface{}) interface{} {
+		return args[0].(string)
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(1)
+
+	_, err := c.Call1("convert", 1)
+	var he *HandlerError
+	if !errors.As(err, &he) {
+		t.Fatalf("got %T %v", err, err)
+	}
+	if he.Route != "convert" || err.Error() != fmt.Sprint(he.Recovered) ||
+		!strings.Contains(err.Error(), "interface conversion") {
+		t.Errorf("unexpected %#v", he)
+	}
+	// the stack is the one of the panic, not of the recover
+	if !strings.Contains(string(he.Stack), "TestHandlerError.func1") {
+		t.Errorf("stack %s", he.Stack)
+	}
+	if s := ErrorWithStack(err); !strings.HasPrefix(s, err.Error()+": ") || !strings.Contains(s, "TestHandlerError.func1") {
+		t.Errorf("ErrorWithStack %s", s)
+	}
+
+	c.AsynCall("convert", 1, func(ret interface{}, err error) {
+		if _, ok := err.(*HandlerError); !ok {
+			t.Errorf("callback got %T", err)
+		}
+	})
+	c.Cb(<-c.ChanAsynRet)
+}
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(10)
+	s.Register("f", func(args []interface{}) {
+		if args[0] != nil {
+			panic(args[0])
+		}
+	})
+	s.Register(2, func(args []interface{}) interface{} {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if s.Metrics() != nil {
+		t.Error("metrics before EnableMetrics")
+	}
+	s.EnableMetrics()
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	for i := 0; i < 10; i++ {
+		var arg interface{}
+		if i%5 == 0 {
+			arg = "failed"
+		}
+		c.Call0("f", arg)
+	}
+	c.Call1(2)
+	c.CallBatch([]CallSpec{{ID: "f", Args: []interface{}{nil}}}, false)
+
+	m := s.Metrics()
+	f, two := m["f"], m["2"]
+	if len(m) != 2 || f.Calls != 11 || f.Errors != 2 || two.Calls != 1 || two.Errors != 0 {
+		t.Fatalf("unexpected %v", m)
+	}
+	if two.Max < time.Millisecond || two.P50 != two.Max || two.P99 != two.Max || two.Total != two.Max {
+		t.Errorf("unexpected %+v", two)
+	}
+	if f.P50 > f.P90 || f.P90 > f.P99 || f.P99 > f.Max {
+		t.Errorf("unexpected %+v", f)
+	}
+	if table := FormatMetrics(m); strings.Count(table, "\r\n") != 2 || !strings.Contains(table, "\r\n2 ") {
+		t.Errorf("table %q", table)
+	}
+}
+
+func TestRoutePercentile(t *testing.T) {
+	r := new(route)
+	for _, d := range []time.Duration{1, 3, 100, 1000} {
+		r.calls++
+		r.max = d
+		r.buckets[bits.Len64(uint64(d))]++
+	}
+	if p := r.percentile(0.5); p != 3 {
+		t.Errorf("p50 %v", p)
+	}
+	if p := r.percentile(0.75); p != 127 {
+		t.Errorf("p75 %v", p)
+	}
+	if p := r.percentile(0.99); p != 1000 {
+		t.Errorf("p99 %v", p)
+	}
+}
+
+func TestCloseStress(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		s := NewServer(4)
+		s.Register("f", func(args []interface{}) interface{} {
+			return 1
+		})
+		done := make(chan struct{})
+		go func() {
+			for ci := range s.ChanCall {
+				s.Exec(ci)
+			}
+			close(done)
+		}()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 1000)
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c := s.Open(20) // room for every asynchronous return
+				for i := 0; i < 20; i++ {
+					if _, err := c.Call1("f"); err != nil {
+						errs <- err
+					}
+					c.AsynCall("f", func(ret interface{}, err error) {
+						if err != nil {
+							errs <- err
+						}
+					})
+					if err := s.Go("f"); err != nil {
+						errs <- err
+					}
+				}
+				c.Close()
+			}()
+		}
+
+		time.Sleep(time.Duration(round) * 50 * time.Microsecond)
+		s.Close()
+		finished := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("callers hang after Close")
+		}
+		<-done
+		close(errs)
+		for err := range errs {
+			if !errors.Is(err, ErrServerClosed) && err.Error() != "chanrpc channel full" {
+				t.Fatalf("unexpected %v", err)
+			}
+		}
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	s := NewServer(10)
+	s.Register("f", func(args []interface{}) {})
+	c := s.Open(10)
+	c.AsynCall("f", func(err error) {
+		if err != ErrServerClosed {
+			t.Errorf("pending call got %v", err)
+		}
+	})
+	s.Close()
+	c.Close()
+
+	if err := c.Call0("f"); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Call0 after Close: %v", err)
+	}
+	var called bool
+	c.AsynCall("f", func(err error) {
+		called = err == ErrClientClosed
+	})
+	if !called || c.pendingAsynCall != 0 {
+		t.Errorf("AsynCall after Close: called %v, pending %v", called, c.pendingAsynCall)
+	}
+	if err := s.Go("f"); err != ErrServerClosed {
+		t.Errorf("Go after Close: %v", err)
+	}
+}
+
+func TestRegisterN(t *testing.T) {
+	s := NewServer(10)
+	var runs int
+	s.RegisterN("add", 2, func(args []interface{}) interface{} {
+		runs++
+		return args[0].(int) + args[1].(int)
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(1)
+
+	_, err := c.Call1("add", 1)
+	if err == nil || err.Error() != "function id add: expected 2 arguments, got 1" {
+		t.Errorf("Call1: %v", err)
+	}
+	c.AsynCall("add", 1, 2, 3, func(ret interface{}, err error) {
+		if err == nil {
+			t.Error("AsynCall with 3 arguments succeeded")
+		}
+	})
+	if err := s.Go("add"); err == nil {
+		t.Error("Go without arguments succeeded")
+	}
+	results := c.CallBatch([]CallSpec{{ID: "add"}}, false)
+	if results[0].Err == nil {
+		t.Error("CallBatch without arguments succeeded")
+	}
+
+	args := []interface{}{1, 2}
+	c.AsynCallFunc("add", func(ret interface{}, err error) {
+		if err != nil || ret != 3 {
+			t.Errorf("AsynCallFunc: %v, %v", ret, err)
+		}
+	}, args...)
+	c.Cb(<-c.ChanAsynRet)
+	if runs != 1 {
+		t.Errorf("ran %v times", runs)
+	}
+}
+
+func TestAsynCallCancel(t *testing.T) {
+	s := NewServer(10)
+	var runs int32
+	s.Register("f", func(args []interface{}) interface{} {
+		atomic.AddInt32(&runs, 1)
+		return 1
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(10)
+
+	var called int
+	h := c.AsynCall("f", func(ret interface{}, err error) {
+		called++
+	})
+	if !h.Cancel() || h.Cancel() {
+		t.Error("Cancel of a pending call")
+	}
+	c.Cb(<-c.ChanAsynRet)
+	if called != 0 || c.pendingAsynCall != 0 || atomic.LoadInt32(&runs) != 1 {
+		t.Errorf("called %v, pending %v, runs %v", called, c.pendingAsynCall, runs)
+	}
+
+	h = c.AsynCall("f", func(ret interface{}, err error) {
+		called++
+	})
+	c.Cb(<-c.ChanAsynRet)
+	if h.Cancel() || called != 1 {
+		t.Errorf("Cancel after the callback: called %v", called)
+	}
+
+	// Close drains canceled calls without running them
+	c.AsynCall("f", func(ret interface{}, err error) {
+		called++
+	}).Cancel()
+	c.Close()
+	if called != 1 || c.pendingAsynCall != 0 {
+		t.Errorf("called %v, pending %v", called, c.pendingAsynCall)
+	}
+}
+
+func TestAsynCallCancelRace(t *testing.T) {
+	s := NewServer(100)
+	s.Register("f", func(args []interface{}) {})
+	serve(s)
+	defer s.Close()
+	c := s.Open(100)
+
+	var called int32
+	handles := make([]*AsynHandle, 100)
+	for i := range handles {
+		handles[i] = c.AsynCall("f", func(err error) {
+			atomic.AddInt32(&called, 1)
+		})
+	}
+	canceled := make(chan int32)
+	go func() {
+		var n int32
+		for _, h := range handles {
+			if h.Cancel() {
+				n++
+			}
+		}
+		canceled <- n
+	}()
+	c.Close()
+	if n := <-canceled; n+atomic.LoadInt32(&called) != 100 {
+		t.Errorf("canceled %v, called %v", n, called)
+	}
+}
+
+func TestRegisterWithOptions(t *testing.T) {
+	s := NewServer(1000)
+	var order []string
+	s.Register("move", func(args []interface{}) {
+		order = append(order, "move")
+	})
+	s.RegisterWithOptions("save", func(args []interface{}) {
+		order = append(order, "save")
+	}, Options{Buffer: 1, Priority: PriorityHigh})
+	s.RegisterWithOptions("chat", func(args []interface{}) {
+		order = append(order, "chat")
+	}, Options{Buffer: 2, Priority: PriorityLow})
+	if s.Lane(PriorityNormal) != nil || s.Lane(PriorityHigh) == nil {
+		t.Fatal("unexpected lanes")
+	}
+	c := s.Open(10)
+
+	for i := 0; i < 1000; i++ {
+		s.Go("move")
+	}
+	s.Go("chat")
+	s.Go("save")
+
+	// the call has its own buffer, full while the shared ChanCall is too
+	var full error
+	c.AsynCall("save", func(err error) {
+		full = err
+	})
+	if full == nil || full.Error() != "chanrpc channel full" {
+		t.Errorf("got %v", full)
+	}
+
+	// consume like the skeleton
+	for len(order) < 1002 {
+		select {
+		case ci := <-s.Lane(PriorityHigh):
+			s.Exec(ci)
+		case ci := <-s.ChanCall:
+			s.Exec(ci)
+		case ci := <-s.Lane(PriorityLow):
+			s.Exec(ci)
+		}
+	}
+	// whatever is received first, Exec runs the higher priority calls before
+	if order[0] != "save" {
+		t.Errorf("save ran after %v", order[0])
+	}
+
+	// the buffer is free again
+	if err := s.Go("save"); err != nil {
+		t.Error(err)
+	}
+	s.Close()
+}
+
+func TestSelfCall(t *testing.T) {
+	s := NewServer(10)
+	c := s.Open(0)
+	c.SetOwner(s)
+	var err error
+	s.Register("f", func(args []interface{}) {
+		err = c.Call0("g")
+	})
+	s.Register("g", func(args []interface{}) {})
+	s.Go("f")
+	s.Exec(<-s.ChanCall)
+	if err == nil || !strings.Contains(err.Error(), "deadlock") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCallCycle(t *testing.T) {
+	a, b := NewServer(10), NewServer(10)
+	ab, ba := b.Open(0), a.Open(0)
+	ab.SetOwner(a)
+	ba.SetOwner(b)
+	a.Register("a", func(args []interface{}) interface{} {
+		ret, err := ab.Call1("b")
+		if err != nil {
+			return err.Error()
+		}
+		return ret
+	})
+	a.Register("a2", func(args []interface{}) interface{} {
+		return "a2"
+	})
+	b.Register("b", func(args []interface{}) interface{} {
+		_, err := ba.Call1("a2")
+		if err != nil {
+			return err.Error()
+		}
+		return "no cycle"
+	})
+	serve(a)
+	serve(b)
+	defer a.Close()
+	defer b.Close()
+
+	ret := make(chan interface{})
+	go func() {
+		r, _ := a.Open(0).Call1("a")
+		ret <- r
+	}()
+	select {
+	case r := <-ret:
+		if r != "function id a2: synchronous call cycle would deadlock: a2 -> b" {
+			t.Errorf("got %v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock")
+	}
+
+	// without a cycle the calls go through
+	if r, err := b.Open(0).Call1("b"); err != nil || r != "no cycle" {
+		t.Errorf("got %v, %v", r, err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	a, b := NewServer(10), NewServer(10)
+	ab := b.Open(10)
+	ab.SetOwner(a)
+	traces := make(chan uint64, 4)
+	a.Register("a", func(args []interface{}) {
+		traces <- a.TraceID()
+		ab.Call0("b")
+		ab.AsynCall("b", func(err error) {})
+	})
+	b.Register("b", func(args []interface{}) {
+		traces <- b.TraceID()
+	})
+	serve(a)
+	serve(b)
+	defer a.Close()
+	defer b.Close()
+
+	// Go starts a trace, the calls made by its handler carry it to b
+	a.Go("a")
+	first, second, third := <-traces, <-traces, <-traces
+	if first == 0 || second != first || third != first {
+		t.Errorf("traces %x %x %x", first, second, third)
+	}
+	a.Go("a")
+	if next := <-traces; next == first {
+		t.Error("Go reused the trace")
+	}
+	<-traces
+	<-traces
+
+	// explicit
+	c := b.Open(0)
+	c.SetTrace(42)
+	c.Call0("b")
+	if trace := <-traces; trace != 42 {
+		t.Errorf("trace %v", trace)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	a, b, c := NewServer(10), NewServer(1), NewServer(10)
+	a.Register("event", func(args []interface{}) interface{} {
+		return "a"
+	})
+	b.Register("event", func(args []interface{}) interface{} {
+		return "b"
+	})
+	g := NewGroup(a, b, c)
+	g.Add(a)
+	if len(g.Servers()) != 3 {
+		t.Fatalf("servers %v", g.Servers())
+	}
+
+	// c does not have the route, b fills up
+	if errs := g.Go("event"); len(errs) != 0 {
+		t.Errorf("errs %v", errs)
+	}
+	errs := g.Go("event")
+	if len(errs) != 1 || errs[b] == nil {
+		t.Errorf("errs %v", errs)
+	}
+	if len(a.ChanCall) != 2 || len(b.ChanCall) != 1 || len(c.ChanCall) != 0 {
+		t.Errorf("queued %v %v %v", len(a.ChanCall), len(b.ChanCall), len(c.ChanCall))
+	}
+	for len(a.ChanCall) > 0 {
+		a.Exec(<-a.ChanCall)
+	}
+	b.Exec(<-b.ChanCall)
+
+	// b never answers
+	serve(a)
+	defer a.Close()
+	results := g.Call(20*time.Millisecond, "event")
+	if len(results) != 2 || results[0].Server != a || results[0].Ret != "a" || results[0].Err != nil ||
+		results[1].Server != b || !errors.Is(results[1].Err, context.DeadlineExceeded) {
+		t.Errorf("results %v", results)
+	}
+
+	g.Remove(b)
+	g.Remove(c)
+	if servers := g.Servers(); len(servers) != 1 || servers[0] != a {
+		t.Errorf("servers %v", servers)
+	}
+}
+
+func TestGroupConcurrent(t *testing.T) {
+	g := NewGroup()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := NewServer(1)
+				s.Register("event", func(args []interface{}) {})
+				g.Add(s)
+				g.Go("event")
+				g.Remove(s)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(g.Servers()) != 0 {
+		t.Errorf("servers %v", g.Servers())
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var (
+		n int64
+		u uint8
+		f float64
+		s string
+		e error
+		v interface{}
+	)
+	if err := Decode([]interface{}{1, 200, 3, "s", nil, 1.5}, &n, &u, &f, &s, &e, &v); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || u != 200 || f != 3 || s != "s" || e != nil || v != 1.5 {
+		t.Errorf("decoded %v %v %v %v %v %v", n, u, f, s, e, v)
+	}
+
+	for _, c := range []struct {
+		ret   []interface{}
+		dests []interface{}
+		err   string
+	}{
+		{[]interface{}{1}, []interface{}{&n, &s}, "chanrpc decode: got 1 results, want 2"},
+		{[]interface{}{1, 2}, []interface{}{&n, &s}, "chanrpc decode: result 1: cannot assign int(2) to string"},
+		{[]interface{}{256}, []interface{}{&u}, "chanrpc decode: result 0: cannot assign int(256) to uint8"},
+		{[]interface{}{-1}, []interface{}{&u}, "chanrpc decode: result 0: cannot assign int(-1) to uint8"},
+		{[]interface{}{1.5}, []interface{}{&n}, "chanrpc decode: result 0: cannot assign float64(1.5) to int64"},
+		{[]interface{}{nil}, []interface{}{&n}, "chanrpc decode: result 0: cannot assign nil to int64"},
+		{[]interface{}{1}, []interface{}{n}, "chanrpc decode: destination 0: expected a non-nil pointer, got int64"},
+	} {
+		if err := Decode(c.ret, c.dests...); err == nil || err.Error() != c.err {
+			t.Errorf("Decode(%v): %v", c.ret, err)
+		}
+	}
+}
+
+func TestCallN2(t *testing.T) {
+	s := NewServer(10)
+	s.Register("pos", func(args []interface{}) []interface{} {
+		return []interface{}{1, 2, "map"}
+	})
+	serve(s)
+	defer s.Close()
+	c := s.Open(0)
+
+	x, y, m, err := CallN3[int32, float64, string](c, "pos")
+	if err != nil || x != 1 || y != 2 || m != "map" {
+		t.Errorf("CallN3: %v %v %v %v", x, y, m, err)
+	}
+	if _, _, err := CallN2[int, int](c, "pos"); err == nil {
+		t.Error("CallN2 of 3 results succeeded")
+	}
+	if _, err := CallN1[int](c, "pos"); err == nil {
+		t.Error("CallN1 of 3 results succeeded")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := NewServer(100)
+	s.RegisterWithOptions("use", func(args []interface{}) {}, Options{Rate: 50, Burst: 3})
+	s.Register("move", func(args []interface{}) {})
+	s.EnableMetrics()
+	c := s.Open(10)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Go("use"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Go("use"); err != ErrRateLimited {
+		t.Errorf("Go: %v", err)
+	}
+	var asyn error
+	c.AsynCall("use", func(err error) {
+		asyn = err
+	})
+	if asyn != ErrRateLimited {
+		t.Errorf("AsynCall: %v", asyn)
+	}
+	if err := s.Go("move"); err != nil {
+		t.Errorf("other route: %v", err)
+	}
+
+	// the bucket refills
+	time.Sleep(40 * time.Millisecond)
+	if err := s.Go("use"); err != nil {
+		t.Errorf("after refill: %v", err)
+	}
+	if m := s.Metrics(); m["use"].Rejected != 2 || m["move"].Rejected != 0 {
+		t.Errorf("metrics %v", m)
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	s := NewServer(100)
+	s.Register("move", func(args []interface{}) {})
+	s.SetQueueLimit(2)
+	s.EnableMetrics()
+	c := s.Open(10)
+
+	s.Go("move")
+	s.Go("move")
+	if err := s.Go("move"); err != ErrOverloaded {
+		t.Errorf("Go: %v", err)
+	}
+	// calls waiting for a return are still accepted
+	var asyn error = errors.New("not called")
+	c.AsynCall("move", func(err error) {
+		asyn = err
+	})
+	for len(s.ChanCall) > 0 {
+		s.Exec(<-s.ChanCall)
+	}
+	c.Cb(<-c.ChanAsynRet)
+	if asyn != nil {
+		t.Errorf("AsynCall: %v", asyn)
+	}
+	if m := s.Metrics(); m["move"].Rejected != 1 || m["move"].Calls != 3 {
+		t.Errorf("metrics %v", m)
+	}
+}
+
+// loopback delivers requests to a local server, as the remote node would.
+type loopback struct {
+	s    *Server
+	drop bool
+}
+
+func (l *loopback) Send(req *Request, done func(interface{}, error)) {
+	if req.N < 0 {
+		l.s.Go(req.ID, req.Args...)
+		return
+	}
+	if l.drop {
+		return
+	}
+	go func() {
+		c := l.s.Open(0)
+		var ret interface{}
+		var err error
+		switch req.N {
+		case 0:
+			err = c.Call0(req.ID, req.Args...)
+		case 1:
+			ret, err = c.Call1(req.ID, req.Args...)
+		default:
+			ret, err = c.CallN(req.ID, req.Args...)
+		}
+		if err != nil { // only the text crosses the wire
+			err = errors.New(err.Error())
+		}
+		done(ret, err)
+	}()
+}
+
+func TestRemoteServer(t *testing.T) {
+	s := NewServer(10)
+	defer s.Close()
+	got := make(chan interface{}, 1)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("pair", func(args []interface{}) []interface{} {
+		return []interface{}{1, "a"}
+	})
+	s.Register("notify", func(args []interface{}) {
+		got <- args[0]
+	})
+	go serve(s)
+
+	l := &loopback{s: s}
+	r := NewRemoteServer(l, 10, 100*time.Millisecond)
+	defer r.Close()
+	c := r.Open(10)
+
+	if ret, err := c.Call1("add", 1, 2); err != nil || ret != 3 {
+		t.Errorf("Call1: %v %v", ret, err)
+	}
+	if ret, err := c.CallN("pair"); err != nil || len(ret) != 2 || ret[1] != "a" {
+		t.Errorf("CallN: %v %v", ret, err)
+	}
+	if err := c.Call0("missing"); err == nil || err.Error() != "function id missing: function not registered" {
+		t.Errorf("Call0: %v", err)
+	}
+	if _, err := c.Call1("pair"); err == nil || err.Error() != "function id pair: return type mismatch" {
+		t.Errorf("Call1: %v", err)
+	}
+
+	var asyn interface{}
+	c.AsynCall("add", 3, 4, func(ret interface{}, err error) {
+		asyn = ret
+	})
+	c.Cb(<-c.ChanAsynRet)
+	if asyn != 7 {
+		t.Errorf("AsynCall: %v", asyn)
+	}
+
+	r.Go("notify", "hi")
+	if v := <-got; v != "hi" {
+		t.Errorf("Go: %v", v)
+	}
+
+	l.drop = true
+	if _, err := c.Call1("add", 1, 2); !errors.Is(err, ErrTimeout) {
+		t.Errorf("timeout: %v", err)
+	}
+	var rets []interface{}
+	c.AsynCall("pair", func(ret []interface{}, err error) {
+		rets = ret
+	})
+	c.Cb(<-c.ChanAsynRet)
+	if rets != nil {
+		t.Errorf("AsynCall after timeout: %v", rets)
+	}
+}
+
+func TestGoReliable(t *testing.T) {
+	s := NewServer(1)
+	s.Register("read", func(args []interface{}) {})
+	s.SetQueueLimit(1)
+	s.EnableMetrics()
+	var dropped []interface{}
+	s.SetDropHandler(func(id interface{}, args []interface{}, err error) {
+		if err != ErrOverloaded {
+			t.Errorf("drop: %v", err)
+		}
+		dropped = append(dropped, id, args[0])
+	})
+
+	s.Go("read", 1)
+	s.Go("read", 2)
+	if len(dropped) != 2 || dropped[1] != 2 {
+		t.Errorf("dropped %v", dropped)
+	}
+	if err := s.GoReliable(10*time.Millisecond, "read", 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GoReliable: %v", err)
+	}
+	if err := s.GoReliable(0, "unread"); err == nil || err.Error() != "function id unread: function not registered" {
+		t.Errorf("GoReliable: %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Exec(<-s.ChanCall)
+	}()
+	if err := s.GoReliable(time.Second, "read", 4); err != nil {
+		t.Errorf("GoReliable: %v", err)
+	}
+	if m := s.Metrics(); m["read"].Dropped != 1 {
+		t.Errorf("metrics %v", m)
+	}
+	s.Close()
+}
+
+func TestOnOverload(t *testing.T) {
+	s := NewServer(4)
+	s.Register("f", func(args []interface{}) {})
+	var fired []int
+	s.OnOverload(0.5, func(depth, cap int) {
+		fired = append(fired, depth, cap)
+	})
+	c := s.Open(10)
+	c.SetFailFast(0.75)
+
+	for i := 0; i < 4; i++ {
+		s.Go("f")
+	}
+	if s.QueueLen() != 4 || s.QueueCap() != 4 {
+		t.Errorf("queue %v/%v", s.QueueLen(), s.QueueCap())
+	}
+	if err := c.Call0("f"); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Call0: %v", err)
+	}
+
+	for s.QueueLen() > 0 {
+		s.Exec(<-s.ChanCall)
+	}
+	if len(fired) != 2 || fired[0] != 3 || fired[1] != 4 {
+		t.Errorf("fired %v", fired)
+	}
+
+	// fires again after dropping below the threshold
+	for i := 0; i < 4; i++ {
+		s.Go("f")
+	}
+	for s.QueueLen() > 0 {
+		s.Exec(<-s.ChanCall)
+	}
+	if len(fired) != 4 {
+		t.Errorf("fired %v", fired)
+	}
+	s.Close()
+}
+
+func TestAsynCallRetry(t *testing.T) {
+	s := NewServer(1)
+	s.Register("f", func(args []interface{}) interface{} {
+		return args[0]
+	})
+	d := timer.NewDispatcher(10)
+	defer d.Close(false)
+	c := s.Open(10)
+	opts := RetryOptions{MaxAttempts: 3, InitialDelay: time.Millisecond, Multiplier: 2}
+
+	s.Go("f", 0) // ChanCall is full
+	var ret interface{}
+	var err error
+	c.AsynCallRetry(d, opts, "f", func(r interface{}, e error) {
+		ret, err = r, e
+	}, 1)
+	(<-d.ChanTimer).Cb() // second attempt, still full
+	s.Exec(<-s.ChanCall)
+	(<-d.ChanTimer).Cb() // third attempt
+	s.Exec(<-s.ChanCall)
+	c.Cb(<-c.ChanAsynRet)
+	if ret != 1 || err != nil {
+		t.Errorf("AsynCallRetry: %v %v", ret, err)
+	}
+
+	// gives up after MaxAttempts with the last error
+	s.Go("f", 0)
+	opts.MaxAttempts = 2
+	c.AsynCallRetry(d, opts, "f", func(_ interface{}, e error) {
+		err = e
+	}, 2)
+	(<-d.ChanTimer).Cb()
+	if err != ErrChannelFull {
+		t.Errorf("AsynCallRetry: %v", err)
+	}
+
+	// canceled while waiting for a retry
+	err = nil
+	called := false
+	h := c.AsynCallRetry(d, opts, "f", func(interface{}, error) {
+		called = true
+	}, 3)
+	h.Cancel()
+	(<-d.ChanTimer).Cb()
+	if called || len(s.ChanCall) != 1 || c.pendingAsynCall != 0 {
+		t.Errorf("canceled retry: %v %v", called, len(s.ChanCall))
+	}
+
+	// errors that are not transient are not retried
+	c.AsynCallRetry(d, opts, "g", func(e error) {
+		err = e
+	})
+	if err == nil || d.PendingCount() != 0 {
+		t.Errorf("AsynCallRetry: %v %v", err, d.PendingCount())
+	}
+	s.Close()
+}
+
+func TestReplace(t *testing.T) {
+	s := NewServer(10)
+	s.Register("v", func(args []interface{}) interface{} { return 1 })
+	s.Alias("version", "v")
+	c := s.Open(10)
+
+	// queued calls run the handler installed when they are dequeued
+	var rets []interface{}
+	for i := 0; i < 2; i++ {
+		c.AsynCall("version", func(ret interface{}, err error) {
+			rets = append(rets, ret)
+		})
+	}
+	s.Replace("v", func(args []interface{}) interface{} { return 2 })
+	s.Exec(<-s.ChanCall)
+	s.Unregister("v")
+	s.Exec(<-s.ChanCall)
+	c.Cb(<-c.ChanAsynRet)
+	c.Cb(<-c.ChanAsynRet)
+	if len(rets) != 2 || rets[0] != 2 || rets[1] != nil {
+		t.Errorf("rets %v", rets)
+	}
+	if _, err := c.Call1("version"); err == nil || err.Error() != "function id version: function not registered" {
+		t.Errorf("Call1: %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Replace with a different type")
+			}
+		}()
+		s.Register("v", func(args []interface{}) {})
+		s.Replace("v", func(args []interface{}) interface{} { return nil })
+	}()
+	s.Close()
+}
+
+func TestResetFunctions(t *testing.T) {
+	s := NewServer(10)
+	s.Register("v", func(args []interface{}) interface{} { return 1 })
+	s.Alias("version", "v")
+	c := s.Open(10)
+
+	restore := s.ResetFunctions()
+	if _, err := c.Call1("version"); err == nil || err.Error() != "function id version: function not registered" {
+		t.Errorf("Call1 after reset: %v", err)
+	}
+	s.Register("v", func(args []interface{}) interface{} { return 2 })
+	serve(s)
+	if r, err := c.Call1("v"); err != nil || r != 2 {
+		t.Errorf("Call1 new: %v %v", r, err)
+	}
+
+	restore()
+	if r, err := c.Call1("version"); err != nil || r != 1 {
+		t.Errorf("Call1 restored: %v %v", r, err)
+	}
+	s.Close()
+}
+
+func TestEnqueued(t *testing.T) {
+	s := NewServer(10)
+	s.Register("f", func(args []interface{}) {})
+	before := time.Now()
+	if err := s.Go("f"); err != nil {
+		t.Fatal(err)
+	}
+	ci := <-s.ChanCall
+	if ci.Enqueued().Before(before) || ci.Enqueued().After(time.Now()) {
+		t.Errorf("Enqueued %v, sent after %v", ci.Enqueued(), before)
+	}
+	s.Exec(ci)
+	s.Close()
+}
+
+func TestReplaceUnderLoad(t *testing.T) {
+	s := NewServer(100)
+	s.Register("v", func(args []interface{}) interface{} { return 0 })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n := 0
+		for ci := range s.ChanCall {
+			n++
+			if n%10 == 0 {
+				v := n
+				s.Replace("v", func(args []interface{}) interface{} { return v })
+			}
+			if n == 500 {
+				s.Alias(n, "v")
+			}
+			s.Exec(ci)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := s.Open(0)
+			last := -1
+			for j := 0; j < 200; j++ {
+				ret, err := c.Call1("v")
+				if err != nil || ret.(int) < last {
+					t.Errorf("Call1: %v %v", ret, err)
+					return
+				}
+				last = ret.(int)
+			}
+		}()
+	}
+	wg.Wait()
+	s.Close()
+	<-done
+}
+
+func TestStream(t *testing.T) {
+	s := NewServer(10)
+	var pushErr error
+	s.Register("mails", func(args []interface{}, push Push) error {
+		for i := 0; i < args[0].(int); i++ {
+			if pushErr = push(i); pushErr != nil {
+				return pushErr
+			}
+		}
+		return nil
+	})
+	s.Register("mail", func(args []interface{}) interface{} { return nil })
+	c := s.Open(0)
+
+	st, err := c.Stream("mails", 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Exec(<-s.ChanCall)
+	var items []interface{}
+	for item := range st.C {
+		if item.Done {
+			if item.Err != nil {
+				t.Error(item.Err)
+			}
+			break
+		}
+		items = append(items, item.Value)
+	}
+	if len(items) != 3 || items[2] != 2 {
+		t.Errorf("items %v", items)
+	}
+
+	// backpressure: push fails instead of buffering
+	st, _ = c.Stream("mails", 2, 5)
+	s.Exec(<-s.ChanCall)
+	if pushErr != ErrChannelFull || len(st.C) != 3 {
+		t.Errorf("push: %v %v", pushErr, len(st.C))
+	}
+
+	st, _ = c.Stream("mails", 2, 5)
+	st.Cancel()
+	s.Exec(<-s.ChanCall)
+	if item := <-st.C; !item.Done || item.Err != ErrStreamCanceled {
+		t.Errorf("canceled: %+v", item)
+	}
+
+	if _, err := c.Stream("mail", 1); err == nil || err.Error() != "function id mail: return type mismatch" {
+		t.Errorf("Stream: %v", err)
+	}
+	if err := c.Call0("mails", 1); err == nil {
+		t.Error("Call0 on a stream")
+	}
+
+	st, _ = c.Stream("mails", 2, 1)
+	s.Close()
+	if item := <-st.C; !item.Done || item.Err != ErrServerClosed {
+		t.Errorf("closed: %+v", item)
+	}
+}
+
+type mailService struct {
+	_     struct{} `chanrpc:"Mail, Count=mail.count"`
+	count int
+}
+
+func (m *mailService) Send(args []interface{}) { m.count++ }
+
+func (m *mailService) Count(args []interface{}) interface{} { return m.count }
+
+func (m *mailService) Read(id int) string { return fmt.Sprint("mail ", id) }
+
+func (m *mailService) Delete(id int) {}
+
+func (m *mailService) Close() {}
+
+func TestRegisterService(t *testing.T) {
+	s := NewServer(10)
+	defer s.Close()
+	serve(s)
+	if err := s.RegisterService(new(mailService), DenyMethods("Delete")); err != nil {
+		t.Fatal(err)
+	}
+	c := s.Open(0)
+
+	c.Call0("Mail.Send")
+	if n, err := c.Call1("mail.count"); err != nil || n != 1 {
+		t.Errorf("Count: %v %v", n, err)
+	}
+	if r, err := c.Call1("Mail.Read", 3); err != nil || r != "mail 3" {
+		t.Errorf("Read: %v %v", r, err)
+	}
+	if _, err := c.Call1("Mail.Read", "3"); err == nil || !strings.Contains(err.Error(), "argument type mismatch") {
+		t.Errorf("Read: %v", err)
+	}
+	for _, id := range []string{"Mail.Delete", "Mail.Close", "Mail.Count"} {
+		if err := c.Call0(id); err == nil || !strings.HasSuffix(err.Error(), "function not registered") {
+			t.Errorf("%v: %v", id, err)
+		}
+	}
+
+	if err := s.RegisterService(new(mailService)); err == nil || !strings.HasSuffix(err.Error(), "already registered") {
+		t.Errorf("collision: %v", err)
+	}
+	if err := s.RegisterService(new(mailService), WithPrefix("guild."), AllowMethods("Read")); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := c.Call1("guild.Mail.Read", 1); err != nil || r != "mail 1" {
+		t.Errorf("Read: %v %v", r, err)
+	}
+	if err := s.RegisterService(new(mailService), WithPrefix("x."), AllowMethods("Close")); err == nil {
+		t.Error("allowed method with an invalid definition")
+	}
+}
+
+func TestSharedClient(t *testing.T) {
+	s := NewServer(10)
+	s.Register("echo", func(args []interface{}) interface{} {
+		return args[0]
+	})
+	serve(s)
+	defer s.Close()
+	sc := s.OpenShared()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				v := i*100 + j
+				if ret, err := sc.Call1("echo", v); err != nil || ret != v {
+					t.Errorf("Call1 %v: %v %v", v, ret, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sc.Close()
+	if _, err := sc.Call1("echo", 1); err != ErrClientClosed {
+		t.Errorf("Call1: %v", err)
+	}
+}
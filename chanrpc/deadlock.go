@@ -0,0 +1,60 @@
+package chanrpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+//同步调用的最长等待链,超过时不再检查
+const maxWaitChain = 64
+
+//服务器的goroutine正在等待的同步调用
+type waiting struct {
+	target *Server     //被调用的服务器
+	id     interface{} //函数id
+}
+
+//设置客户端所属的服务器,即使用客户端的goroutine所服务的服务器
+//设置后同步调用会等待所属服务器自己,或者等待一个(间接)等待所属服务器的服务器时,立即返回错误而不是死锁
+func (c *Client) SetOwner(owner *Server) {
+	c.owner = owner
+}
+
+//开始等待同步调用,调用会形成等待环时返回错误
+func (c *Client) beginWait(id interface{}) error {
+	if c.owner == nil {
+		return nil
+	}
+	if c.owner == c.s { //调用自己
+		return fmt.Errorf("function id %v: synchronous call to the server of the caller would deadlock", id)
+	}
+
+	//先记录再检查,两个goroutine同时形成环时至少有一个能发现
+	w := &waiting{target: c.s, id: id}
+	c.owner.waiting.Store(w)
+	chain := []interface{}{id}
+	for s := c.s; len(chain) < maxWaitChain; {
+		next := s.waiting.Load()
+		if next == nil {
+			return nil
+		}
+		chain = append(chain, next.id)
+		if next.target == c.owner {
+			c.owner.waiting.Store(nil)
+			ids := make([]string, len(chain))
+			for i := range chain {
+				ids[i] = fmt.Sprint(chain[i])
+			}
+			return fmt.Errorf("function id %v: synchronous call cycle would deadlock: %v", id, strings.Join(ids, " -> "))
+		}
+		s = next.target
+	}
+	return nil
+}
+
+//结束等待同步调用
+func (c *Client) endWait() {
+	if c.owner != nil {
+		c.owner.waiting.Store(nil)
+	}
+}
@@ -0,0 +1,59 @@
+package chanrpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//Go丢弃调用时执行的函数,参数为函数id、调用参数和丢弃的原因
+type DropHandler func(id interface{}, args []interface{}, err error)
+
+//设置Go丢弃调用时执行的函数,f在调用Go的goroutine中执行,必须在调用Open()和Go()之前调用
+func (s *Server) SetDropHandler(f DropHandler) {
+	s.onDrop = f
+}
+
+//记录和处理一次被丢弃的投递
+func (s *Server) drop(id interface{}, args []interface{}, err error) {
+	if s.metrics != nil {
+		s.metrics.drop(id)
+	}
+	if s.onDrop != nil {
+		s.onDrop(id, args, err)
+	}
+}
+
+//可靠投递,在timeout内等待入队,入队失败返回错误,timeout为0表示一直等待
+//与Go不同,函数未注册返回错误,不受SetQueueLimit限制,失败时不执行SetDropHandler设置的函数
+func (s *Server) GoReliable(timeout time.Duration, id interface{}, args ...interface{}) error {
+	id, f := s.lookup(id) //根据id取得对应的f
+	if s.remote != nil {
+		f = remoteCast
+	}
+	if f == nil {
+		return fmt.Errorf("function id %v: function not registered", id)
+	}
+	if err := s.checkArgs(id, args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := s.send(ctx, &CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		trace:    NewTraceID(),
+		reliable: true,
+	}, true)
+	if err != nil {
+		return fmt.Errorf("function id %v: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package chanrpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"runtime/debug"
+)
+
+//函数异常时返回的错误,Stack为异常发生处的堆栈
+type HandlerError struct {
+	Route     interface{} //函数id
+	Recovered interface{} //recover()的返回值
+	Stack     []byte
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprint(e.Recovered)
+}
+
+//把异常转化为错误,必须在recover的defer函数中调用,堆栈才是异常发生处的
+func panicError(id interface{}, r interface{}) error {
+	if e, ok := r.(*typeError); ok { //类型错误不需要堆栈
+		return e
+	}
+	return &HandlerError{Route: id, Recovered: r, Stack: debug.Stack()}
+}
+
+//用于记录日志,配置了LenStackBuf时在HandlerError后面附上堆栈,最多LenStackBuf字节
+func ErrorWithStack(err error) string {
+	var e *HandlerError
+	if conf.LenStackBuf <= 0 || !errors.As(err, &e) {
+		return err.Error()
+	}
+
+	stack := e.Stack
+	if len(stack) > conf.LenStackBuf {
+		stack = stack[:conf.LenStackBuf]
+	}
+	return fmt.Sprintf("%v: %s", err, stack)
+}
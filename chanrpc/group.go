@@ -0,0 +1,124 @@
+package chanrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//一组服务器,用于向多个服务器广播调用,goroutine safe
+type Group struct {
+	mu      sync.RWMutex
+	servers []*Server
+}
+
+//广播调用的一个服务器的结果
+type GroupResult struct {
+	Server *Server
+	Ret    interface{}
+	Err    error
+}
+
+//创建服务器组
+func NewGroup(servers ...*Server) *Group {
+	g := new(Group)
+	g.servers = append(g.servers, servers...)
+	return g
+}
+
+//添加服务器,已经在组中时不重复添加
+func (g *Group) Add(s *Server) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, gs := range g.servers {
+		if gs == s {
+			return
+		}
+	}
+	g.servers = append(g.servers, s)
+}
+
+//移除服务器
+func (g *Group) Remove(s *Server) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, gs := range g.servers {
+		if gs == s {
+			//复制而不是原地修改,正在广播的goroutine还在读取旧的切片
+			servers := make([]*Server, 0, len(g.servers)-1)
+			servers = append(servers, g.servers[:i]...)
+			g.servers = append(servers, g.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+//组中的服务器
+func (g *Group) Servers() []*Server {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]*Server(nil), g.servers...)
+}
+
+//取得组中的服务器快照,切片不会被修改
+func (g *Group) snapshot() []*Server {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.servers
+}
+
+//向组中注册了id的所有服务器发起调用,不阻塞,跳过没有注册id的服务器
+//返回失败的服务器和错误,比如管道已满,服务器已关闭
+func (g *Group) Go(id interface{}, args ...interface{}) map[*Server]error {
+	var errs map[*Server]error
+	for _, s := range g.snapshot() {
+		id, f := s.lookup(id)
+		if f == nil {
+			continue
+		}
+
+		err := s.checkArgs(id, args)
+		if err == nil {
+			err = s.send(context.Background(), &CallInfo{
+				id:    id,
+				f:     f,
+				args:  args,
+				trace: NewTraceID(),
+			}, false)
+		}
+		if err != nil {
+			if errs == nil {
+				errs = make(map[*Server]error)
+			}
+			errs[s] = err
+		}
+	}
+	return errs
+}
+
+//向组中注册了id的所有服务器同时发起调用,等待所有返回或超时,函数必须有一个返回值
+//超时的服务器的结果为context.DeadlineExceeded,结果按服务器在组中的顺序排列
+func (g *Group) Call(timeout time.Duration, id interface{}, args ...interface{}) []GroupResult {
+	var servers []*Server
+	for _, s := range g.snapshot() {
+		if _, f := s.lookup(id); f != nil {
+			servers = append(servers, s)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := make([]GroupResult, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+			ret, err := s.Open(0).Call1Ctx(ctx, id, args...)
+			results[i] = GroupResult{Server: s, Ret: ret, Err: err}
+		}(i, s)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,126 @@
+package chanrpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+//调用超过函数的频率限制时返回的错误
+var ErrRateLimited = errors.New("chanrpc rate limited")
+
+//ChanCall中的调用达到SetQueueLimit的数量时,投递返回的错误
+var ErrOverloaded = errors.New("chanrpc server overloaded")
+
+//令牌桶,goroutine safe
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 //每秒补充的令牌
+	burst  float64 //令牌上限
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := new(limiter)
+	l.rate = rate
+	l.burst = float64(burst)
+	l.tokens = l.burst
+	l.last = time.Now()
+	return l
+}
+
+//取一个令牌,没有时返回false
+func (l *limiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+//设置ChanCall中的调用数量上限,达到时新的投递(Go)立即返回ErrOverloaded,为0表示不限制
+//同步和异步调用以及GoReliable不受影响,必须在调用Open()和Go()之前调用
+func (s *Server) SetQueueLimit(n int) {
+	s.queueLimit = n
+}
+
+//在调用者的goroutine中检查频率限制和过载
+func (s *Server) admit(ci *CallInfo) error {
+	var err error
+	if l := s.limiters[ci.id]; l != nil && !l.allow(time.Now()) {
+		err = ErrRateLimited
+	} else if s.queueLimit > 0 && ci.chanRet == nil && !ci.reliable && s.queues[ci.id] == nil && len(s.ChanCall) >= s.queueLimit {
+		err = ErrOverloaded
+	}
+
+	if err != nil && s.metrics != nil {
+		s.metrics.reject(ci.id)
+	}
+	return err
+}
+
+//ChanCall使用率超过阈值时的回调,只在服务器的goroutine中访问
+type overload struct {
+	threshold float64
+	fn        func(depth, cap int)
+	above     bool //上一次检查时是否超过阈值
+}
+
+//ChanCall中的调用数量,goroutine safe
+func (s *Server) QueueLen() int {
+	return len(s.ChanCall)
+}
+
+//ChanCall的容量,goroutine safe
+func (s *Server) QueueCap() int {
+	return cap(s.ChanCall)
+}
+
+//ChanCall的使用率,容量为0时返回0
+func (s *Server) utilization() float64 {
+	if cap(s.ChanCall) == 0 {
+		return 0
+	}
+	return float64(len(s.ChanCall)) / float64(cap(s.ChanCall))
+}
+
+//设置ChanCall使用率超过threshold时执行的函数,每次从不超过变为超过时在服务器的goroutine中执行一次fn
+//必须在调用Open()和Go()之前调用
+func (s *Server) OnOverload(threshold float64, fn func(depth, cap int)) {
+	s.overload = &overload{
+		threshold: threshold,
+		fn:        fn,
+	}
+}
+
+//在Exec中检查ChanCall的使用率
+func (s *Server) checkOverload() {
+	o := s.overload
+	if o == nil {
+		return
+	}
+
+	above := s.utilization() > o.threshold
+	if above && !o.above {
+		o.fn(len(s.ChanCall), cap(s.ChanCall))
+	}
+	o.above = above
+}
+
+//设置调用前检查服务器ChanCall的使用率,超过limit时立即返回ErrOverloaded而不阻塞,为0表示不检查
+//有独立缓冲的函数不检查
+func (c *Client) SetFailFast(limit float64) {
+	c.failFast = limit
+}
@@ -0,0 +1,153 @@
+package chanrpc
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//一个函数id的调用统计
+type RouteStats struct {
+	Calls    uint64
+	Errors   uint64
+	Rejected uint64        //被频率限制或过载保护拒绝的调用
+	Dropped  uint64        //Go没有投递成功而丢弃的调用
+	Total    time.Duration //总耗时
+	P50      time.Duration //耗时的百分位数,按2的幂分桶估计
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+//每个函数id的调用统计
+type metrics struct {
+	mu     sync.Mutex
+	routes map[interface{}]*route
+}
+
+type route struct {
+	calls    uint64
+	errors   uint64
+	rejected uint64
+	dropped  uint64
+	total    time.Duration
+	max      time.Duration
+	buckets  [64]uint64 //第i个桶记录耗时在[2^(i-1), 2^i)纳秒内的调用
+}
+
+//启用调用统计,必须在调用Open()和Go()之前调用
+func (s *Server) EnableMetrics() {
+	if s.metrics == nil {
+		s.metrics = &metrics{routes: make(map[interface{}]*route)}
+	}
+}
+
+//必须在持有m.mu时调用
+func (m *metrics) route(id interface{}) *route {
+	r := m.routes[id]
+	if r == nil {
+		r = new(route)
+		m.routes[id] = r
+	}
+	return r
+}
+
+//记录一次被拒绝的调用,在调用者的goroutine中调用
+func (m *metrics) reject(id interface{}) {
+	m.mu.Lock()
+	m.route(id).rejected++
+	m.mu.Unlock()
+}
+
+//记录一次被丢弃的投递,在调用者的goroutine中调用
+func (m *metrics) drop(id interface{}) {
+	m.mu.Lock()
+	m.route(id).dropped++
+	m.mu.Unlock()
+}
+
+//记录一次调用
+func (m *metrics) record(id interface{}, d time.Duration, err error) {
+	m.mu.Lock()
+	r := m.route(id)
+	r.calls++
+	if err != nil {
+		r.errors++
+	}
+	r.total += d
+	if d > r.max {
+		r.max = d
+	}
+	if d < 0 {
+		d = 0
+	}
+	r.buckets[bits.Len64(uint64(d))]++
+	m.mu.Unlock()
+}
+
+//估计第p百分位的耗时,返回所在桶的上界,不超过最大耗时
+func (r *route) percentile(p float64) time.Duration {
+	n := uint64(float64(r.calls)*p + 0.5)
+	if n < 1 {
+		n = 1
+	}
+	var sum uint64
+	for i, c := range r.buckets {
+		sum += c
+		if sum >= n {
+			d := time.Duration(1)<<uint(i) - 1
+			if d > r.max || i == 63 {
+				d = r.max
+			}
+			return d
+		}
+	}
+	return r.max
+}
+
+//返回每个函数id的调用统计快照,键为fmt.Sprint(id),未启用时返回nil
+//goroutine safe
+func (s *Server) Metrics() map[string]RouteStats {
+	m := s.metrics
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]RouteStats, len(m.routes))
+	for id, r := range m.routes {
+		stats[fmt.Sprint(id)] = RouteStats{
+			Calls:    r.calls,
+			Errors:   r.errors,
+			Rejected: r.rejected,
+			Dropped:  r.dropped,
+			Total:    r.total,
+			P50:      r.percentile(0.5),
+			P90:      r.percentile(0.9),
+			P99:      r.percentile(0.99),
+			Max:      r.max,
+		}
+	}
+	return stats
+}
+
+//把调用统计格式化为表格,按函数id排序
+func FormatMetrics(stats map[string]RouteStats) string {
+	ids := make([]string, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-24v %10v %8v %8v %8v %12v %12v %12v %12v", "route", "calls", "errors", "rejected", "dropped", "p50", "p90", "p99", "max")
+	for _, id := range ids {
+		r := stats[id]
+		fmt.Fprintf(&b, "\r\n%-24v %10v %8v %8v %8v %12v %12v %12v %12v", id, r.Calls, r.Errors, r.Rejected, r.Dropped, r.P50, r.P90, r.P99, r.Max)
+	}
+	return b.String()
+}
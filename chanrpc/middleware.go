@@ -0,0 +1,100 @@
+package chanrpc
+
+import (
+	"github.com/name5566/leaf/log"
+	"time"
+)
+
+//中间件,在服务器的goroutine中围绕调用执行,调用next继续执行后面的中间件和函数
+//不调用next则直接返回,此时可以用SetRet、SetErr设置返回值
+type Middleware func(ci *CallInfo, next func())
+
+//添加中间件,按添加顺序执行,必须在调用Open()和Go()之前调用
+func (s *Server) Use(m Middleware) {
+	s.middleware = append(s.middleware, m)
+}
+
+//函数id
+func (ci *CallInfo) ID() interface{} {
+	return ci.id
+}
+
+//参数
+func (ci *CallInfo) Args() []interface{} {
+	return ci.args
+}
+
+//开始入队的时刻,包括等待管道空位的时间,time.Since(ci.Enqueued())为调用排队的时间
+func (ci *CallInfo) Enqueued() time.Time {
+	return ci.enqueued
+}
+
+//返回值,调用next之后有效
+func (ci *CallInfo) Ret() interface{} {
+	return ci.ret
+}
+
+//错误,调用next之后有效
+func (ci *CallInfo) Err() error {
+	return ci.err
+}
+
+//设置返回值
+func (ci *CallInfo) SetRet(ret interface{}) {
+	ci.ret = ret
+}
+
+//设置错误
+func (ci *CallInfo) SetErr(err error) {
+	ci.err = err
+}
+
+//经过所有中间件执行调用,中间件中的异常也转化为错误
+func (s *Server) intercept(ci *CallInfo) (ret interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret, err = nil, panicError(ci.id, r)
+		}
+	}()
+
+	ci.ret, ci.err = nil, nil
+	s.next(ci, 0)()
+	return ci.ret, ci.err
+}
+
+//返回执行第i个中间件的函数
+func (s *Server) next(ci *CallInfo, i int) func() {
+	if i == len(s.middleware) {
+		return func() {
+			ci.ret, ci.err = exec(ci.id, ci.f, ci.args) //执行调用
+		}
+	}
+
+	return func() {
+		s.middleware[i](ci, s.next(ci, i+1))
+	}
+}
+
+//日志中间件,记录每个调用的函数id、耗时和错误
+func LogCalls(ci *CallInfo, next func()) {
+	start := time.Now()
+	next()
+	if ci.err != nil {
+		log.Trace(ci.trace).Error("chanrpc %v: %v, %v", ci.id, time.Since(start), ci.err)
+	} else {
+		log.Trace(ci.trace).Debug("chanrpc %v: %v", ci.id, time.Since(start))
+	}
+}
+
+//恢复中间件,把后面的中间件中的异常转化为错误并记录日志
+//函数本身的异常总是转化为错误
+func Recover(ci *CallInfo, next func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			ci.ret, ci.err = nil, panicError(ci.id, r)
+			log.Trace(ci.trace).Error("chanrpc %v: %v", ci.id, ErrorWithStack(ci.err))
+		}
+	}()
+
+	next()
+}
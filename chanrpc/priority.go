@@ -0,0 +1,124 @@
+package chanrpc
+
+import (
+	"context"
+	"github.com/name5566/leaf/log"
+)
+
+//函数的优先级
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+//注册选项
+type Options struct {
+	Buffer   int      //函数独立的缓冲大小,调用不占用ChanCall,为0且普通优先级时使用ChanCall
+	Priority Priority //优先级,Exec先执行优先级更高的调用
+	Rate     float64  //每秒允许的调用次数,为0表示不限制,超过时调用者立即得到ErrRateLimited
+	Burst    int      //允许瞬间发起的调用次数,至少为1
+}
+
+//有独立缓冲的函数的调用队列
+type queue struct {
+	slots chan struct{} //缓冲中的每个调用占用一个位置
+	lane  int           //所在管道的下标
+}
+
+//注册id->func的映射,函数有独立的缓冲和优先级,调用通过Lane(opts.Priority)传给服务器
+//必须在调用Open()和Go()之前调用
+func (s *Server) RegisterWithOptions(id interface{}, f interface{}, opts Options) {
+	if opts.Priority < PriorityLow || opts.Priority > PriorityHigh {
+		panic("invalid priority")
+	}
+
+	s.Register(id, f)
+	if opts.Rate > 0 {
+		s.limiters[id] = newLimiter(opts.Rate, opts.Burst)
+	}
+	if opts.Buffer < 1 && opts.Priority == PriorityNormal { //使用ChanCall
+		return
+	}
+	if opts.Buffer < 1 {
+		opts.Buffer = 1
+	}
+
+	q := &queue{slots: make(chan struct{}, opts.Buffer), lane: laneOf(opts.Priority)}
+	s.queues[id] = q
+
+	//管道容量为其中所有函数的缓冲之和,占到位置的调用入队时不会阻塞
+	capacity := opts.Buffer
+	if s.lanes[q.lane] != nil {
+		capacity += cap(s.lanes[q.lane])
+	}
+	s.lanes[q.lane] = make(chan *CallInfo, capacity)
+}
+
+//返回优先级为p的函数的调用管道,没有这个优先级的函数时为nil
+//服务器的goroutine需要同时读取ChanCall和这些管道,并用Exec执行读取到的调用
+func (s *Server) Lane(p Priority) chan *CallInfo {
+	if p < PriorityLow || p > PriorityHigh {
+		return nil
+	}
+	return s.lanes[laneOf(p)]
+}
+
+func laneOf(p Priority) int {
+	return int(p - PriorityLow)
+}
+
+//调用所在管道的下标,ChanCall中的调用为普通优先级
+func (ci *CallInfo) laneIndex() int {
+	if ci.queue != nil {
+		return ci.queue.lane
+	}
+	return laneOf(PriorityNormal)
+}
+
+//将有独立缓冲的函数的调用传入所在管道,先占一个位置,服务器执行时让出
+func (s *Server) sendQueued(ctx context.Context, q *queue, ci *CallInfo, block bool) error {
+	if !block { //非阻塞
+		select {
+		case q.slots <- struct{}{}:
+		case <-s.closing:
+			return ErrServerClosed
+		default: //当缓冲满时,返回管道已满错误
+			return ErrChannelFull
+		}
+	} else {
+		select {
+		case q.slots <- struct{}{}: //当缓冲满时阻塞
+		case <-s.closing:
+			return ErrServerClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	ci.queue = q
+	s.lanes[q.lane] <- ci
+	return nil
+}
+
+//执行管道中优先级比ci高的所有调用
+func (s *Server) execAbove(ci *CallInfo) {
+	for i := len(s.lanes) - 1; i > ci.laneIndex(); i-- {
+		if s.lanes[i] == nil {
+			continue
+		}
+		for {
+			select {
+			case hci := <-s.lanes[i]:
+				if err := s.Exec(hci); err != nil {
+					log.Error("%v", ErrorWithStack(err))
+				}
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package chanrpc
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//远程调用的请求,由Transport序列化后发送到另一个节点
+type Request struct {
+	ID   interface{}   //函数id
+	Args []interface{} //参数
+	N    int           //0 1 2分别表示0个 1个 n个返回值,-1表示投递(Go),不需要回复
+}
+
+//把调用发送到远程节点的传输层
+type Transport interface {
+	//发送请求,收到回复或发送失败时调用done,done可以在任意goroutine中调用
+	//投递(Go)的done为nil
+	Send(req *Request, done func(ret interface{}, err error))
+}
+
+//远程调用超时返回的错误
+var ErrTimeout = errors.New("chanrpc remote call timeout")
+
+//远程服务器不支持批量调用
+var errRemoteBatch = errors.New("chanrpc batch not supported by remote server")
+
+//远程服务器的函数,值为返回值个数,函数是否注册由远程节点检查
+type remoteFunc int
+
+const remoteCast remoteFunc = -1
+
+//远程rpc服务器
+type remote struct {
+	transport Transport
+	timeout   time.Duration
+}
+
+//创建远程rpc服务器,通过t把调用发送到另一个节点上的服务器
+//返回的服务器和本地服务器一样使用Open、Go发起调用,不需要注册函数,也不需要执行Exec
+//函数未注册等错误由远程节点返回,错误信息与本地调用相同
+//timeout为0表示不超时,超时的调用返回包装了ErrTimeout的错误
+func NewRemoteServer(t Transport, l int, timeout time.Duration) *Server {
+	s := NewServer(l)
+	s.remote = &remote{
+		transport: t,
+		timeout:   timeout,
+	}
+	go s.forward()
+	return s
+}
+
+//把ChanCall中的调用转发到远程节点,服务器关闭时返回
+func (s *Server) forward() {
+	for ci := range s.ChanCall {
+		if b, ok := ci.f.(*batch); ok {
+			s.ret(ci, &RetInfo{ret: batchFailed(len(b.calls), errRemoteBatch)})
+			continue
+		}
+
+		n := ci.f.(remoteFunc)
+		req := &Request{
+			ID:   ci.id,
+			Args: ci.args,
+			N:    int(n),
+		}
+		if n == remoteCast {
+			s.remote.transport.Send(req, nil)
+			continue
+		}
+
+		s.remote.transport.Send(req, s.reply(ci, n))
+	}
+}
+
+//返回处理远程回复的函数,回复和超时只有先到的一个生效
+func (s *Server) reply(ci *CallInfo, n remoteFunc) func(interface{}, error) {
+	var replied int32
+	finish := func(ret interface{}, err error) {
+		if !atomic.CompareAndSwapInt32(&replied, 0, 1) {
+			return
+		}
+		if n == 2 { //n个返回值,出错时也保证是[]interface{}
+			rets, _ := ret.([]interface{})
+			ret = rets
+		}
+		s.ret(ci, &RetInfo{ret: ret, err: err})
+	}
+
+	var t *time.Timer
+	if s.remote.timeout > 0 {
+		t = time.AfterFunc(s.remote.timeout, func() {
+			finish(nil, fmt.Errorf("function id %v: %w", ci.id, ErrTimeout))
+		})
+	}
+
+	return func(ret interface{}, err error) {
+		if t != nil {
+			t.Stop()
+		}
+		finish(ret, err)
+	}
+}
@@ -0,0 +1,115 @@
+package chanrpc
+
+import (
+	"errors"
+	"github.com/name5566/leaf/timer"
+	"sync/atomic"
+	"time"
+)
+
+//异步调用的重试选项
+type RetryOptions struct {
+	MaxAttempts  int                  //最多调用次数,包括第一次,小于1时为1
+	InitialDelay time.Duration        //第一次重试前等待的时间
+	Multiplier   float64              //每次重试后等待时间乘以这个值,小于1时为1
+	Retryable    func(err error) bool //判断错误是否可以重试,为nil时使用IsRetryable
+}
+
+//错误是否是暂时的:管道已满、过载、频率限制或服务器已关闭(热更新时可能重新打开)
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrChannelFull) ||
+		errors.Is(err, ErrOverloaded) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrServerClosed)
+}
+
+//一个带重试的异步调用,只在客户端的goroutine中访问
+type retry struct {
+	c       *Client
+	d       *timer.Dispatcher
+	opts    RetryOptions
+	id      interface{}
+	args    []interface{}
+	cb      interface{} //调用者的回调
+	attempt interface{} //每次调用使用的回调
+	n       int
+	h       *AsynHandle
+	tries   int
+	delay   time.Duration
+}
+
+//发起带重试的异步调用,可以重试的错误在d的定时器中重试,不阻塞客户端的goroutine
+//需要自己写c.Cb(<-c.ChanAsynRet)和定时器的回调,客户端和d应该在同一个goroutine中使用
+//重试次数用完后cb接收最后一次的错误,返回的句柄在重试中也可以取消
+func (c *Client) AsynCallRetry(d *timer.Dispatcher, opts RetryOptions, id interface{}, cb interface{}, args ...interface{}) *AsynHandle {
+	r := &retry{
+		c:     c,
+		d:     d,
+		opts:  opts,
+		id:    id,
+		args:  args,
+		cb:    cb,
+		h:     new(AsynHandle),
+		delay: opts.InitialDelay,
+	}
+	if r.opts.MaxAttempts < 1 {
+		r.opts.MaxAttempts = 1
+	}
+	if r.opts.Multiplier < 1 {
+		r.opts.Multiplier = 1
+	}
+	if r.opts.Retryable == nil {
+		r.opts.Retryable = IsRetryable
+	}
+
+	switch cb.(type) { //判断回调函数的类型
+	case func(error):
+		r.n = 0
+		r.attempt = func(err error) { r.done(nil, err) }
+	case func(interface{}, error):
+		r.n = 1
+		r.attempt = func(ret interface{}, err error) { r.done(ret, err) }
+	case func([]interface{}, error):
+		r.n = 2
+		r.attempt = func(ret []interface{}, err error) { r.done(ret, err) }
+	default:
+		panic("definition of callback function is invalid")
+	}
+
+	r.call()
+	return r.h
+}
+
+//发起一次调用,已取消时不再调用
+func (r *retry) call() {
+	if atomic.LoadInt32(&r.h.state) != asynPending {
+		return
+	}
+
+	r.tries++
+	if err := r.c.asynCall(r.id, r.args, r.attempt, r.n, nil); err != nil {
+		r.done(nil, err)
+	}
+}
+
+//一次调用结束,可以重试时等待后重试,否则执行调用者的回调
+func (r *retry) done(ret interface{}, err error) {
+	if err != nil && r.tries < r.opts.MaxAttempts && r.opts.Retryable(err) && atomic.LoadInt32(&r.h.state) == asynPending {
+		r.d.AfterFunc(r.delay, r.call)
+		r.delay = time.Duration(float64(r.delay) * r.opts.Multiplier)
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&r.h.state, asynPending, asynDone) { //已取消
+		return
+	}
+	switch cb := r.cb.(type) {
+	case func(error):
+		cb(err)
+	case func(interface{}, error):
+		cb(ret, err)
+	case func([]interface{}, error):
+		rets, _ := ret.([]interface{})
+		cb(rets, err)
+	}
+}
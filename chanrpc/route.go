@@ -0,0 +1,109 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//id或别名是否已注册,必须在持有s.fmu时调用
+func (s *Server) registered(id interface{}) bool {
+	_, ok := s.functions[id]
+	_, alias := s.aliases[id]
+	return ok || alias
+}
+
+//id或别名是否已注册,goroutine safe
+func (s *Server) Registered(id interface{}) bool {
+	s.fmu.RLock()
+	defer s.fmu.RUnlock()
+	return s.registered(id)
+}
+
+//执行时的f,ChanCall中的调用执行出队时注册的f,入队后被注销时返回nil
+//流式函数绑定到调用的Stream上
+func (s *Server) current(ci *CallInfo) interface{} {
+	s.fmu.RLock()
+	f := s.functions[ci.id]
+	s.fmu.RUnlock()
+
+	if h, ok := f.(func([]interface{}, Push) error); ok {
+		return ci.stream.bind(h)
+	}
+	return f
+}
+
+//注销id->func的映射,id是别名时只注销别名,注销函数时同时注销它的别名
+//已经入队的调用返回函数未注册的错误,频率限制、独立缓冲等选项保留,重新注册后仍然有效
+//goroutine safe,可以在有调用时执行
+func (s *Server) Unregister(id interface{}) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	if _, ok := s.aliases[id]; ok {
+		delete(s.aliases, id)
+		return
+	}
+
+	delete(s.functions, id)
+	delete(s.arity, id)
+	for alias, to := range s.aliases {
+		if to == id {
+			delete(s.aliases, alias)
+		}
+	}
+}
+
+//替换id对应的f,f的类型必须与原来的相同,参数个数和其他选项不变
+//已经入队的调用出队时执行新的f,goroutine safe,可以在有调用时执行
+func (s *Server) Replace(id interface{}, f interface{}) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	if to, ok := s.aliases[id]; ok {
+		id = to
+	}
+
+	old := s.functions[id]
+	if old == nil {
+		panic(fmt.Sprintf("function id %v: function not registered", id))
+	}
+	if reflect.TypeOf(old) != reflect.TypeOf(f) {
+		panic(fmt.Sprintf("function id %v: definition of function is invalid", id))
+	}
+	s.functions[id] = f
+}
+
+//注册newID作为existingID的别名,用newID发起的调用执行existingID对应的f
+//调用按existingID统计和限制频率,existingID被替换后别名执行新的f
+//goroutine safe,可以在有调用时执行
+func (s *Server) Alias(newID interface{}, existingID interface{}) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	if to, ok := s.aliases[existingID]; ok {
+		existingID = to
+	}
+
+	if s.functions[existingID] == nil {
+		panic(fmt.Sprintf("function id %v: function not registered", existingID))
+	}
+	if s.registered(newID) {
+		panic(fmt.Sprintf("function id %v: already registered", newID))
+	}
+	s.aliases[newID] = existingID
+}
+
+//注销所有函数和别名,返回恢复它们的函数,用于把服务器交给另一个模块(见module.Swap)
+//频率限制、独立缓冲等选项保留,已经入队的调用出队时执行届时注册的f
+//goroutine safe,可以在有调用时执行
+func (s *Server) ResetFunctions() (restore func()) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	functions, arity, aliases := s.functions, s.arity, s.aliases
+	s.functions = make(map[interface{}]interface{})
+	s.arity = make(map[interface{}]int)
+	s.aliases = make(map[interface{}]interface{})
+
+	return func() {
+		s.fmu.Lock()
+		defer s.fmu.Unlock()
+		s.functions, s.arity, s.aliases = functions, arity, aliases
+	}
+}
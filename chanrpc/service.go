@@ -0,0 +1,184 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//RegisterService的选项
+type ServiceOption func(*service)
+
+type service struct {
+	prefix string
+	allow  map[string]bool //为nil表示不限制
+	deny   map[string]bool
+}
+
+//在函数id前加上prefix,同一类型的多个服务使用不同的prefix
+func WithPrefix(prefix string) ServiceOption {
+	return func(sv *service) {
+		sv.prefix = prefix
+	}
+}
+
+//只注册这些方法,签名不支持时返回错误
+func AllowMethods(names ...string) ServiceOption {
+	return func(sv *service) {
+		if sv.allow == nil {
+			sv.allow = make(map[string]bool)
+		}
+		for _, name := range names {
+			sv.allow[name] = true
+		}
+	}
+}
+
+//不注册这些方法
+func DenyMethods(names ...string) ServiceOption {
+	return func(sv *service) {
+		if sv.deny == nil {
+			sv.deny = make(map[string]bool)
+		}
+		for _, name := range names {
+			sv.deny[name] = true
+		}
+	}
+}
+
+var argsType = reflect.TypeOf([]interface{}(nil))
+
+//服务中的一个函数
+type serviceFunc struct {
+	id string
+	f  interface{}
+	n  int //参数个数,-1表示不检查
+}
+
+//把recv的导出方法注册为函数,函数id为"类型名.方法名"
+//支持Register支持的签名,以及一个任意类型参数、无返回值或一个返回值的方法(与Register1、Register1R相同)
+//其他签名的方法被跳过
+//recv的结构体可以有一个带`chanrpc:"..."`标签的字段(通常是_ struct{}),标签为逗号分隔的项:
+//不含=的项代替类型名,"方法名=函数id"指定方法的完整函数id
+//函数id已注册或重复时返回错误,此时不注册任何方法
+func (s *Server) RegisterService(recv interface{}, opts ...ServiceOption) error {
+	sv := new(service)
+	for _, opt := range opts {
+		opt(sv)
+	}
+
+	v := reflect.ValueOf(recv)
+	name, routes := serviceTag(reflect.Indirect(v).Type())
+	for m := range sv.allow {
+		if !v.MethodByName(m).IsValid() {
+			return fmt.Errorf("method %v.%v: not found", name, m)
+		}
+	}
+
+	var fs []serviceFunc
+	seen := make(map[string]bool)
+	for i := 0; i < v.NumMethod(); i++ {
+		m := v.Type().Method(i).Name
+		if sv.deny[m] || sv.allow != nil && !sv.allow[m] {
+			continue
+		}
+
+		id := routes[m]
+		if id == "" {
+			id = name + "." + m
+		}
+		id = sv.prefix + id
+
+		f, n := method(id, v.Method(i))
+		if f == nil {
+			if sv.allow != nil {
+				return fmt.Errorf("method %v.%v: definition of function is invalid", name, m)
+			}
+			continue
+		}
+		if seen[id] {
+			return fmt.Errorf("function id %v: already registered", id)
+		}
+		seen[id] = true
+		fs = append(fs, serviceFunc{id: id, f: f, n: n})
+	}
+
+	s.fmu.RLock()
+	for _, sf := range fs {
+		if s.registered(sf.id) {
+			s.fmu.RUnlock()
+			return fmt.Errorf("function id %v: already registered", sf.id)
+		}
+	}
+	s.fmu.RUnlock()
+
+	for _, sf := range fs {
+		if sf.n < 0 {
+			s.Register(sf.id, sf.f)
+		} else {
+			s.RegisterN(sf.id, sf.n, sf.f)
+		}
+	}
+	return nil
+}
+
+//读取结构体的chanrpc标签,没有指定名字时为类型名
+func serviceTag(typ reflect.Type) (name string, routes map[string]string) {
+	name = typ.Name()
+	if typ.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		tag, ok := typ.Field(i).Tag.Lookup("chanrpc")
+		if !ok {
+			continue
+		}
+		for _, item := range strings.Split(tag, ",") {
+			item = strings.TrimSpace(item)
+			if k, v, ok := strings.Cut(item, "="); ok {
+				if routes == nil {
+					routes = make(map[string]string)
+				}
+				routes[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			} else if item != "" {
+				name = item
+			}
+		}
+	}
+	return
+}
+
+//把方法转化为可以注册的函数,n为参数个数,-1表示不检查,不支持的签名返回nil
+func method(id string, m reflect.Value) (interface{}, int) {
+	switch f := m.Interface().(type) {
+	case func([]interface{}), func([]interface{}) interface{}, func([]interface{}) []interface{}, func([]interface{}, Push) error:
+		return f, -1
+	}
+
+	typ := m.Type()
+	if typ.NumIn() != 1 || typ.IsVariadic() || typ.In(0) == argsType || typ.NumOut() > 1 {
+		return nil, 0
+	}
+
+	in := typ.In(0)
+	call := func(args []interface{}) []reflect.Value {
+		a := reflect.Zero(in) //nil只能赋给接口
+		if args[0] != nil {
+			a = reflect.ValueOf(args[0])
+		}
+		if args[0] == nil && in.Kind() != reflect.Interface || args[0] != nil && !a.Type().AssignableTo(in) {
+			panic(&typeError{id: id, what: "argument", expected: in, actual: args[0]})
+		}
+		return m.Call([]reflect.Value{a})
+	}
+
+	if typ.NumOut() == 0 {
+		return func(args []interface{}) {
+			call(args)
+		}, 1
+	}
+	return func(args []interface{}) interface{} {
+		return call(args)[0].Interface()
+	}, 1
+}
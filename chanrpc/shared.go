@@ -0,0 +1,83 @@
+package chanrpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+//可以在多个goroutine中同时使用的rpc客户端,只支持同步调用
+//每个调用从池中取一个Client,使用自己的同步返回管道,用完放回池中
+//goroutine safe
+type SharedClient struct {
+	s      *Server
+	pool   sync.Pool
+	closed int32
+}
+
+//打开一个可以在多个goroutine中使用的rpc客户端
+func (s *Server) OpenShared() *SharedClient {
+	sc := new(SharedClient)
+	sc.s = s
+	sc.pool.New = func() interface{} {
+		return s.Open(0) //不发起异步调用,不需要异步返回管道
+	}
+	return sc
+}
+
+//取一个Client执行f,关闭后返回ErrClientClosed
+func (sc *SharedClient) with(f func(c *Client) error) error {
+	if atomic.LoadInt32(&sc.closed) == 1 {
+		return ErrClientClosed
+	}
+
+	c := sc.pool.Get().(*Client)
+	err := f(c)
+	sc.pool.Put(c) //ctx结束时callSync已经换了新的同步返回管道,迟到的返回值不会被下一个调用读到
+	return err
+}
+
+//调用0
+func (sc *SharedClient) Call0(id interface{}, args ...interface{}) error {
+	return sc.Call0Ctx(context.Background(), id, args...)
+}
+
+//调用1
+func (sc *SharedClient) Call1(id interface{}, args ...interface{}) (interface{}, error) {
+	return sc.Call1Ctx(context.Background(), id, args...)
+}
+
+//调用N
+func (sc *SharedClient) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
+	return sc.CallNCtx(context.Background(), id, args...)
+}
+
+//带ctx的调用0
+func (sc *SharedClient) Call0Ctx(ctx context.Context, id interface{}, args ...interface{}) error {
+	return sc.with(func(c *Client) error {
+		return c.Call0Ctx(ctx, id, args...)
+	})
+}
+
+//带ctx的调用1
+func (sc *SharedClient) Call1Ctx(ctx context.Context, id interface{}, args ...interface{}) (ret interface{}, err error) {
+	err = sc.with(func(c *Client) error {
+		ret, err = c.Call1Ctx(ctx, id, args...)
+		return err
+	})
+	return
+}
+
+//带ctx的调用N
+func (sc *SharedClient) CallNCtx(ctx context.Context, id interface{}, args ...interface{}) (ret []interface{}, err error) {
+	err = sc.with(func(c *Client) error {
+		ret, err = c.CallNCtx(ctx, id, args...)
+		return err
+	})
+	return
+}
+
+//关闭客户端,之后发起的调用都返回ErrClientClosed
+func (sc *SharedClient) Close() {
+	atomic.StoreInt32(&sc.closed, 1)
+}
@@ -0,0 +1,94 @@
+package chanrpc
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//流式函数用来发送一项结果,客户端的管道已满时返回ErrChannelFull,客户端已取消时返回ErrStreamCanceled
+type Push func(interface{}) error
+
+//客户端取消流式调用后Push返回的错误
+var ErrStreamCanceled = errors.New("chanrpc stream canceled")
+
+//流式调用的一项结果,Done为true时是最后一项,Err为函数返回的错误
+type StreamItem struct {
+	Value interface{}
+	Err   error
+	Done  bool
+}
+
+//流式调用,在C中读取结果直到Done为true
+type Stream struct {
+	C        <-chan StreamItem
+	c        chan StreamItem
+	l        int   //Push可以使用的缓冲,多一个位置留给最后一项
+	canceled int32 //只在客户端取消时修改
+}
+
+//执行流式函数的函数,f返回的错误作为最后一项的Err
+type streamCall func([]interface{}) error
+
+//取消流式调用,之后Push返回ErrStreamCanceled,仍然会收到Done为true的最后一项
+//goroutine safe
+func (st *Stream) Cancel() {
+	atomic.StoreInt32(&st.canceled, 1)
+}
+
+//在服务器的goroutine中发送一项结果,不阻塞
+func (st *Stream) push(v interface{}) error {
+	if st == nil || atomic.LoadInt32(&st.canceled) == 1 { //Go发起的调用没有接收者
+		return ErrStreamCanceled
+	}
+	if len(st.c) >= st.l {
+		return ErrChannelFull
+	}
+	st.c <- StreamItem{Value: v}
+	return nil
+}
+
+//发送最后一项,总是有位置
+func (st *Stream) finish(err error) {
+	st.c <- StreamItem{Err: err, Done: true}
+}
+
+//把流式函数绑定到调用的Stream上
+func (st *Stream) bind(f func([]interface{}, Push) error) streamCall {
+	return func(args []interface{}) error {
+		return f(args, st.push)
+	}
+}
+
+//发起流式调用,f必须是func([]interface{}, Push) error,l为Push可以使用的缓冲大小
+//f每次Push的结果立即发送到返回的Stream中,缓冲已满时Push返回ErrChannelFull而不阻塞服务器
+func (c *Client) Stream(id interface{}, l int, args ...interface{}) (*Stream, error) {
+	id, f := c.s.lookup(id)
+	if f == nil {
+		return nil, fmt.Errorf("function id %v: function not registered", id)
+	}
+	if _, ok := f.(func([]interface{}, Push) error); !ok {
+		return nil, fmt.Errorf("function id %v: return type mismatch", id)
+	}
+	if err := c.s.checkArgs(id, args); err != nil {
+		return nil, err
+	}
+
+	if l < 1 {
+		l = 1
+	}
+	st := &Stream{c: make(chan StreamItem, l+1), l: l}
+	st.C = st.c
+
+	err := c.call(&CallInfo{
+		id:     id,
+		f:      f,
+		args:   args,
+		stream: st,
+		trace:  c.traceID(),
+	}, true)
+	if err != nil {
+		return nil, fmt.Errorf("function id %v: %w", id, err)
+	}
+	return st, nil
+}
@@ -0,0 +1,56 @@
+package chanrpc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//最近生成的跟踪id
+var lastTraceID = uint64(time.Now().UnixNano())
+
+//生成一个新的跟踪id,不为0,goroutine safe
+func NewTraceID() uint64 {
+	for {
+		if id := atomic.AddUint64(&lastTraceID, 1); id != 0 {
+			return id
+		}
+	}
+}
+
+//调用的跟踪id
+func (ci *CallInfo) TraceID() uint64 {
+	return ci.trace
+}
+
+//正在执行的调用的跟踪id,只能在服务器的goroutine中调用
+//日志中可以用log.Trace(s.TraceID())带上跟踪id
+func (s *Server) TraceID() uint64 {
+	return s.trace
+}
+
+//以trace为当前的跟踪id执行f,用于在服务器的goroutine中执行回调时延续发起者的跟踪id
+//只能在服务器的goroutine中调用
+func (s *Server) WithTrace(trace uint64, f func()) {
+	prev := s.trace
+	s.trace = trace
+	defer func() {
+		s.trace = prev
+	}()
+	f()
+}
+
+//设置客户端之后发起的调用的跟踪id,为0时使用所属服务器正在执行的调用的跟踪id,都没有时生成新的
+func (c *Client) SetTrace(trace uint64) {
+	c.trace = trace
+}
+
+//客户端发起的调用的跟踪id
+func (c *Client) traceID() uint64 {
+	if c.trace != 0 {
+		return c.trace
+	}
+	if c.owner != nil && c.owner.trace != 0 { //使用客户端的goroutine就是所属服务器的goroutine
+		return c.owner.trace
+	}
+	return NewTraceID()
+}
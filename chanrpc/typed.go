@@ -0,0 +1,187 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//类型错误,参数或返回值的类型与注册的函数不符
+type typeError struct {
+	id       interface{}
+	what     string //"argument"或"return value"
+	expected reflect.Type
+	actual   interface{}
+}
+
+func (e *typeError) Error() string {
+	return fmt.Sprintf("function id %v: %v type mismatch: expected %v, got %T", e.id, e.what, e.expected, e.actual)
+}
+
+//取得A的类型,A为接口时也有效
+func typeOf[A any]() reflect.Type {
+	return reflect.TypeOf((*A)(nil)).Elem()
+}
+
+//断言v的类型为T,T为接口时nil也符合
+func assert[T any](v interface{}) (T, bool) {
+	t, ok := v.(T)
+	if !ok && v == nil {
+		ok = typeOf[T]().Kind() == reflect.Interface
+	}
+	return t, ok
+}
+
+//取出唯一的参数,类型不符时panic一个typeError,由Exec转化为错误返回
+func arg[A any](id interface{}, args []interface{}) A {
+	if len(args) != 1 {
+		panic(&typeError{id: id, what: "argument", expected: typeOf[A](), actual: args})
+	}
+	return argAt[A](id, args, 0)
+}
+
+//取出第i个参数,类型不符时panic一个typeError
+func argAt[A any](id interface{}, args []interface{}, i int) A {
+	a, ok := assert[A](args[i])
+	if !ok {
+		panic(&typeError{id: id, what: "argument", expected: typeOf[A](), actual: args[i]})
+	}
+	return a
+}
+
+//注册一个参数、无返回值的函数,可以用Call1Void或Call0调用
+func Register1[A any](s *Server, id interface{}, f func(A)) {
+	s.RegisterN(id, 1, func(args []interface{}) {
+		f(arg[A](id, args))
+	})
+}
+
+//注册一个参数、一个返回值的函数,可以用Call1或Client.Call1调用
+func Register1R[A, R any](s *Server, id interface{}, f func(A) R) {
+	s.RegisterN(id, 1, func(args []interface{}) interface{} {
+		return f(arg[A](id, args))
+	})
+}
+
+//注册两个参数、无返回值的函数,例如处理器路由的消息和userData
+func Register2[A, B any](s *Server, id interface{}, f func(A, B)) {
+	s.RegisterN(id, 2, func(args []interface{}) {
+		f(argAt[A](id, args, 0), argAt[B](id, args, 1))
+	})
+}
+
+//调用一个参数、无返回值的函数
+func Call1Void[A any](c *Client, id interface{}, a A) error {
+	return c.Call0(id, a)
+}
+
+//调用一个参数、一个返回值的函数,返回值类型不符时返回错误
+func Call1[A, R any](c *Client, id interface{}, a A) (R, error) {
+	ret, err := c.Call1(id, a)
+	if err != nil {
+		var r R
+		return r, err
+	}
+
+	r, ok := assert[R](ret)
+	if !ok {
+		return r, &typeError{id: id, what: "return value", expected: typeOf[R](), actual: ret}
+	}
+	return r, nil
+}
+
+//把CallN的返回值依次赋给dests指向的变量,类型可以赋值,或者都是数值类型且转换后不溢出
+//个数不符或类型不符时返回错误,不会panic
+func Decode(ret []interface{}, dests ...interface{}) error {
+	if len(ret) != len(dests) {
+		return fmt.Errorf("chanrpc decode: got %v results, want %v", len(ret), len(dests))
+	}
+
+	for i, dest := range dests {
+		d := reflect.ValueOf(dest)
+		if d.Kind() != reflect.Pointer || d.IsNil() {
+			return fmt.Errorf("chanrpc decode: destination %v: expected a non-nil pointer, got %T", i, dest)
+		}
+		if err := assign(d.Elem(), ret[i]); err != nil {
+			return fmt.Errorf("chanrpc decode: result %v: %v", i, err)
+		}
+	}
+	return nil
+}
+
+//把v赋给d
+func assign(d reflect.Value, v interface{}) error {
+	if v == nil {
+		switch d.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			d.SetZero()
+			return nil
+		}
+		return fmt.Errorf("cannot assign nil to %v", d.Type())
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(d.Type()) {
+		d.Set(rv)
+		return nil
+	}
+
+	overflow := true
+	switch {
+	case isInt(rv.Kind()) && isInt(d.Kind()):
+		overflow = d.OverflowInt(rv.Int())
+	case isUint(rv.Kind()) && isUint(d.Kind()):
+		overflow = d.OverflowUint(rv.Uint())
+	case isInt(rv.Kind()) && isUint(d.Kind()):
+		overflow = rv.Int() < 0 || d.OverflowUint(uint64(rv.Int()))
+	case isUint(rv.Kind()) && isInt(d.Kind()):
+		overflow = rv.Uint() > 1<<63-1 || d.OverflowInt(int64(rv.Uint()))
+	case isFloat(rv.Kind()) && isFloat(d.Kind()):
+		overflow = d.OverflowFloat(rv.Float())
+	case (isInt(rv.Kind()) || isUint(rv.Kind())) && isFloat(d.Kind()):
+		overflow = false
+	}
+	if overflow {
+		return fmt.Errorf("cannot assign %T(%v) to %v", v, v, d.Type())
+	}
+	d.Set(rv.Convert(d.Type()))
+	return nil
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+//调用n个返回值的函数,并把一个返回值解码为R1
+func CallN1[R1 any](c *Client, id interface{}, args ...interface{}) (r1 R1, err error) {
+	ret, err := c.CallN(id, args...)
+	if err == nil {
+		err = Decode(ret, &r1)
+	}
+	return
+}
+
+//调用n个返回值的函数,并把两个返回值解码为R1,R2
+func CallN2[R1, R2 any](c *Client, id interface{}, args ...interface{}) (r1 R1, r2 R2, err error) {
+	ret, err := c.CallN(id, args...)
+	if err == nil {
+		err = Decode(ret, &r1, &r2)
+	}
+	return
+}
+
+//调用n个返回值的函数,并把三个返回值解码为R1,R2,R3
+func CallN3[R1, R2, R3 any](c *Client, id interface{}, args ...interface{}) (r1 R1, r2 R2, r3 R3, err error) {
+	ret, err := c.CallN(id, args...)
+	if err == nil {
+		err = Decode(ret, &r1, &r2, &r3)
+	}
+	return
+}
@@ -1,9 +1,13 @@
 package cluster
 
 import (
+	"bytes"
+	"encoding/gob"
 	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/network"
 	"math"
+	"sync"
 	"time"
 )
 
@@ -33,7 +37,7 @@ func Init() {
 		client.PendingWriteNum = conf.PendingWriteNum
 		client.LenMsgLen = 4
 		client.MaxMsgLen = math.MaxUint32
-		client.NewAgent = newAgent
+		client.NewAgent = newNodeAgent(addr)
 
 		client.Start()
 		clients = append(clients, client)
@@ -51,15 +55,61 @@ func Destroy() {
 }
 
 type Agent struct {
-	conn *network.TCPConn
+	sync.Mutex
+	conn    *network.TCPConn
+	addr    string
+	seq     uint64
+	pending map[uint64]func(interface{}, error)
+	closed  bool
 }
 
 func newAgent(conn *network.TCPConn) network.Agent {
 	a := new(Agent)
 	a.conn = conn
+	a.pending = make(map[uint64]func(interface{}, error))
 	return a
 }
 
-func (a *Agent) Run() {}
+// agents of conf.ConnAddrs are the ones NewRemoteServer calls through
+func newNodeAgent(addr string) func(*network.TCPConn) network.Agent {
+	return func(conn *network.TCPConn) network.Agent {
+		a := newAgent(conn).(*Agent)
+		a.addr = addr
+		setNode(addr, a)
+		return a
+	}
+}
+
+func (a *Agent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message: %v", err)
+			break
+		}
+
+		msg := new(message)
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(msg)
+		if err != nil {
+			log.Error("decode message: %v", err)
+			break
+		}
+		a.handle(msg)
+	}
+}
 
-func (a *Agent) OnClose() {}
+func (a *Agent) OnClose() {
+	if a.addr != "" {
+		removeNode(a.addr, a)
+	}
+
+	a.Lock()
+	a.closed = true
+	pending := a.pending
+	a.pending = nil
+	a.Unlock()
+
+	for _, done := range pending {
+		done(nil, ErrDisconnected)
+	}
+}
@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"errors"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRemoteServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := chanrpc.NewServer(10)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("slow", func(args []interface{}) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+	defer s.Close()
+	Export("game", s)
+	defer func() {
+		mu.Lock()
+		delete(exported, "game")
+		mu.Unlock()
+	}()
+
+	conf.ListenAddr = addr
+	conf.ConnAddrs = []string{addr}
+	Init()
+	defer Destroy()
+
+	r := NewRemoteServer(addr, "game", 10, 100*time.Millisecond)
+	defer r.Close()
+	c := r.Open(10)
+
+	var ret interface{}
+	for i := 0; i < 100; i++ {
+		ret, err = c.Call1("add", 1, 2)
+		if err != ErrNotConnected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil || ret != 3 {
+		t.Fatalf("Call1: %v %v", ret, err)
+	}
+
+	if _, err := c.Call1("sub", 1, 2); err == nil || err.Error() != "function id sub: function not registered" {
+		t.Errorf("Call1: %v", err)
+	}
+	if err := c.Call0("slow"); !errors.Is(err, chanrpc.ErrTimeout) {
+		t.Errorf("Call0: %v", err)
+	}
+
+	other := NewRemoteServer(addr, "login", 10, time.Second)
+	defer other.Close()
+	if err := other.Open(0).Call0("add", 1, 2); err == nil || err.Error() != "server login: not exported" {
+		t.Errorf("Call0: %v", err)
+	}
+}
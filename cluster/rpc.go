@@ -0,0 +1,193 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/log"
+	"sync"
+	"time"
+)
+
+// arguments and return values are gob encoded, types other than
+// the basic ones must be registered with gob.Register
+func init() {
+	gob.Register([]interface{}(nil))
+}
+
+var (
+	ErrNotConnected = errors.New("cluster: node not connected")
+	ErrDisconnected = errors.New("cluster: node disconnected")
+)
+
+var (
+	mu       sync.Mutex
+	exported = make(map[string]*chanrpc.Server)
+	nodes    = make(map[string]*Agent)
+)
+
+// message is a request when Reply is false, Seq is 0 for a request
+// without a reply (Go)
+type message struct {
+	Seq    uint64
+	Server string
+	Req    *chanrpc.Request
+	Reply  bool
+	Ret    interface{}
+	Err    string
+}
+
+// Export makes s callable from other nodes by name
+func Export(name string, s *chanrpc.Server) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := exported[name]; ok {
+		panic(fmt.Sprintf("server %v: already exported", name))
+	}
+	exported[name] = s
+}
+
+func exportedServer(name string) *chanrpc.Server {
+	mu.Lock()
+	defer mu.Unlock()
+	return exported[name]
+}
+
+// NewRemoteServer returns a server whose calls go to the server exported
+// as name on the node at addr, addr must be one of conf.ConnAddrs
+// calls made while the node is not connected return ErrNotConnected
+func NewRemoteServer(addr string, name string, l int, timeout time.Duration) *chanrpc.Server {
+	return chanrpc.NewRemoteServer(&transport{addr: addr, server: name}, l, timeout)
+}
+
+type transport struct {
+	addr   string
+	server string
+}
+
+func (t *transport) Send(req *chanrpc.Request, done func(interface{}, error)) {
+	mu.Lock()
+	a := nodes[t.addr]
+	mu.Unlock()
+
+	if a == nil {
+		if done != nil {
+			done(nil, ErrNotConnected)
+		}
+		return
+	}
+	a.send(t.server, req, done)
+}
+
+func setNode(addr string, a *Agent) {
+	mu.Lock()
+	defer mu.Unlock()
+	nodes[addr] = a
+}
+
+func removeNode(addr string, a *Agent) {
+	mu.Lock()
+	defer mu.Unlock()
+	if nodes[addr] == a {
+		delete(nodes, addr)
+	}
+}
+
+func (a *Agent) send(server string, req *chanrpc.Request, done func(interface{}, error)) {
+	msg := &message{Server: server, Req: req}
+	if done != nil {
+		a.Lock()
+		if a.closed {
+			a.Unlock()
+			done(nil, ErrDisconnected)
+			return
+		}
+		a.seq++
+		msg.Seq = a.seq
+		a.pending[msg.Seq] = done
+		a.Unlock()
+	}
+
+	if err := a.write(msg); err != nil && done != nil {
+		if done = a.take(msg.Seq); done != nil {
+			done(nil, err)
+		}
+	}
+}
+
+func (a *Agent) take(seq uint64) func(interface{}, error) {
+	a.Lock()
+	defer a.Unlock()
+	done := a.pending[seq]
+	delete(a.pending, seq)
+	return done
+}
+
+func (a *Agent) write(msg *message) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		return err
+	}
+	return a.conn.WriteMsg(buf.Bytes())
+}
+
+func (a *Agent) handle(msg *message) {
+	if msg.Reply {
+		done := a.take(msg.Seq)
+		if done == nil {
+			return
+		}
+		var err error
+		if msg.Err != "" {
+			err = errors.New(msg.Err)
+		}
+		done(msg.Ret, err)
+		return
+	}
+
+	s := exportedServer(msg.Server)
+	req := msg.Req
+	if s == nil || req == nil {
+		log.Error("server %v: not exported", msg.Server)
+		if msg.Seq != 0 {
+			a.reply(msg.Seq, nil, fmt.Errorf("server %v: not exported", msg.Server))
+		}
+		return
+	}
+
+	if msg.Seq == 0 {
+		if err := s.Go(req.ID, req.Args...); err != nil {
+			log.Error("%v", err)
+		}
+		return
+	}
+
+	go func() {
+		c := s.Open(0)
+		var ret interface{}
+		var err error
+		switch req.N {
+		case 0:
+			err = c.Call0(req.ID, req.Args...)
+		case 1:
+			ret, err = c.Call1(req.ID, req.Args...)
+		default:
+			ret, err = c.CallN(req.ID, req.Args...)
+		}
+		a.reply(msg.Seq, ret, err)
+	}()
+}
+
+func (a *Agent) reply(seq uint64, ret interface{}, err error) {
+	msg := &message{Seq: seq, Reply: true, Ret: ret}
+	if err != nil {
+		msg.Err = err.Error()
+	}
+	if e := a.write(msg); e != nil {
+		// ret may not be encodable, report that instead
+		log.Error("reply %v: %v", seq, e)
+		a.write(&message{Seq: seq, Reply: true, Err: e.Error()})
+	}
+}
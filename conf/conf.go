@@ -1,8 +1,13 @@
 package conf
 
+import "time"
+
 var (
 	LenStackBuf = 4096
 
+	// module
+	ShutdownTimeout time.Duration // 0 means waiting for every module
+
 	// log
 	LogLevel string
 	LogPath  string
@@ -12,6 +17,11 @@ var (
 	ConsolePrompt string = "Leaf# "
 	ProfilePath   string
 
+	// built-in console commands, "" disables one
+	ConsoleGoCommand     = "go"
+	ConsoleHealthCommand = "health"
+	ConsoleStatsCommand  = "queues"
+
 	// cluster
 	ListenAddr      string
 	ConnAddrs       []string
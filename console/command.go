@@ -58,6 +58,17 @@ func (c *ExternalCommand) run(_args []string) string {
 	return output
 }
 
+// Registered reports whether a command named name is registered
+// goroutine not safe
+func Registered(name string) bool {
+	for _, c := range commands {
+		if c.name() == name {
+			return true
+		}
+	}
+	return false
+}
+
 // you must call the function before calling console.Init
 // goroutine not safe
 func Register(name string, help string, f interface{}, server *chanrpc.Server) {
@@ -76,6 +87,41 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	commands = append(commands, c)
 }
 
+type funcCommand struct {
+	_name string
+	_help string
+	f     func(args []string) string
+}
+
+func (c *funcCommand) name() string {
+	return c._name
+}
+
+func (c *funcCommand) help() string {
+	return c._help
+}
+
+func (c *funcCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// f runs in the console goroutine, so it must be goroutine safe
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+
+	c := new(funcCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
 // help
 type CommandHelp struct{}
 
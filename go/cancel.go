@@ -0,0 +1,36 @@
+package g
+
+import "sync/atomic"
+
+const (
+	jobQueued int32 = iota
+	jobStarted
+	jobCanceled
+)
+
+// Handle cancels an f submitted by GoCancelable
+type Handle struct {
+	j *job
+}
+
+// GoCancelable is Go returning a handle that can cancel f before it starts.
+// f starts at once on its own goroutine unless in pool mode, so canceling
+// is mostly useful with NewPool
+func (g *Go) GoCancelable(f func(), cb func()) (*Handle, error) {
+	j := &job{g: g, f: f, cb: cb}
+	if err := g.submit(j); err != nil {
+		return nil, err
+	}
+	return &Handle{j: j}, nil
+}
+
+// Cancel prevents f from running if it has not started yet and reports
+// whether it did. The callback of a canceled f is never executed, Close
+// still accounts for it. Goroutine safe
+func (h *Handle) Cancel() bool {
+	if !atomic.CompareAndSwapInt32(&h.j.state, jobQueued, jobCanceled) {
+		return false
+	}
+	h.j.g.untrack(h.j)
+	return true
+}
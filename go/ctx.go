@@ -0,0 +1,35 @@
+package g
+
+import "context"
+
+// GoCtx is GoErr for an f taking a context, which is canceled when ctx is
+// or when the Go is closed. When the context is already canceled as f is
+// about to start, f is skipped and cb receives the context error, cb
+// receives nil when f ran
+func (g *Go) GoCtx(ctx context.Context, f func(ctx context.Context), cb func(err error)) error {
+	return g.submit(&job{g: g, fc: f, ctx: ctx, cbe: cb})
+}
+
+// Context returns the context canceled by Close, CloseDiscard and
+// CloseTimeout, the context of GoCtx derives from it
+func (g *Go) Context() context.Context {
+	return g.ctx
+}
+
+func (j *job) runCtx() error {
+	if err := j.ctx.Err(); err != nil {
+		return err
+	}
+	if err := j.g.ctx.Err(); err != nil {
+		return err
+	}
+
+	// no goroutine watching the two contexts
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+	stop := context.AfterFunc(j.g.ctx, cancel)
+	defer stop()
+
+	j.fc(ctx)
+	return nil
+}
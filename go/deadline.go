@@ -0,0 +1,65 @@
+package g
+
+import (
+	"github.com/name5566/leaf/log"
+	"sync/atomic"
+	"time"
+)
+
+// WithDeadline sets the deadline of every f, see GoWithDeadline
+func WithDeadline(d time.Duration) Option {
+	return func(g *Go) {
+		g.deadline = d
+	}
+}
+
+// SetDeadlineHandler sets a function called from a timer goroutine when an
+// f is still running at its deadline, instead of logging an error. When h
+// returns true the f is abandoned: its callback is dropped and Close stops
+// waiting for it, the goroutine keeps running though. f of a LinearContext
+// are never abandoned. Must be called before Go
+func (g *Go) SetDeadlineHandler(h func(name string, elapsed time.Duration) (abandon bool)) {
+	g.onDeadline = h
+}
+
+// GoWithDeadline is Go with a watchdog reporting f when it runs longer
+// than d, d is counted from the start of f
+func (g *Go) GoWithDeadline(d time.Duration, f func(), cb func()) error {
+	return g.submit(&job{g: g, f: f, cb: cb, deadline: d})
+}
+
+// called by the watchdog timer
+func (j *job) expire() {
+	j.g.mu.Lock()
+	elapsed := time.Since(j.started)
+	j.g.mu.Unlock()
+
+	if j.g.onDeadline == nil {
+		log.Error("go %v: still running after %v", j.name, elapsed)
+		return
+	}
+
+	var abandon bool
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("deadline handler: %v", r)
+			}
+		}()
+		abandon = j.g.onDeadline(j.name, elapsed)
+	}()
+	if !abandon || j.linear != nil || !atomic.CompareAndSwapInt32(&j.finished, 0, 1) {
+		return
+	}
+
+	j.g.mu.Lock()
+	j.abandoned = true
+	j.g.mu.Unlock()
+
+	// Cb accounts for the abandoned f as if it had completed
+	if j.ordered != nil {
+		j.ordered.complete(j.seq, nil)
+	} else {
+		j.g.done(nil)
+	}
+}
@@ -0,0 +1,80 @@
+package g
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// JobInfo describes an f that has not finished, see Go.Dump
+type JobInfo struct {
+	Name      string
+	Submitted time.Time
+	Started   time.Time // zero while waiting for a worker
+	Abandoned bool      // past its deadline, Close does not wait for it
+}
+
+func (info JobInfo) String() string {
+	name := info.Name
+	if name == "" {
+		name = "-"
+	}
+	if info.Started.IsZero() {
+		return fmt.Sprintf("%v submitted %v waiting", name, info.Submitted.Format(time.RFC3339))
+	}
+	s := fmt.Sprintf("%v submitted %v running %v", name, info.Submitted.Format(time.RFC3339), time.Since(info.Started))
+	if info.Abandoned {
+		s += " abandoned"
+	}
+	return s
+}
+
+// Pending returns the number of f whose callback has not been executed
+// by Cb yet. Goroutine safe
+func (g *Go) Pending() int {
+	return int(atomic.LoadInt64(&g.pendingGo))
+}
+
+// Running returns the number of f being executed. Goroutine safe
+func (g *Go) Running() int {
+	return int(atomic.LoadInt64(&g.running))
+}
+
+// Dump lists the f that have not finished, oldest first. Goroutine safe
+func (g *Go) Dump() []JobInfo {
+	g.mu.Lock()
+	infos := make([]JobInfo, 0, len(g.jobs))
+	for j := range g.jobs {
+		infos = append(infos, JobInfo{
+			Name:      j.name,
+			Submitted: j.submitted,
+			Started:   j.started,
+			Abandoned: j.abandoned,
+		})
+	}
+	g.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Submitted.Before(infos[j].Submitted)
+	})
+	return infos
+}
+
+// OnCbQueued sets a function called with how long each callback waited in
+// ChanCb, from the goroutine calling Cb right before the callback. Must be
+// called before the first Go
+func (g *Go) OnCbQueued(h func(wait time.Duration)) {
+	g.onQueued = h
+}
+
+// wraps cb to report its time in ChanCb
+func (g *Go) stamp(cb func()) func() {
+	queued := time.Now()
+	return func() {
+		g.onQueued(time.Since(queued))
+		if cb != nil {
+			cb()
+		}
+	}
+}
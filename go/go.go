@@ -2,21 +2,80 @@ package g
 
 import (
 	"container/list"
+	"context"
+	"fmt"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
 	"runtime"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // one Go per goroutine (goroutine not safe)
 type Go struct {
-	ChanCb    chan func()
-	pendingGo int
+	ChanCb     chan func()
+	pendingGo  int64 // accessed atomically
+	running    int64 // accessed atomically
+	mu         sync.Mutex
+	jobs       map[*job]struct{} // f not finished yet
+	pool       *pool             // nil for a goroutine per f
+	reuse      *reuse            // nil unless WithKeepAlive
+	keepAlive  time.Duration
+	maxIdle    int
+	deadline   time.Duration
+	onDeadline func(name string, elapsed time.Duration) bool
+	onPanic    func(stage string, recovered interface{}, stack []byte)
+	abandoned  chan struct{} // closed when CloseTimeout stops waiting
+	cmu        sync.RWMutex  // closed and the pendingGo increments of submissions
+	closed     bool
+	wake       chan struct{} // a submission failed after counting as pending
+	onDropped  func(cb func())
+	onQueued   func(wait time.Duration)
+	ctx        context.Context // canceled by Close
+	cancel     context.CancelFunc
+}
+
+// stages passed to the panic handler
+const (
+	StageF  = "f"  // the background f, on its own goroutine
+	StageCb = "cb" // the callback, on the goroutine owning the Go
+)
+
+// a submitted f and its callback, f is one of f, fe and fr
+type job struct {
+	g         *Go
+	name      string
+	submitted time.Time
+	started   time.Time // zero while waiting for a worker
+	state     int32     // jobQueued, jobStarted or jobCanceled, accessed atomically
+	ordered   *OrderedContext
+	seq       uint64 // submission order in ordered
+	linear    *LinearContext
+	deadline  time.Duration // 0 for the default of the Go
+	finished  int32         // set once the callback is delivered or abandoned
+	abandoned bool          // guarded by g.mu
+	f         func()
+	fe        func() error
+	fr        func() (interface{}, error)
+	fc        func(context.Context)
+	ctx       context.Context // of fc
+	cb        func()
+	cbe       func(error)
+	cbr       func(interface{}, error)
+	ret       interface{}
+	err       error
+}
+
+// executed by a goroutine or a pool worker
+type runner interface {
+	run()
+	skip() // instead of run if the pool abandons it
 }
 
 type LinearGo struct {
-	f  func()
-	cb func()
+	job
 }
 
 type LinearContext struct {
@@ -24,46 +83,262 @@ type LinearContext struct {
 	linearGo       *list.List
 	mutexLinearGo  sync.Mutex
 	mutexExecution sync.Mutex
+	abort          bool
+	onComplete     func(err error, executed int)
+	err            error // first error of the chain, guarded by mutexExecution
+	executed       int   // guarded by mutexExecution
 }
 
-func New(l int) *Go {
+func New(l int, opts ...Option) *Go {
 	g := new(Go)
 	g.ChanCb = make(chan func(), l)
+	g.abandoned = make(chan struct{})
+	g.wake = make(chan struct{}, 1)
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.jobs = make(map[*job]struct{})
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.keepAlive > 0 {
+		g.reuse = newReuse(g.keepAlive, g.maxIdle)
+	}
 	return g
 }
 
-func (g *Go) Go(f func(), cb func()) {
-	g.pendingGo++
+// returns an error only in pool mode, see NewPool
+func (g *Go) Go(f func(), cb func()) error {
+	return g.submit(&job{g: g, f: f, cb: cb})
+}
+
+// GoNamed is Go with a name shown by Dump
+func (g *Go) GoNamed(name string, f func(), cb func()) error {
+	return g.submit(&job{g: g, name: name, f: f, cb: cb})
+}
+
+// GoErr is Go for an f returning an error, which is passed to cb
+func (g *Go) GoErr(f func() error, cb func(error)) error {
+	return g.submit(&job{g: g, fe: f, cbe: cb})
+}
+
+// GoResult is Go for an f returning a result, which is passed to cb
+func (g *Go) GoResult(f func() (interface{}, error), cb func(interface{}, error)) error {
+	return g.submit(&job{g: g, fr: f, cbr: cb})
+}
+
+func (g *Go) submit(j *job) error {
+	g.track(j)
+	err := g.start(j)
+	if err != nil {
+		g.untrack(j)
+	}
+	return err
+}
+
+// counts r as pending and executes it, ErrClosed after Close. Counting
+// first means a Close running concurrently waits for r
+func (g *Go) start(r runner) error {
+	g.cmu.RLock()
+	if g.closed {
+		g.cmu.RUnlock()
+		return ErrClosed
+	}
+	atomic.AddInt64(&g.pendingGo, 1)
+	g.cmu.RUnlock()
+
+	err := g.exec(r)
+	if err == nil {
+		return nil
+	}
+
+	g.cmu.RLock()
+	if !g.closed { // not behind CloseTimeout, which reset pendingGo
+		atomic.AddInt64(&g.pendingGo, -1)
+		select {
+		case g.wake <- struct{}{}: // Close may be waiting for r
+		default:
+		}
+	}
+	g.cmu.RUnlock()
+	return err
+}
+
+func (g *Go) isClosed() bool {
+	g.cmu.RLock()
+	defer g.cmu.RUnlock()
+	return g.closed
+}
+
+// marks g closed if nothing is pending, a callback executed while closing
+// may still call Go
+func (g *Go) seal() bool {
+	g.cmu.Lock()
+	defer g.cmu.Unlock()
+	if g.Pending() > 0 {
+		return false
+	}
+	g.closed = true
+	return true
+}
+
+// the next callback, ok is false when a failed submission woke the caller
+func (g *Go) wait() (cb func(), ok bool) {
+	select {
+	case cb = <-g.ChanCb:
+		return cb, true
+	case <-g.wake:
+		return nil, false
+	}
+}
+
+func (g *Go) track(j *job) {
+	j.submitted = time.Now()
+	g.mu.Lock()
+	g.jobs[j] = struct{}{}
+	g.mu.Unlock()
+}
+
+func (g *Go) untrack(j *job) {
+	g.mu.Lock()
+	delete(g.jobs, j)
+	g.mu.Unlock()
+}
+
+func (j *job) run() {
+	if !atomic.CompareAndSwapInt32(&j.state, jobQueued, jobStarted) {
+		j.skip() // canceled
+		return
+	}
+
+	j.g.mu.Lock()
+	j.started = time.Now()
+	j.g.mu.Unlock()
+	atomic.AddInt64(&j.g.running, 1)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if j.linear != nil && j.err == nil {
+				j.err = fmt.Errorf("panic: %v", r)
+			}
+			j.g.handlePanic(StageF, j.name, r)
+		}
+		atomic.AddInt64(&j.g.running, -1)
+		j.g.untrack(j)
+		j.finish(j.callback()) // after the handler, so that Close waits for it
+	}()
+
+	if d := j.deadline; d > 0 || j.g.deadline > 0 {
+		if d == 0 {
+			d = j.g.deadline
+		}
+		t := time.AfterFunc(d, j.expire)
+		defer t.Stop()
+	}
+
+	switch {
+	case j.f != nil:
+		j.f()
+	case j.fe != nil:
+		j.err = j.fe()
+	case j.fc != nil:
+		j.err = j.runCtx()
+	default:
+		j.ret, j.err = j.fr()
+	}
+}
+
+// the callback delivered to ChanCb
+func (j *job) callback() func() {
+	switch {
+	case j.cbe != nil:
+		return j.doneErr
+	case j.cbr != nil:
+		return j.doneResult
+	}
+	return j.cb
+}
 
-	go func() {
+func (j *job) doneErr() {
+	j.cbe(j.err)
+}
+
+func (j *job) doneResult() {
+	j.cbr(j.ret, j.err)
+}
+
+func (j *job) skip() {
+	j.g.untrack(j)
+	j.finish(nil)
+}
+
+func (j *job) finish(cb func()) {
+	if !atomic.CompareAndSwapInt32(&j.finished, 0, 1) {
+		return // abandoned by the watchdog
+	}
+	if j.linear != nil {
+		cb = j.linear.stepDone(j, cb)
+	}
+	if j.ordered != nil {
+		j.ordered.complete(j.seq, cb)
+		return
+	}
+	j.g.done(cb)
+}
+
+// runs r on a new goroutine, or on a worker in pool mode
+func (g *Go) exec(r runner) error {
+	switch {
+	case g.pool != nil:
+		return g.pool.submit(r)
+	case g.reuse != nil:
+		g.reuse.exec(r)
+	default:
+		go r.run()
+	}
+	return nil
+}
+
+// SetPanicHandler sets a function called after a panic in f or cb is
+// logged, it is called from the goroutine that panicked so it must be
+// goroutine safe when f may panic. The callback of a panicking f is still
+// delivered, after h returns. Must be called before Go
+func (g *Go) SetPanicHandler(h func(stage string, recovered interface{}, stack []byte)) {
+	g.onPanic = h
+}
+
+func (g *Go) handlePanic(stage string, name string, r interface{}) {
+	msg := fmt.Sprint(r)
+	if name != "" {
+		msg = name + ": " + msg
+	}
+
+	var stack []byte
+	if conf.LenStackBuf > 0 {
+		buf := make([]byte, conf.LenStackBuf)
+		l := runtime.Stack(buf, false)
+		stack = buf[:l]
+		log.Error("%v: %s", msg, stack)
+	} else {
+		log.Error("%v", msg)
+		if g.onPanic != nil {
+			stack = debug.Stack()
+		}
+	}
+
+	if g.onPanic != nil {
 		defer func() {
-			g.ChanCb <- cb
 			if r := recover(); r != nil {
-				if conf.LenStackBuf > 0 {
-					buf := make([]byte, conf.LenStackBuf)
-					l := runtime.Stack(buf, false)
-					log.Error("%v: %s", r, buf[:l])
-				} else {
-					log.Error("%v", r)
-				}
+				log.Error("panic handler: %v", r)
 			}
 		}()
-
-		f()
-	}()
+		g.onPanic(stage, r, stack)
+	}
 }
 
 func (g *Go) Cb(cb func()) {
 	defer func() {
-		g.pendingGo--
+		atomic.AddInt64(&g.pendingGo, -1)
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
-			}
+			g.handlePanic(StageCb, "", r)
 		}
 	}()
 
@@ -72,10 +347,125 @@ func (g *Go) Cb(cb func()) {
 	}
 }
 
+// delivers cb to ChanCb, or drops it if CloseTimeout stopped waiting
+func (g *Go) done(cb func()) {
+	c := cb
+	if g.onQueued != nil {
+		c = g.stamp(cb)
+	}
+	select {
+	case g.ChanCb <- c:
+	case <-g.abandoned:
+		g.dropped(cb)
+	}
+}
+
+// OnDroppedCb sets a function called with each callback that is dropped
+// instead of executed, by CloseDiscard or after CloseTimeout stops
+// waiting. In the latter case it is called from the goroutine of the f,
+// so it must be goroutine safe. Must be called before Close
+func (g *Go) OnDroppedCb(h func(cb func())) {
+	g.onDropped = h
+}
+
+func (g *Go) dropped(cb func()) {
+	if cb == nil || g.onDropped == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("dropped callback handler: %v", r)
+		}
+	}()
+	g.onDropped(cb)
+}
+
+// waits for all the f and executes their callbacks, Go returns ErrClosed
+// afterwards. Calling Close again does nothing. Goroutine safe with respect
+// to Go: an f submitted while closing is waited for, or Go returns ErrClosed
 func (g *Go) Close() {
-	for g.pendingGo > 0 {
-		g.Cb(<-g.ChanCb)
+	if g.isClosed() {
+		return
+	}
+	g.cancel()
+	for !g.seal() {
+		if cb, ok := g.wait(); ok {
+			g.Cb(cb)
+		}
 	}
+	g.stop()
+}
+
+// CloseDiscard is Close, except that the callbacks are dropped instead of
+// executed and passed to the OnDroppedCb handler. It returns the number of
+// callbacks dropped. Goroutine safe with respect to Go like Close
+func (g *Go) CloseDiscard() int {
+	if g.isClosed() {
+		return 0
+	}
+	g.cancel()
+	n := 0
+	for !g.seal() {
+		cb, ok := g.wait()
+		if !ok {
+			continue
+		}
+		atomic.AddInt64(&g.pendingGo, -1)
+		if cb != nil {
+			n++
+			g.dropped(cb)
+		}
+	}
+	g.stop()
+	return n
+}
+
+// stops the workers once nothing is pending
+func (g *Go) stop() {
+	if g.pool != nil {
+		g.pool.close()
+	}
+	if g.reuse != nil {
+		g.reuse.close()
+	}
+}
+
+// CloseTimeout is Close, except that it stops waiting after d and returns
+// the number of f still outstanding. Their callbacks are dropped when they
+// complete, and the f still waiting for a worker in pool mode are dropped.
+// Goroutine safe with respect to Go: once it stops waiting, a Go blocked on
+// a full pool queue returns ErrClosed
+func (g *Go) CloseTimeout(d time.Duration) int {
+	if g.isClosed() {
+		return 0
+	}
+	g.cancel()
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	for !g.seal() {
+		select {
+		case cb := <-g.ChanCb:
+			g.Cb(cb)
+		case <-g.wake:
+		case <-t.C:
+			g.cmu.Lock()
+			g.closed = true
+			n := int(atomic.SwapInt64(&g.pendingGo, 0))
+			g.cmu.Unlock()
+			close(g.abandoned)
+			if g.pool != nil {
+				g.pool.abandon()
+			}
+			if g.reuse != nil {
+				g.reuse.close()
+			}
+			return n
+		}
+	}
+
+	g.stop()
+	return 0
 }
 
 func (g *Go) NewLinearContext() *LinearContext {
@@ -85,34 +475,117 @@ func (g *Go) NewLinearContext() *LinearContext {
 	return c
 }
 
-func (c *LinearContext) Go(f func(), cb func()) {
-	c.g.pendingGo++
+func (c *LinearContext) Go(f func(), cb func()) error {
+	return c.submit(&LinearGo{job{g: c.g, f: f, cb: cb}})
+}
 
+// GoErr is Go for an f returning an error, which is passed to cb
+func (c *LinearContext) GoErr(f func() error, cb func(error)) error {
+	return c.submit(&LinearGo{job{g: c.g, fe: f, cbe: cb}})
+}
+
+// GoResult is Go for an f returning a result, which is passed to cb
+func (c *LinearContext) GoResult(f func() (interface{}, error), cb func(interface{}, error)) error {
+	return c.submit(&LinearGo{job{g: c.g, fr: f, cbr: cb}})
+}
+
+// GoStep is GoErr with a step name, shown by Dump, in the panic log and
+// in the error passed to OnComplete. A panic in f is an error of the step
+func (c *LinearContext) GoStep(name string, f func() error, cb func(error)) error {
+	return c.submit(&LinearGo{job{g: c.g, name: name, fe: f, cbe: cb}})
+}
+
+// SetAbortOnError makes a failing or panicking f skip the f queued after
+// it, their callbacks are not executed. Must be called before Go
+func (c *LinearContext) SetAbortOnError(abort bool) {
+	c.abort = abort
+}
+
+// OnComplete sets a callback executed after the callback of the last
+// queued f, that is whenever the chain drains. It receives the first
+// error of the chain and the number of f executed, skipped ones not
+// included, then a new chain starts. Must be called before Go
+func (c *LinearContext) OnComplete(cb func(err error, executed int)) {
+	c.onComplete = cb
+}
+
+func (c *LinearContext) submit(e *LinearGo) error {
+	e.linear = c
+	c.g.track(&e.job)
 	c.mutexLinearGo.Lock()
-	c.linearGo.PushBack(&LinearGo{f: f, cb: cb})
+	c.linearGo.PushBack(e)
 	c.mutexLinearGo.Unlock()
 
-	go func() {
-		c.mutexExecution.Lock()
-		defer c.mutexExecution.Unlock()
-
+	if err := c.g.start(c); err != nil {
 		c.mutexLinearGo.Lock()
-		e := c.linearGo.Remove(c.linearGo.Front()).(*LinearGo)
+		c.linearGo.Remove(c.linearGo.Back())
 		c.mutexLinearGo.Unlock()
+		c.g.untrack(&e.job)
+		return err
+	}
+	return nil
+}
 
-		defer func() {
-			c.g.ChanCb <- e.cb
-			if r := recover(); r != nil {
-				if conf.LenStackBuf > 0 {
-					buf := make([]byte, conf.LenStackBuf)
-					l := runtime.Stack(buf, false)
-					log.Error("%v: %s", r, buf[:l])
-				} else {
-					log.Error("%v", r)
-				}
+// runs the first f, each run matches one submit
+func (c *LinearContext) run() {
+	c.mutexExecution.Lock()
+	defer c.mutexExecution.Unlock()
+
+	c.mutexLinearGo.Lock()
+	e := c.linearGo.Remove(c.linearGo.Front()).(*LinearGo)
+	c.mutexLinearGo.Unlock()
+
+	if c.abort && c.err != nil {
+		e.skip()
+		return
+	}
+	e.run()
+}
+
+// records the result of e and appends the completion to cb when the
+// chain drains, called with mutexExecution held
+func (c *LinearContext) stepDone(e *job, cb func()) func() {
+	if atomic.LoadInt32(&e.state) != jobStarted {
+		cb = nil
+	} else {
+		c.executed++
+		if e.err != nil && c.err == nil {
+			c.err = e.err
+			if e.name != "" {
+				c.err = fmt.Errorf("step %v: %w", e.name, e.err)
 			}
-		}()
+		}
+	}
 
-		e.f()
-	}()
+	c.mutexLinearGo.Lock()
+	drained := c.linearGo.Len() == 0
+	c.mutexLinearGo.Unlock()
+	if !drained {
+		return cb
+	}
+
+	err, executed := c.err, c.executed
+	c.err, c.executed = nil, 0
+	if c.onComplete == nil {
+		return cb
+	}
+	onComplete := c.onComplete
+	return func() {
+		if cb != nil {
+			cb()
+		}
+		onComplete(err, executed)
+	}
+}
+
+// drops the first f, keeping the others in order
+func (c *LinearContext) skip() {
+	c.mutexExecution.Lock()
+	defer c.mutexExecution.Unlock()
+
+	c.mutexLinearGo.Lock()
+	e := c.linearGo.Remove(c.linearGo.Front()).(*LinearGo)
+	c.mutexLinearGo.Unlock()
+
+	e.skip()
 }
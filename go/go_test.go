@@ -0,0 +1,674 @@
+package g
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// executes the callbacks like Close, but d can still be used
+func drain(d *Go) {
+	for d.Pending() > 0 {
+		d.Cb(<-d.ChanCb)
+	}
+}
+
+func TestPool(t *testing.T) {
+	d := NewPool(100, 3)
+	var running, max int32
+	for i := 0; i < 30; i++ {
+		d.Go(func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}, nil)
+	}
+	d.Close()
+	if max > 3 || max == 0 {
+		t.Errorf("%v workers running at once", max)
+	}
+	if err := d.Go(func() {}, nil); err != ErrClosed {
+		t.Errorf("Go after Close: %v", err)
+	}
+}
+
+func TestPoolOverflowError(t *testing.T) {
+	d := NewPool(10, 1, WithQueueLen(1), WithOverflowError())
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.Go(func() {
+		close(started)
+		<-release
+	}, nil)
+	<-started
+	if err := d.Go(func() {}, nil); err != nil {
+		t.Errorf("queued: %v", err)
+	}
+	if err := d.Go(func() {}, nil); err != ErrQueueFull {
+		t.Errorf("overflow: %v", err)
+	}
+	close(release)
+	d.Close()
+}
+
+func TestPoolCloseAbandon(t *testing.T) {
+	d := NewPool(10, 1, WithQueueLen(5))
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var ran, cbs int
+	d.Go(func() {
+		close(started)
+		<-release
+	}, func() {
+		cbs++
+	})
+	<-started
+	for i := 0; i < 3; i++ {
+		d.Go(func() {
+			ran++
+		}, func() {
+			cbs++
+		})
+	}
+	c := d.NewLinearContext()
+	c.Go(func() {
+		ran++
+	}, func() {
+		cbs++
+	})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	d.CloseAbandon()
+	if ran != 0 || cbs != 1 || d.Pending() != 0 {
+		t.Errorf("ran %v, callbacks %v, pending %v", ran, cbs, d.Pending())
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	for _, d := range []*Go{New(10), NewPool(10, 2)} {
+		var stages []string
+		panics := make(chan string, 10)
+		d.SetPanicHandler(func(stage string, r interface{}, stack []byte) {
+			if len(stack) == 0 {
+				t.Error("no stack")
+			}
+			panics <- stage + ":" + r.(string)
+		})
+
+		cbs := 0
+		d.Go(func() {
+			panic("load")
+		}, func() {
+			cbs++
+		})
+		d.Go(func() {}, func() {
+			panic("apply")
+		})
+		d.NewLinearContext().Go(func() {
+			panic("save")
+		}, func() {
+			cbs++
+		})
+		d.Close()
+
+		close(panics)
+		for p := range panics {
+			stages = append(stages, p)
+		}
+		if cbs != 2 || d.Pending() != 0 || len(stages) != 3 {
+			t.Errorf("callbacks %v, pending %v, panics %v", cbs, d.Pending(), stages)
+		}
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	for _, d := range []*Go{New(0), NewPool(0, 1, WithQueueLen(2))} {
+		stuck := make(chan struct{})
+		called := false
+		d.Go(func() {}, func() {})
+		d.Go(func() {
+			<-stuck
+		}, func() {
+			called = true
+		})
+		d.Go(func() {}, func() {})
+
+		n := d.CloseTimeout(20 * time.Millisecond)
+		if d.pool == nil && n != 1 || d.pool != nil && n != 2 {
+			t.Errorf("%v outstanding", n)
+		}
+		close(stuck) // the late callback is dropped, not blocking on ChanCb
+		time.Sleep(10 * time.Millisecond)
+		if called {
+			t.Error("late callback executed")
+		}
+	}
+
+	d := New(1)
+	d.Go(func() {}, nil)
+	if n := d.CloseTimeout(time.Second); n != 0 {
+		t.Errorf("%v outstanding", n)
+	}
+}
+
+func TestCloseTimeoutSubmitting(t *testing.T) {
+	d := NewPool(100, 1, WithQueueLen(0))
+	stuck := make(chan struct{})
+	defer close(stuck)
+	d.Go(func() {
+		<-stuck
+	}, nil)
+
+	errC := make(chan error)
+	go func() {
+		errC <- d.Go(func() {}, nil) // blocks, the only worker is busy
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if n := d.CloseTimeout(time.Millisecond); n != 2 {
+		t.Errorf("%v outstanding", n)
+	}
+	if err := <-errC; err != ErrClosed {
+		t.Errorf("blocked Go: %v", err)
+	}
+	if err := d.Go(func() {}, nil); err != ErrClosed {
+		t.Errorf("Go after close: %v", err)
+	}
+}
+
+func TestGoResult(t *testing.T) {
+	d := New(10)
+	var errs []error
+	var rets []interface{}
+	d.GoErr(func() error {
+		return ErrQueueFull
+	}, func(err error) {
+		errs = append(errs, err)
+	})
+	d.GoResult(func() (interface{}, error) {
+		return 42, nil
+	}, func(ret interface{}, err error) {
+		rets = append(rets, ret)
+		errs = append(errs, err)
+	})
+	d.GoErr(func() error { return nil }, nil)
+
+	c := d.NewLinearContext()
+	c.GoResult(func() (interface{}, error) {
+		return "first", nil
+	}, func(ret interface{}, err error) {
+		rets = append(rets, ret)
+	})
+	c.GoErr(func() error {
+		panic("second")
+	}, func(err error) {
+		errs = append(errs, err)
+	})
+	d.Close()
+
+	if len(rets) != 2 || rets[0] != 42 && rets[1] != 42 || len(errs) != 3 {
+		t.Errorf("results %v, errors %v", rets, errs)
+	}
+}
+
+func TestPendingRunningDump(t *testing.T) {
+	d := New(10)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.GoNamed("slow", func() {
+		close(started)
+		<-release
+	}, nil)
+	<-started
+	d.Go(func() {
+		panic("boom")
+	}, nil)
+	d.Cb(<-d.ChanCb)
+
+	infos := d.Dump()
+	if d.Pending() != 1 || d.Running() != 1 || len(infos) != 1 || infos[0].Name != "slow" || infos[0].Started.IsZero() {
+		t.Errorf("pending %v, running %v, dump %v", d.Pending(), d.Running(), infos)
+	}
+
+	close(release)
+	d.Close()
+	if d.Pending() != 0 || d.Running() != 0 || len(d.Dump()) != 0 {
+		t.Errorf("pending %v, running %v, dump %v", d.Pending(), d.Running(), d.Dump())
+	}
+}
+
+func TestCancel(t *testing.T) {
+	d := NewPool(10, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.Go(func() {
+		close(started)
+		<-release
+	}, nil)
+	<-started
+
+	var ran, cbs int
+	h, _ := d.GoCancelable(func() {
+		ran++
+	}, func() {
+		cbs++
+	})
+	if !h.Cancel() || h.Cancel() || len(d.Dump()) != 1 {
+		t.Errorf("cancel a queued f")
+	}
+	close(release)
+	d.Close()
+	if ran != 0 || cbs != 0 || d.Pending() != 0 {
+		t.Errorf("ran %v, callbacks %v, pending %v", ran, cbs, d.Pending())
+	}
+
+	d = New(10)
+	h, _ = d.GoCancelable(func() {
+		ran++
+	}, func() {
+		cbs++
+	})
+	d.Cb(<-d.ChanCb)
+	if h.Cancel() || ran != 1 || cbs != 1 {
+		t.Errorf("cancel a finished f: ran %v, callbacks %v", ran, cbs)
+	}
+	d.Close()
+}
+
+func TestOrderedContext(t *testing.T) {
+	d := New(10)
+	c := d.NewOrderedContext(3)
+	release := make(chan struct{})
+	var order []int
+	c.Go(func() {
+		<-release
+	}, func() {
+		order = append(order, 1)
+	})
+	c.GoErr(func() error {
+		panic("second")
+	}, func(error) {
+		order = append(order, 2)
+	})
+	c.Go(func() {}, func() {
+		order = append(order, 3)
+	})
+	if err := c.Go(func() {}, nil); err != ErrReorderFull {
+		t.Errorf("submit beyond max: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-d.ChanCb:
+		t.Errorf("callback delivered before the first f finished")
+	default:
+	}
+	close(release)
+	d.Close()
+
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 || c.Outstanding() != 0 {
+		t.Errorf("order %v, outstanding %v", order, c.Outstanding())
+	}
+}
+
+func TestLinearSteps(t *testing.T) {
+	d := New(10)
+	c := d.NewLinearContext()
+	c.SetAbortOnError(true)
+	var steps []string
+	var completions []string
+	c.OnComplete(func(err error, executed int) {
+		completions = append(completions, fmt.Sprintf("%v %v", err, executed))
+	})
+
+	release := make(chan struct{})
+	c.GoStep("load", func() error {
+		<-release
+		return nil
+	}, func(err error) {
+		steps = append(steps, "load")
+	})
+	c.GoStep("parse", func() error {
+		panic("bad chunk")
+	}, func(err error) {
+		steps = append(steps, "parse")
+	})
+	c.Go(func() {
+		steps = append(steps, "skipped f")
+	}, func() {
+		steps = append(steps, "skipped cb")
+	})
+	close(release)
+	drain(d)
+
+	c.Go(func() {}, func() {
+		steps = append(steps, "next")
+	})
+	d.Close()
+
+	if strings.Join(steps, ",") != "load,parse,next" ||
+		len(completions) != 2 || completions[0] != "step parse: panic: bad chunk 2" || completions[1] != "<nil> 1" {
+		t.Errorf("steps %v, completions %v", steps, completions)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	d := New(10, WithKeepAlive(time.Second), WithMaxIdleWorkers(2))
+	var n int32
+	for i := 0; i < 100; i++ {
+		d.Go(func() {
+			atomic.AddInt32(&n, 1)
+		}, nil)
+		d.Cb(<-d.ChanCb)
+	}
+	if n != 100 || d.Pending() != 0 {
+		t.Errorf("ran %v, pending %v", n, d.Pending())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if idle := atomic.LoadInt32(&d.reuse.idleCount); idle < 1 || idle > 2 {
+		t.Errorf("idle workers %v", idle)
+	}
+	d.Close()
+}
+
+func TestDeadline(t *testing.T) {
+	d := New(10, WithDeadline(time.Hour))
+	expired := make(chan string, 1)
+	d.SetDeadlineHandler(func(name string, elapsed time.Duration) bool {
+		expired <- name
+		return true
+	})
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	var cbs int
+	d.GoNamed("fast", func() {}, func() {
+		cbs++
+	})
+	d.GoWithDeadline(10*time.Millisecond, func() {
+		defer close(finished)
+		<-release
+	}, func() {
+		cbs++
+	})
+	d.Close()
+
+	if <-expired != "" || cbs != 1 || d.Pending() != 0 || len(d.Dump()) != 1 || !d.Dump()[0].Abandoned {
+		t.Errorf("callbacks %v, pending %v, dump %v", cbs, d.Pending(), d.Dump())
+	}
+	close(release)
+	<-finished
+	time.Sleep(10 * time.Millisecond)
+	if len(d.ChanCb) != 0 || d.Pending() != 0 || len(d.Dump()) != 0 {
+		t.Errorf("abandoned f completed: pending %v, dump %v", d.Pending(), d.Dump())
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	d := New(10)
+	var results []string
+	onDone := func(ret interface{}, err error) {
+		results = append(results, fmt.Sprintf("%v %v", ret, err))
+	}
+	add := func(n int) Stage {
+		return func(in interface{}) (interface{}, error) {
+			v, _ := in.(int)
+			return v + n, nil
+		}
+	}
+
+	d.Pipeline().Bg(add(1)).Fg(add(10)).Bg(add(100)).OnDone(onDone).Run()
+	drain(d)
+
+	var ran bool
+	d.Pipeline().Bg(add(1)).Fg(func(interface{}) (interface{}, error) {
+		panic("transform")
+	}).Bg(func(in interface{}) (interface{}, error) {
+		ran = true
+		return in, nil
+	}).OnDone(onDone).Run()
+	drain(d)
+
+	release := make(chan struct{})
+	p := d.Pipeline().Bg(func(interface{}) (interface{}, error) {
+		<-release
+		return 1, nil
+	}).Fg(func(in interface{}) (interface{}, error) {
+		ran = true
+		return in, nil
+	}).OnDone(onDone)
+	p.Run()
+	p.Cancel()
+	close(release)
+	d.Close()
+
+	if ran || len(results) != 3 || results[0] != "111 <nil>" ||
+		results[1] != "<nil> pipeline stage 1: panic: transform" || results[2] != "<nil> "+ErrPipelineCanceled.Error() {
+		t.Errorf("ran %v, results %q", ran, results)
+	}
+}
+
+func TestCloseDiscard(t *testing.T) {
+	d := New(10)
+	var dropped []func()
+	d.OnDroppedCb(func(cb func()) {
+		dropped = append(dropped, cb)
+	})
+	var cbs int
+	for i := 0; i < 3; i++ {
+		d.Go(func() {}, func() {
+			cbs++
+		})
+	}
+	d.Go(func() {}, nil)
+
+	if n := d.CloseDiscard(); n != 3 || len(dropped) != 3 || cbs != 0 || d.Pending() != 0 {
+		t.Errorf("dropped %v, handled %v, callbacks %v, pending %v", n, len(dropped), cbs, d.Pending())
+	}
+	if err := d.Go(func() {}, nil); err != ErrClosed {
+		t.Errorf("Go after close: %v", err)
+	}
+	d.Close()
+	if d.CloseDiscard() != 0 || d.CloseTimeout(time.Second) != 0 {
+		t.Errorf("close twice")
+	}
+	dropped[0]()
+	if cbs != 1 {
+		t.Errorf("compensating callback not executed")
+	}
+}
+
+func TestCloseWhileSubmitting(t *testing.T) {
+	closes := map[string]func(d *Go) int{
+		"Close": func(d *Go) int {
+			d.Close()
+			return 0
+		},
+		"CloseDiscard": func(d *Go) int {
+			return d.CloseDiscard()
+		},
+		"CloseTimeout": func(d *Go) int {
+			return d.CloseTimeout(time.Second)
+		},
+	}
+	for name, closeGo := range closes {
+		// ChanCb holds every callback in pool mode, a callback blocking on a
+		// full queue while the workers wait for ChanCb deadlocks
+		for _, d := range []*Go{New(10), NewPool(500, 2, WithQueueLen(1)), New(10, WithKeepAlive(time.Second))} {
+			var ran, accepted, dropped int32
+			d.OnDroppedCb(func(cb func()) {
+				atomic.AddInt32(&dropped, 1)
+			})
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						if d.Go(func() {}, func() {
+							atomic.AddInt32(&ran, 1)
+						}) == nil {
+							atomic.AddInt32(&accepted, 1)
+						}
+					}
+				}()
+			}
+			accepted++
+			d.Go(func() {}, func() {
+				atomic.AddInt32(&ran, 1)
+				// a callback may submit more work while closing
+				if d.Go(func() {}, nil) != nil {
+					t.Errorf("%v: Go from a callback while closing", name)
+				}
+			})
+			n := closeGo(d)
+			wg.Wait()
+			if int(dropped) != n || ran+dropped != accepted || d.Pending() != 0 {
+				t.Errorf("%v: accepted %v, callbacks %v, dropped %v of %v, pending %v", name, accepted, ran, dropped, n, d.Pending())
+			}
+		}
+	}
+}
+
+func TestCloseTimeoutWhileSubmitting(t *testing.T) {
+	for _, d := range []*Go{New(0), NewPool(0, 1, WithQueueLen(0)), New(0, WithKeepAlive(time.Second))} {
+		stuck := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					if d.Go(func() {
+						<-stuck
+					}, nil) == ErrClosed {
+						return
+					}
+				}
+			}()
+		}
+		time.Sleep(5 * time.Millisecond)
+		if n := d.CloseTimeout(time.Millisecond); n == 0 {
+			t.Errorf("nothing outstanding")
+		}
+		wg.Wait() // every blocked Go returned
+		close(stuck)
+		for d.Running() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if err := d.Go(func() {}, nil); err != ErrClosed {
+			t.Errorf("Go after close: %v", err)
+		}
+	}
+}
+
+func TestGoCtx(t *testing.T) {
+	d := NewPool(10, 1)
+	release := make(chan struct{})
+	d.Go(func() {
+		<-release
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran bool
+	var errs []error
+	d.GoCtx(ctx, func(context.Context) {
+		ran = true
+	}, func(err error) {
+		errs = append(errs, err)
+	})
+	cancel()
+	close(release)
+	drain(d)
+
+	started := make(chan struct{})
+	d.GoCtx(context.Background(), func(ctx context.Context) {
+		close(started)
+		<-ctx.Done() // canceled by Close
+	}, func(err error) {
+		errs = append(errs, err)
+	})
+	<-started
+	d.Close()
+
+	if ran || len(errs) != 2 || errs[0] != context.Canceled || errs[1] != nil || d.Context().Err() == nil {
+		t.Errorf("ran %v, errors %v", ran, errs)
+	}
+}
+
+func BenchmarkGo(b *testing.B) {
+	d := New(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.Go(func() {}, nil)
+		d.Cb(<-d.ChanCb)
+	}
+}
+
+func BenchmarkGoResult(b *testing.B) {
+	d := New(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.GoResult(func() (interface{}, error) { return nil, nil }, func(interface{}, error) {})
+		d.Cb(<-d.ChanCb)
+	}
+}
+
+// a 1 microsecond f, batches of 50 are in flight as with 50k f per second
+// finishing within a millisecond
+func benchmarkTiny(b *testing.B, d *Go) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.Go(func() {
+			for t := time.Now(); time.Since(t) < time.Microsecond; {
+			}
+		}, nil)
+		if d.Pending() >= 50 {
+			d.Cb(<-d.ChanCb)
+		}
+	}
+	d.Close()
+}
+
+func BenchmarkGoTiny(b *testing.B) {
+	benchmarkTiny(b, New(100))
+}
+
+func BenchmarkGoTinyKeepAlive(b *testing.B) {
+	benchmarkTiny(b, New(100, WithKeepAlive(time.Second)))
+}
+
+func TestOnCbQueued(t *testing.T) {
+	d := New(10)
+	var waits []time.Duration
+	d.OnCbQueued(func(wait time.Duration) {
+		waits = append(waits, wait)
+	})
+	var cbs int
+	d.Go(func() {}, func() {
+		cbs++
+	})
+	d.Go(func() {}, nil)
+	time.Sleep(20 * time.Millisecond)
+	d.Close()
+	if len(waits) != 2 || cbs != 1 {
+		t.Fatalf("waits %v, callbacks %v", waits, cbs)
+	}
+	for _, w := range waits {
+		if w < 20*time.Millisecond {
+			t.Errorf("wait %v, want at least 20ms", w)
+		}
+	}
+}
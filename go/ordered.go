@@ -0,0 +1,103 @@
+package g
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrReorderFull = errors.New("g: reorder buffer full")
+
+// OrderedContext runs its f in parallel like Go, but delivers their
+// callbacks to ChanCb in submission order. At most max f may be
+// outstanding, so that a stalled f cannot make the callbacks of the
+// ones submitted after it pile up without bound
+type OrderedContext struct {
+	g          *Go
+	max        int
+	mu         sync.Mutex
+	next       uint64 // seq of the next submission
+	release    uint64 // seq of the next callback to deliver
+	buf        map[uint64]func()
+	delivering bool
+}
+
+// NewOrderedContext returns an ordered context, max <= 0 means no limit
+func (g *Go) NewOrderedContext(max int) *OrderedContext {
+	c := new(OrderedContext)
+	c.g = g
+	c.max = max
+	c.buf = make(map[uint64]func())
+	return c
+}
+
+// Go returns ErrReorderFull when max f are outstanding
+func (c *OrderedContext) Go(f func(), cb func()) error {
+	return c.submit(&job{g: c.g, f: f, cb: cb})
+}
+
+// GoErr is Go for an f returning an error, which is passed to cb
+func (c *OrderedContext) GoErr(f func() error, cb func(error)) error {
+	return c.submit(&job{g: c.g, fe: f, cbe: cb})
+}
+
+// GoResult is Go for an f returning a result, which is passed to cb
+func (c *OrderedContext) GoResult(f func() (interface{}, error), cb func(interface{}, error)) error {
+	return c.submit(&job{g: c.g, fr: f, cbr: cb})
+}
+
+// Outstanding returns the number of f whose callback has not been
+// delivered yet
+func (c *OrderedContext) Outstanding() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.next - c.release)
+}
+
+func (c *OrderedContext) submit(j *job) error {
+	c.mu.Lock()
+	if c.max > 0 && c.next-c.release >= uint64(c.max) {
+		c.mu.Unlock()
+		return ErrReorderFull
+	}
+	j.ordered = c
+	j.seq = c.next
+	c.next++
+	c.mu.Unlock()
+
+	if err := c.g.submit(j); err != nil {
+		// goroutine not safe, so j is still the last submission
+		c.mu.Lock()
+		c.next--
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// buffers cb and delivers the callbacks that are now in order, only one
+// goroutine delivers at a time so that ChanCb sees them in order
+func (c *OrderedContext) complete(seq uint64, cb func()) {
+	c.mu.Lock()
+	c.buf[seq] = cb
+	if c.delivering {
+		c.mu.Unlock()
+		return
+	}
+	c.delivering = true
+
+	for {
+		cb, ok := c.buf[c.release]
+		if !ok {
+			c.delivering = false
+			c.mu.Unlock()
+			return
+		}
+		delete(c.buf, c.release)
+		c.mu.Unlock()
+
+		c.g.done(cb)
+
+		c.mu.Lock()
+		c.release++
+	}
+}
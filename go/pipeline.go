@@ -0,0 +1,129 @@
+package g
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var ErrPipelineCanceled = errors.New("g: pipeline canceled")
+
+// Stage receives the value returned by the previous stage, nil for the
+// first one
+type Stage func(in interface{}) (out interface{}, err error)
+
+type pipelineStage struct {
+	f  Stage
+	bg bool
+}
+
+// Pipeline chains stages running in the background and on the goroutine
+// owning the Go, build it with Bg, Fg and OnDone then call Run. An error or
+// a panic in a stage skips the remaining ones and is passed to OnDone
+type Pipeline struct {
+	g        *Go
+	stages   []pipelineStage
+	onDone   func(ret interface{}, err error)
+	canceled int32
+	started  bool
+}
+
+func (g *Go) Pipeline() *Pipeline {
+	return &Pipeline{g: g}
+}
+
+// Bg adds a stage executed like the f of Go
+func (p *Pipeline) Bg(f Stage) *Pipeline {
+	p.stages = append(p.stages, pipelineStage{f: f, bg: true})
+	return p
+}
+
+// Fg adds a stage executed on the goroutine owning the Go, after the
+// callback of the previous background stage comes through ChanCb
+func (p *Pipeline) Fg(f Stage) *Pipeline {
+	p.stages = append(p.stages, pipelineStage{f: f})
+	return p
+}
+
+// OnDone sets the callback receiving the value of the last stage or the
+// first error, executed on the goroutine owning the Go
+func (p *Pipeline) OnDone(cb func(ret interface{}, err error)) *Pipeline {
+	p.onDone = cb
+	return p
+}
+
+// Run starts the pipeline, it must be called on the goroutine owning the
+// Go, which runs a leading Fg stage at once. Errors submitting a
+// background stage are passed to OnDone
+func (p *Pipeline) Run() {
+	if p.started {
+		panic("pipeline already started")
+	}
+	p.started = true
+	p.step(0, nil)
+}
+
+// Cancel skips the stages that have not started, a running background
+// stage still completes but its value is dropped and OnDone receives
+// ErrPipelineCanceled. Goroutine safe
+func (p *Pipeline) Cancel() {
+	atomic.StoreInt32(&p.canceled, 1)
+}
+
+// runs stage i and the foreground stages following it, always on the
+// goroutine owning the Go
+func (p *Pipeline) step(i int, in interface{}) {
+	for ; i < len(p.stages); i++ {
+		if atomic.LoadInt32(&p.canceled) == 1 {
+			p.done(nil, ErrPipelineCanceled)
+			return
+		}
+
+		s := p.stages[i]
+		if s.bg {
+			next := i + 1
+			err := p.g.GoResult(func() (interface{}, error) {
+				return p.call(StageF, next-1, s.f, in)
+			}, func(ret interface{}, err error) {
+				if err != nil {
+					p.done(nil, err)
+					return
+				}
+				p.step(next, ret)
+			})
+			if err != nil {
+				p.done(nil, err)
+			}
+			return
+		}
+
+		var err error
+		if in, err = p.call(StageCb, i, s.f, in); err != nil {
+			p.done(nil, err)
+			return
+		}
+	}
+
+	if atomic.LoadInt32(&p.canceled) == 1 {
+		p.done(nil, ErrPipelineCanceled)
+		return
+	}
+	p.done(in, nil)
+}
+
+// calls f, turning a panic into an error of stage i
+func (p *Pipeline) call(stage string, i int, f Stage, in interface{}) (out interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.g.handlePanic(stage, fmt.Sprintf("pipeline stage %v", i), r)
+			out, err = nil, fmt.Errorf("pipeline stage %v: panic: %v", i, r)
+		}
+	}()
+	return f(in)
+}
+
+func (p *Pipeline) done(ret interface{}, err error) {
+	if p.onDone != nil {
+		p.onDone(ret, err)
+	}
+}
@@ -0,0 +1,144 @@
+package g
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrQueueFull = errors.New("g: queue full")
+	ErrClosed    = errors.New("g: closed")
+)
+
+type PoolOption func(*pool)
+
+// WithQueueLen sets how many f can wait for a worker, maxWorkers by default
+func WithQueueLen(n int) PoolOption {
+	return func(p *pool) {
+		p.queueLen = n
+	}
+}
+
+// WithOverflowError makes Go return ErrQueueFull when the queue is full,
+// instead of blocking until a worker is free. Blocking while the workers
+// wait for room in ChanCb deadlocks, so ChanCb must be long enough or the
+// owner must not block
+func WithOverflowError() PoolOption {
+	return func(p *pool) {
+		p.overflowError = true
+	}
+}
+
+type pool struct {
+	jobs          chan runner
+	queueLen      int
+	overflowError bool
+	mu            sync.RWMutex // held during submit, jobs is closed under the write lock
+	closed        bool
+	quit          chan struct{} // closed by abandon, wakes the blocked submit
+	quitOnce      sync.Once
+	abandoned     int32
+	wg            sync.WaitGroup
+}
+
+// NewPool returns a Go whose f are executed by at most maxWorkers long-lived
+// goroutines, callbacks still come through ChanCb in completion order
+func NewPool(l int, maxWorkers int, opts ...PoolOption) *Go {
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+
+	p := new(pool)
+	p.queueLen = maxWorkers
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.queueLen < 0 {
+		p.queueLen = 0
+	}
+	p.jobs = make(chan runner, p.queueLen)
+	p.quit = make(chan struct{})
+
+	p.wg.Add(maxWorkers)
+	for i := 0; i < maxWorkers; i++ {
+		go p.work()
+	}
+
+	g := New(l)
+	g.pool = p
+	return g
+}
+
+func (p *pool) work() {
+	defer p.wg.Done()
+	for r := range p.jobs {
+		if atomic.LoadInt32(&p.abandoned) == 1 {
+			r.skip()
+		} else {
+			r.run()
+		}
+	}
+}
+
+func (p *pool) submit(r runner) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if !p.overflowError {
+		select {
+		case p.jobs <- r:
+			return nil
+		case <-p.quit:
+			return ErrClosed
+		}
+	}
+
+	select {
+	case p.jobs <- r:
+		return nil
+	case <-p.quit:
+		return ErrClosed
+	default:
+		return ErrQueueFull
+	}
+}
+
+// closes jobs once no submit is in flight, reports whether it did
+func (p *pool) shut() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.closed = true
+	close(p.jobs)
+	return true
+}
+
+func (p *pool) close() {
+	if p.shut() {
+		p.wg.Wait()
+	}
+}
+
+// stops the workers without waiting for the running f, a submit blocked
+// on a full queue returns ErrClosed
+func (p *pool) abandon() {
+	atomic.StoreInt32(&p.abandoned, 1)
+	p.quitOnce.Do(func() {
+		close(p.quit)
+	})
+	p.shut()
+}
+
+// CloseAbandon is Close, except that the f still waiting for a worker are
+// dropped without executing their callbacks, the running ones are waited for
+func (g *Go) CloseAbandon() {
+	if g.pool != nil {
+		atomic.StoreInt32(&g.pool.abandoned, 1)
+	}
+	g.Close()
+}
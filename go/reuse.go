@@ -0,0 +1,100 @@
+package g
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Option func(*Go)
+
+// DefaultMaxIdleWorkers is the idle worker limit when WithKeepAlive is used
+// without WithMaxIdleWorkers
+const DefaultMaxIdleWorkers = 64
+
+// WithKeepAlive makes the goroutine running an f wait up to d for the next
+// f instead of exiting, which saves spawning a goroutine per f when many
+// small f are submitted. Ignored in pool mode
+func WithKeepAlive(d time.Duration) Option {
+	return func(g *Go) {
+		g.keepAlive = d
+	}
+}
+
+// WithMaxIdleWorkers sets how many goroutines may wait for an f at a time,
+// the others exit once their f is done
+func WithMaxIdleWorkers(n int) Option {
+	return func(g *Go) {
+		g.maxIdle = n
+	}
+}
+
+// goroutines kept alive between f
+type reuse struct {
+	keepAlive time.Duration
+	maxIdle   int32
+	idleCount int32
+	idle      chan runner
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+func newReuse(keepAlive time.Duration, maxIdle int) *reuse {
+	if maxIdle <= 0 {
+		maxIdle = DefaultMaxIdleWorkers
+	}
+	return &reuse{
+		keepAlive: keepAlive,
+		maxIdle:   int32(maxIdle),
+		idle:      make(chan runner),
+		quit:      make(chan struct{}),
+	}
+}
+
+// hands r to an idle goroutine, or spawns one
+func (w *reuse) exec(r runner) {
+	select {
+	case w.idle <- r:
+	default:
+		go w.work(r)
+	}
+}
+
+func (w *reuse) work(r runner) {
+	var t *time.Timer
+	for {
+		r.run()
+
+		if atomic.AddInt32(&w.idleCount, 1) > w.maxIdle {
+			atomic.AddInt32(&w.idleCount, -1)
+			return
+		}
+		if t == nil {
+			t = time.NewTimer(w.keepAlive)
+		} else {
+			t.Reset(w.keepAlive)
+		}
+
+		select {
+		case r = <-w.idle:
+			atomic.AddInt32(&w.idleCount, -1)
+			if !t.Stop() {
+				<-t.C
+			}
+		case <-t.C:
+			atomic.AddInt32(&w.idleCount, -1)
+			return
+		case <-w.quit:
+			atomic.AddInt32(&w.idleCount, -1)
+			t.Stop()
+			return
+		}
+	}
+}
+
+// lets the idle goroutines exit
+func (w *reuse) close() {
+	w.closeOnce.Do(func() {
+		close(w.quit)
+	})
+}
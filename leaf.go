@@ -10,7 +10,14 @@ import (
 	"os/signal"
 )
 
-func Run(mods ...module.Module) {
+// Run runs the modules until an interrupt, it returns the error of a module
+// failing to initialize, after destroying the modules initialized before it.
+//
+// The sequence is: logger, module.Init (every OnInit, then the OnAllInited
+// hooks, then every Run), cluster, console, wait for the signal, then console,
+// cluster and module.Destroy (every OnDestroy, then the OnAllDestroyed hooks).
+// The logger is closed last, so the OnAllDestroyed hooks can still log.
+func Run(mods ...module.Module) error {
 	// logger
 	if conf.LogLevel != "" {
 		logger, err := log.New(conf.LogLevel, conf.LogPath)
@@ -27,12 +34,18 @@ func Run(mods ...module.Module) {
 	for i := 0; i < len(mods); i++ {
 		module.Register(mods[i])
 	}
-	module.Init()
+	if err := module.Init(); err != nil {
+		log.Error("%v", err)
+		return err
+	}
 
 	// cluster
 	cluster.Init()
 
 	// console
+	builtin(conf.ConsoleGoCommand, module.RegisterGoCommand)
+	builtin(conf.ConsoleHealthCommand, module.RegisterHealthCommand)
+	builtin(conf.ConsoleStatsCommand, module.RegisterStatsCommand)
 	console.Init()
 
 	// close
@@ -42,5 +55,23 @@ func Run(mods ...module.Module) {
 	log.Release("Leaf closing down (signal: %v)", sig)
 	console.Destroy()
 	cluster.Destroy()
-	module.Destroy()
+	if conf.ShutdownTimeout > 0 {
+		module.DestroyTimeout(conf.ShutdownTimeout)
+	} else {
+		module.Destroy()
+	}
+	return nil
+}
+
+// registers a built-in console command, unless name is empty or a module
+// already registered a command with that name
+func builtin(name string, register func(name string)) {
+	if name == "" {
+		return
+	}
+	if console.Registered(name) {
+		log.Release("console command %v already registered, built-in one skipped", name)
+		return
+	}
+	register(name)
 }
@@ -25,4 +25,6 @@ func Example() {
 
 	log.Debug("will not print")
 	log.Release("My name is %v", name)
+
+	log.Named("game").Release("My name is %v", name)
 }
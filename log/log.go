@@ -151,3 +151,56 @@ func Fatal(format string, a ...interface{}) {
 func Close() {
 	gLogger.Close()
 }
+
+// Trace logs with a trace id, e.g. log.Trace(id).Debug(...)
+type Trace uint64
+
+func (t Trace) prefix(format string) string {
+	if t == 0 {
+		return format
+	}
+	return fmt.Sprintf("[trace %016x] ", uint64(t)) + format
+}
+
+func (t Trace) Debug(format string, a ...interface{}) {
+	gLogger.Debug(t.prefix(format), a...)
+}
+
+func (t Trace) Release(format string, a ...interface{}) {
+	gLogger.Release(t.prefix(format), a...)
+}
+
+func (t Trace) Error(format string, a ...interface{}) {
+	gLogger.Error(t.prefix(format), a...)
+}
+
+func (t Trace) Fatal(format string, a ...interface{}) {
+	gLogger.Fatal(t.prefix(format), a...)
+}
+
+// Named logs with a name, e.g. log.Named("game").Error(...), every line
+// is tagged with the name so that lines of different modules can be told apart
+type Named string
+
+func (n Named) prefix(format string) string {
+	if n == "" {
+		return format
+	}
+	return "[" + strings.ReplaceAll(string(n), "%", "%%") + "] " + format
+}
+
+func (n Named) Debug(format string, a ...interface{}) {
+	gLogger.Debug(n.prefix(format), a...)
+}
+
+func (n Named) Release(format string, a ...interface{}) {
+	gLogger.Release(n.prefix(format), a...)
+}
+
+func (n Named) Error(format string, a ...interface{}) {
+	gLogger.Error(n.prefix(format), a...)
+}
+
+func (n Named) Fatal(format string, a ...interface{}) {
+	gLogger.Fatal(n.prefix(format), a...)
+}
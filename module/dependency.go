@@ -0,0 +1,115 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+)
+
+//模块的名字,用于声明依赖
+type Named interface {
+	Name() string
+}
+
+//声明依赖的模块,依赖的模块先初始化、后关闭
+type Dependent interface {
+	Dependencies() []string //依赖的模块的名字
+}
+
+//模块的名字,没有实现Named时为类型名
+func name(mi Module) string {
+	if n, ok := mi.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", mi)
+}
+
+//按依赖排序模块,被依赖的模块在前,没有依赖关系的模块保持注册顺序
+//依赖的模块未注册或依赖有环时返回错误
+func sortModules(ms []*module) ([]*module, error) {
+	byName := make(map[string]*module, len(ms)) //类型名重复的模块为nil
+	for _, m := range ms {
+		n := name(m.mi)
+		if o, ok := byName[n]; ok {
+			if _, named := m.mi.(Named); named && o != nil {
+				return nil, fmt.Errorf("module %v: already registered", n)
+			}
+			byName[n] = nil
+			continue
+		}
+		byName[n] = m
+	}
+
+	for _, m := range ms {
+		d, ok := m.mi.(Dependent)
+		if !ok {
+			continue
+		}
+		m.deps = m.deps[:0]
+		for _, n := range d.Dependencies() {
+			dep, ok := byName[n]
+			if !ok {
+				return nil, fmt.Errorf("module %v: dependency %v is not registered", name(m.mi), n)
+			}
+			if dep == nil {
+				return nil, fmt.Errorf("module %v: dependency %v is ambiguous", name(m.mi), n)
+			}
+			m.deps = append(m.deps, dep)
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[*module]int, len(ms))
+	var path []*module
+	sorted := make([]*module, 0, len(ms))
+	var visit func(m *module) error
+	visit = func(m *module) error {
+		switch state[m] {
+		case visited:
+			return nil
+		case visiting:
+			var names []string
+			for i := len(path) - 1; i >= 0; i-- {
+				names = append(names, name(path[i].mi))
+				if path[i] == m {
+					break
+				}
+			}
+			for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+				names[i], names[j] = names[j], names[i]
+			}
+			return fmt.Errorf("module dependency cycle: %v -> %v", strings.Join(names, " -> "), name(m.mi))
+		}
+
+		state[m] = visiting
+		path = append(path, m)
+		for _, dep := range m.deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[m] = visited
+		sorted = append(sorted, m)
+		return nil
+	}
+
+	for _, m := range ms {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+//初始化前按依赖排序模块
+func sortMods() error {
+	sorted, err := sortModules(mods)
+	if err != nil {
+		return err
+	}
+	mods = sorted
+	return nil
+}
@@ -0,0 +1,299 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"github.com/name5566/leaf/timer"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+//在同一个goroutine上运行的一组模块,作为一个名字为组名的模块注册
+type group struct {
+	*Skeleton          //组的骨架,OnInit时创建
+	name      string   //组名
+	modules   []Module //组内的模块,按注册顺序
+}
+
+//注册的组,只在Init之前使用
+var groups = make(map[string]*group)
+
+//把模块注册到名字为n的组,同一组的模块共享一个goroutine、Go、定时器分发器和命令rpc服务器
+//组在第一次注册时作为一个模块注册,组内的模块按注册顺序执行OnInit,按注册的逆序关闭和执行OnDestroy
+//模块必须嵌入Skeleton,它的Run不会被调用,rpc服务器的调用、tick、Go回调和定时器都在组的goroutine中执行
+//Skeleton必须在Init之前设置,并在模块的OnInit中调用Skeleton.Init,否则组初始化失败
+//组的Go、定时器、过载检查和暂停使用第一个模块的Skeleton的设置,管道长度取所有模块中最大的
+//组内的模块在同一个goroutine中,可以直接调用彼此的函数,不能用rpc同步调用彼此,否则死锁
+//必须在Init之前调用
+func RegisterOn(n string, mi Module) {
+	if _, ok := mi.(skeletal); !ok {
+		log.Fatal("module %v: RegisterOn requires a module embedding Skeleton", name(mi))
+	}
+
+	gr := groups[n]
+	if gr == nil {
+		gr = &group{name: n}
+		groups[n] = gr
+		Register(gr)
+	}
+	gr.modules = append(gr.modules, mi)
+}
+
+func (gr *group) Name() string {
+	return gr.name
+}
+
+//组内模块依赖的组外模块
+func (gr *group) Dependencies() []string {
+	inside := make(map[string]bool, len(gr.modules))
+	for _, mi := range gr.modules {
+		inside[name(mi)] = true
+	}
+	var deps []string
+	for _, mi := range gr.modules {
+		if d, ok := mi.(Dependent); ok {
+			for _, dn := range d.Dependencies() {
+				if !inside[dn] {
+					deps = append(deps, dn)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+func (gr *group) OnInit() {
+	if err := gr.OnInitE(); err != nil {
+		panic(err)
+	}
+}
+
+//创建组的骨架,再按顺序初始化组内的模块
+//组内的模块初始化失败时按逆序关闭并销毁已经初始化的模块
+func (gr *group) OnInitE() error {
+	if len(gr.modules) == 0 {
+		return nil
+	}
+	for _, mi := range gr.modules {
+		if mi.(skeletal).skeleton() == nil {
+			return fmt.Errorf("module %v: Skeleton must be set before OnInit to run on group %v", name(mi), gr.name)
+		}
+	}
+
+	first := gr.modules[0].(skeletal).skeleton()
+	host := &Skeleton{
+		GoWorkers:         first.GoWorkers,
+		GoCloseTimeout:    first.GoCloseTimeout,
+		GoKeepAlive:       first.GoKeepAlive,
+		GoDiscardOnClose:  first.GoDiscardOnClose,
+		TimerWheelTick:    first.TimerWheelTick,
+		TimerClock:        first.TimerClock,
+		TimerLaneLen:      first.TimerLaneLen,
+		TimerFullPolicy:   first.TimerFullPolicy,
+		OverloadThreshold: first.OverloadThreshold,
+		OverloadInterval:  first.OverloadInterval,
+		PauseTimers:       first.PauseTimers,
+	}
+	for _, mi := range gr.modules {
+		s := mi.(skeletal).skeleton()
+		if s.GoLen > host.GoLen {
+			host.GoLen = s.GoLen
+		}
+		if s.TimerDispatcherLen > host.TimerDispatcherLen {
+			host.TimerDispatcherLen = s.TimerDispatcherLen
+		}
+	}
+	old := gr.Skeleton
+	restart := old != nil && old.restarting
+	if restart { //重启,释放Run异常时没有关闭的资源,新的骨架接管命令rpc服务器
+		for _, m := range old.members {
+			m.stopTicker()
+		}
+		old.release()
+		host.server, host.commandServer = old.server, old.commandServer
+		host.restarting = true
+	}
+	host.logger = log.Named(gr.name)
+	host.Init()
+	gr.Skeleton = host
+
+	for i, mi := range gr.modules {
+		s := mi.(skeletal).skeleton()
+		s.host = host
+		s.restarting = restart
+		var err error
+		func() {
+			defer func() {
+				s.restarting = false
+				if r := recover(); r != nil {
+					panic(fmt.Sprintf("module %v: %v", name(mi), r)) //指出是哪个模块
+				}
+			}()
+			err = onInit(mi)
+		}()
+		if err != nil {
+			gr.close()
+			gr.destroy(i)
+			return fmt.Errorf("module %v: init: %w", name(mi), err)
+		}
+		if mi.(skeletal).skeleton() != s || s.g != host.g { //没有在组上初始化,Go和定时器不会执行
+			gr.close()
+			gr.destroy(i + 1)
+			return fmt.Errorf("module %v: Skeleton not initialized on group %v, call Skeleton.Init in OnInit", name(mi), gr.name)
+		}
+		host.members = append(host.members, s)
+	}
+	return nil
+}
+
+//在组的goroutine上运行的模块的初始化,共享组的Go、定时器分发器和命令rpc服务器
+func (s *Skeleton) initMember() {
+	h := s.host
+	s.g = h.g
+	s.dispatcher = h.dispatcher
+	s.commandServer = h.commandServer
+	s.queues = h.queues
+	s.server = s.ChanRPCServer
+	if s.server == nil {
+		s.server = chanrpc.NewServer(0)
+	}
+	s.initTicker()
+}
+
+//组的事件循环,同时读取所有模块的rpc服务器和tick,以及组的Go、定时器和命令
+//暂停期间只读取命令,PauseTimers为false时也读取定时器和tick
+func (gr *group) Run(closeSig chan bool) {
+	if gr.Skeleton == nil { //空的组
+		<-closeSig
+		return
+	}
+
+	h := gr.Skeleton
+	cases := []reflect.SelectCase{recv(closeSig), recv(h.ctrlC)}
+	paused := []reflect.SelectCase{recv(closeSig), recv(h.ctrlC)} //暂停期间读取的管道
+	handlers := make([]func(v reflect.Value), len(cases))         //关闭信号、Pause和Resume没有处理函数
+	pausedHandlers := make([]func(v reflect.Value), len(paused))
+	add := func(whilePaused bool, c interface{}, f func(v reflect.Value)) {
+		cases = append(cases, recv(c))
+		handlers = append(handlers, f)
+		if whilePaused {
+			paused = append(paused, recv(c))
+			pausedHandlers = append(pausedHandlers, f)
+		}
+	}
+
+	add(false, h.g.ChanCb, func(v reflect.Value) {
+		h.g.Cb(v.Interface().(func()))
+	})
+	for _, c := range []chan *timer.Timer{h.dispatcher.Lane(timer.PriorityHigh), h.dispatcher.ChanTimer, h.dispatcher.Lane(timer.PriorityLow)} {
+		add(!h.PauseTimers, c, func(v reflect.Value) {
+			h.execTimer(v.Interface().(*timer.Timer))
+		})
+	}
+	add(true, h.commandServer.ChanCall, func(v reflect.Value) {
+		h.execCommand(v.Interface().(*chanrpc.CallInfo))
+	})
+	for _, s := range h.members {
+		exec := func(v reflect.Value) {
+			s.execRPC(v.Interface().(*chanrpc.CallInfo))
+		}
+		add(false, s.server.Lane(chanrpc.PriorityHigh), exec)
+		add(false, s.server.ChanCall, exec)
+		add(false, s.server.Lane(chanrpc.PriorityNormal), exec)
+		add(false, s.server.Lane(chanrpc.PriorityLow), exec)
+		add(!h.PauseTimers, s.tickC, func(reflect.Value) {
+			s.tick(time.Now())
+		})
+	}
+
+	for {
+		cs, hs := cases, handlers
+		if h.Paused() {
+			select { //关闭信号优先
+			case <-closeSig:
+				gr.close()
+				return
+			default:
+			}
+			cs, hs = paused, pausedHandlers
+		}
+		i, v, ok := reflect.Select(cs)
+		if i == 0 { //关闭信号
+			break
+		}
+		if !ok { //管道已关闭,不再读取
+			cs[i].Chan = reflect.Value{}
+			continue
+		}
+		if hs[i] != nil { //Pause或Resume只需要唤醒循环
+			hs[i](v)
+		}
+		h.checkOverload()
+	}
+	gr.close()
+}
+
+//按逆序关闭组内已经初始化的模块的rpc服务器和tick,再关闭组的骨架
+func (gr *group) close() {
+	h := gr.Skeleton
+	for i := len(h.members) - 1; i >= 0; i-- {
+		h.members[i].server.Close()
+		h.members[i].stopTicker()
+	}
+	h.commandServer.Close()
+	h.server.Close()
+	h.release()
+}
+
+//模块在控制台输出中的名字,组附上组内模块的名字,例如"world [scene, npc]"
+func label(mi Module) string {
+	gr, ok := mi.(*group)
+	if !ok {
+		return name(mi)
+	}
+	names := make([]string, 0, len(gr.modules))
+	for _, m := range gr.modules {
+		names = append(names, name(m))
+	}
+	return fmt.Sprintf("%v [%v]", gr.name, strings.Join(names, ", "))
+}
+
+//模块的Skeleton是否已经初始化,空的组和还没有设置Skeleton的模块没有
+func hasSkeleton(mi Module) bool {
+	sk, ok := mi.(skeletal)
+	return ok && sk.skeleton() != nil && sk.skeleton().g != nil
+}
+
+func recv(c interface{}) reflect.SelectCase {
+	return reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)}
+}
+
+//按注册的逆序销毁组内的模块,一个模块异常不影响其他模块
+func (gr *group) OnDestroy() {
+	gr.destroy(len(gr.modules))
+}
+
+//按逆序销毁组内的前n个模块
+func (gr *group) destroy(n int) {
+	for i := n - 1; i >= 0; i-- {
+		mi := gr.modules[i]
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if conf.LenStackBuf > 0 {
+						buf := make([]byte, conf.LenStackBuf)
+						l := runtime.Stack(buf, false)
+						log.Named(name(mi)).Error("%v: %s", r, buf[:l])
+					} else {
+						log.Named(name(mi)).Error("%v", r)
+					}
+				}
+			}()
+			mi.OnDestroy()
+		}()
+	}
+}
@@ -0,0 +1,155 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"github.com/name5566/leaf/console"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//模块实现该接口以提供健康检查,使用Skeleton的模块在模块的goroutine中执行Health
+type Healther interface {
+	Health() error
+}
+
+//模块的健康状态
+type HealthStatus struct {
+	Healthy bool
+	Latency time.Duration //健康检查的耗时,包括等待模块的goroutine的时间
+	Err     error         //Health返回的错误,或超时的错误
+}
+
+func (st HealthStatus) String() string {
+	if st.Healthy {
+		return fmt.Sprintf("healthy %v", st.Latency)
+	}
+	return fmt.Sprintf("unhealthy %v: %v", st.Latency, st.Err)
+}
+
+//每个模块健康检查的默认最长时间
+var DefaultHealthTimeout = time.Second
+
+//健康检查的函数id,注册在Skeleton的命令rpc服务器上
+type healthCheck struct{}
+
+func (healthCheck) String() string {
+	return "health"
+}
+
+//在模块的goroutine中执行健康检查,Skeleton实现了该接口
+type loopChecker interface {
+	checkHealth(ctx context.Context, h Healther) error
+}
+
+func (s *Skeleton) initHealth() {
+	s.commandServer.Register(healthCheck{}, func(args []interface{}) interface{} {
+		return args[0].(func() error)()
+	})
+}
+
+func (s *Skeleton) checkHealth(ctx context.Context, h Healther) error {
+	ret, err := s.commandServer.Open(0).Call1Ctx(ctx, healthCheck{}, h.Health)
+	if err != nil {
+		return err
+	}
+	err, _ = ret.(error)
+	return err
+}
+
+//同时检查所有实现了Healther的模块,包括通过RegisterOn注册到组的模块,每个模块最多等待timeout,超时的模块为不健康
+//没有响应的模块不会阻塞其他模块的检查,但同一组的模块在组的goroutine中依次检查
+//返回模块名字到健康状态的映射
+func HealthReport(timeout time.Duration) map[string]HealthStatus {
+	var ms []Module
+	for _, m := range registered() {
+		if gr, ok := m.mi.(*group); ok {
+			ms = append(ms, gr.modules...)
+			continue
+		}
+		ms = append(ms, m.mi)
+	}
+
+	report := make(map[string]HealthStatus)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, mi := range ms {
+		h, ok := mi.(Healther)
+		if !ok {
+			continue
+		}
+
+		n := name(mi)
+		mu.Lock()
+		if _, ok := report[n]; ok { //类型名相同的模块
+			n = fmt.Sprintf("%v#%p", n, mi)
+		}
+		report[n] = HealthStatus{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(mi Module) {
+			defer wg.Done()
+			st := checkHealth(mi, h, timeout)
+			mu.Lock()
+			report[n] = st
+			mu.Unlock()
+		}(mi)
+	}
+	wg.Wait()
+	return report
+}
+
+func checkHealth(mi Module, h Healther, timeout time.Duration) HealthStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	if c, ok := mi.(loopChecker); ok && hasSkeleton(mi) {
+		err = c.checkHealth(ctx, h)
+	} else {
+		err = callHealth(ctx, h)
+	}
+	return HealthStatus{Healthy: err == nil, Latency: time.Since(start), Err: err}
+}
+
+//在新的goroutine中执行Health,超时后不再等待
+func callHealth(ctx context.Context, h Healther) error {
+	c := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c <- fmt.Errorf("health: %v", r)
+			}
+		}()
+		c <- h.Health()
+	}()
+
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("health: %w", ctx.Err())
+	}
+}
+
+//注册打印所有模块健康状态的控制台命令
+func RegisterHealthCommand(name string) {
+	console.RegisterFunc(name, "check the health of every module", func([]string) string {
+		report := HealthReport(DefaultHealthTimeout)
+		names := make([]string, 0, len(report))
+		for n := range report {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		lines := make([]string, 0, len(names))
+		for _, n := range names {
+			lines = append(lines, n+": "+report[n].String())
+		}
+		return strings.Join(lines, "\r\n")
+	})
+}
@@ -0,0 +1,74 @@
+package module
+
+import (
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/log"
+	"runtime"
+	"sync"
+)
+
+//一组生命周期钩子,只执行一次
+type hooks struct {
+	name string
+	mu   sync.Mutex
+	fs   []func()
+	done bool
+}
+
+var (
+	allInited    = &hooks{name: "all inited"}
+	allDestroyed = &hooks{name: "all destroyed"}
+)
+
+//注册在Init中所有模块的OnInit之后、任何模块的Run之前执行的函数,例如预热依赖多个模块的缓存
+//按注册顺序执行,一个函数异常时记录日志并继续执行后面的函数,Init失败时不执行
+//此时模块还没有运行,不能用rpc同步调用模块
+//需要在Init之前调用
+func OnAllInited(f func()) {
+	allInited.add(f)
+}
+
+//注册在Destroy中所有模块的OnDestroy之后执行的函数,例如最后一次刷新日志和统计
+//按注册顺序执行,一个函数异常时记录日志并继续执行后面的函数
+//Init失败时在销毁已经初始化的模块后执行,DestroyTimeout超时时不再等待没有销毁完的模块
+//需要在Destroy之前调用
+func OnAllDestroyed(f func()) {
+	allDestroyed.add(f)
+}
+
+func (h *hooks) add(f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fs = append(h.fs, f)
+}
+
+//按注册顺序执行,已经执行过时不再执行
+func (h *hooks) run() {
+	h.mu.Lock()
+	if h.done {
+		h.mu.Unlock()
+		return
+	}
+	h.done = true
+	fs := h.fs
+	h.mu.Unlock()
+
+	for _, f := range fs {
+		h.call(f)
+	}
+}
+
+func (h *hooks) call(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("%v hook: %v: %s", h.name, r, buf[:l])
+			} else {
+				log.Error("%v hook: %v", h.name, r)
+			}
+		}
+	}()
+	f()
+}
@@ -1,10 +1,15 @@
 package module
 
 import (
+	"fmt"
 	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/go" //包名实际为g
 	"github.com/name5566/leaf/log"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
 )
 
 //模块接口
@@ -14,47 +19,303 @@ type Module interface {
 	Run(closeSig chan bool) //运行函数
 }
 
+//初始化可能失败的模块实现该接口,实现了该接口的模块用OnInitE代替OnInit初始化
+type InitErrorer interface {
+	OnInitE() error
+}
+
 //模块
 type module struct {
-	mi       Module         //实现了模块接口的某对象
-	closeSig chan bool      //传输关闭信号的管道
-	wg       sync.WaitGroup //等待组
+	mi          Module         //实现了模块接口的某对象
+	closeSig    chan bool      //传输关闭信号的管道
+	wg          sync.WaitGroup //等待组
+	priority    int            //关闭优先级,越大越先关闭
+	hasPriority bool           //是否指定了关闭优先级
+	after       []Module       //在这些模块关闭之后才关闭
+	timeout     time.Duration  //销毁的最长时间,为0时一直等待
+	deps        []*module      //依赖的模块,见Dependent
+	restart     RestartPolicy  //Run异常时的重启策略
+	status      status         //运行状态
+	closing     bool           //正在关闭或已经关闭,由modsMu保护
+	ready       bool           //OnInit已经执行完,由modsMu保护
+}
+
+//注册模块的选项
+type Option func(*module)
+
+//指定关闭优先级,优先级越大越先关闭,优先级相同的模块同时关闭
+//没有指定优先级的模块在指定了非负优先级的模块之后,按注册的逆序逐个关闭
+func WithStopPriority(p int) Option {
+	return func(m *module) {
+		m.priority = p
+		m.hasPriority = true
+	}
+}
+
+//在mods关闭之后才关闭,不受优先级影响,等待期间优先级更低的模块可以先关闭
+func WithStopAfter(mods ...Module) Option {
+	return func(m *module) {
+		m.after = append(m.after, mods...)
+	}
+}
+
+//关闭并销毁模块的最长时间,超时后记录错误并继续关闭其他模块
+func WithDestroyTimeout(d time.Duration) Option {
+	return func(m *module) {
+		m.timeout = d
+	}
 }
 
 //模块数组,用于保存注册的模块
-var mods []*module
+var (
+	mods   []*module
+	modsMu sync.Mutex //Init之后保护mods,见RuntimeRegister
+)
+
+//所有模块的快照
+func registered() []*module {
+	modsMu.Lock()
+	defer modsMu.Unlock()
+	return append([]*module(nil), mods...)
+}
 
 //注册模块
-func Register(mi Module) {
+func Register(mi Module, opts ...Option) {
 	m := new(module)                //创建一个模块
 	m.mi = mi                       //保存实现了模块接口的对象mi
 	m.closeSig = make(chan bool, 1) //创建用于传输关闭信号的管道
-	mods = append(mods, m)          //保存模块到模块数组中
+	for _, opt := range opts {
+		opt(m)
+	}
+	mods = append(mods, m) //保存模块到模块数组中
 }
 
-//初始化模块
-func Init() {
+//初始化模块,被依赖的模块先初始化,所有模块的OnInit执行完后执行OnAllInited注册的函数,再按初始化的顺序运行模块
+//模块在所有模块初始化后才运行,OnInit中不能用rpc同步调用其他模块
+//模块的OnInitE返回错误时按初始化的逆序关闭并销毁已经初始化的模块,然后返回错误,之后不需要调用Destroy
+func Init() error {
+	if err := sortMods(); err != nil {
+		return err
+	}
 	for i := 0; i < len(mods); i++ {
-		mods[i].mi.OnInit() //调用各模块的OnInit函数
-		go run(mods[i])     //在一个新的goroutine中运行模块
+		if err := onInit(mods[i].mi); err != nil { //调用各模块的OnInit函数
+			rollback(i)
+			return fmt.Errorf("module %v: init: %w", name(mods[i].mi), err)
+		}
+		setReady(mods[i])
+	}
+	allInited.run()
+	for _, m := range mods {
+		start(m)
 	}
+	return nil
 }
 
-//销毁模块
+//在一个新的goroutine中运行模块
+func start(m *module) {
+	m.wg.Add(1) //等待goroutine数加1,在启动goroutine前加,避免关闭时Wait先于Add执行
+	go run(m)
+}
+
+//执行模块的初始化,实现了InitErrorer时执行OnInitE
+func onInit(mi Module) error {
+	inject(mi)
+	defer inject(mi) //在OnInit中才设置Skeleton的模块
+	if ie, ok := mi.(InitErrorer); ok {
+		return ie.OnInitE()
+	}
+	mi.OnInit()
+	return nil
+}
+
+//初始化第n个模块失败时,按逆序关闭并销毁前n个模块,再执行OnAllDestroyed注册的函数
+//前n个模块还没有运行,先运行它们,以便由Run释放资源
+func rollback(n int) {
+	modsMu.Lock()
+	for _, m := range mods {
+		m.closing = true //Destroy不再关闭它们
+	}
+	modsMu.Unlock()
+
+	for i := 0; i < n; i++ {
+		start(mods[i])
+	}
+	for i := n - 1; i >= 0; i-- {
+		shutdown(mods[i])
+	}
+	allDestroyed.run()
+}
+
+//销毁模块,按关闭优先级和依赖关闭,所有模块的OnDestroy执行完后执行OnAllDestroyed注册的函数
 func Destroy() {
-	for i := len(mods) - 1; i >= 0; i-- {
-		m := mods[i]       //取得对应索引的模块
-		m.closeSig <- true //向管道发送关闭信号(导致Run内的死循环结束,向下执行到m.wg.Done())
-		m.wg.Wait()        //等待该模块所在goroutine执行完成
-		destroy(m)         //销毁该模块
+	destroyAll(nil)
+	allDestroyed.run()
+}
+
+//销毁模块,超过d后不再等待,返回还没有销毁完的模块数
+//超时时不再等待没有销毁完的模块,直接执行OnAllDestroyed注册的函数
+func DestroyTimeout(d time.Duration) int {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	n := destroyAll(t.C)
+	allDestroyed.run()
+	return n
+}
+
+//同时关闭所有就绪的模块,deadline为nil时一直等待
+func destroyAll(deadline <-chan time.Time) int {
+	var ms []*module
+	modsMu.Lock()
+	for _, m := range mods {
+		if !m.closing { //不包括正在运行时注销的模块
+			m.closing = true
+			ms = append(ms, m)
+		}
+	}
+	modsMu.Unlock()
+	mods := ms
+
+	n := len(mods)
+	index := make(map[*module]int, n)
+	priority := make([]int, n)
+	for i, m := range mods {
+		index[m] = i
+		priority[i] = i - n //没有指定优先级的模块按初始化的逆序关闭
+		if m.hasPriority {
+			priority[i] = m.priority
+		}
 	}
+	wait := make([][]int, n) //关闭前需要等待关闭的模块
+	for i, m := range mods {
+		for _, mi := range m.after {
+			for j, o := range mods {
+				if o.mi == mi {
+					wait[i] = append(wait[i], j)
+				}
+			}
+		}
+		for _, dep := range m.deps { //被依赖的模块后关闭
+			if j, ok := index[dep]; ok {
+				wait[j] = append(wait[j], i)
+			}
+		}
+	}
+
+	started := make([]bool, n)
+	done := make([]bool, n)
+	forced := make([]bool, n)     //依赖有环时忽略依赖
+	waiting := func(i int) bool { //还要等待其他模块关闭
+		for _, j := range wait[i] {
+			if !done[j] {
+				return true
+			}
+		}
+		return false
+	}
+	ready := func(i int) bool {
+		if forced[i] {
+			return true
+		}
+		if waiting(i) {
+			return false
+		}
+		for j := 0; j < n; j++ { //等待其他模块的模块不阻塞优先级更低的模块
+			if !done[j] && priority[j] > priority[i] && (started[j] || !waiting(j)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	finished := make(chan int, n)
+	running, left := 0, n
+	for left > 0 {
+		for i := n - 1; i >= 0; i-- {
+			if !started[i] && ready(i) {
+				started[i] = true
+				running++
+				go stop(mods[i], i, finished)
+			}
+		}
+		if running == 0 { //依赖有环,忽略优先级最高的模块的依赖
+			j := -1
+			for i := n - 1; i >= 0; i-- {
+				if !started[i] && (j < 0 || priority[i] > priority[j]) {
+					j = i
+				}
+			}
+			mods[j].logger().Error("stop dependencies form a cycle, ignored")
+			forced[j] = true
+			continue
+		}
+
+		select {
+		case i := <-finished:
+			done[i] = true
+			running--
+			left--
+		case <-deadline:
+			log.Error("destroy modules: %v modules still running after the deadline", left)
+			return left
+		}
+	}
+	return 0
 }
 
-//运行模块
+//关闭并销毁第i个模块,超时后不再等待
+func stop(m *module, i int, finished chan int) {
+	if m.timeout <= 0 {
+		shutdown(m)
+		finished <- i
+		return
+	}
+
+	c := make(chan struct{})
+	go func() {
+		shutdown(m)
+		close(c)
+	}()
+	t := time.NewTimer(m.timeout)
+	defer t.Stop()
+	select {
+	case <-c:
+	case <-t.C:
+		m.logger().Error("destroy still running after %v", m.timeout)
+	}
+	finished <- i
+}
+
+func shutdown(m *module) {
+	m.closeSig <- true //向管道发送关闭信号(导致Run内的死循环结束,向下执行到m.wg.Done())
+	m.wg.Wait()        //等待该模块所在goroutine执行完成
+	destroy(m)         //销毁该模块
+}
+
+//运行模块,Run异常时按重启策略处理
 func run(m *module) {
-	m.wg.Add(1)          //等待goroutine数加1
-	m.mi.Run(m.closeSig) //调用模块的Run函数(skeleton内实现,一个死循环)
-	m.wg.Done()          //等待goroutine数减1
+	for {
+		m.status.setRunning(true)
+		r := m.call(func() {
+			m.mi.Run(m.closeSig) //调用模块的Run函数(skeleton内实现,一个死循环)
+		})
+		m.status.setRunning(false)
+		if r == nil || !m.recoverRun() {
+			break
+		}
+	}
+	m.wg.Done() //等待goroutine数减1
+}
+
+//以模块名字为标签的日志
+func (m *module) logger() log.Named {
+	return log.Named(name(m.mi))
+}
+
+//设置嵌入的Skeleton的日志,在OnInit之前和之后各执行一次,已经设置时不再设置
+func inject(mi Module) {
+	if sk, ok := mi.(skeletal); ok && sk.skeleton() != nil && sk.skeleton().logger == "" {
+		sk.skeleton().logger = log.Named(name(mi))
+	}
 }
 
 //销毁模块
@@ -62,14 +323,39 @@ func destroy(m *module) {
 	defer func() {
 		if r := recover(); r != nil { //捕获异常
 			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf) //创建一个字节切片用于存储格式化后的stack trace
-				l := runtime.Stack(buf, false)        //格式化调用Stack函数的goroutine的stack trace
-				log.Error("%v: %s", r, buf[:l])       //打印错误消息和stack trace
+				buf := make([]byte, conf.LenStackBuf)  //创建一个字节切片用于存储格式化后的stack trace
+				l := runtime.Stack(buf, false)         //格式化调用Stack函数的goroutine的stack trace
+				m.logger().Error("%v: %s", r, buf[:l]) //打印错误消息和stack trace
 			} else {
-				log.Error("%v", r) //只打印错误消息
+				m.logger().Error("%v", r) //只打印错误消息
 			}
 		}
 	}()
 
 	m.mi.OnDestroy() //先调用模块的销毁函数,再执行上面的延迟函数
 }
+
+//有Go的模块,Skeleton实现了该接口
+type goDumper interface {
+	GoPending() int
+	GoRunning() int
+	GoDump() []g.JobInfo
+}
+
+//注册打印所有模块Go中未完成的f的控制台命令
+func RegisterGoCommand(name string) {
+	console.RegisterFunc(name, "dump the outstanding go jobs of every module", func([]string) string {
+		var lines []string
+		for _, m := range registered() {
+			d, ok := m.mi.(goDumper)
+			if !ok || !hasSkeleton(m.mi) {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%v: pending %v, running %v", label(m.mi), d.GoPending(), d.GoRunning()))
+			for _, info := range d.GoDump() {
+				lines = append(lines, "  "+info.String())
+			}
+		}
+		return strings.Join(lines, "\r\n")
+	})
+}
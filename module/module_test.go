@@ -0,0 +1,1097 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/go"
+	"github.com/name5566/leaf/log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type initModule struct {
+	name   string
+	err    error
+	events *[]string
+}
+
+func (m *initModule) Name() string {
+	return m.name
+}
+
+func (m *initModule) OnInit() {
+	*m.events = append(*m.events, "init "+m.name)
+}
+
+func (m *initModule) OnInitE() error {
+	*m.events = append(*m.events, "init "+m.name)
+	return m.err
+}
+
+func (m *initModule) OnDestroy() {
+	*m.events = append(*m.events, "destroy "+m.name)
+}
+
+func (m *initModule) Run(closeSig chan bool) {
+	<-closeSig
+	*m.events = append(*m.events, "stop "+m.name)
+}
+
+// hides the OnInitE of initModule, initialized with OnInit
+type legacyModule struct {
+	initModule
+}
+
+func (m *legacyModule) OnInitE() {}
+
+func TestInitRollback(t *testing.T) {
+	mods = nil
+	var events []string
+	errMongo := errors.New("mongo unreachable")
+	Register(&initModule{name: "a", events: &events})
+	Register(&initModule{name: "b", events: &events})
+	Register(&initModule{name: "c", err: errMongo, events: &events})
+	Register(&initModule{name: "d", events: &events})
+
+	err := Init()
+	if !errors.Is(err, errMongo) || err.Error() != "module c: init: mongo unreachable" {
+		t.Fatalf("Init: %v", err)
+	}
+	want := []string{"init a", "init b", "init c", "stop b", "destroy b", "stop a", "destroy a"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+
+	events = nil
+	Destroy()
+	if len(events) != 0 {
+		t.Errorf("Destroy after a failed Init: %v", events)
+	}
+}
+
+func TestInitWithoutError(t *testing.T) {
+	mods = nil
+	var events []string
+	Register(&initModule{name: "a", events: &events})
+	Register(&legacyModule{initModule{name: "b", events: &events}})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	Destroy()
+	want := []string{"init a", "init b", "stop b", "destroy b", "stop a", "destroy a"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+}
+
+type lookupModule struct {
+	*Skeleton
+	name   string
+	lookup func()
+}
+
+func (m *lookupModule) Name() string {
+	return m.name
+}
+
+func (m *lookupModule) OnInit() {
+	m.Skeleton.Init()
+	if m.lookup != nil {
+		m.lookup()
+	}
+}
+
+func (m *lookupModule) OnDestroy() {}
+
+func TestGet(t *testing.T) {
+	mods = nil
+	server := chanrpc.NewServer(10)
+	Register(&lookupModule{Skeleton: &Skeleton{ChanRPCServer: server}, name: "a"})
+	Register(&lookupModule{Skeleton: new(Skeleton), name: "b", lookup: func() {
+		if h, err := Get("a"); err != nil || h.ChanRPC() != server {
+			t.Errorf("Get a in OnInit of b: %v", err)
+		}
+		if _, err := Get("c"); !errors.Is(err, ErrNotReady) {
+			t.Errorf("Get c before its OnInit: %v", err)
+		}
+	}})
+	Register(&lookupModule{Skeleton: new(Skeleton), name: "c"})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Get("x"); !errors.Is(err, ErrNotRegistered) {
+		t.Errorf("Get x: %v", err)
+	}
+	h, err := Get("c")
+	if err != nil || h.Name() != "c" || h.ChanRPC() == nil {
+		t.Fatalf("Get c: %v", err)
+	}
+	for i := 0; i < 100 && !h.Alive(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !h.Alive() {
+		t.Error("c not alive after Init")
+	}
+	Destroy()
+	if h.Alive() {
+		t.Error("c alive after Destroy")
+	}
+}
+
+func TestOverload(t *testing.T) {
+	server := chanrpc.NewServer(10)
+	s := &Skeleton{ChanRPCServer: server, OverloadThreshold: 0.5, OverloadInterval: time.Hour}
+	s.Init()
+	var depths []int
+	s.OnOverload(func(st SkeletonStats) {
+		depths = append(depths, st.RPC.Len)
+	})
+	block := make(chan struct{})
+	server.Register("block", func([]interface{}) {
+		<-block
+	})
+	server.Register("noop", func([]interface{}) {})
+
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	server.Go("block")
+	for i := 0; i < 8; i++ {
+		server.Go("noop")
+	}
+	close(block)
+	if err := server.Open(0).Call0("noop"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Overloaded() {
+		t.Error("overloaded after the queue drained")
+	}
+	closeSig <- true
+	<-done
+
+	if len(depths) != 1 || depths[0] <= 5 {
+		t.Errorf("OnOverload called with depths %v, want one call above 5", depths)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	mods = nil
+	allInited, allDestroyed = &hooks{name: "all inited"}, &hooks{name: "all destroyed"}
+	var events []string
+	Register(&initModule{name: "a", events: &events})
+	Register(&initModule{name: "b", events: &events})
+	OnAllInited(func() {
+		if h, err := Get("a"); err != nil || h.Alive() {
+			t.Errorf("a running in OnAllInited: %v", err)
+		}
+		events = append(events, "all inited")
+	})
+	OnAllInited(func() {
+		panic("warm up failed")
+	})
+	OnAllInited(func() {
+		events = append(events, "after panic")
+	})
+	OnAllDestroyed(func() {
+		events = append(events, "all destroyed")
+	})
+
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	Destroy()
+	Destroy()
+	want := []string{"init a", "init b", "all inited", "after panic", "stop b", "destroy b", "stop a", "destroy a", "all destroyed"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+}
+
+func TestPause(t *testing.T) {
+	server := chanrpc.NewServer(10)
+	s := &Skeleton{ChanRPCServer: server}
+	s.Init()
+	var got []int
+	server.Register("add", func(args []interface{}) {
+		got = append(got, args[0].(int))
+	})
+	server.Register("pause", func([]interface{}) {
+		s.Pause()
+	})
+	s.commandServer.Register("ping", func([]interface{}) {})
+
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	c := server.Open(0)
+	if err := c.Call0("pause"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		server.Go("add", i)
+	}
+	if err := s.commandServer.Open(0).Call0("ping"); err != nil {
+		t.Fatalf("command while paused: %v", err)
+	}
+	if len(got) != 0 || !s.Paused() {
+		t.Errorf("executed %v while paused", got)
+	}
+
+	s.Resume()
+	if err := c.Call0("add", 4); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[1 2 3 4]" {
+		t.Errorf("executed %v after Resume, want [1 2 3 4]", got)
+	}
+
+	if err := c.Call0("pause"); err != nil {
+		t.Fatal(err)
+	}
+	closeSig <- true
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("paused module not closed")
+	}
+}
+
+func TestLabel(t *testing.T) {
+	gr := &group{name: "world", modules: []Module{&lookupModule{name: "scene"}, &lookupModule{name: "npc"}}}
+	if l := label(gr); l != "world [scene, npc]" {
+		t.Errorf("label of a group: %v", l)
+	}
+	if l := label(&lookupModule{name: "scene"}); l != "scene" {
+		t.Errorf("label of a module: %v", l)
+	}
+	if hasSkeleton(gr) || hasSkeleton(&lookupModule{name: "scene"}) {
+		t.Error("hasSkeleton without a Skeleton")
+	}
+}
+
+// events of modules stopping concurrently
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(e string) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.events, ", ")
+}
+
+type stopModule struct {
+	name  string
+	rec   *recorder
+	stuck chan struct{} // Run ignores closeSig until it is closed
+}
+
+func (m *stopModule) Name() string {
+	return m.name
+}
+
+func (m *stopModule) OnInit() {}
+
+func (m *stopModule) OnDestroy() {
+	m.rec.add(m.name)
+}
+
+func (m *stopModule) Run(closeSig chan bool) {
+	if m.stuck != nil {
+		<-m.stuck
+		return
+	}
+	<-closeSig
+}
+
+func TestStopOrder(t *testing.T) {
+	mods = nil
+	rec := new(recorder)
+	a := &stopModule{name: "a", rec: rec}
+	b := &stopModule{name: "b", rec: rec}
+	c := &stopModule{name: "c", rec: rec}
+	d := &stopModule{name: "d", rec: rec}
+	Register(a, WithStopPriority(10))
+	Register(b)
+	Register(c)
+	Register(d, WithStopAfter(b))
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	Destroy()
+	if got := rec.String(); got != "a, c, b, d" {
+		t.Errorf("stop order %v, want a, c, b, d", got)
+	}
+}
+
+func TestStopCycle(t *testing.T) {
+	mods = nil
+	rec := new(recorder)
+	a := &stopModule{name: "a", rec: rec}
+	b := &stopModule{name: "b", rec: rec}
+	Register(a, WithStopAfter(b))
+	Register(b, WithStopAfter(a))
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		Destroy()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Destroy hung on a stop cycle")
+	}
+	if got := rec.String(); got != "b, a" {
+		t.Errorf("stop order %v, want b, a", got)
+	}
+}
+
+func TestDestroyTimeout(t *testing.T) {
+	mods = nil
+	rec := new(recorder)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	Register(&stopModule{name: "a", rec: rec, stuck: stuck})
+	Register(&stopModule{name: "b", rec: rec})
+	Register(&stopModule{name: "c", rec: rec, stuck: stuck}, WithDestroyTimeout(10*time.Millisecond))
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := DestroyTimeout(100 * time.Millisecond); n != 1 {
+		t.Errorf("DestroyTimeout: %v modules left, want 1", n)
+	}
+	if got := rec.String(); got != "b" { // c gave up after its own timeout, a is still stuck
+		t.Errorf("destroyed %v, want b", got)
+	}
+}
+
+type depModule struct {
+	initModule
+	deps []string
+}
+
+func (m *depModule) Dependencies() []string {
+	return m.deps
+}
+
+func TestDependencyOrder(t *testing.T) {
+	mods = nil
+	var events []string
+	Register(&depModule{initModule{name: "game", events: &events}, []string{"db", "login"}})
+	Register(&depModule{initModule{name: "login", events: &events}, []string{"db"}})
+	Register(&initModule{name: "gate", events: &events})
+	Register(&initModule{name: "db", events: &events})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	Destroy()
+	want := []string{"init db", "init login", "init game", "init gate",
+		"stop gate", "destroy gate", "stop game", "destroy game", "stop login", "destroy login", "stop db", "destroy db"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+}
+
+func TestDependencyErrors(t *testing.T) {
+	var events []string
+	mods = nil
+	Register(&depModule{initModule{name: "game", events: &events}, []string{"db"}})
+	if err := Init(); err == nil || err.Error() != "module game: dependency db is not registered" {
+		t.Errorf("missing dependency: %v", err)
+	}
+
+	mods = nil
+	Register(&depModule{initModule{name: "a", events: &events}, []string{"b"}})
+	Register(&depModule{initModule{name: "b", events: &events}, []string{"c"}})
+	Register(&depModule{initModule{name: "c", events: &events}, []string{"a"}})
+	if err := Init(); err == nil || err.Error() != "module dependency cycle: a -> b -> c -> a" {
+		t.Errorf("cycle: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("modules initialized despite the errors: %v", events)
+	}
+}
+
+type panicModule struct {
+	inits, destroys int32
+}
+
+func (m *panicModule) Name() string {
+	return "panic"
+}
+
+func (m *panicModule) OnInit() {
+	atomic.AddInt32(&m.inits, 1)
+}
+
+func (m *panicModule) OnDestroy() {
+	atomic.AddInt32(&m.destroys, 1)
+}
+
+func (m *panicModule) Run(closeSig chan bool) {
+	panic("boom")
+}
+
+func TestRestart(t *testing.T) {
+	mods = nil
+	m := new(panicModule)
+	Register(m, WithRestartPolicy(RestartPolicy{Mode: Restart, MaxRestarts: 2, Backoff: 10 * time.Millisecond}))
+	start := time.Now()
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var st Status
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if st = Statuses()[0]; st.Panics == 3 && !st.Running {
+			break
+		}
+	}
+	if st.Name != "panic" || st.Restarts != 2 || st.Panics != 3 || st.Running || st.LastPanic != "boom" {
+		t.Fatalf("status %+v", st)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond { // backoff 10ms, then 20ms
+		t.Errorf("restarted twice within %v", d)
+	}
+	if inits, destroys := atomic.LoadInt32(&m.inits), atomic.LoadInt32(&m.destroys); inits != 3 || destroys != 2 {
+		t.Errorf("%v inits and %v destroys, want 3 and 2", inits, destroys)
+	}
+
+	Destroy()
+	if destroys := atomic.LoadInt32(&m.destroys); destroys != 3 {
+		t.Errorf("%v destroys after Destroy, want 3", destroys)
+	}
+}
+
+// registers a command and an RPC function in OnInit, its first Run panics
+type commandModule struct {
+	*Skeleton
+	command     string // console commands are global, unique per test run
+	inits, runs int32
+	firstGo     *g.Go
+	firstServer *chanrpc.Server // the command server
+}
+
+var commandRuns int32
+
+func (m *commandModule) Name() string {
+	return "command"
+}
+
+func (m *commandModule) OnInit() {
+	m.Init()
+	n := atomic.AddInt32(&m.inits, 1)
+	if n == 1 {
+		m.firstGo, m.firstServer = m.g, m.commandServer
+	}
+	m.RegisterCommand(m.command, "inits so far", func(args []interface{}) interface{} {
+		return fmt.Sprint(n)
+	})
+	m.RegisterChanRPC("inits", func(args []interface{}) interface{} {
+		return n
+	})
+}
+
+func (m *commandModule) OnDestroy() {}
+
+func (m *commandModule) Run(closeSig chan bool) {
+	if atomic.AddInt32(&m.runs, 1) == 1 {
+		panic("boom")
+	}
+	m.Skeleton.Run(closeSig)
+}
+
+func TestRestartSkeleton(t *testing.T) {
+	mods = nil
+	m := &commandModule{Skeleton: &Skeleton{ChanRPCServer: chanrpc.NewServer(1)}}
+	m.command = fmt.Sprintf("restart-count-%v", atomic.AddInt32(&commandRuns, 1))
+	Register(m, WithRestartPolicy(RestartPolicy{Mode: Restart, Backoff: time.Millisecond}))
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt32(&m.runs) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if st := Statuses()[0]; st.Restarts != 1 {
+		t.Fatalf("status %+v", st)
+	}
+	if err := m.firstGo.Go(func() {}, nil); err == nil {
+		t.Error("Go of the panicked Run not closed")
+	}
+	if m.commandServer != m.firstServer || m.g == m.firstGo {
+		t.Error("restart did not keep the command server or replace Go")
+	}
+	if ret, err := m.commandServer.Open(0).Call1(m.command); err != nil || ret != "2" {
+		t.Errorf("command after restart: %v, %v", ret, err)
+	}
+	if ret, err := m.ChanRPCServer.Open(0).Call1("inits"); err != nil || ret != int32(2) {
+		t.Errorf("rpc after restart: %v, %v", ret, err)
+	}
+	Destroy()
+}
+
+type depStopModule struct {
+	stopModule
+	deps []string
+}
+
+func (m *depStopModule) Dependencies() []string {
+	return m.deps
+}
+
+func TestRuntimeRegister(t *testing.T) {
+	mods = nil
+	rec := new(recorder)
+	Register(&stopModule{name: "a", rec: rec})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RuntimeRegister(&depStopModule{stopModule{name: "b", rec: rec}, []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RuntimeRegister(&stopModule{name: "b", rec: rec}); err == nil {
+		t.Error("registered b twice")
+	}
+	if err := RuntimeRegister(&depStopModule{stopModule{name: "c", rec: rec}, []string{"x"}}); err == nil {
+		t.Error("registered c depending on a missing module")
+	}
+	if h, err := Get("b"); err != nil || h.ChanRPC() != nil {
+		t.Errorf("Get b: %v", err)
+	}
+
+	if err := RuntimeUnregister("a"); err == nil || err.Error() != "module a: required by b" {
+		t.Errorf("unregister a required by b: %v", err)
+	}
+	if err := RuntimeUnregister("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RuntimeUnregister("b"); err == nil {
+		t.Error("unregistered b twice")
+	}
+	if got := rec.String(); got != "b" {
+		t.Errorf("destroyed %v after unregister, want b", got)
+	}
+
+	// Run not returning in time: not destroyed, and skipped by Destroy
+	stuck := make(chan struct{})
+	defer close(stuck)
+	if err := RuntimeRegister(&stopModule{name: "s", rec: rec, stuck: stuck}, WithDestroyTimeout(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RuntimeUnregister("s"); err == nil {
+		t.Error("unregistered s with Run still running")
+	}
+
+	Destroy()
+	if got := rec.String(); got != "b, a" {
+		t.Errorf("destroyed %v, want b, a", got)
+	}
+}
+
+func TestTickPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy TickPolicy
+		delta  time.Duration
+	}{
+		{TickCoalesce, 35 * time.Millisecond},
+		{TickSkip, 10 * time.Millisecond},
+	} {
+		s := &Skeleton{TickInterval: 10 * time.Millisecond, TickPolicy: tt.policy}
+		s.Init()
+		var deltas []time.Duration
+		s.OnTick(func(delta time.Duration) {
+			deltas = append(deltas, delta)
+			time.Sleep(5 * time.Millisecond) // slow tick
+		})
+
+		// the previous tick ran late, 3 ticks past
+		start := s.ticker.last
+		s.tick(start.Add(35 * time.Millisecond))
+		s.tick(start.Add(45 * time.Millisecond))
+		s.close()
+
+		if len(deltas) != 2 || deltas[0] != tt.delta || deltas[1] != 10*time.Millisecond {
+			t.Errorf("policy %v: deltas %v, want [%v 10ms]", tt.policy, deltas, tt.delta)
+		}
+		st := s.TickStats()
+		if st.Ticks != 2 || st.Missed != 2 || st.LastDuration < 5*time.Millisecond ||
+			st.MaxDuration < st.LastDuration || st.AvgDuration < 5*time.Millisecond {
+			t.Errorf("policy %v: stats %v", tt.policy, st)
+		}
+	}
+}
+
+type healthModule struct {
+	*Skeleton
+	name   string
+	health func() error
+}
+
+func (m *healthModule) Name() string {
+	return m.name
+}
+
+func (m *healthModule) OnInit() {
+	m.Skeleton.Init()
+}
+
+func (m *healthModule) OnDestroy() {}
+
+func (m *healthModule) Health() error {
+	return m.health()
+}
+
+type stuckHealthModule struct {
+	stopModule
+	block chan struct{}
+}
+
+func (m *stuckHealthModule) Health() error {
+	<-m.block
+	return nil
+}
+
+func TestHealthReport(t *testing.T) {
+	mods = nil
+	groups = make(map[string]*group)
+	errDown := errors.New("down")
+	RegisterOn("world", &healthModule{Skeleton: new(Skeleton), name: "scene", health: func() error { return nil }})
+	RegisterOn("world", &healthModule{Skeleton: new(Skeleton), name: "npc", health: func() error { return errDown }})
+	block := make(chan struct{})
+	defer close(block)
+	Register(&stuckHealthModule{stopModule{name: "db", rec: new(recorder)}, block})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy()
+
+	start := time.Now()
+	report := HealthReport(50 * time.Millisecond)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("HealthReport took %v", d)
+	}
+	if len(report) != 3 {
+		t.Fatalf("report %v", report)
+	}
+	if st := report["scene"]; !st.Healthy {
+		t.Errorf("scene: %v", st)
+	}
+	if st := report["npc"]; st.Healthy || !errors.Is(st.Err, errDown) {
+		t.Errorf("npc: %v", st)
+	}
+	if st := report["db"]; st.Healthy || !errors.Is(st.Err, context.DeadlineExceeded) {
+		t.Errorf("db: %v", st)
+	}
+}
+
+func TestStats(t *testing.T) {
+	server := chanrpc.NewServer(10)
+	s := &Skeleton{ChanRPCServer: server, GoLen: 10}
+	s.Init()
+	fDone, cbDone := make(chan struct{}, 2), make(chan struct{}, 2)
+	blocking, block := make(chan struct{}), make(chan struct{})
+	server.Register("spawn", func([]interface{}) {
+		for i := 0; i < 2; i++ {
+			s.Go(func() { fDone <- struct{}{} }, func() { cbDone <- struct{}{} })
+		}
+	})
+	server.Register("block", func([]interface{}) {
+		close(blocking)
+		<-block
+	})
+	server.Register("noop", func([]interface{}) {})
+
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+
+	server.Go("spawn")
+	server.Go("block")
+	<-blocking
+	<-fDone
+	<-fDone
+	for i := 0; i < 3; i++ {
+		server.Go("noop")
+	}
+	var st SkeletonStats
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if st = s.Stats(); st.Go.Len == 2 {
+			break
+		}
+	}
+	if st.RPC.Len != 3 || st.RPC.Cap != 10 || st.Go.Len != 2 || st.Go.Cap != 10 || st.RPC.Processed != 2 { // spawn and block
+		t.Errorf("stats while blocked: %v", st)
+	}
+
+	close(block)
+	<-cbDone
+	<-cbDone
+	if err := server.Open(0).Call0("noop"); err != nil {
+		t.Fatal(err)
+	}
+	st = s.Stats()
+	if st.RPC.Len != 0 || st.RPC.Processed != 6 || st.Go.Len != 0 || st.Go.Processed != 2 ||
+		st.RPC.AvgWait <= 0 || st.Go.AvgWait <= 0 {
+		t.Errorf("stats after the load: %v", st)
+	}
+}
+
+type loginMsg struct {
+	Name string
+}
+
+func TestTypedRegister(t *testing.T) {
+	server := chanrpc.NewServer(10)
+	s := &Skeleton{ChanRPCServer: server}
+	s.Init()
+	var got []string
+	if err := Register1(s, "greet", func(name string) { got = append(got, "greet "+name) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register1R(s, "double", func(n int) int { return 2 * n }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMsg(s, func(msg *loginMsg, agent string) { got = append(got, msg.Name+" from "+agent) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Register1(s, "greet", func(int) {}); err == nil || err.Error() != "function id greet: already registered" {
+		t.Errorf("duplicate Register1: %v", err)
+	}
+	if err := Register1R(s, "double", func(int) int { return 0 }); err == nil {
+		t.Error("duplicate Register1R")
+	}
+	if err := RegisterMsg(s, func(*loginMsg, string) {}); err == nil {
+		t.Error("duplicate RegisterMsg")
+	}
+	if err := RegisterMsg(s, func(loginMsg, string) {}); err == nil || err.Error() != "message module.loginMsg: pointer required" {
+		t.Errorf("RegisterMsg of a struct: %v", err)
+	}
+
+	closeSig := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	c := server.Open(0)
+	if err := c.Call0("greet", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := c.Call1("double", 21); err != nil || ret != 42 {
+		t.Errorf("double: %v, %v", ret, err)
+	}
+	if err := c.Call0(reflect.TypeOf(&loginMsg{}), &loginMsg{Name: "bob"}, "gate"); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[greet alice bob from gate]" {
+		t.Errorf("handlers got %v", got)
+	}
+}
+
+// sets its Skeleton in OnInit, like most leaf modules
+type lateModule struct {
+	*Skeleton
+}
+
+func (m *lateModule) Name() string {
+	return "late"
+}
+
+func (m *lateModule) OnInit() {
+	m.Skeleton = new(Skeleton)
+	m.Skeleton.Init()
+}
+
+func (m *lateModule) OnDestroy() {}
+
+func TestLoggerName(t *testing.T) {
+	dir := t.TempDir()
+	logger, err := log.New("debug", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Export(logger)
+	defer func() {
+		std, _ := log.New("debug", "")
+		log.Export(std)
+		logger.Close()
+	}()
+
+	mods = nil
+	m := new(lateModule)
+	Register(m)
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	m.Logger().Release("hello %v", 1)
+	Destroy()
+
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("log files %v: %v", files, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "[release] [late] hello 1") {
+		t.Errorf("log %q", b)
+	}
+}
+
+type groupModule struct {
+	*Skeleton
+	name string
+	rec  *recorder
+	init bool // calls Skeleton.Init in OnInit
+}
+
+func (m *groupModule) Name() string {
+	return m.name
+}
+
+func (m *groupModule) OnInit() {
+	if m.init {
+		m.Skeleton.Init()
+	}
+	m.rec.add("init " + m.name)
+}
+
+func (m *groupModule) OnDestroy() {
+	m.rec.add("destroy " + m.name)
+}
+
+func TestRegisterOn(t *testing.T) {
+	mods = nil
+	groups = make(map[string]*group)
+	rec := new(recorder)
+	scene := &groupModule{Skeleton: &Skeleton{TimerDispatcherLen: 10}, name: "scene", rec: rec, init: true}
+	RegisterOn("world", scene)
+	RegisterOn("world", &groupModule{Skeleton: &Skeleton{GoLen: 10}, name: "npc", rec: rec, init: true})
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	fired := make(chan struct{})
+	scene.AfterFunc(time.Millisecond, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("timer of a group member not fired")
+	}
+	Destroy()
+	if got := rec.String(); got != "init scene, init npc, destroy npc, destroy scene" {
+		t.Errorf("events %v", got)
+	}
+}
+
+func TestRegisterOnErrors(t *testing.T) {
+	// Skeleton set in OnInit
+	mods = nil
+	groups = make(map[string]*group)
+	RegisterOn("world", new(lateModule))
+	if err := Init(); err == nil || err.Error() != "module world: init: module late: Skeleton must be set before OnInit to run on group world" {
+		t.Errorf("nil Skeleton: %v", err)
+	}
+
+	// Skeleton initialized before joining the group
+	mods = nil
+	groups = make(map[string]*group)
+	rec := new(recorder)
+	s := &Skeleton{TimerDispatcherLen: 10}
+	s.Init()
+	defer s.close()
+	RegisterOn("world", &groupModule{Skeleton: new(Skeleton), name: "scene", rec: rec, init: true})
+	RegisterOn("world", &groupModule{Skeleton: s, name: "npc", rec: rec})
+	err := Init()
+	if err == nil || err.Error() != "module world: init: module npc: Skeleton not initialized on group world, call Skeleton.Init in OnInit" {
+		t.Errorf("Skeleton initialized before: %v", err)
+	}
+	if got := rec.String(); got != "init scene, init npc, destroy npc, destroy scene" {
+		t.Errorf("events %v", got)
+	}
+}
+
+type swapModule struct {
+	*Skeleton
+	version string
+	state   int
+	err     error // returned by OnInitE
+	rec     *recorder
+}
+
+func (m *swapModule) Name() string {
+	return "game"
+}
+
+func (m *swapModule) OnInit() {}
+
+func (m *swapModule) OnInitE() error {
+	m.Skeleton.Init()
+	m.RegisterChanRPC("version", func([]interface{}) interface{} {
+		return m.version
+	})
+	m.rec.add("init " + m.version)
+	return m.err
+}
+
+func (m *swapModule) OnDestroy() {
+	m.rec.add("destroy " + m.version)
+}
+
+func (m *swapModule) TransferState(from Module) error {
+	m.state = from.(*swapModule).state
+	return nil
+}
+
+type renamedModule struct {
+	swapModule
+}
+
+func (m *renamedModule) Name() string {
+	return "other"
+}
+
+func TestSwap(t *testing.T) {
+	mods = nil
+	rec := new(recorder)
+	server := chanrpc.NewServer(10)
+	old := &swapModule{Skeleton: &Skeleton{ChanRPCServer: server}, version: "v1", state: 7, rec: rec}
+	Register(old)
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy()
+	c := server.Open(0)
+	version := func() interface{} {
+		ret, err := c.Call1("version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	for _, tt := range []struct {
+		mi  Module
+		err string
+	}{
+		{&swapModule{version: "v2", rec: rec}, "module game: new module has no Skeleton"},
+		{&renamedModule{swapModule{Skeleton: new(Skeleton), version: "v2", rec: rec}}, "module game: new module is named other"},
+		{&swapModule{Skeleton: &Skeleton{ChanRPCServer: chanrpc.NewServer(10)}, version: "v2", rec: rec}, "module game: new module uses a different ChanRPCServer"},
+	} {
+		if err := Swap("game", tt.mi); err == nil || err.Error() != tt.err {
+			t.Errorf("Swap: %v, want %v", err, tt.err)
+		}
+	}
+
+	// old module busy and not paused in time
+	timeout := DefaultSwapTimeout
+	DefaultSwapTimeout = 20 * time.Millisecond
+	blocking, block := make(chan struct{}), make(chan struct{})
+	old.RegisterChanRPC("block", func([]interface{}) {
+		close(blocking)
+		<-block
+	})
+	server.Go("block")
+	<-blocking
+	err := Swap("game", &swapModule{Skeleton: new(Skeleton), version: "v2", rec: rec})
+	DefaultSwapTimeout = timeout
+	close(block)
+	if err == nil || err.Error() != "module game: not paused after 20ms" {
+		t.Errorf("Swap of a busy module: %v", err)
+	}
+
+	// OnInitE of the new module failing
+	errInit := errors.New("config missing")
+	if err := Swap("game", &swapModule{Skeleton: new(Skeleton), version: "v2", err: errInit, rec: rec}); !errors.Is(err, errInit) {
+		t.Errorf("Swap with a failing OnInitE: %v", err)
+	}
+	if v := version(); v != "v1" {
+		t.Errorf("version %v after failed swaps, want v1", v)
+	}
+
+	m := &swapModule{Skeleton: new(Skeleton), version: "v3", rec: rec}
+	if err := Swap("game", m); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(); v != "v3" || m.state != 7 {
+		t.Errorf("version %v and state %v after swap, want v3 and 7", v, m.state)
+	}
+	if h, err := Get("game"); err != nil || h.ChanRPC() != server {
+		t.Errorf("Get after swap: %v", err)
+	}
+	if got := rec.String(); got != "init v1, init v2, init v3, destroy v1" {
+		t.Errorf("events %v", got)
+	}
+}
+
+func TestGoClosed(t *testing.T) {
+	s := &Skeleton{GoLen: 1}
+	s.Init()
+	s.close()
+
+	calls := map[string]func(){
+		"Go": func() {
+			s.Go(func() {}, nil)
+		},
+		"GoNamed": func() {
+			s.GoNamed("closed", func() {}, nil)
+		},
+		"GoCancelable": func() {
+			s.GoCancelable(func() {}, nil)
+		},
+		"GoCtx": func() {
+			s.GoCtx(context.Background(), func(context.Context) {}, nil)
+		},
+	}
+	for n, f := range calls {
+		func() {
+			defer func() {
+				if r := recover(); r != g.ErrClosed {
+					t.Errorf("%v after close: recovered %v, want %v", n, r, g.ErrClosed)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,125 @@
+package module
+
+import (
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/conf"
+	"github.com/name5566/leaf/timer"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+//过载期间OnOverload注册的函数最多每隔这么久执行一次,OverloadInterval为0时使用
+var DefaultOverloadInterval = time.Second
+
+//过载检查的状态
+type overload struct {
+	handlers []func(st SkeletonStats)
+	last     time.Time //上次执行处理函数的时间,只在模块的goroutine中使用
+	flag     int32     //是否过载,原子操作
+}
+
+//注册过载处理函数,任一管道的使用率超过OverloadThreshold时在模块的goroutine中执行,
+//过载期间最多每隔OverloadInterval执行一次,st为执行时各个管道的统计,模块可以据此开始丢弃不重要的工作
+//需要在Init之后调用,OverloadThreshold为0时不会执行
+//通过RegisterOn注册的模块注册到组,组使用第一个模块的OverloadThreshold和OverloadInterval
+func (s *Skeleton) OnOverload(f func(st SkeletonStats)) {
+	if s.host != nil {
+		s.host.OnOverload(f)
+		return
+	}
+	s.overload.handlers = append(s.overload.handlers, f)
+}
+
+//最近一次检查时模块是否过载,goroutine安全,例如gate可以据此提前拒绝低优先级的客户端消息
+//模块每处理完一个调用、回调、定时器或tick时检查一次
+func (s *Skeleton) Overloaded() bool {
+	if s.host != nil {
+		return s.host.Overloaded()
+	}
+	return atomic.LoadInt32(&s.overload.flag) == 1
+}
+
+//在模块的goroutine中检查是否过载,过载时按间隔执行处理函数
+func (s *Skeleton) checkOverload() {
+	if s.OverloadThreshold <= 0 {
+		return
+	}
+
+	ov := &s.overload
+	if !s.aboveThreshold() {
+		atomic.StoreInt32(&ov.flag, 0)
+		return
+	}
+	atomic.StoreInt32(&ov.flag, 1)
+	if len(ov.handlers) == 0 {
+		return
+	}
+
+	interval := s.OverloadInterval
+	if interval <= 0 {
+		interval = DefaultOverloadInterval
+	}
+	now := time.Now()
+	if !ov.last.IsZero() && now.Sub(ov.last) < interval {
+		return
+	}
+	ov.last = now
+	st := s.Stats()
+	for _, f := range ov.handlers {
+		s.callOverload(f, st)
+	}
+}
+
+//是否有一个管道的使用率超过OverloadThreshold,组的骨架包括所有模块的rpc服务器
+func (s *Skeleton) aboveThreshold() bool {
+	over := func(l int, c int) bool {
+		return c > 0 && float64(l) > s.OverloadThreshold*float64(c)
+	}
+
+	rpcOver := func(server *chanrpc.Server) bool {
+		if over(len(server.ChanCall), cap(server.ChanCall)) {
+			return true
+		}
+		for _, p := range []chanrpc.Priority{chanrpc.PriorityHigh, chanrpc.PriorityNormal, chanrpc.PriorityLow} {
+			lane := server.Lane(p)
+			if over(len(lane), cap(lane)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if rpcOver(s.server) {
+		return true
+	}
+	for _, m := range s.members {
+		if rpcOver(m.server) {
+			return true
+		}
+	}
+	if over(len(s.commandServer.ChanCall), cap(s.commandServer.ChanCall)) || over(len(s.g.ChanCb), cap(s.g.ChanCb)) {
+		return true
+	}
+	for _, c := range []chan *timer.Timer{s.dispatcher.Lane(timer.PriorityHigh), s.dispatcher.ChanTimer, s.dispatcher.Lane(timer.PriorityLow)} {
+		if over(len(c), cap(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Skeleton) callOverload(f func(st SkeletonStats), st SkeletonStats) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				s.logger.Error("overload: %v: %s", r, buf[:l])
+			} else {
+				s.logger.Error("overload: %v", r)
+			}
+		}
+	}()
+	f(st)
+}
@@ -0,0 +1,91 @@
+package module
+
+import (
+	"github.com/name5566/leaf/timer"
+	"sync/atomic"
+	"time"
+)
+
+//暂停模块,暂停期间不再读取rpc服务器和Go的回调管道,调用和回调在管道中等待,Resume后按顺序执行
+//仍然处理关闭信号和命令,PauseTimers为true时也不执行定时器和tick
+//管道满时按原有的方式处理,例如rpc服务器的超时和丢弃、定时器的TimerFullPolicy
+//goroutine安全,可以在模块自己的rpc调用中执行,此时当前调用执行完后暂停
+//通过RegisterOn注册的模块暂停整个组
+func (s *Skeleton) Pause() {
+	s.setPaused(1)
+}
+
+//恢复运行暂停的模块
+func (s *Skeleton) Resume() {
+	s.setPaused(0)
+}
+
+//模块是否已暂停,goroutine安全
+func (s *Skeleton) Paused() bool {
+	if s.host != nil {
+		return s.host.Paused()
+	}
+	return atomic.LoadInt32(&s.paused) == 1
+}
+
+func (s *Skeleton) setPaused(v int32) {
+	if s.host != nil {
+		s.host.setPaused(v)
+		return
+	}
+	atomic.StoreInt32(&s.paused, v)
+	select { //唤醒模块的goroutine,已经有没处理的通知时不需要再通知
+	case s.ctrlC <- struct{}{}:
+	default:
+	}
+}
+
+//暂停期间的事件循环,恢复运行时返回true,模块应退出时返回false
+func (s *Skeleton) runPaused(closeSig chan bool) bool {
+	timers := make([]chan *timer.Timer, 3) //为nil时不读取
+	var tickC <-chan time.Time
+	if !s.PauseTimers {
+		timers = []chan *timer.Timer{s.dispatcher.Lane(timer.PriorityHigh), s.dispatcher.ChanTimer, s.dispatcher.Lane(timer.PriorityLow)}
+		tickC = s.tickC
+	}
+
+	for s.Paused() {
+		select { //关闭信号优先
+		case <-closeSig:
+			s.close()
+			return false
+		default:
+		}
+
+		select {
+		case <-closeSig:
+			s.close()
+			return false
+		case resume := <-s.pauseC: //热替换
+			if !<-resume {
+				s.release()
+				return false
+			}
+		case <-s.ctrlC:
+		case ci := <-s.commandServer.ChanCall:
+			s.execCommand(ci)
+		case t := <-timers[0]:
+			s.execTimer(t)
+		case t := <-timers[1]:
+			s.execTimer(t)
+		case t := <-timers[2]:
+			s.execTimer(t)
+		case <-tickC:
+			s.tick(time.Now())
+		}
+		s.checkOverload()
+	}
+	return true
+}
+
+//关闭rpc服务器和命令rpc服务器,再关闭Go、定时器分发器和tick
+func (s *Skeleton) close() {
+	s.commandServer.Close()
+	s.server.Close()
+	s.release()
+}
@@ -0,0 +1,85 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+)
+
+//模块已注册但还没有初始化完时Get返回的错误,用errors.Is判断
+var ErrNotReady = errors.New("module not ready")
+
+//模块没有注册时Get返回的错误,用errors.Is判断
+var ErrNotRegistered = errors.New("module not registered")
+
+//按名字引用的模块,不需要导入模块所在的包
+//每次调用时按名字查找当前注册的模块,被Swap替换后引用新的模块
+type Handle struct {
+	name string
+}
+
+//按名字取得实现了Named的模块,goroutine safe
+//不等待模块初始化,模块的OnInit还没有执行完时返回ErrNotReady,避免在OnInit中等待而死锁
+//被依赖的模块先初始化,所以在OnInit中可以取得Dependencies中的模块
+func Get(n string) (*Handle, error) {
+	modsMu.Lock()
+	defer modsMu.Unlock()
+	m := findNamed(n)
+	if m == nil {
+		return nil, fmt.Errorf("module %v: %w", n, ErrNotRegistered)
+	}
+	if !m.ready {
+		return nil, fmt.Errorf("module %v: %w", n, ErrNotReady)
+	}
+	return &Handle{name: n}, nil
+}
+
+//模块的名字
+func (h *Handle) Name() string {
+	return h.name
+}
+
+//模块的rpc服务器,模块没有嵌入Skeleton或已经注销时为nil
+func (h *Handle) ChanRPC() *chanrpc.Server {
+	modsMu.Lock()
+	m := findNamed(h.name)
+	modsMu.Unlock()
+	if m == nil {
+		return nil
+	}
+	if sk, ok := m.mi.(skeletal); ok && sk.skeleton() != nil {
+		return sk.skeleton().server
+	}
+	return nil
+}
+
+//模块是否在运行:已经初始化、Run正在执行,并且没有开始关闭
+func (h *Handle) Alive() bool {
+	modsMu.Lock()
+	m := findNamed(h.name)
+	alive := m != nil && m.ready && !m.closing
+	modsMu.Unlock()
+	if !alive {
+		return false
+	}
+
+	m.status.mu.Lock()
+	defer m.status.mu.Unlock()
+	return m.status.running
+}
+
+//按名字查找实现了Named的模块,需要持有modsMu
+func findNamed(n string) *module {
+	for _, m := range mods {
+		if named, ok := m.mi.(Named); ok && named.Name() == n {
+			return m
+		}
+	}
+	return nil
+}
+
+func setReady(m *module) {
+	modsMu.Lock()
+	m.ready = true
+	modsMu.Unlock()
+}
@@ -0,0 +1,205 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"runtime"
+	"sync"
+	"time"
+)
+
+//Run异常时的处理方式
+type RestartMode int
+
+const (
+	Ignore  RestartMode = iota //记录错误,模块停止运行,其他模块继续运行
+	Exit                       //记录致命错误,退出进程
+	Restart                    //尽量执行OnDestroy,然后重新执行OnInit和Run
+)
+
+//Run异常时的重启策略
+type RestartPolicy struct {
+	Mode        RestartMode
+	MaxRestarts int           //最多重启次数,为0时不限制,超过后按Ignore处理
+	Backoff     time.Duration //第一次重启前等待的时间,之后每次加倍,为0时使用DefaultRestartBackoff
+	MaxBackoff  time.Duration //重启前最多等待的时间,为0时使用DefaultRestartMaxBackoff
+}
+
+var (
+	DefaultRestartBackoff    = time.Second
+	DefaultRestartMaxBackoff = time.Minute
+)
+
+//指定Run异常时的重启策略,默认为Ignore
+//重启时OnInit可以再次调用Skeleton.Init,原来的Go、定时器分发器和tick被关闭,rpc服务器和命令rpc服务器保留
+//再次注册的命令和rpc函数替换原来的函数
+func WithRestartPolicy(p RestartPolicy) Option {
+	return func(m *module) {
+		m.restart = p
+	}
+}
+
+//模块的运行状态,用于健康检查
+type Status struct {
+	Name        string
+	Running     bool        //Run是否在运行
+	Restarts    int         //重启次数
+	Panics      int         //Run异常的次数
+	LastPanic   interface{} //最后一次异常
+	LastPanicAt time.Time
+}
+
+//运行状态,由mu保护
+type status struct {
+	mu          sync.Mutex
+	running     bool
+	restarts    int
+	panics      int
+	lastPanic   interface{}
+	lastPanicAt time.Time
+}
+
+//所有模块的运行状态,goroutine安全
+func Statuses() []Status {
+	ms := registered()
+	ss := make([]Status, 0, len(ms))
+	for _, m := range ms {
+		m.status.mu.Lock()
+		ss = append(ss, Status{
+			Name:        name(m.mi),
+			Running:     m.status.running,
+			Restarts:    m.status.restarts,
+			Panics:      m.status.panics,
+			LastPanic:   m.status.lastPanic,
+			LastPanicAt: m.status.lastPanicAt,
+		})
+		m.status.mu.Unlock()
+	}
+	return ss
+}
+
+func (s *status) setRunning(running bool) {
+	s.mu.Lock()
+	s.running = running
+	s.mu.Unlock()
+}
+
+//执行f,返回异常
+func (m *module) call(f func()) (r interface{}) {
+	defer func() {
+		if r = recover(); r == nil {
+			return
+		}
+		if conf.LenStackBuf > 0 {
+			buf := make([]byte, conf.LenStackBuf)
+			l := runtime.Stack(buf, false)
+			m.logger().Error("%v: %s", r, buf[:l])
+		} else {
+			m.logger().Error("%v", r)
+		}
+
+		m.status.mu.Lock()
+		m.status.panics++
+		m.status.lastPanic = r
+		m.status.lastPanicAt = time.Now()
+		m.status.mu.Unlock()
+	}()
+
+	f()
+	return
+}
+
+//OnInit或OnInitE中的异常
+type panicError struct {
+	r interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.r)
+}
+
+//执行模块的初始化,异常被记录并转化为错误
+func (m *module) init() error {
+	var err error
+	if r := m.call(func() {
+		err = onInit(m.mi)
+	}); r != nil {
+		return panicError{r}
+	}
+	return err
+}
+
+//按重启策略处理Run的异常,返回false表示不再运行
+func (m *module) recoverRun() bool {
+	p := m.restart
+	switch p.Mode {
+	case Exit:
+		m.logger().Fatal("run panicked, exiting")
+	case Restart:
+	default:
+		return false
+	}
+
+	for {
+		m.status.mu.Lock()
+		restarts := m.status.restarts
+		m.status.mu.Unlock()
+		if p.MaxRestarts > 0 && restarts >= p.MaxRestarts {
+			m.logger().Error("restarted %v times, giving up", restarts)
+			return false
+		}
+
+		if !m.backoff(restarts) {
+			return false
+		}
+
+		destroy(m)
+		m.status.mu.Lock()
+		m.status.restarts++
+		m.status.mu.Unlock()
+		m.logger().Release("restarting")
+		m.setRestarting(true)
+		err := m.init()
+		m.setRestarting(false)
+		if err == nil {
+			return true
+		} else if _, ok := err.(panicError); !ok { //异常已经记录
+			m.logger().Error("init: %v", err)
+		}
+	}
+}
+
+//标记模块的骨架正在重启,重新执行的Skeleton.Init释放Run异常时没有关闭的资源
+//RegisterCommand和RegisterChanRPC替换已经注册的命令和函数,而不是因为重复注册而失败
+func (m *module) setRestarting(restarting bool) {
+	if sk, ok := m.mi.(skeletal); ok && sk.skeleton() != nil {
+		sk.skeleton().restarting = restarting
+	}
+}
+
+//第restarts+1次重启前等待,返回false表示模块正在关闭
+func (m *module) backoff(restarts int) bool {
+	d := m.restart.Backoff
+	if d <= 0 {
+		d = DefaultRestartBackoff
+	}
+	max := m.restart.MaxBackoff
+	if max <= 0 {
+		max = DefaultRestartMaxBackoff
+	}
+	for i := 0; i < restarts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-m.closeSig: //关闭时不再重启,OnDestroy由Destroy执行
+		return false
+	}
+}
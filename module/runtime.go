@@ -0,0 +1,120 @@
+package module
+
+import (
+	"fmt"
+	"time"
+)
+
+//运行时注销模块时等待Run返回的默认最长时间,模块指定了WithDestroyTimeout时使用指定的时间
+var DefaultUnregisterTimeout = 10 * time.Second
+
+//运行时注册模块,立即执行OnInit并运行模块,需要在Init之后调用
+//依赖的模块必须已经注册,模块的名字不能与已注册的模块相同
+func RuntimeRegister(mi Module, opts ...Option) error {
+	m := new(module)
+	m.mi = mi
+	m.closeSig = make(chan bool, 1)
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	modsMu.Lock()
+	n := name(mi)
+	for _, o := range mods {
+		if _, named := mi.(Named); named && name(o.mi) == n {
+			modsMu.Unlock()
+			return fmt.Errorf("module %v: already registered", n)
+		}
+	}
+	if d, ok := mi.(Dependent); ok {
+		for _, dn := range d.Dependencies() {
+			dep := find(dn)
+			if dep == nil {
+				modsMu.Unlock()
+				return fmt.Errorf("module %v: dependency %v is not registered", n, dn)
+			}
+			m.deps = append(m.deps, dep)
+		}
+	}
+	mods = append(mods, m)
+	modsMu.Unlock()
+
+	if err := m.init(); err != nil {
+		modsMu.Lock()
+		remove(m)
+		modsMu.Unlock()
+		return fmt.Errorf("module %v: init: %w", n, err)
+	}
+	setReady(m)
+	start(m)
+	return nil
+}
+
+//运行时注销模块,发送关闭信号并等待Run返回,然后执行OnDestroy
+//Run没有及时返回时不执行OnDestroy并返回错误,模块仍然被注销
+//被其他模块依赖或正在被Destroy关闭的模块不能注销
+func RuntimeUnregister(n string) error {
+	modsMu.Lock()
+	m := find(n)
+	if m == nil {
+		modsMu.Unlock()
+		return fmt.Errorf("module %v: not registered", n)
+	}
+	if m.closing {
+		modsMu.Unlock()
+		return fmt.Errorf("module %v: being destroyed", n)
+	}
+	for _, o := range mods {
+		for _, dep := range o.deps {
+			if dep == m && !o.closing {
+				modsMu.Unlock()
+				return fmt.Errorf("module %v: required by %v", n, name(o.mi))
+			}
+		}
+	}
+	m.closing = true
+	remove(m)
+	modsMu.Unlock()
+
+	timeout := m.timeout
+	if timeout <= 0 {
+		timeout = DefaultUnregisterTimeout
+	}
+
+	m.closeSig <- true
+	c := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(c)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-c:
+	case <-t.C:
+		return fmt.Errorf("module %v: run still running after %v, not destroyed", n, timeout)
+	}
+
+	destroy(m)
+	return nil
+}
+
+//按名字查找模块,需要持有modsMu
+func find(n string) *module {
+	for _, m := range mods {
+		if name(m.mi) == n {
+			return m
+		}
+	}
+	return nil
+}
+
+//从mods中删除模块,需要持有modsMu
+func remove(m *module) {
+	for i, o := range mods {
+		if o == m {
+			mods = append(mods[:i], mods[i+1:]...)
+			return
+		}
+	}
+}
@@ -1,23 +1,51 @@
 package module
 
 import (
+	"context"
 	"github.com/name5566/leaf/chanrpc"
 	"github.com/name5566/leaf/console"
 	"github.com/name5566/leaf/go" //包名实际为g
 	"github.com/name5566/leaf/log"
 	"github.com/name5566/leaf/timer"
+	"strings"
 	"time"
 )
 
 //骨架
 type Skeleton struct {
 	GoLen              int               //Go管道长度
+	GoWorkers          int               //Go的工作goroutine数量,大于0时最多同时执行这么多个f,否则每个f一个goroutine
+	GoCloseTimeout     time.Duration     //关闭时等待Go的最长时间,为0时使用DefaultGoCloseTimeout,小于0时一直等待
+	GoKeepAlive        time.Duration     //大于0时执行完f的goroutine最多等待这么久以执行下一个f,GoWorkers大于0时无效
+	GoDiscardOnClose   bool              //关闭时不执行Go剩余的回调,交给OnGoDroppedCb设置的函数处理,此时不受GoCloseTimeout限制
 	TimerDispatcherLen int               //定时器分发器管道长度
+	TimerWheelTick     time.Duration     //时间轮的刻度,大于0时定时器使用时间轮,适合定时器很多的模块
+	TimerClock         timer.Clock       //定时器使用的时钟,为空时使用系统时钟,测试时可传入timer.FakeClock
+	TimerLaneLen       int               //高、低优先级定时器管道长度,大于0时启用优先级,普通优先级使用TimerDispatcherLen
+	TimerFullPolicy    timer.FullPolicy  //定时器管道满时的处理方式,默认阻塞
+	TickInterval       time.Duration     //大于0时每隔这么久执行一次OnTick注册的函数
+	TickPolicy         TickPolicy        //模块来不及执行tick时的处理方式,默认合并
+	OverloadThreshold  float64           //大于0时任一管道的长度超过容量的这个比例视为过载,见OnOverload
+	OverloadInterval   time.Duration     //过载期间OnOverload注册的函数最多每隔这么久执行一次,为0时使用DefaultOverloadInterval
+	PauseTimers        bool              //Pause期间也不执行定时器和tick
 	ChanRPCServer      *chanrpc.Server   //RPC服务器引用(外部传入)
 	g                  *g.Go             //leaf的Go机制
 	dispatcher         *timer.Dispatcher //定时器分发器
 	server             *chanrpc.Server   //RPC服务器引用(内部引用)
 	commandServer      *chanrpc.Server   //命令RPC服务器引用
+	ticker             *ticker           //tick的状态
+	tickC              <-chan time.Time  //没有设置TickInterval时为nil
+	inherit            *Skeleton         //热替换时被替换的模块的骨架,见Swap,Init后为nil
+	inherited          bool              //rpc服务器是否从被替换的模块接管
+	restarting         bool              //按RestartPolicy重启时为true,Init保留rpc服务器和命令rpc服务器,重复注册的函数和命令替换原来的
+	pauseC             chan chan bool    //热替换时暂停模块,从收到的管道读取是否恢复运行
+	queues             *queueStats       //各个管道的统计
+	host               *Skeleton         //通过RegisterOn注册时为组的骨架,共享它的goroutine、Go、定时器和命令rpc服务器
+	members            []*Skeleton       //组的骨架上运行的模块
+	logger             log.Named         //以模块名字为标签的日志,OnInit之前由模块运行时设置
+	overload           overload          //过载检查的状态
+	paused             int32             //是否暂停,原子操作,见Pause
+	ctrlC              chan struct{}     //Pause和Resume唤醒模块的goroutine
 }
 
 //初始化
@@ -30,15 +58,55 @@ func (s *Skeleton) Init() {
 		s.TimerDispatcherLen = 0
 	}
 
-	s.g = g.New(s.GoLen)                                     //创建Go
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen) //创建分发器
-	s.server = s.ChanRPCServer                               //外部传入的,内部引用
+	if s.host != nil { //在组的goroutine上运行
+		s.initMember()
+		return
+	}
+	if s.restarting && s.g != nil { //重启,释放Run异常时没有关闭的Go、定时器分发器和tick
+		s.release()
+	}
+
+	if s.GoWorkers > 0 { //使用有限的工作goroutine
+		s.g = g.NewPool(s.GoLen, s.GoWorkers)
+	} else {
+		s.g = g.New(s.GoLen, g.WithKeepAlive(s.GoKeepAlive)) //创建Go
+	}
+	var opts []timer.DispatcherOption
+	if s.TimerWheelTick > 0 { //使用时间轮
+		opts = append(opts, timer.WithTimingWheel(s.TimerWheelTick))
+	}
+	if s.TimerClock != nil { //使用指定的时钟
+		opts = append(opts, timer.WithClock(s.TimerClock))
+	}
+	if s.TimerLaneLen > 0 { //启用优先级
+		opts = append(opts, timer.WithPriorityLanes(s.TimerLaneLen))
+	}
+	opts = append(opts, timer.WithFullPolicy(s.TimerFullPolicy))
+	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen, opts...) //创建分发器
+	s.pauseC = make(chan chan bool)
+	s.ctrlC = make(chan struct{}, 1)
+	s.initStats()
+	if s.inherit != nil { //热替换,接管被替换的模块的rpc服务器
+		s.server = s.inherit.server
+		s.commandServer = s.inherit.commandServer
+		s.inherit, s.inherited = nil, true
+		s.initTicker()
+		s.initHealth()
+		return
+	}
+	if s.restarting && s.commandServer != nil { //重启,控制台中的命令仍然指向原来的命令rpc服务器
+		s.initTicker()
+		return
+	}
+	s.server = s.ChanRPCServer //外部传入的,内部引用
 
 	if s.server == nil { //外部传入的为空
 		s.server = chanrpc.NewServer(0) //内部创建一个
 	}
 
 	s.commandServer = chanrpc.NewServer(0) //创建命令RPC服务器
+	s.initTicker()
+	s.initHealth()
 }
 
 //实现了Module接口的Run方法并提供了:
@@ -48,30 +116,81 @@ func (s *Skeleton) Init() {
 //4.timer(用于定时器)
 func (s *Skeleton) Run(closeSig chan bool) {
 	for { //死循环
+		if s.Paused() && !s.runPaused(closeSig) { //暂停期间只处理关闭信号、命令和定时器
+			return
+		}
 		select {
 		case <-closeSig: //读取关闭信号
-			s.commandServer.Close() //关闭命令rpc服务器
-			s.server.Close()        //关闭rpc服务器
-			s.g.Close()             //关闭Go
+			s.close() //关闭rpc服务器、命令rpc服务器、Go、定时器分发器和tick
 			return
-		case ci := <-s.server.ChanCall: //从rpc服务器读取调用信息
-			err := s.server.Exec(ci) //执行调用
-			if err != nil {
-				log.Error("%v", err)
+		case <-s.ctrlC: //Pause或Resume
+		case resume := <-s.pauseC: //热替换,暂停期间不执行任何调用和回调
+			if !<-resume { //替换成功,rpc服务器已交给新模块
+				s.release()
+				return
 			}
+		case ci := <-s.server.Lane(chanrpc.PriorityHigh): //从高优先级函数的管道读取调用信息,没有这样的函数时为nil
+			s.execRPC(ci)
+		case ci := <-s.server.ChanCall: //从rpc服务器读取调用信息
+			s.execRPC(ci)
+		case ci := <-s.server.Lane(chanrpc.PriorityNormal): //从有独立缓冲的普通优先级函数的管道读取调用信息
+			s.execRPC(ci)
+		case ci := <-s.server.Lane(chanrpc.PriorityLow): //从低优先级函数的管道读取调用信息
+			s.execRPC(ci)
 		case ci := <-s.commandServer.ChanCall: //从命令rpc服务器读取调用信息
-			err := s.commandServer.Exec(ci) //执行命令调用
-			if err != nil {
-				log.Error("%v", err)
-			}
+			s.execCommand(ci)
 		case cb := <-s.g.ChanCb: //从Go的回调管道中读取回调函数
 			s.g.Cb(cb) //执行回调函数（不用自己写 d.Cb(<-d.ChanCb)了 ）
+		case t := <-s.dispatcher.Lane(timer.PriorityHigh): //从高优先级管道读取到时定时器,未启用优先级时为nil
+			s.execTimer(t) //执行定时器回调
 		case t := <-s.dispatcher.ChanTimer: //从分发器中读取到时定时器
-			t.Cb() //执行定时器回调
+			s.execTimer(t) //先执行高优先级的定时器,再执行定时器回调
+		case t := <-s.dispatcher.Lane(timer.PriorityLow): //从低优先级管道读取到时定时器
+			s.execTimer(t) //先执行更高优先级的定时器
+		case <-s.tickC: //执行tick,没有设置TickInterval时为nil
+			s.tick(time.Now()) //不使用ticker的时间,落后时它是tick进入管道的时间
 		}
+		s.checkOverload() //每处理完一项检查一次是否过载
+	}
+}
+
+//关闭Go、定时器分发器和tick,不关闭rpc服务器
+func (s *Skeleton) release() {
+	s.closeGo()               //关闭Go
+	s.dispatcher.Close(false) //关闭定时器分发器,丢弃已经到时但未执行的定时器
+	s.stopTicker()
+}
+
+//以模块名字为标签的日志,模块代码用它记录日志,例如m.Logger().Error(...)
+//没有通过module注册的Skeleton不带标签
+func (s *Skeleton) Logger() log.Named {
+	return s.logger
+}
+
+//执行rpc调用,先执行优先级更高的调用
+func (s *Skeleton) execRPC(ci *chanrpc.CallInfo) {
+	s.queues.rpc.observe(time.Since(ci.Enqueued()))
+	err := s.server.Exec(ci) //执行调用
+	if err != nil {
+		s.logger.Error("%v", chanrpc.ErrorWithStack(err)) //函数异常时附上异常发生处的堆栈
+	}
+}
+
+//执行命令调用
+func (s *Skeleton) execCommand(ci *chanrpc.CallInfo) {
+	s.queues.command.observe(time.Since(ci.Enqueued()))
+	err := s.commandServer.Exec(ci)
+	if err != nil {
+		s.logger.Error("%v", chanrpc.ErrorWithStack(err))
 	}
 }
 
+//执行定时器,先执行优先级更高的定时器
+func (s *Skeleton) execTimer(t *timer.Timer) {
+	s.queues.timer.observe(t.Waited())
+	s.dispatcher.Exec(t)
+}
+
 //注册定时器
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -81,6 +200,83 @@ func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+//注册在d到d+jitter之间随机时刻执行的定时器,避免大量定时器同时到时
+func (s *Skeleton) AfterFuncJitter(d time.Duration, jitter time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncJitter(d, jitter, cb)
+}
+
+//注册在时刻t执行的定时器,t已过去时立即执行,会跟随系统时间的调整
+func (s *Skeleton) At(t time.Time, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.At(t, cb)
+}
+
+//注册带标签的定时器,可以用StopTag一起停止
+func (s *Skeleton) AfterFuncTagged(tag string, d time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncTagged(tag, d, cb)
+}
+
+//注册带标签的cron
+func (s *Skeleton) CronFuncTagged(tag string, cronExpr *timer.CronExpr, cb func()) *timer.Cron {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronFuncTagged(tag, cronExpr, cb)
+}
+
+//停止带有tag标签的所有定时器和cron,返回停止的个数
+func (s *Skeleton) StopTag(tag string) int {
+	return s.dispatcher.StopTag(tag)
+}
+
+//注册定时器,ctx结束时自动停止,回调中应检查ctx.Err()
+func (s *Skeleton) AfterFuncCtx(ctx context.Context, d time.Duration, cb func(ctx context.Context)) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncCtx(ctx, d, cb)
+}
+
+//注册指定优先级的定时器,需要设置TimerLaneLen
+func (s *Skeleton) AfterFuncPriority(p timer.Priority, d time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncPriority(p, d, cb)
+}
+
+//一次注册多个定时器,比逐个调用AfterFunc开销小
+func (s *Skeleton) AfterFuncBatch(entries []timer.TimerEntry) []*timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncBatch(entries)
+}
+
+//注册按固定间隔重复的定时器
+func (s *Skeleton) TickerFunc(d time.Duration, cb func()) *timer.Ticker {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.TickerFunc(d, cb)
+}
+
 //注册cron
 func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
@@ -90,13 +286,230 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
+//注册cron,overrun指定回调执行期间到时的触发如何处理,backlog为CronQueue连续补执行的最大次数
+func (s *Skeleton) CronFuncOverrun(cronExpr *timer.CronExpr, overrun timer.CronOverrun, backlog int, cb func()) *timer.Cron {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronFuncOverrun(cronExpr, overrun, backlog, cb)
+}
+
+//注册cron,固定在loc时区计算,不受主机时区设置影响
+func (s *Skeleton) CronFuncInLocation(cronExpr *timer.CronExpr, loc *time.Location, cb func()) *timer.Cron {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronFuncInLocation(cronExpr, loc, cb)
+}
+
+//注册带名字的定时器,名字用于慢回调的报告
+func (s *Skeleton) AfterFuncNamed(name string, d time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncNamed(name, d, cb)
+}
+
+//注册带名字的cron
+func (s *Skeleton) CronFuncNamed(name string, cronExpr *timer.CronExpr, cb func()) *timer.Cron {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronFuncNamed(name, cronExpr, cb)
+}
+
+//设置慢回调的处理函数,回调执行时间达到threshold时调用fn,默认记录执行超过1秒的回调
+func (s *Skeleton) SetTimerSlowCallbackHandler(threshold time.Duration, fn func(d time.Duration, name string)) {
+	s.dispatcher.SetSlowCallbackHandler(threshold, fn)
+}
+
+//设置持久化定时器的存储,并重新注册其中的定时器,到时调用handler
+func (s *Skeleton) RestoreTimers(store timer.Store, handler func(id string, payload []byte)) error {
+	return s.dispatcher.Restore(store, handler)
+}
+
+//注册持久化的定时器,进程重启后可以用RestoreTimers恢复
+func (s *Skeleton) AfterFuncPersistent(id string, fireAt time.Time, payload []byte) (*timer.Timer, error) {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncPersistent(id, fireAt, payload)
+}
+
+//通过定时器重试op,直到成功或达到最大次数,最后调用done
+func (s *Skeleton) Retry(opts timer.RetryOptions, op func() error, done func(error)) *timer.Retry {
+	if s.TimerDispatcherLen == 0 { //判断定时器分发管道长度
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.Retry(opts, op, done)
+}
+
+//暂停所有cron,暂停期间到时的触发被跳过
+func (s *Skeleton) PauseCrons() {
+	s.dispatcher.PauseAll()
+}
+
+//恢复所有暂停的cron,从当前时间重新计算下次触发时间
+func (s *Skeleton) ResumeCrons() {
+	s.dispatcher.ResumeAll()
+}
+
+//设置定时器回调panic时的处理函数,默认记录日志
+func (s *Skeleton) SetTimerPanicHandler(h func(recovered interface{}, stack []byte)) {
+	s.dispatcher.SetPanicHandler(h)
+}
+
+//定时器分发器的统计信息
+func (s *Skeleton) TimerStats() timer.DispatcherStats {
+	return s.dispatcher.Stats()
+}
+
+//定时器分发器中所有存活定时器的快照
+func (s *Skeleton) TimerDump() []timer.TimerInfo {
+	return s.dispatcher.Dump()
+}
+
+//注册查看定时器的控制台命令,输出统计信息和每个存活的定时器
+func (s *Skeleton) RegisterTimerCommand(name string) {
+	s.RegisterCommand(name, "dump the pending timers", func(args []interface{}) interface{} {
+		lines := []string{s.dispatcher.Stats().String()}
+		for _, info := range s.dispatcher.Dump() {
+			lines = append(lines, info.String())
+		}
+		return strings.Join(lines, "\r\n")
+	})
+}
+
+//启用rpc服务器的调用统计,并注册打印每个函数id统计表的控制台命令
+func (s *Skeleton) RegisterRPCMetricsCommand(name string) {
+	s.server.EnableMetrics()
+	s.RegisterCommand(name, "print the rpc call metrics", func(args []interface{}) interface{} {
+		return chanrpc.FormatMetrics(s.server.Metrics())
+	})
+}
+
+//Go中未完成的f的数量,回调执行后减少
+func (s *Skeleton) GoPending() int {
+	return s.g.Pending()
+}
+
+//Go中正在执行的f的数量
+func (s *Skeleton) GoRunning() int {
+	return s.g.Running()
+}
+
+//Go中所有未完成的f的快照,按提交时间排序
+func (s *Skeleton) GoDump() []g.JobInfo {
+	return s.g.Dump()
+}
+
+//设置处理被丢弃的Go回调的函数,关闭超时后丢弃的回调在f的goroutine中处理,需要在Init之后调用
+func (s *Skeleton) OnGoDroppedCb(h func(cb func())) {
+	s.g.OnDroppedCb(h)
+}
+
+//关闭时等待Go的默认最长时间
+var DefaultGoCloseTimeout = 10 * time.Second
+
+//关闭Go,超时后不再等待未完成的f,它们的回调被丢弃
+func (s *Skeleton) closeGo() {
+	if s.GoDiscardOnClose {
+		if n := s.g.CloseDiscard(); n > 0 {
+			s.logger.Release("close go: %v callbacks dropped", n)
+		}
+		return
+	}
+
+	d := s.GoCloseTimeout
+	if d == 0 {
+		d = DefaultGoCloseTimeout
+	}
+	if d < 0 {
+		s.g.Close()
+		return
+	}
+
+	if n := s.g.CloseTimeout(d); n > 0 {
+		s.logger.Error("close go: %v jobs still running after %v, callbacks dropped", n, d)
+	}
+}
+
 //一般的go
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 { //如果Go管道为空
 		panic("invalid GoLen")
 	}
 
-	s.g.Go(f, cb)
+	if trace := s.server.TraceID(); trace != 0 && cb != nil { //回调延续正在执行的rpc调用的跟踪id
+		_cb := cb
+		cb = func() {
+			s.server.WithTrace(trace, _cb)
+		}
+	}
+	s.checkGo(s.g.Go(f, cb))
+}
+
+//带名字的go,名字在GoDump中显示
+func (s *Skeleton) GoNamed(name string, f func(), cb func()) {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	if trace := s.server.TraceID(); trace != 0 && cb != nil {
+		_cb := cb
+		cb = func() {
+			s.server.WithTrace(trace, _cb)
+		}
+	}
+	s.checkGo(s.g.GoNamed(name, f, cb))
+}
+
+//可以在f开始执行前取消的go,取消后不执行回调
+func (s *Skeleton) GoCancelable(f func(), cb func()) *g.Handle {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	if trace := s.server.TraceID(); trace != 0 && cb != nil {
+		_cb := cb
+		cb = func() {
+			s.server.WithTrace(trace, _cb)
+		}
+	}
+	h, err := s.g.GoCancelable(f, cb)
+	s.checkGo(err)
+	return h
+}
+
+//带上下文的go,ctx取消或模块关闭时f的上下文被取消,f开始前已取消时跳过f,回调收到上下文的错误
+func (s *Skeleton) GoCtx(ctx context.Context, f func(ctx context.Context), cb func(err error)) {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	if trace := s.server.TraceID(); trace != 0 && cb != nil {
+		_cb := cb
+		cb = func(err error) {
+			s.server.WithTrace(trace, func() {
+				_cb(err)
+			})
+		}
+	}
+	s.checkGo(s.g.GoCtx(ctx, f, cb))
+}
+
+//Go、GoNamed、GoCancelable和GoCtx提交失败时记录日志并抛错,例如模块的Go已经关闭
+func (s *Skeleton) checkGo(err error) {
+	if err != nil {
+		s.logger.Error("go: %v", err)
+		panic(err)
+	}
 }
 
 //创建线性上下文，再执行线性上下文的Go
@@ -108,17 +521,47 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+//创建有序上下文,f并行执行,回调按提交顺序执行,最多max个未完成的f
+func (s *Skeleton) NewOrderedContext(max int) *g.OrderedContext {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewOrderedContext(max)
+}
+
+//创建流水线,Bg阶段在后台执行,Fg阶段在模块的goroutine中执行
+func (s *Skeleton) Pipeline() *g.Pipeline {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.Pipeline()
+}
+
 //向管道RPC注册函数
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil { //外部没有传入RPC服务器
 		panic("invalid ChanRPCServer") //抛错
 	}
 
+	if s.restarting && s.server.Registered(id) { //重启时再次注册,替换原来的函数
+		s.server.Replace(id, f)
+		return
+	}
 	s.server.Register(id, f) //注册函数f
 }
 
 //注册命令
 func (s *Skeleton) RegisterCommand(name string, help string, f interface{}) {
+	if s.restarting && s.commandServer.Registered(name) { //重启时控制台中已经有这个命令,只替换它的函数
+		s.commandServer.Replace(name, f)
+		return
+	}
+	if s.inherited { //热替换时控制台已经初始化,只能替换已有命令的函数
+		s.commandServer.Register(name, f)
+		return
+	}
 	console.Register(name, help, f, s.commandServer) //调用控制台的注册功能
 	//实际上是将函数注册进s.commandServer,但是控制台也需要注册命令,以向s.commandServer发起rpc调用
 }
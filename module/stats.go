@@ -0,0 +1,123 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"github.com/name5566/leaf/console"
+	"github.com/name5566/leaf/timer"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Skeleton的一个管道的统计
+type QueueStats struct {
+	Len       int           //管道中等待的数量
+	Cap       int           //管道的容量
+	Processed uint64        //启动以来处理的数量
+	AvgWait   time.Duration //排队时间的滑动平均,越近的样本权重越大
+}
+
+func (qs QueueStats) String() string {
+	return fmt.Sprintf("%v/%v wait %v processed %v", qs.Len, qs.Cap, qs.AvgWait, qs.Processed)
+}
+
+//Skeleton各个管道的统计,用于找出模块积压的位置
+type SkeletonStats struct {
+	RPC     QueueStats //ChanRPCServer,包括有独立缓冲的函数的管道
+	Command QueueStats //命令rpc服务器
+	Go      QueueStats //Go的回调管道
+	Timer   QueueStats //定时器分发器,包括优先级管道
+}
+
+func (st SkeletonStats) String() string {
+	return fmt.Sprintf("rpc %v, command %v, go %v, timer %v", st.RPC, st.Command, st.Go, st.Timer)
+}
+
+//一个管道的处理数量和排队时间,只在模块的goroutine中更新
+type queueStat struct {
+	processed uint64 //原子操作
+	avg       int64  //排队时间的滑动平均,纳秒,原子操作
+}
+
+//滑动平均中新样本的权重为1/avgWeight
+const avgWeight = 8
+
+func (q *queueStat) observe(wait time.Duration) {
+	if atomic.AddUint64(&q.processed, 1) == 1 {
+		atomic.StoreInt64(&q.avg, int64(wait))
+		return
+	}
+	avg := atomic.LoadInt64(&q.avg)
+	atomic.StoreInt64(&q.avg, avg+(int64(wait)-avg)/avgWeight)
+}
+
+func (q *queueStat) stats(l int, c int) QueueStats {
+	return QueueStats{
+		Len:       l,
+		Cap:       c,
+		Processed: atomic.LoadUint64(&q.processed),
+		AvgWait:   time.Duration(atomic.LoadInt64(&q.avg)),
+	}
+}
+
+//各个管道的统计
+type queueStats struct {
+	rpc     queueStat
+	command queueStat
+	g       queueStat
+	timer   queueStat
+}
+
+func (s *Skeleton) initStats() {
+	s.queues = new(queueStats)
+	s.g.OnCbQueued(s.queues.g.observe)
+}
+
+//各个管道的长度、容量、处理数量和平均排队时间,goroutine safe,需要在Init之后调用
+//通过RegisterOn注册的模块返回整个组的统计
+func (s *Skeleton) Stats() SkeletonStats {
+	if s.host != nil {
+		return s.host.Stats()
+	}
+
+	var st SkeletonStats
+	l, c := s.rpcLen()
+	for _, m := range s.members { //组的骨架包括所有模块的rpc服务器
+		ml, mc := m.rpcLen()
+		l, c = l+ml, c+mc
+	}
+	st.RPC = s.queues.rpc.stats(l, c)
+	st.Command = s.queues.command.stats(len(s.commandServer.ChanCall), cap(s.commandServer.ChanCall))
+	st.Go = s.queues.g.stats(len(s.g.ChanCb), cap(s.g.ChanCb))
+	l, c = len(s.dispatcher.ChanTimer), cap(s.dispatcher.ChanTimer)
+	for _, p := range []timer.Priority{timer.PriorityHigh, timer.PriorityLow} {
+		lane := s.dispatcher.Lane(p)
+		l, c = l+len(lane), c+cap(lane)
+	}
+	st.Timer = s.queues.timer.stats(l, c)
+	return st
+}
+
+//rpc服务器所有管道的长度和容量
+func (s *Skeleton) rpcLen() (l int, c int) {
+	l, c = len(s.server.ChanCall), cap(s.server.ChanCall)
+	for _, p := range []chanrpc.Priority{chanrpc.PriorityHigh, chanrpc.PriorityNormal, chanrpc.PriorityLow} {
+		lane := s.server.Lane(p)
+		l, c = l+len(lane), c+cap(lane)
+	}
+	return
+}
+
+//注册打印所有使用Skeleton的模块的管道统计的控制台命令,每个模块一行
+func RegisterStatsCommand(cmd string) {
+	console.RegisterFunc(cmd, "print the queue stats of every module", func([]string) string {
+		var lines []string
+		for _, m := range registered() {
+			if sk, ok := m.mi.(skeletal); ok && hasSkeleton(m.mi) {
+				lines = append(lines, fmt.Sprintf("%v: %v", label(m.mi), sk.skeleton().Stats()))
+			}
+		}
+		return strings.Join(lines, "\r\n")
+	})
+}
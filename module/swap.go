@@ -0,0 +1,177 @@
+package module
+
+import (
+	"fmt"
+	"time"
+)
+
+//新模块实现该接口以在热替换时接管被替换的模块的状态
+//在新模块OnInit之后调用,此时被替换的模块已经暂停,可以安全地读取from
+//返回错误时热替换失败,恢复运行被替换的模块
+type StateTransferer interface {
+	TransferState(from Module) error
+}
+
+//热替换时等待被替换的模块暂停和退出的默认最长时间
+var DefaultSwapTimeout = 10 * time.Second
+
+//嵌入了Skeleton的模块实现了该接口
+type skeletal interface {
+	skeleton() *Skeleton
+}
+
+func (s *Skeleton) skeleton() *Skeleton {
+	return s
+}
+
+//热替换名字为n的模块,两个模块都必须嵌入Skeleton,新模块的名字必须为n
+//新模块的Skeleton必须在调用Swap之前设置,不能在OnInit中才设置,新模块在OnInit中调用Skeleton.Init
+//先暂停被替换的模块,再执行新模块的OnInit和TransferState,新模块接管同一个rpc服务器和命令rpc服务器,
+//所以通过ChanRPCServer的路由(包括gate转发给模块的消息)不受影响,暂停期间的调用在服务器的管道中等待新模块执行
+//新模块运行后被替换的模块执行完剩余的Go回调后退出,然后执行它的OnDestroy
+//任何一步失败时恢复运行被替换的模块并返回错误
+//新模块不能使用RegisterWithOptions,只能替换已经注册到控制台的命令
+func Swap(n string, mi Module) error {
+	modsMu.Lock()
+	old := find(n)
+	if old == nil {
+		modsMu.Unlock()
+		return fmt.Errorf("module %v: not registered", n)
+	}
+	if old.closing {
+		modsMu.Unlock()
+		return fmt.Errorf("module %v: being destroyed", n)
+	}
+	old.closing = true
+	modsMu.Unlock()
+
+	err := swap(old, n, mi)
+	if err != nil {
+		modsMu.Lock()
+		old.closing = false
+		modsMu.Unlock()
+	}
+	return err
+}
+
+func swap(old *module, n string, mi Module) error {
+	if _, ok := old.mi.(*group); ok {
+		return fmt.Errorf("module %v: a group cannot be swapped", n)
+	}
+	om, ok := old.mi.(skeletal)
+	nm, ok2 := mi.(skeletal)
+	if !ok || !ok2 {
+		return fmt.Errorf("module %v: swap requires both modules to embed Skeleton", n)
+	}
+	if name(mi) != n {
+		return fmt.Errorf("module %v: new module is named %v", n, name(mi))
+	}
+	o, s := om.skeleton(), nm.skeleton()
+	if s == nil {
+		return fmt.Errorf("module %v: new module has no Skeleton", n)
+	}
+	if s.ChanRPCServer == nil {
+		s.ChanRPCServer = o.ChanRPCServer
+	} else if s.ChanRPCServer != o.server {
+		return fmt.Errorf("module %v: new module uses a different ChanRPCServer", n)
+	}
+
+	resume := make(chan bool, 1)
+	t := time.NewTimer(DefaultSwapTimeout)
+	select {
+	case o.pauseC <- resume: //收到后被替换的模块不再执行任何调用
+		t.Stop()
+	case <-t.C:
+		return fmt.Errorf("module %v: not paused after %v", n, DefaultSwapTimeout)
+	}
+
+	restore := o.server.ResetFunctions()
+	restoreCommands := o.commandServer.ResetFunctions()
+	m := &module{
+		mi:          mi,
+		closeSig:    make(chan bool, 1),
+		priority:    old.priority,
+		hasPriority: old.hasPriority,
+		after:       old.after,
+		timeout:     old.timeout,
+		deps:        old.deps,
+		restart:     old.restart,
+		ready:       true,
+	}
+	s.inherit = o
+	err := initSwapped(m, s, old.mi)
+	if err != nil {
+		restore()
+		restoreCommands()
+		resume <- true
+		return fmt.Errorf("module %v: swap: %w", n, err)
+	}
+
+	modsMu.Lock()
+	for i, mm := range mods {
+		if mm == old {
+			mods[i] = m
+		}
+		for j, dep := range mm.deps {
+			if dep == old {
+				mm.deps[j] = m
+			}
+		}
+		for j, a := range mm.after {
+			if a == old.mi {
+				mm.after[j] = mi
+			}
+		}
+	}
+	modsMu.Unlock()
+	start(m)
+
+	resume <- false //被替换的模块执行完剩余的Go回调后退出
+	c := make(chan struct{})
+	go func() {
+		old.wg.Wait()
+		close(c)
+	}()
+	t = time.NewTimer(DefaultSwapTimeout)
+	defer t.Stop()
+	select {
+	case <-c:
+		destroy(old)
+	case <-t.C:
+		old.logger().Error("replaced module still running after %v, not destroyed", DefaultSwapTimeout)
+	}
+	return nil
+}
+
+//执行新模块的OnInit和TransferState,失败时释放新模块已经创建的资源
+func initSwapped(m *module, s *Skeleton, from Module) error {
+	if err := m.init(); err != nil {
+		if s.inherited {
+			s.release()
+		}
+		s.inherit = nil
+		return fmt.Errorf("init: %w", err)
+	}
+	if !s.inherited {
+		s.inherit = nil
+		destroy(m)
+		return fmt.Errorf("init: Skeleton.Init not called")
+	}
+
+	st, ok := m.mi.(StateTransferer)
+	if !ok {
+		return nil
+	}
+	var err error
+	if r := m.call(func() {
+		err = st.TransferState(from)
+	}); r != nil {
+		err = fmt.Errorf("panic: %v", r)
+	}
+	if err != nil {
+		s.release()
+		destroy(m)
+		return fmt.Errorf("transfer state: %w", err)
+	}
+	return nil
+}
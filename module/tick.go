@@ -0,0 +1,117 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/conf"
+	"runtime"
+	"sync"
+	"time"
+)
+
+//处理来不及执行的tick的方式
+type TickPolicy int
+
+const (
+	TickCoalesce TickPolicy = iota //合并为一次tick,delta为距上次tick的实际时间
+	TickSkip                       //丢弃,delta总是TickInterval
+)
+
+//tick的统计信息
+type TickStats struct {
+	Ticks        uint64        //执行的tick数
+	Missed       uint64        //合并或丢弃的tick数
+	LastDuration time.Duration //最近一次tick处理函数的执行时间
+	MaxDuration  time.Duration
+	AvgDuration  time.Duration
+}
+
+func (st TickStats) String() string {
+	return fmt.Sprintf("ticks %v, missed %v, last %v, max %v, avg %v",
+		st.Ticks, st.Missed, st.LastDuration, st.MaxDuration, st.AvgDuration)
+}
+
+//tick的状态
+type ticker struct {
+	t        *time.Ticker
+	handlers []func(delta time.Duration)
+	last     time.Time
+	mu       sync.Mutex //保护stats和total
+	stats    TickStats
+	total    time.Duration
+}
+
+//注册tick处理函数,在模块的goroutine中每TickInterval执行一次,delta为距上次tick的时间
+//需要在Init之后调用,TickInterval为0时不会执行
+func (s *Skeleton) OnTick(f func(delta time.Duration)) {
+	s.ticker.handlers = append(s.ticker.handlers, f)
+}
+
+//tick的统计信息,goroutine安全
+func (s *Skeleton) TickStats() TickStats {
+	s.ticker.mu.Lock()
+	defer s.ticker.mu.Unlock()
+	return s.ticker.stats
+}
+
+func (s *Skeleton) initTicker() {
+	s.ticker = new(ticker)
+	if s.TickInterval > 0 {
+		s.ticker.t = time.NewTicker(s.TickInterval)
+		s.ticker.last = time.Now()
+		s.tickC = s.ticker.t.C
+	}
+}
+
+func (s *Skeleton) stopTicker() {
+	if s.ticker.t != nil {
+		s.ticker.t.Stop()
+	}
+}
+
+//执行tick,落后时按TickPolicy处理错过的tick
+func (s *Skeleton) tick(now time.Time) {
+	tk := s.ticker
+	elapsed := now.Sub(tk.last)
+	tk.last = now
+	var missed uint64
+	if n := elapsed / s.TickInterval; n > 1 {
+		missed = uint64(n - 1)
+	}
+
+	delta := elapsed
+	if s.TickPolicy == TickSkip {
+		delta = s.TickInterval
+	}
+
+	start := time.Now()
+	for _, f := range tk.handlers {
+		s.callTick(f, delta)
+	}
+	d := time.Since(start)
+
+	tk.mu.Lock()
+	tk.stats.Ticks++
+	tk.stats.Missed += missed
+	tk.stats.LastDuration = d
+	if d > tk.stats.MaxDuration {
+		tk.stats.MaxDuration = d
+	}
+	tk.total += d
+	tk.stats.AvgDuration = tk.total / time.Duration(tk.stats.Ticks)
+	tk.mu.Unlock()
+}
+
+func (s *Skeleton) callTick(f func(delta time.Duration), delta time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				s.logger.Error("tick: %v: %s", r, buf[:l])
+			} else {
+				s.logger.Error("tick: %v", r)
+			}
+		}
+	}()
+	f(delta)
+}
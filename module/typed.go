@@ -0,0 +1,51 @@
+package module
+
+import (
+	"fmt"
+	"github.com/name5566/leaf/chanrpc"
+	"reflect"
+)
+
+//检查是否可以向管道RPC注册id
+func (s *Skeleton) checkRegister(id interface{}) error {
+	if s.ChanRPCServer == nil { //外部没有传入RPC服务器
+		panic("invalid ChanRPCServer")
+	}
+	if s.server.Registered(id) {
+		return fmt.Errorf("function id %v: already registered", id)
+	}
+	return nil
+}
+
+//向管道RPC注册一个参数、无返回值的函数,见chanrpc.Register1,id已注册时返回错误
+func Register1[A any](s *Skeleton, id interface{}, f func(A)) error {
+	if err := s.checkRegister(id); err != nil {
+		return err
+	}
+	chanrpc.Register1(s.server, id, f)
+	return nil
+}
+
+//向管道RPC注册一个参数、一个返回值的函数,见chanrpc.Register1R,id已注册时返回错误
+func Register1R[A, R any](s *Skeleton, id interface{}, f func(A) R) error {
+	if err := s.checkRegister(id); err != nil {
+		return err
+	}
+	chanrpc.Register1R(s.server, id, f)
+	return nil
+}
+
+//向管道RPC注册处理消息M的函数,id为M的类型,与处理器SetRouter的消息类型相同
+//f的参数为处理器路由的消息和userData,gate路由的userData为gate.Agent
+//M不是指针或id已注册时返回错误
+func RegisterMsg[M, U any](s *Skeleton, f func(msg M, userData U)) error {
+	id := reflect.TypeOf((*M)(nil)).Elem()
+	if id.Kind() != reflect.Ptr {
+		return fmt.Errorf("message %v: pointer required", id)
+	}
+	if err := s.checkRegister(id); err != nil {
+		return err
+	}
+	chanrpc.Register2(s.server, id, f)
+	return nil
+}
@@ -0,0 +1,62 @@
+package timer
+
+import (
+	"container/heap"
+	"time"
+)
+
+type TimerEntry struct {
+	Delay time.Duration
+	Cb    func()
+}
+
+// AfterFuncBatch arms a timer per entry under a single lock, the timers are
+// allocated together and returned in the order of the entries
+func (disp *Dispatcher) AfterFuncBatch(entries []TimerEntry) []*Timer {
+	now := disp.clock.Now()
+	slab := make([]Timer, len(entries))
+	timers := make([]*Timer, len(entries))
+	for i := range entries {
+		t := &slab[i]
+		t.disp = disp
+		t.cb = entries[i].Cb
+		t.index = -1
+		t.created = now
+		timers[i] = t
+	}
+
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+	if disp.closed {
+		return timers
+	}
+
+	// heapify once rather than sifting every timer up, unless the heap is
+	// larger than the batch
+	heapify := len(disp.timers) < len(timers)
+	for i, t := range timers {
+		t.when = now.Add(entries[i].Delay)
+		disp.seq++
+		t.seq = disp.seq
+		switch {
+		case disp.wheel != nil:
+			disp.wheel.add(t)
+		case heapify:
+			t.index = len(disp.timers)
+			disp.timers = append(disp.timers, t)
+		default:
+			heap.Push(&disp.timers, t)
+		}
+	}
+	disp.pending += len(timers)
+
+	if disp.wheel == nil && len(timers) > 0 {
+		if heapify {
+			heap.Init(&disp.timers)
+		}
+		if first := disp.timers[0]; disp.next.IsZero() || first.when.Before(disp.next) {
+			disp.arm(first.when)
+		}
+	}
+	return timers
+}
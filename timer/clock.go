@@ -0,0 +1,130 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a Dispatcher
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d, like time.AfterFunc
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer created by a Clock, *time.Timer implements it
+type ClockTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// the default Clock, backed by the time package
+var RealClock Clock = realClock{}
+
+// FakeClock is a Clock that only moves when Advance is called, for tests.
+// It is goroutine safe.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *FakeClock
+	f    func()
+	when time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = now
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	t := &fakeTimer{c: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d and calls the functions of the timers
+// due meanwhile, in order and synchronously, with Now returning their due
+// time. A Dispatcher sends its due timers to ChanTimer from there, so ChanTimer
+// must have room for them or be drained by another goroutine.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		var first *fakeTimer
+		for _, t := range c.timers {
+			if !t.when.After(end) && (first == nil || t.when.Before(first.when)) {
+				first = t
+			}
+		}
+		if first == nil {
+			break
+		}
+		c.remove(first)
+		if first.when.After(c.now) {
+			c.now = first.when
+		}
+		c.mu.Unlock()
+		first.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Jump steps the wall clock by d, backwards if d is negative, without time
+// passing: timers keep their remaining durations, like runtime timers do.
+func (c *FakeClock) Jump(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		t.when = t.when.Add(d)
+	}
+	c.mu.Unlock()
+}
+
+// must be called with c.mu held
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, ct := range c.timers {
+		if ct == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.c.remove(t)
+	t.when = t.c.now.Add(d)
+	t.c.timers = append(t.c.timers, t)
+	return active
+}
@@ -0,0 +1,69 @@
+package timer
+
+import (
+	"container/list"
+	"sync"
+)
+
+//NewCronExpr缓存的表达式个数
+const cronCacheSize = 1024
+
+//解析过的表达式,按最近使用淘汰
+//缓存的*CronExpr是共享的,不能修改
+type cronCache struct {
+	sync.Mutex
+	size  int
+	items map[string]*list.Element
+	lru   *list.List
+}
+
+type cronCacheItem struct {
+	expr     string
+	cronExpr *CronExpr
+}
+
+var defaultCronCache = newCronCache(cronCacheSize)
+
+func newCronCache(size int) *cronCache {
+	return &cronCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+func (c *cronCache) get(expr string) *CronExpr {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.items[expr]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cronCacheItem).cronExpr
+}
+
+//加入缓存,已经存在时返回已有的表达式
+func (c *cronCache) add(expr string, cronExpr *CronExpr) *CronExpr {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.items[expr]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cronCacheItem).cronExpr
+	}
+	c.items[expr] = c.lru.PushFront(&cronCacheItem{expr, cronExpr})
+	for c.lru.Len() > c.size {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.items, elem.Value.(*cronCacheItem).expr)
+	}
+	return cronExpr
+}
+
+func (c *cronCache) len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,86 @@
+package timer
+
+import (
+	"fmt"
+)
+
+//解析失败的原因
+type ParseReason int
+
+const (
+	ReasonInvalid        ParseReason = iota //其他错误
+	ReasonFieldCount                        //字段个数不是5、6或7
+	ReasonTimeZone                          //CRON_TZ时区错误
+	ReasonDescriptor                        //未知的@预定义表达式
+	ReasonTooManySlashes                    //多个"/"
+	ReasonTooManyHyphens                    //多个"-"
+	ReasonInvalidRange                      //范围格式错误或起始值大于结束值
+	ReasonOutOfRange                        //超出字段的取值范围
+	ReasonBadIncrement                      //增幅错误
+	ReasonInvalidValue                      //无法识别的值
+	ReasonMisplaced                         //特殊字符用在了不允许的位置,如?、W、#
+)
+
+var parseReasonNames = []string{
+	"invalid",
+	"field-count",
+	"time-zone",
+	"descriptor",
+	"too-many-slashes",
+	"too-many-hyphens",
+	"invalid-range",
+	"out-of-range",
+	"bad-increment",
+	"invalid-value",
+	"misplaced",
+}
+
+func (r ParseReason) String() string {
+	if r < 0 || int(r) >= len(parseReasonNames) {
+		return fmt.Sprintf("ParseReason(%d)", int(r))
+	}
+	return parseReasonNames[r]
+}
+
+//cron表达式的解析错误,可以用errors.As取得
+type ParseError struct {
+	Expr       string      //完整的表达式
+	FieldName  string      //出错的字段名,如"minutes"、"day-of-week",与字段无关时为空
+	FieldIndex int         //出错的字段在表达式中的位置,从0开始,与字段无关时为-1
+	Token      string      //出错的部分
+	Reason     ParseReason //出错的原因
+	Err        error       //底层错误,如加载时区的错误,可以为nil
+	msg        string
+}
+
+func (e *ParseError) Error() string {
+	if e.FieldName == "" {
+		return fmt.Sprintf("invalid expr %v: %v", e.Expr, e.msg)
+	}
+	return fmt.Sprintf("invalid expr %v: %v: %v", e.Expr, e.FieldName, e.msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+//字段内的错误,由parse补充表达式和字段信息
+func fieldError(reason ParseReason, token string, format string, a ...interface{}) error {
+	return &ParseError{
+		FieldIndex: -1,
+		Token:      token,
+		Reason:     reason,
+		msg:        fmt.Sprintf(format, a...),
+	}
+}
+
+//与字段无关的错误
+func exprError(expr string, reason ParseReason, token string, format string, a ...interface{}) error {
+	return &ParseError{
+		Expr:       expr,
+		FieldIndex: -1,
+		Token:      token,
+		Reason:     reason,
+		msg:        fmt.Sprintf(format, a...),
+	}
+}
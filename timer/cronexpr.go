@@ -2,21 +2,55 @@ package timer
 
 // reference: https://github.com/robfig/cron
 import (
-	"fmt"
+	"hash/fnv"
 	"math"
+	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , - H
+// Minutes      | Yes        | 0-59            | * / , - H
+// Hours        | Yes        | 0-23            | * / , - H
+// Day of month | Yes        | 1-31            | * / , - ? L W H
+// Month        | Yes        | 1-12 or JAN-DEC | * / , - H
+// Day of week  | Yes        | 0-7 or SUN-SAT  | * / , - ? L # H
+// Year         | No         | 1970-2099       | * / , - H
+//
+// L in day-of-month means the last day of the month,
+// dL in day-of-week means the last weekday d of the month (5L is the last Friday).
+// NW in day-of-month means the weekday (Monday to Friday) nearest to the Nth,
+// without leaving the month. LW means the last weekday of the month.
+// d#n in day-of-week means the nth weekday d of the month (2#2 is the second Tuesday).
+// Both 0 and 7 in day-of-week mean Sunday, so 5-7 is Friday through Sunday.
+// Ranges wrap around in every field but day-of-month and year, so 22-2 in
+// hours is 22,23,0,1,2 and FRI-MON in day-of-week is Friday through Monday.
+// ? in day-of-month or day-of-week means no specific value, like *, so only the
+// other day field is used (0 0 9 ? * MON is every Monday at 09:00).
+//
+// H in any field stands for a stable value hashed from a seed, so schedules
+// sharing an expression do not all fire at once. H(a-b) limits the value to
+// a-b, H/n and H(a-b)/n fire every n starting at a hashed offset below n.
+// Plain H in day-of-month is limited to 1-28 so every month matches.
+//
+// Predefined schedules:
+// @yearly (or @annually) | 0 0 0 1 1 *
+// @monthly               | 0 0 0 1 * *
+// @weekly                | 0 0 0 * * 0
+// @daily (or @midnight)  | 0 0 0 * * *
+// @hourly                | 0 0 * * * *
+// @every <duration>      | every duration, as parsed by time.ParseDuration
+//
+// A leading CRON_TZ=<location> (or TZ=<location>) pins the evaluation to
+// that location, e.g. "CRON_TZ=Asia/Shanghai 0 0 5 * * *".
+//
+// A trailing ~ <duration> makes Next add a random delay of up to duration to
+// every occurrence, e.g. "0 0 4 * * * ~ 300s". The delay never reaches the
+// following occurrence.
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,60 +58,325 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	lastDom bool           //L,每月最后一天
+	lastDow uint64         //dL,每月最后一个星期d,按星期记录
+	nthDow  uint64         //d#n,每月第n个星期d,按(n-1)*7+d记录
+	domW    uint64         //NW,离N号最近的工作日,按N记录
+	lastW   bool           //LW,每月最后一个工作日
+	domAny  bool           //day-of-month没有限定(*或?),只按day-of-week匹配
+	dowAny  bool           //day-of-week没有限定(*或?),只按day-of-month匹配
+	year    []uint64       //Year,按year-minYear记录,为nil表示没有设置
+	every   time.Duration  //@every的间隔,不为0时忽略其他字段
+	loc     *time.Location //计算时使用的时区,为nil表示使用传入时间的时区
+	jitter  time.Duration  //Next随机延迟的上限,为0表示不延迟
+	rand    *jitterRand    //随机延迟使用的随机数,为nil表示使用math/rand
+}
+
+//带锁的随机数,rand.Rand不是goroutine safe的
+type jitterRand struct {
+	sync.Mutex
+	r *rand.Rand
+}
+
+//[0,n)内的随机数,r为nil时使用math/rand
+func (r *jitterRand) int63n(n int64) int64 {
+	if r == nil {
+		return rand.Int63n(n)
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.r.Int63n(n)
 }
 
+//Year字段的取值范围
+const (
+	minYear = 1970
+	maxYear = 2099
+)
+
+//月份别名,不区分大小写
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+//星期别名,不区分大小写
+var dowNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+//预定义的cron表达式
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+//字段名,按补齐Seconds后的位置
+var cronFieldNames = []string{"seconds", "minutes", "hours", "day-of-month", "month", "day-of-week", "year"}
+
+//解析选项
+type cronParser struct {
+	strict bool   //严格模式,带增幅的范围只产生一个值时报错
+	seed   string //H的散列种子
+	name   string //正在解析的字段名,用于错误信息和H的散列
+}
+
+//NewCronExpr中H使用的盐,进程内不变
+var cronHashSalt = strconv.FormatInt(time.Now().UnixNano(), 36)
+
 //创建cron表达式
+//相同的表达式返回同一个*CronExpr,不要修改它
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
-	fields := strings.Fields(expr)            //用空格分割表达式
-	if len(fields) != 5 && len(fields) != 6 { //数组长度为5或者6,因为Seconds不是强制设置的
-		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
+	if cronExpr = defaultCronCache.get(expr); cronExpr != nil {
 		return
 	}
+	cronExpr, err = newCronExpr(expr, nil, cronParser{seed: expr + cronHashSalt})
+	if err != nil {
+		return
+	}
+	return defaultCronCache.add(expr, cronExpr), nil
+}
+
+//创建cron表达式,出错时panic,用于初始化时的常量表达式
+func MustNewCronExpr(expr string) *CronExpr {
+	cronExpr, err := NewCronExpr(expr)
+	if err != nil {
+		panic(err)
+	}
+	return cronExpr
+}
+
+//创建cron表达式,固定在loc时区计算,表达式中的CRON_TZ优先
+func NewCronExprInLocation(expr string, loc *time.Location) (cronExpr *CronExpr, err error) {
+	return newCronExpr(expr, loc, cronParser{seed: expr + cronHashSalt})
+}
+
+//创建cron表达式,严格检查增幅,如5-10/30只产生一个值,会返回错误
+func NewCronExprStrict(expr string) (cronExpr *CronExpr, err error) {
+	return newCronExpr(expr, nil, cronParser{strict: true, seed: expr + cronHashSalt})
+}
+
+//创建cron表达式,Next会加上不超过maxJitter的随机延迟,覆盖表达式中的~
+func NewCronExprWithJitter(expr string, maxJitter time.Duration) (cronExpr *CronExpr, err error) {
+	cronExpr, err = NewCronExpr(expr)
+	if err != nil {
+		return
+	}
+	if maxJitter < 0 {
+		err = exprError(expr, ReasonOutOfRange, maxJitter.String(), "jitter must not be negative")
+		return nil, err
+	}
+	c := *cronExpr //NewCronExpr返回的表达式是共享的
+	c.jitter = maxJitter
+	return &c, nil
+}
+
+//创建cron表达式,H由seed散列得到,相同的seed总是得到相同的值
+func NewCronExprSeeded(expr string, seed string) (cronExpr *CronExpr, err error) {
+	return newCronExpr(expr, nil, cronParser{seed: seed})
+}
+
+func newCronExpr(expr string, loc *time.Location, p cronParser) (cronExpr *CronExpr, err error) {
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") { //时区前缀
+		i := strings.IndexAny(spec, " \t")
+		if i == -1 {
+			err = exprError(expr, ReasonTimeZone, spec, "missing fields after time zone")
+			return
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			pe := exprError(expr, ReasonTimeZone, name, "%v", err).(*ParseError)
+			pe.Err = err
+			err = pe
+			return
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	var jitter time.Duration
+	if i := strings.Index(spec, "~"); i != -1 { //随机延迟后缀
+		token := strings.TrimSpace(spec[i+1:])
+		jitter, err = time.ParseDuration(token)
+		if err != nil {
+			pe := exprError(expr, ReasonInvalidValue, token, "%v", err).(*ParseError)
+			pe.Err = err
+			err = pe
+			return
+		}
+		if jitter <= 0 {
+			err = exprError(expr, ReasonOutOfRange, token, "jitter must be positive")
+			return
+		}
+		spec = strings.TrimSpace(spec[:i])
+	}
+
+	cronExpr, err = p.parse(spec)
+	if err != nil {
+		if pe, ok := err.(*ParseError); ok {
+			pe.Expr = expr
+		}
+		return
+	}
+	cronExpr.loc = loc
+	cronExpr.jitter = jitter
+	return
+}
 
-	if len(fields) == 5 { //没有设置Seconds,自己在最前面添加一个0
+//返回固定在loc时区计算的副本
+func (e *CronExpr) InLocation(loc *time.Location) *CronExpr {
+	c := *e
+	c.loc = loc
+	return &c
+}
+
+//返回随机延迟使用src的副本,用于得到可重现的延迟
+func (e *CronExpr) WithJitterSource(src rand.Source) *CronExpr {
+	c := *e
+	c.rand = &jitterRand{r: rand.New(src)}
+	return &c
+}
+
+//解析cron表达式
+func (p *cronParser) parse(expr string) (cronExpr *CronExpr, err error) {
+	if strings.HasPrefix(strings.TrimSpace(expr), "@") { //预定义的表达式
+		return p.parseMacro(expr)
+	}
+
+	fields := strings.Fields(expr)          //用空格分割表达式
+	if len(fields) < 5 || len(fields) > 7 { //数组长度为5、6或者7,因为Seconds和Year不是强制设置的
+		err = exprError(expr, ReasonFieldCount, "", "expected 5, 6 or 7 fields, got %v", len(fields))
+		return
+	}
+
+	n := len(fields)
+	if n == 5 { //没有设置Seconds,自己在最前面添加一个0
 		fields = append([]string{"0"}, fields...)
 	}
 
 	cronExpr = new(CronExpr) //创建一个cron表达式
 
+	//?只能用于day-of-month或day-of-week,且不能同时使用
+	for i, f := range fields {
+		if i != 3 && i != 5 && strings.Contains(f, "?") {
+			p.name = cronFieldNames[i]
+			err = fieldError(ReasonMisplaced, f, "? can only be used in day-of-month or day-of-week: %v", f)
+			goto onError
+		}
+	}
+	if fields[3] == "?" && fields[5] == "?" {
+		p.name = "day-of-week"
+		err = fieldError(ReasonMisplaced, "?", "? can not be used in both day-of-month and day-of-week")
+		goto onError
+	}
+
 	//解析字段
 	//Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	p.name = "seconds"
+	cronExpr.sec, err = p.parseField(fields[0], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	//Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	p.name = "minutes"
+	cronExpr.min, err = p.parseField(fields[1], 0, 59, nil)
 	if err != nil {
 		goto onError
 	}
 	//Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	p.name = "hours"
+	cronExpr.hour, err = p.parseField(fields[2], 0, 23, nil)
 	if err != nil {
 		goto onError
 	}
 	//Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	p.name = "day-of-month"
+	err = p.parseDomField(cronExpr, fields[3])
 	if err != nil {
 		goto onError
 	}
 	//Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	p.name = "month"
+	cronExpr.month, err = p.parseField(fields[4], 1, 12, monthNames)
 	if err != nil {
 		goto onError
 	}
 	//Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	p.name = "day-of-week"
+	err = p.parseDowField(cronExpr, fields[5])
 	if err != nil {
 		goto onError
 	}
+	//Year
+	if len(fields) == 7 {
+		p.name = "year"
+		cronExpr.year, err = p.parseYearField(fields[6])
+		if err != nil {
+			goto onError
+		}
+	}
 	return
 
 onError:
-	err = fmt.Errorf("invalid expr %v: %v", expr, err)
+	pe, ok := err.(*ParseError)
+	if !ok {
+		pe = &ParseError{Reason: ReasonInvalid, Err: err, msg: err.Error()}
+	}
+	pe.Expr = expr
+	pe.FieldName = p.name
+	pe.FieldIndex = -1
+	for i, name := range cronFieldNames {
+		if name == p.name {
+			pe.FieldIndex = i
+			if n == 5 { //没有Seconds,位置前移
+				pe.FieldIndex--
+			}
+		}
+	}
+	err = pe
 	return
 }
 
+//解析预定义的表达式
+func (p *cronParser) parseMacro(expr string) (cronExpr *CronExpr, err error) {
+	fields := strings.Fields(expr)
+	if fields[0] == "@every" {
+		if len(fields) != 2 {
+			err = exprError(expr, ReasonDescriptor, fields[0], "expected @every <duration>")
+			return
+		}
+		var d time.Duration
+		d, err = time.ParseDuration(fields[1])
+		if err != nil {
+			pe := exprError(expr, ReasonInvalidValue, fields[1], "%v", err).(*ParseError)
+			pe.Err = err
+			err = pe
+			return
+		}
+		if d < time.Second { //间隔最小为1秒
+			err = exprError(expr, ReasonOutOfRange, fields[1], "duration must be at least 1s")
+			return
+		}
+		cronExpr = new(CronExpr)
+		cronExpr.every = d - d%time.Second
+		return
+	}
+
+	spec, ok := cronMacros[fields[0]]
+	if !ok || len(fields) != 1 {
+		err = exprError(expr, ReasonDescriptor, fields[0], "unknown descriptor")
+		return
+	}
+	return p.parse(spec)
+}
+
 //解析cron字段
 // 1. *
 // 2. num
@@ -85,34 +384,79 @@ onError:
 // 4. */num
 // 5. num/num (means num-max/num)
 // 6. num-num/num
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+// num也可以是names中的别名(如JAN、MON)
+func (p *cronParser) parseField(field string, min int, max int, names map[string]int) (cronField uint64, err error) {
 	fields := strings.Split(field, ",") //使用","分割字段
 	for _, field := range fields {
-		rangeAndIncr := strings.Split(field, "/") //使用符号"/"分割,获得范围和增幅
-		if len(rangeAndIncr) > 2 {                //肯定不大于2
-			err = fmt.Errorf("too many slashes: %v", field)
+		var start, end, incr int
+		start, end, incr, err = p.parseRange(field, min, max, names)
+		if err != nil {
 			return
 		}
 
+		// cronField
+		if incr == 1 && end <= max { //没有增幅，增幅为1
+			cronField |= ^(math.MaxUint64 << uint(end+1)) & (math.MaxUint64 << uint(start))
+			//比如start和end都等于2（没有增幅，start和end相等）
+			//^(math.MaxUint64 << uint(end+1))等于：
+			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0111
+			//(math.MaxUint64 << uint(start))等于：
+			//1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1100
+			//两者与操作后等于：
+			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0100
+			//就是相当于左移了2位
+
+			//比如start等于0，end等于6
+			//^(math.MaxUint64 << uint(end+1))等于：
+			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0111 1111
+			//(math.MaxUint64 << uint(start))等于：
+			//1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111
+			//两者与操作后等于：
+			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0111 1111
+			//
+		} else {
+			count := p.count(min, max)
+			for i := start; i <= end; i += incr {
+				cronField |= 1 << uint((i-min)%count+min) //根据增幅计算关键值再移位,环绕的范围取模
+			}
+		}
+	}
+
+	return
+}
+
+//解析字段中的一项,获得起始值、结束值和增幅
+//循环的字段(除Day of month和Year外)允许环绕的范围,如22-2,此时end大于max,使用时按取值个数取模
+func (p *cronParser) parseRange(field string, min int, max int, names map[string]int) (start int, end int, incr int, err error) {
+	rangeAndIncr := strings.Split(field, "/") //使用符号"/"分割,获得范围和增幅
+	if len(rangeAndIncr) > 2 {                //肯定不大于2
+		err = fieldError(ReasonTooManySlashes, field, "too many slashes: %v", field)
+		return
+	}
+
+	hashed := strings.HasPrefix(rangeAndIncr[0], "H") || strings.HasPrefix(rangeAndIncr[0], "h")
+	if hashed { //H或H(a-b),先取得范围,确定增幅后再散列
+		start, end, err = parseHashRange(rangeAndIncr[0], min, max, names)
+		if err != nil {
+			return
+		}
+	} else {
 		startAndEnd := strings.Split(rangeAndIncr[0], "-") //使用符号"-"分割,获得范围的起始值和结束值
 		if len(startAndEnd) > 2 {                          //肯定不大于2
-			err = fmt.Errorf("too many hyphens: %v", rangeAndIncr[0])
+			err = fieldError(ReasonTooManyHyphens, rangeAndIncr[0], "too many hyphens: %v", rangeAndIncr[0])
 			return
 		}
 
-		var start, end int //用于存储起始值和结束值
-
 		if startAndEnd[0] == "*" { //如果起始值为*
 			if len(startAndEnd) != 1 { //范围必须只有一个*,而不是first-last形式
-				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
+				err = fieldError(ReasonInvalidRange, rangeAndIncr[0], "invalid range: %v", rangeAndIncr[0])
 				return
 			}
 			start = min //起始值等于最小值
 			end = max   //结束值等于最大值
 		} else {
-			start, err = strconv.Atoi(startAndEnd[0]) //转化为整数
+			start, err = parseCronValue(startAndEnd[0], names) //转化为整数
 			if err != nil {
-				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
 			}
 			// end
@@ -125,103 +469,544 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 				}
 			} else {
 				//For example 3-59/15 in the 1st field (minutes) would indicate the 3rd minute of the hour and every 15 minutes thereafter
-				end, err = strconv.Atoi(startAndEnd[1]) //获取结束值
+				end, err = parseCronValue(startAndEnd[1], names) //获取结束值
 				if err != nil {
-					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 					return
 				}
 			}
 		}
 
-		if start > end { //起始值不能大于结束值
-			err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
+	}
+
+	if start < min || start > max { //起始值不能超出取值范围
+		err = fieldError(ReasonOutOfRange, rangeAndIncr[0], "out of range [%v, %v]: %v", min, max, rangeAndIncr[0])
+		return
+	}
+	if end < min || end > max { //结束值不能超出取值范围
+		err = fieldError(ReasonOutOfRange, rangeAndIncr[0], "out of range [%v, %v]: %v", min, max, rangeAndIncr[0])
+		return
+	}
+	if start > end { //起始值大于结束值时只有循环的字段可以环绕
+		if p.name == "day-of-month" || p.name == "year" {
+			err = fieldError(ReasonInvalidRange, rangeAndIncr[0], "invalid range: %v", rangeAndIncr[0])
 			return
 		}
-		if start < min { //起始值不能小于最小值
-			err = fmt.Errorf("out of range [%v, %v]: %v", min, max, rangeAndIncr[0])
+		end += p.count(min, max)
+	}
+	// increment
+	if len(rangeAndIncr) == 1 { //没有增幅
+		incr = 1 //增幅为1，为什么不是0，如果用户设置增幅为1怎么办？
+	} else { //有增幅
+		incr, err = strconv.Atoi(rangeAndIncr[1]) //获取增幅
+		if err != nil {
+			err = fieldError(ReasonBadIncrement, rangeAndIncr[1], "invalid increment: %v", rangeAndIncr[1])
 			return
 		}
-		if end > max { //结束值不能大于最大值
-			err = fmt.Errorf("out of range [%v, %v]: %v", min, max, rangeAndIncr[0])
+		if incr <= 0 { //增幅不能小于等于0
+			err = fieldError(ReasonBadIncrement, rangeAndIncr[1], "invalid increment: %v", rangeAndIncr[1])
 			return
 		}
-		//没有检查增幅的有效性
+		count := p.count(min, max)
+		if incr > count { //增幅不能大于字段的取值个数
+			err = fieldError(ReasonBadIncrement, field, "increment out of range [1, %v]: %v", count, field)
+			return
+		}
+	}
+	if hashed { //H在范围内取散列值,有增幅时在第一个增幅内取散列值
+		n := end - start + 1
+		if incr > 1 && incr < n {
+			n = incr
+		}
+		start += int(p.hash() % uint32(n))
+		if incr == 1 {
+			end = start
+		}
+	}
+	if p.strict && len(rangeAndIncr) == 2 && start+incr > end { //严格模式下,增幅必须产生多个值
+		err = fieldError(ReasonBadIncrement, field, "increment produces a single value: %v", field)
+		return
+	}
+	return
+}
 
-		// increment
-		var incr int                //用于存储增幅
-		if len(rangeAndIncr) == 1 { //没有增幅
-			incr = 1 //增幅为1，为什么不是0，如果用户设置增幅为1怎么办？
-		} else { //有增幅
-			incr, err = strconv.Atoi(rangeAndIncr[1]) //获取增幅
+//解析H的范围,H为字段的全部范围,H(a-b)为a到b
+func parseHashRange(field string, min int, max int, names map[string]int) (start int, end int, err error) {
+	if len(field) == 1 {
+		if max == 31 { //Day of month最大取28,保证每个月都能匹配
+			max = 28
+		}
+		if max == 7 { //Day of week的7和0都是星期日,只取0-6
+			max = 6
+		}
+		return min, max, nil
+	}
+
+	if field[1] != '(' || field[len(field)-1] != ')' {
+		err = fieldError(ReasonInvalidRange, field, "invalid hash range: %v", field)
+		return
+	}
+	startAndEnd := strings.Split(field[2:len(field)-1], "-")
+	if len(startAndEnd) != 2 {
+		err = fieldError(ReasonInvalidRange, field, "invalid hash range: %v", field)
+		return
+	}
+	start, err = parseCronValue(startAndEnd[0], names)
+	if err != nil {
+		return
+	}
+	end, err = parseCronValue(startAndEnd[1], names)
+	return
+}
+
+//当前字段的取值个数
+func (p *cronParser) count(min int, max int) int {
+	if p.name == "day-of-week" { //0和7是同一天,一周只有7天
+		return 7
+	}
+	return max - min + 1
+}
+
+//当前字段的散列值,由种子和字段名决定
+func (p *cronParser) hash() uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(p.seed))
+	h.Write([]byte{0})
+	h.Write([]byte(p.name))
+	return h.Sum32()
+}
+
+//解析Day of month字段,取出L、W后其余部分按普通字段解析
+func (p *cronParser) parseDomField(e *CronExpr, field string) (err error) {
+	if field == "?" { //不限定
+		e.dom = 0xfffffffe
+		e.domAny = true
+		return
+	}
+
+	var rest []string
+	for _, f := range strings.Split(field, ",") {
+		switch {
+		case strings.EqualFold(f, "L"):
+			e.lastDom = true
+		case strings.EqualFold(f, "LW"):
+			e.lastW = true
+		case len(f) > 1 && (f[len(f)-1] == 'W' || f[len(f)-1] == 'w'):
+			if strings.ContainsAny(f, "-/*") { //W不能用于范围和增幅
+				err = fieldError(ReasonMisplaced, f, "W can not be used with ranges or increments: %v", f)
+				return
+			}
+			var n int
+			n, err = strconv.Atoi(f[:len(f)-1])
 			if err != nil {
-				err = fmt.Errorf("invalid increment: %v", rangeAndIncr[1])
+				err = fieldError(ReasonInvalidValue, f, "invalid value: %v", f)
 				return
 			}
-			if incr <= 0 { //增幅不能小于等于0
-				err = fmt.Errorf("invalid increment: %v", rangeAndIncr[1])
+			if n < 1 || n > 31 {
+				err = fieldError(ReasonOutOfRange, f, "out of range [1, 31]: %v", f)
 				return
 			}
+			e.domW |= 1 << uint(n)
+		default:
+			rest = append(rest, f)
 		}
+	}
 
-		// cronField
-		if incr == 1 { //没有增幅，增幅为1
-			cronField |= ^(math.MaxUint64 << uint(end+1)) & (math.MaxUint64 << uint(start))
-			//比如start和end都等于2（没有增幅，start和end相等）
-			//^(math.MaxUint64 << uint(end+1))等于：
-			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0111
-			//(math.MaxUint64 << uint(start))等于：
-			//1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1100
-			//两者与操作后等于：
-			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0100
-			//就是相当于左移了2位
+	if len(rest) > 0 {
+		e.dom, err = p.parseField(strings.Join(rest, ","), 1, 31, nil)
+	}
+	e.domAny = e.dom == 0xfffffffe && !e.lastDom && !e.lastW && e.domW == 0
+	return
+}
 
-			//比如start等于0，end等于6
-			//^(math.MaxUint64 << uint(end+1))等于：
-			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0111 1111
-			//(math.MaxUint64 << uint(start))等于：
-			//1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111 1111
-			//两者与操作后等于：
-			//0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0000 0111 1111
-			//
-		} else {
-			for i := start; i <= end; i += incr {
-				cronField |= 1 << uint(i) //根据增幅计算关键值再移位
+//解析Day of week字段,取出dL、d#n后其余部分按普通字段解析
+func (p *cronParser) parseDowField(e *CronExpr, field string) (err error) {
+	if field == "?" { //不限定
+		e.dow = 0x7f
+		e.dowAny = true
+		return
+	}
+
+	var rest []string
+	for _, f := range strings.Split(field, ",") {
+		if strings.Contains(f, "#") {
+			dayAndNth := strings.Split(f, "#")
+			if len(dayAndNth) != 2 || strings.ContainsAny(dayAndNth[0], "-/*") { //#不能用于范围和增幅
+				err = fieldError(ReasonMisplaced, f, "invalid nth weekday: %v", f)
+				return
+			}
+			var d, n int
+			d, err = parseCronValue(dayAndNth[0], dowNames)
+			if err != nil {
+				return
+			}
+			if d < 0 || d > 7 {
+				err = fieldError(ReasonOutOfRange, f, "out of range [0, 7]: %v", f)
+				return
 			}
+			d %= 7 //7也是星期日
+			n, err = strconv.Atoi(dayAndNth[1])
+			if err != nil || n < 1 || n > 5 {
+				err = fieldError(ReasonOutOfRange, f, "invalid nth weekday: %v", f)
+				return
+			}
+			e.nthDow |= 1 << uint((n-1)*7+d)
+		} else if len(f) > 1 && (f[len(f)-1] == 'L' || f[len(f)-1] == 'l') {
+			var d int
+			d, err = parseCronValue(f[:len(f)-1], dowNames)
+			if err != nil {
+				return
+			}
+			if d < 0 || d > 7 {
+				err = fieldError(ReasonOutOfRange, f, "out of range [0, 7]: %v", f)
+				return
+			}
+			d %= 7 //7也是星期日
+			e.lastDow |= 1 << uint(d)
+		} else {
+			rest = append(rest, f)
 		}
 	}
 
+	if len(rest) > 0 {
+		e.dow, err = p.parseField(strings.Join(rest, ","), 0, 7, dowNames)
+		if e.dow&(1<<7) != 0 { //7也是星期日
+			e.dow = e.dow&^(1<<7) | 1
+		}
+	}
+	e.dowAny = e.dow == 0x7f && e.lastDow == 0 && e.nthDow == 0
+	return
+}
+
+//解析Year字段
+func (p *cronParser) parseYearField(field string) (year []uint64, err error) {
+	year = make([]uint64, (maxYear-minYear)/64+1)
+	for _, f := range strings.Split(field, ",") {
+		var start, end, incr int
+		start, end, incr, err = p.parseRange(f, minYear, maxYear, nil)
+		if err != nil {
+			return
+		}
+		for i := start - minYear; i <= end-minYear; i += incr {
+			year[i/64] |= 1 << uint(i%64)
+		}
+	}
 	return
 }
 
+func (e *CronExpr) matchYear(y int) bool {
+	if y < minYear || y > maxYear {
+		return false
+	}
+	i := y - minYear
+	return e.year[i/64]&(1<<uint(i%64)) != 0
+}
+
+//不早于y的第一个匹配年份,没有则返回0
+func (e *CronExpr) nextYear(y int) int {
+	if y < minYear {
+		y = minYear
+	}
+	for ; y <= maxYear; y++ {
+		if e.matchYear(y) {
+			return y
+		}
+	}
+	return 0
+}
+
+//不晚于y的最后一个匹配年份,没有则返回0
+func (e *CronExpr) prevYear(y int) int {
+	if y > maxYear {
+		y = maxYear
+	}
+	for ; y >= minYear; y-- {
+		if e.matchYear(y) {
+			return y
+		}
+	}
+	return 0
+}
+
+//解析单个值,数字或别名
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fieldError(ReasonInvalidValue, value, "invalid value: %v", value)
+	}
+	return n, nil
+}
+
+//t所在月份的天数
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+//离本月n号最近的工作日,不跨月,n号不存在时返回0
+func nearestWeekday(t time.Time, n int) int {
+	days := daysIn(t)
+	if n > days {
+		return 0
+	}
+
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if n == 1 { //1号是星期六,往后到星期一
+			return 3
+		}
+		return n - 1
+	case time.Sunday:
+		if n == days { //最后一天是星期天,往前到星期五
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
+}
+
+func (e *CronExpr) matchDom(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&e.dom != 0 {
+		return true
+	}
+	if e.lastDom && day == daysIn(t) {
+		return true
+	}
+	if e.lastW && day == nearestWeekday(t, daysIn(t)) {
+		return true
+	}
+	//最近工作日最多偏移2天
+	for n := day - 2; n <= day+2; n++ {
+		if n >= 1 && n <= 31 && 1<<uint(n)&e.domW != 0 && nearestWeekday(t, n) == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	if 1<<uint(t.Weekday())&e.dow != 0 {
+		return true
+	}
+	//第n个星期d
+	if 1<<uint((t.Day()-1)/7*7+int(t.Weekday()))&e.nthDow != 0 {
+		return true
+	}
+	//最后一个星期d: 再过一周就到下个月了
+	return 1<<uint(t.Weekday())&e.lastDow != 0 && t.Day()+7 > daysIn(t)
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if e.domAny {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if e.dowAny {
+		return e.matchDom(t)
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	return e.matchDow(t) || e.matchDom(t)
 }
 
+//t(截断到秒)是否匹配表达式,@every总是匹配
+// goroutine safe
+func (e *CronExpr) Match(t time.Time) bool {
+	if e.every > 0 {
+		return true
+	}
+	if e.loc != nil {
+		t = t.In(e.loc)
+	}
+	t = t.Truncate(time.Second)
+
+	w := toWall(t)
+	if e.matchWall(w) {
+		//出现两次的墙上时间只在第一次匹配
+		return wallToTime(w, t.Location()).Equal(t)
+	}
+	//夏令时开始时,被跳过的墙上时间在跳变的时刻匹配
+	if start, _ := t.ZoneBounds(); start.Equal(t) {
+		return e.search(t, 0).Equal(t)
+	}
+	return false
+}
+
+//墙上时间w的各字段是否匹配
+func (e *CronExpr) matchWall(w time.Time) bool {
+	if e.year != nil && !e.matchYear(w.Year()) {
+		return false
+	}
+	return 1<<uint(w.Second())&e.sec != 0 &&
+		1<<uint(w.Minute())&e.min != 0 &&
+		1<<uint(w.Hour())&e.hour != 0 &&
+		1<<uint(w.Month())&e.month != 0 &&
+		e.matchDay(w)
+}
+
+//t的墙上时间,用UTC表示以避开夏令时
+func toWall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+//墙上时间w在loc中对应的时刻
+//w不存在时(夏令时开始)返回跳变后的第一个时刻,w出现两次时(夏令时结束)返回第一次
+func wallToTime(w time.Time, loc *time.Location) time.Time {
+	t := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, loc)
+	if loc == time.UTC {
+		return t
+	}
+
+	//用t所在时区及前后相邻时区的偏移量尝试,取最早的一个
+	start, end := t.ZoneBounds()
+	offsets := make([]int, 0, 3)
+	if !start.IsZero() {
+		_, offset := start.Add(-time.Second).Zone()
+		offsets = append(offsets, offset)
+	}
+	_, offset := t.Zone()
+	offsets = append(offsets, offset)
+	if !end.IsZero() {
+		_, offset := end.Zone()
+		offsets = append(offsets, offset)
+	}
+
+	var first time.Time
+	for _, offset := range offsets {
+		c := w.Add(-time.Duration(offset) * time.Second).In(loc)
+		if toWall(c).Equal(w) && (first.IsZero() || c.Before(first)) {
+			first = c
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
+
+	//不存在,取跳变的时刻
+	if toWall(t).Before(w) {
+		return end
+	}
+	return start
+}
+
+//下一个匹配的时间,没有设置Year时最多搜索到下一年,搜索不到返回零值
+//设置了随机延迟时加上延迟
 // goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
+	return e.NextWithin(t, 0)
+}
+
+//与Next相同,但t截断到秒后匹配时返回截断后的t
+//用于按计划时间而不是当前时间链式计算,@every总是返回截断后的t
+// goroutine safe
+func (e *CronExpr) NextInclusive(t time.Time) time.Time {
+	if e.Match(t) {
+		return t.Truncate(time.Second)
+	}
+	return e.Next(t)
+}
+
+//下一个匹配的时间,最多搜索到t之后horizon,horizon小于等于0时与Next相同
+//用于很少触发的表达式,如0 0 0 29 2 *
+// goroutine safe
+func (e *CronExpr) NextWithin(t time.Time, horizon time.Duration) time.Time {
+	if e.loc == nil {
+		return e.delay(e.next(t, horizon))
+	}
+
+	//在固定时区计算,再转回调用者的时区
+	next := e.delay(e.next(t.In(e.loc), horizon))
+	if next.IsZero() {
+		return next
+	}
+	return next.In(t.Location())
+}
+
+//给匹配的时间t加上随机延迟,延迟小于t到下一个匹配的时间的间隔
+func (e *CronExpr) delay(t time.Time) time.Time {
+	if e.jitter <= 0 || t.IsZero() {
+		return t
+	}
+
+	n := int64(e.jitter) + 1
+	if following := e.next(t, 0); !following.IsZero() && int64(following.Sub(t)) < n {
+		n = int64(following.Sub(t))
+	}
+
+	return t.Add(time.Duration(e.rand.int63n(n)))
+}
+
+func (e *CronExpr) next(t time.Time, horizon time.Duration) time.Time {
+	if e.every > 0 { //@every,从t开始经过固定间隔
+		next := t.Truncate(time.Second).Add(e.every)
+		if horizon > 0 && next.After(t.Add(horizon)) {
+			return time.Time{}
+		}
+		return next
+	}
+
 	// the upcoming second
-	t = t.Truncate(time.Second).Add(time.Second)
+	next := e.search(t.Truncate(time.Second).Add(time.Second), horizon)
+	if horizon > 0 && next.After(t.Add(horizon)) {
+		return time.Time{}
+	}
+	return next
+}
 
-	year := t.Year()
+//从整秒t开始查找第一个匹配的时间(包括t)
+//在墙上时间上查找,再转换为t所在时区的时刻
+func (e *CronExpr) search(t time.Time, horizon time.Duration) time.Time {
+	loc := t.Location()
+	//从t前一秒的墙上时间之后开始,t正好是夏令时跳变时,被跳过的墙上时间也会被查找
+	w := toWall(t.Add(-time.Second)).Add(time.Second)
+
+	//搜索的上限,默认没有设置Year时到下一年年底
+	var limit time.Time
+	if horizon > 0 {
+		limit = toWall(t.Add(horizon))
+	} else if e.year == nil {
+		limit = time.Date(w.Year()+2, time.January, 1, 0, 0, 0, 0, time.UTC).Add(-time.Second)
+	}
+
+	for {
+		w = e.searchWall(w, limit)
+		if w.IsZero() {
+			return w
+		}
+		next := wallToTime(w, loc)
+		if !next.Before(t) {
+			return next
+		}
+		w = w.Add(time.Second)
+	}
+}
+
+//从墙上时间t开始查找第一个匹配的墙上时间(包括t),最多搜索到limit,limit为零值时不限制
+func (e *CronExpr) searchWall(t time.Time, limit time.Time) time.Time {
 	initFlag := false
 
 retry:
-	// Year
-	if t.Year() > year+1 {
+	if !limit.IsZero() && t.After(limit) {
 		return time.Time{}
 	}
 
+	// Year
+	if e.year != nil { //设置了Year,跳到下一个匹配的年份
+		y := e.nextYear(t.Year())
+		if y == 0 {
+			return time.Time{}
+		}
+		if y != t.Year() {
+			initFlag = true
+			t = time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location())
+			goto retry
+		}
+	}
+
 	// Month
 	for 1<<uint(t.Month())&e.month == 0 {
 		if !initFlag {
@@ -286,5 +1071,309 @@ retry:
 		}
 	}
 
+	if !limit.IsZero() && t.After(limit) {
+		return time.Time{}
+	}
 	return t
 }
+
+//接下来的n个匹配的时间,严格递增,搜索不到时提前结束
+// goroutine safe
+func (e *CronExpr) NextN(t time.Time, n int) []time.Time {
+	if n <= 0 {
+		return nil
+	}
+
+	loc := t.Location()
+	if e.loc != nil {
+		t = t.In(e.loc)
+	}
+
+	times := make([]time.Time, 0, n)
+	t = e.next(t, 0)
+	for !t.IsZero() {
+		times = append(times, t.In(loc))
+		if len(times) == n {
+			break
+		}
+		if e.every > 0 {
+			t = t.Add(e.every)
+		} else {
+			t = e.search(t.Add(time.Second), 0)
+		}
+	}
+	return times
+}
+
+//依次回调from之后(不包括from)、to之前(包括to)的每个匹配的时间,回调返回false时停止
+//范围很大时(如多年的每秒)应该使用Each而不是Between
+// goroutine safe
+func (e *CronExpr) Each(from time.Time, to time.Time, fn func(time.Time) bool) {
+	loc := from.Location()
+	if e.loc != nil {
+		from = from.In(e.loc)
+	}
+
+	for t := from; to.After(t); {
+		t = e.next(t, to.Sub(t))
+		if t.IsZero() || !fn(t.In(loc)) {
+			return
+		}
+	}
+}
+
+//from之后(不包括from)、to之前(包括to)的所有匹配的时间
+// goroutine safe
+func (e *CronExpr) Between(from time.Time, to time.Time) []time.Time {
+	var times []time.Time
+	e.Each(from, to, func(t time.Time) bool {
+		times = append(times, t)
+		return true
+	})
+	return times
+}
+
+//上一个匹配的时间,严格早于t,最多向前搜索到上一年
+// goroutine safe
+func (e *CronExpr) Prev(t time.Time) time.Time {
+	if e.loc == nil {
+		return e.prev(t)
+	}
+
+	prev := e.prev(t.In(e.loc))
+	if prev.IsZero() {
+		return prev
+	}
+	return prev.In(t.Location())
+}
+
+func (e *CronExpr) prev(t time.Time) time.Time {
+	if e.every > 0 { //@every,从t开始倒退固定间隔
+		return t.Truncate(time.Second).Add(-e.every)
+	}
+
+	// the previous second
+	if t.Truncate(time.Second).Equal(t) {
+		t = t.Add(-time.Second)
+	} else {
+		t = t.Truncate(time.Second)
+	}
+
+	return e.searchBack(t)
+}
+
+//从整秒t开始向前查找最后一个匹配的时间(包括t)
+func (e *CronExpr) searchBack(t time.Time) time.Time {
+	loc := t.Location()
+	//t在夏令时结束后重复的时段内时,重复时段的墙上时间第一次出现都早于t
+	w := toWall(t)
+	if start, _ := t.ZoneBounds(); !start.IsZero() {
+		if before := toWall(start.Add(-time.Second)); before.After(w) {
+			w = before
+		}
+	}
+	for {
+		w = e.searchWallBack(w)
+		if w.IsZero() {
+			return w
+		}
+		prev := wallToTime(w, loc)
+		if !prev.After(t) {
+			return prev
+		}
+		w = w.Add(-time.Second)
+	}
+}
+
+//从墙上时间t开始向前查找最后一个匹配的墙上时间(包括t),最多搜索到上一年
+func (e *CronExpr) searchWallBack(t time.Time) time.Time {
+	year := t.Year()
+
+	//每次回退都退到上一个单位的最后一秒,低位字段不需要重置
+retry:
+	// Year
+	if e.year != nil { //设置了Year,退到上一个匹配的年份
+		y := e.prevYear(t.Year())
+		if y == 0 {
+			return time.Time{}
+		}
+		if y != t.Year() {
+			t = time.Date(y+1, time.January, 1, 0, 0, 0, 0, t.Location()).Add(-time.Second)
+		}
+	} else if t.Year() < year-1 {
+		return time.Time{}
+	}
+
+	// Month
+	for 1<<uint(t.Month())&e.month == 0 {
+		t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).Add(-time.Second)
+		if t.Month() == time.December {
+			goto retry
+		}
+	}
+
+	// Day
+	for !e.matchDay(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Second)
+		if t.Day() == daysIn(t) {
+			goto retry
+		}
+	}
+
+	// Hours
+	for 1<<uint(t.Hour())&e.hour == 0 {
+		t = t.Truncate(time.Hour).Add(-time.Second)
+		if t.Hour() == 23 {
+			goto retry
+		}
+	}
+
+	// Minutes
+	for 1<<uint(t.Minute())&e.min == 0 {
+		t = t.Truncate(time.Minute).Add(-time.Second)
+		if t.Minute() == 59 {
+			goto retry
+		}
+	}
+
+	// Seconds
+	for 1<<uint(t.Second())&e.sec == 0 {
+		t = t.Add(-time.Second)
+		if t.Second() == 59 {
+			goto retry
+		}
+	}
+
+	return t
+}
+
+//还原为规范的表达式,相邻的值合并为范围,等差的值合并为增幅
+func (e *CronExpr) String() string {
+	var prefix string
+	if e.loc != nil {
+		prefix = "CRON_TZ=" + e.loc.String() + " "
+	}
+	var suffix string
+	if e.jitter > 0 {
+		suffix = " ~ " + e.jitter.String()
+	}
+	if e.every > 0 {
+		return prefix + "@every " + e.every.String() + suffix
+	}
+
+	fields := []string{
+		formatCronMask(e.sec, 0, 59),
+		formatCronMask(e.min, 0, 59),
+		formatCronMask(e.hour, 0, 23),
+		e.formatDomField(),
+		formatCronMask(e.month, 1, 12),
+		e.formatDowField(),
+	}
+	if e.year != nil {
+		fields = append(fields, formatCronBits(e.matchYear, minYear, maxYear))
+	}
+	return prefix + strings.Join(fields, " ") + suffix
+}
+
+func (e *CronExpr) formatDomField() string {
+	var items []string
+	if e.dom != 0 {
+		items = append(items, formatCronMask(e.dom, 1, 31))
+	}
+	if e.lastDom {
+		items = append(items, "L")
+	}
+	if e.lastW {
+		items = append(items, "LW")
+	}
+	for n := 1; n <= 31; n++ {
+		if 1<<uint(n)&e.domW != 0 {
+			items = append(items, strconv.Itoa(n)+"W")
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (e *CronExpr) formatDowField() string {
+	var items []string
+	if e.dow != 0 {
+		items = append(items, formatCronMask(e.dow, 0, 6))
+	}
+	for d := 0; d <= 6; d++ {
+		if 1<<uint(d)&e.lastDow != 0 {
+			items = append(items, strconv.Itoa(d)+"L")
+		}
+	}
+	for i := 0; i < 35; i++ {
+		if 1<<uint(i)&e.nthDow != 0 {
+			items = append(items, strconv.Itoa(i%7)+"#"+strconv.Itoa(i/7+1))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func formatCronMask(mask uint64, min int, max int) string {
+	return formatCronBits(func(i int) bool {
+		return 1<<uint(i)&mask != 0
+	}, min, max)
+}
+
+//将[min, max]中匹配的值格式化为字段
+func formatCronBits(has func(int) bool, min int, max int) string {
+	var values []int
+	for i := min; i <= max; i++ {
+		if has(i) {
+			values = append(values, i)
+		}
+	}
+
+	if len(values) == max-min+1 {
+		return "*"
+	}
+
+	//等差且到最大值为止,如*/15、5/10
+	if len(values) > 2 {
+		incr := values[1] - values[0]
+		step := incr > 1
+		for i := 2; step && i < len(values); i++ {
+			step = values[i]-values[i-1] == incr
+		}
+		if step && values[len(values)-1]+incr > max {
+			if values[0] == min {
+				return "*/" + strconv.Itoa(incr)
+			}
+			return strconv.Itoa(values[0]) + "/" + strconv.Itoa(incr)
+		}
+	}
+
+	//相邻的值合并为范围
+	var items []string
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		if j == i {
+			items = append(items, strconv.Itoa(values[i]))
+		} else {
+			items = append(items, strconv.Itoa(values[i])+"-"+strconv.Itoa(values[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(items, ",")
+}
+
+// encoding.TextMarshaler
+func (e *CronExpr) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// encoding.TextUnmarshaler
+func (e *CronExpr) UnmarshalText(text []byte) error {
+	c, err := NewCronExpr(string(text))
+	if err != nil {
+		return err
+	}
+	*e = *c
+	return nil
+}
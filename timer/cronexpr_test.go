@@ -0,0 +1,1304 @@
+package timer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, expr string) *CronExpr {
+	e, err := NewCronExpr(expr)
+	if err != nil {
+		t.Fatalf("NewCronExpr(%q): %v", expr, err)
+	}
+	return e
+}
+
+func sameMasks(a, b *CronExpr) bool {
+	return a.sec == b.sec &&
+		a.min == b.min &&
+		a.hour == b.hour &&
+		a.dom == b.dom &&
+		a.month == b.month &&
+		a.dow == b.dow
+}
+
+func TestCronExprNameAliases(t *testing.T) {
+	cases := []struct {
+		alias   string
+		numeric string
+	}{
+		{"0 0 12 * JAN-MAR MON-FRI", "0 0 12 * 1-3 1-5"},
+		{"0 0 12 * jan,Jun,DEC sun", "0 0 12 * 1,6,12 0"},
+		{"0 0 12 * FEB/3 MON-FRI/2", "0 0 12 * 2/3 1-5/2"},
+		{"0 0 12 * * 1,TUE,4", "0 0 12 * * 1,2,4"},
+		{"0 0 12 * Oct-dec sat", "0 0 12 * 10-12 6"},
+	}
+
+	for _, c := range cases {
+		a := mustParse(t, c.alias)
+		n := mustParse(t, c.numeric)
+		if !sameMasks(a, n) {
+			t.Errorf("%q and %q produce different masks", c.alias, c.numeric)
+		}
+	}
+}
+
+func TestCronExprNameAliasErrors(t *testing.T) {
+	cases := []string{
+		"0 0 12 * JANUARY *",
+		"0 0 12 * MON *",
+		"0 0 12 * * JAN",
+		"0 JAN 12 * * *",
+	}
+
+	for _, expr := range cases {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprMacros(t *testing.T) {
+	cases := []struct {
+		macro string
+		spec  string
+	}{
+		{"@yearly", "0 0 0 1 1 *"},
+		{"@annually", "0 0 0 1 1 *"},
+		{"@monthly", "0 0 0 1 * *"},
+		{"@weekly", "0 0 0 * * 0"},
+		{"@daily", "0 0 0 * * *"},
+		{"@midnight", "0 0 0 * * *"},
+		{"@hourly", "0 0 * * * *"},
+	}
+
+	for _, c := range cases {
+		if !sameMasks(mustParse(t, c.macro), mustParse(t, c.spec)) {
+			t.Errorf("%v does not expand to %q", c.macro, c.spec)
+		}
+	}
+}
+
+func TestCronExprEvery(t *testing.T) {
+	e := mustParse(t, "@every 5m30s")
+
+	now := time.Date(2000, 1, 1, 20, 10, 5, 500, time.UTC)
+	next := e.Next(now)
+	if want := time.Date(2000, 1, 1, 20, 15, 35, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Next(%v) = %v, want %v", now, next, want)
+	}
+}
+
+func TestCronExprMacroErrors(t *testing.T) {
+	cases := []string{
+		"@often",
+		"@daily 1",
+		"@every",
+		"@every 5x",
+		"@every 500ms",
+		"@every 1m 2m",
+	}
+
+	for _, expr := range cases {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprLastDayOfMonth(t *testing.T) {
+	e := mustParse(t, "0 0 0 L * *")
+
+	cases := []struct {
+		from time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(1900, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(1900, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 4, 10, 0, 0, 0, 0, time.UTC), time.Date(2023, 4, 30, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		if got := e.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("Next(%v) = %v, want %v", c.from, got, c.want)
+		}
+	}
+}
+
+func TestCronExprLastWeekday(t *testing.T) {
+	e := mustParse(t, "0 0 0 * * 5L")
+
+	cases := []struct {
+		from time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 23, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 2, 24, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC), time.Date(2023, 4, 28, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		if got := e.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("Next(%v) = %v, want %v", c.from, got, c.want)
+		}
+	}
+
+	// 31 February never exists, but L always does
+	if got := mustParse(t, "0 0 0 L 2 *").Next(time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)); !got.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Next = %v", got)
+	}
+
+	for _, expr := range []string{"0 0 0 L-1 * *", "0 0 0 * * 1-5L"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprNearestWeekday(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// 2022-01-01 is a Saturday: roll forward to Monday the 3rd
+		{"0 0 0 1W * *", day(2021, 12, 31), day(2022, 1, 3)},
+		// 2022-01-15 is a Saturday: Friday the 14th
+		{"0 0 0 15W * *", day(2022, 1, 1), day(2022, 1, 14)},
+		// 2022-05-15 is a Sunday: Monday the 16th
+		{"0 0 0 15W * *", day(2022, 5, 1), day(2022, 5, 16)},
+		// 2022-07-15 is a Friday
+		{"0 0 0 15W * *", day(2022, 7, 1), day(2022, 7, 15)},
+		// 2022-07-31 is a Sunday: never leave the month, Friday the 29th
+		{"0 0 0 31W * *", day(2022, 7, 1), day(2022, 7, 29)},
+		{"0 0 0 LW * *", day(2022, 7, 1), day(2022, 7, 29)},
+		// 2022-04-30 is a Saturday
+		{"0 0 0 LW * *", day(2022, 4, 1), day(2022, 4, 29)},
+		{"0 0 0 1W,15W * *", day(2022, 1, 3), day(2022, 1, 14)},
+		{"0 0 0 1W,15W * *", day(2022, 1, 14), day(2022, 2, 1)},
+	}
+
+	for _, c := range cases {
+		if got := mustParse(t, c.expr).Next(c.from); !got.Equal(c.want) {
+			t.Errorf("%q: Next(%v) = %v, want %v", c.expr, c.from, got, c.want)
+		}
+	}
+
+	for _, expr := range []string{"0 0 0 1-5W * *", "0 0 0 1/5W * *", "0 0 0 32W * *", "0 0 0 W * *", "0 0 0 *W * *"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprNthWeekday(t *testing.T) {
+	cases := []struct {
+		expr  string
+		dates [][2]int
+	}{
+		{"0 0 9 * * 2#2", [][2]int{
+			{1, 10}, {2, 14}, {3, 14}, {4, 11}, {5, 9}, {6, 13},
+			{7, 11}, {8, 8}, {9, 12}, {10, 10}, {11, 14}, {12, 12},
+		}},
+		{"0 0 9 * * TUE#2", [][2]int{
+			{1, 10}, {2, 14}, {3, 14}, {4, 11}, {5, 9}, {6, 13},
+			{7, 11}, {8, 8}, {9, 12}, {10, 10}, {11, 14}, {12, 12},
+		}},
+		// months without a fifth Friday are skipped
+		{"0 0 9 * * 5#5", [][2]int{{3, 31}, {6, 30}, {9, 29}, {12, 29}}},
+	}
+
+	for _, c := range cases {
+		e := mustParse(t, c.expr)
+		next := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, d := range c.dates {
+			next = e.Next(next)
+			want := time.Date(2023, time.Month(d[0]), d[1], 9, 0, 0, 0, time.UTC)
+			if !next.Equal(want) {
+				t.Errorf("%q: got %v, want %v", c.expr, next, want)
+				break
+			}
+		}
+	}
+
+	for _, expr := range []string{"0 0 0 * * 1-2#2", "0 0 0 * * 2#6", "0 0 0 * * 2#0", "0 0 0 * * 2#1#1", "0 0 0 * * 9#1"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprQuestionMark(t *testing.T) {
+	cases := []struct {
+		expr, same string
+	}{
+		{"0 0 9 ? * MON", "0 0 9 * * MON"},
+		{"0 0 9 15 * ?", "0 0 9 15 * *"},
+		{"0 0 9 ? * 2#2", "0 0 9 * * 2#2"},
+		{"0 9 L * ?", "0 9 L * *"},
+		{"0 0 9 ? * * 2030", "0 0 9 * * * 2030"},
+	}
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range cases {
+		e, want := mustParse(t, c.expr), mustParse(t, c.same)
+		if !reflect.DeepEqual(e.NextN(start, 10), want.NextN(start, 10)) {
+			t.Errorf("%q: schedule differs from %q", c.expr, c.same)
+		}
+		if e.String() != want.String() {
+			t.Errorf("%q: String() = %q, want %q", c.expr, e.String(), want.String())
+		}
+	}
+
+	// ? leaves the other day field alone instead of OR-ing both
+	e := mustParse(t, "0 0 9 ? * MON")
+	if next := e.Next(start); !next.Equal(time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %v, want first Monday", next)
+	}
+
+	for _, expr := range []string{
+		"0 0 9 ? * ?",
+		"? 0 9 * * *",
+		"0 ? 9 * * *",
+		"0 0 ? * * *",
+		"0 0 9 * ? *",
+		"0 0 9 * * * ?",
+		"0 0 9 ?,1 * *",
+		"0 0 9 * * ?/2",
+	} {
+		_, err := NewCronExpr(expr)
+		if err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+	if _, err := NewCronExpr("0 0 9 ? * ?"); err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("expected both-fields error, got %v", err)
+	}
+	if _, err := NewCronExpr("0 0 ? * * *"); err == nil || !strings.Contains(err.Error(), "hours") {
+		t.Errorf("expected hours error, got %v", err)
+	}
+}
+
+func TestCronExprHash(t *testing.T) {
+	// the same seed always gives the same schedule
+	a, err := NewCronExprSeeded("H H * * * *", "module-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewCronExprSeeded("H H * * * *", "module-a")
+	if a.String() != b.String() {
+		t.Errorf("same seed: %q != %q", a.String(), b.String())
+	}
+
+	// different seeds spread out
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		e, err := NewCronExprSeeded("H H * * * *", "module-"+strings.Repeat("x", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[e.String()] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("only %v distinct schedules out of 20 seeds", len(seen))
+	}
+
+	cases := []string{
+		"H * * * * *",
+		"0 H H H H H",
+		"0 H(0-29) H(9-17) * * H(MON-FRI)",
+		"0 H/15 * * * *",
+		"0 H(10-40)/10 * * * *",
+		"0 0 0 H * * H(2030-2035)",
+		"0 0 H/7 * * *",
+	}
+	for _, expr := range cases {
+		for i := 0; i < 50; i++ {
+			seed := strings.Repeat("s", i)
+			e, err := NewCronExprSeeded(expr, seed)
+			if err != nil {
+				t.Fatalf("NewCronExprSeeded(%q): %v", expr, err)
+			}
+			// the resolved expression is a plain one with the same masks
+			if r := mustParse(t, e.String()); !sameMasks(e, r) {
+				t.Errorf("%q: %q does not round trip", expr, e.String())
+			}
+			if e.sec>>60 != 0 || e.min>>60 != 0 || e.hour>>24 != 0 || e.dom&1 != 0 || e.dom>>32 != 0 ||
+				e.month&1 != 0 || e.month>>13 != 0 || e.dow>>7 != 0 {
+				t.Errorf("%q seed %q: value out of bounds: %q", expr, seed, e.String())
+			}
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		e, _ := NewCronExprSeeded("0 0 0 H * *", strings.Repeat("d", i))
+		if days := maskValues(e.dom, 1, 31); len(days) != 1 || days[0] > 28 {
+			t.Errorf("day-of-month H: got %v", days)
+		}
+		e, _ = NewCronExprSeeded("0 H(0-29) H(9-17) * * *", strings.Repeat("r", i))
+		if min := maskValues(e.min, 0, 59); len(min) != 1 || min[0] > 29 {
+			t.Errorf("H(0-29): got %v", min)
+		}
+		if hour := maskValues(e.hour, 0, 23); len(hour) != 1 || hour[0] < 9 || hour[0] > 17 {
+			t.Errorf("H(9-17): got %v", hour)
+		}
+		// steps are evenly spaced from the hashed phase
+		e, _ = NewCronExprSeeded("0 H/15 * * * *", strings.Repeat("p", i))
+		min := maskValues(e.min, 0, 59)
+		if len(min) != 4 || min[0] >= 15 || min[1]-min[0] != 15 || min[2]-min[1] != 15 || min[3]-min[2] != 15 {
+			t.Errorf("H/15: got %v", min)
+		}
+		e, _ = NewCronExprSeeded("0 H(10-40)/10 * * * *", strings.Repeat("p", i))
+		min = maskValues(e.min, 0, 59)
+		if min[0] < 10 || min[0] >= 20 || min[len(min)-1] > 40 || min[1]-min[0] != 10 {
+			t.Errorf("H(10-40)/10: got %v", min)
+		}
+	}
+
+	// plain NewCronExpr hashes the expression itself
+	c, d := mustParse(t, "H H * * * *"), mustParse(t, "H H * * * *")
+	if c.String() != d.String() {
+		t.Errorf("NewCronExpr: %q != %q", c.String(), d.String())
+	}
+
+	for _, expr := range []string{
+		"H(0-60) * * * * *",
+		"H(5) * * * * *",
+		"0 0 0 H(10-5) * *",
+		"H(0-5 * * * * *",
+		"Hx * * * * *",
+		"H/0 * * * * *",
+		"H-5 * * * * *",
+	} {
+		if _, err := NewCronExprSeeded(expr, "seed"); err == nil {
+			t.Errorf("NewCronExprSeeded(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprParseError(t *testing.T) {
+	cases := []struct {
+		expr   string
+		field  string
+		index  int
+		token  string
+		reason ParseReason
+	}{
+		{"* * * *", "", -1, "", ReasonFieldCount},
+		{"* * * * * * * *", "", -1, "", ReasonFieldCount},
+		{"CRON_TZ=Asia/Shanghai", "", -1, "CRON_TZ=Asia/Shanghai", ReasonTimeZone},
+		{"CRON_TZ=Nowhere/Atlantis 0 0 * * *", "", -1, "Nowhere/Atlantis", ReasonTimeZone},
+		{"@fortnightly", "", -1, "@fortnightly", ReasonDescriptor},
+		{"@every", "", -1, "@every", ReasonDescriptor},
+		{"@every 5x", "", -1, "5x", ReasonInvalidValue},
+		{"@every 10ms", "", -1, "10ms", ReasonOutOfRange},
+		{"0 */5/2 * * * *", "minutes", 1, "*/5/2", ReasonTooManySlashes},
+		{"*/5/2 * * * *", "minutes", 0, "*/5/2", ReasonTooManySlashes},
+		{"0 0 1-2-3 * * *", "hours", 2, "1-2-3", ReasonTooManyHyphens},
+		{"0 0 *-3 * * *", "hours", 2, "*-3", ReasonInvalidRange},
+		{"0 0 0 5-3 * *", "day-of-month", 3, "5-3", ReasonInvalidRange},
+		{"0 0 0 1 1 * 2030-2025", "year", 6, "2030-2025", ReasonInvalidRange},
+		{"0 0 0-24 * * *", "hours", 2, "0-24", ReasonOutOfRange},
+		{"0 0 0 0 * *", "day-of-month", 3, "0", ReasonOutOfRange},
+		{"0 0 0 * * * 1969", "year", 6, "1969", ReasonOutOfRange},
+		{"0 */x * * * *", "minutes", 1, "x", ReasonBadIncrement},
+		{"0 */0 * * * *", "minutes", 1, "0", ReasonBadIncrement},
+		{"0 */61 * * * *", "minutes", 1, "*/61", ReasonBadIncrement},
+		{"0 0 0 * FOO *", "month", 4, "FOO", ReasonInvalidValue},
+		{"0 0 0 * * 1,FUNDAY", "day-of-week", 5, "FUNDAY", ReasonInvalidValue},
+		{"0 0 0 xW * *", "day-of-month", 3, "xW", ReasonInvalidValue},
+		{"0 0 0 32W * *", "day-of-month", 3, "32W", ReasonOutOfRange},
+		{"0 0 0 1-5W * *", "day-of-month", 3, "1-5W", ReasonMisplaced},
+		{"0 0 0 * * 1-2#2", "day-of-week", 5, "1-2#2", ReasonMisplaced},
+		{"0 0 0 * * 9#1", "day-of-week", 5, "9#1", ReasonOutOfRange},
+		{"0 0 0 * * 2#6", "day-of-week", 5, "2#6", ReasonOutOfRange},
+		{"0 0 0 * * 9L", "day-of-week", 5, "9L", ReasonOutOfRange},
+		{"0 0 0 * * xL", "day-of-week", 5, "x", ReasonInvalidValue},
+		{"0 0 ? * * *", "hours", 2, "?", ReasonMisplaced},
+		{"0 0 0 ? * ?", "day-of-week", 5, "?", ReasonMisplaced},
+		{"H(0-60) * * * * *", "seconds", 0, "H(0-60)", ReasonOutOfRange},
+		{"H(5) * * * * *", "seconds", 0, "H(5)", ReasonInvalidRange},
+		{"0 * * * * * 2000/200", "year", 6, "2000/200", ReasonBadIncrement},
+	}
+
+	for _, c := range cases {
+		_, err := NewCronExpr(c.expr)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%q: expected *ParseError, got %v", c.expr, err)
+			continue
+		}
+		if pe.Expr != c.expr || pe.FieldName != c.field || pe.FieldIndex != c.index ||
+			pe.Token != c.token || pe.Reason != c.reason {
+			t.Errorf("%q: got {%q %q %v %q %v}, want {%q %q %v %q %v}", c.expr,
+				pe.Expr, pe.FieldName, pe.FieldIndex, pe.Token, pe.Reason,
+				c.expr, c.field, c.index, c.token, c.reason)
+		}
+	}
+
+	_, err := NewCronExprStrict("0 50/30 * * * *")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Reason != ReasonBadIncrement || pe.Token != "50/30" || pe.FieldIndex != 1 {
+		t.Errorf("strict: got %#v", err)
+	}
+
+	// the message is unchanged
+	_, err = NewCronExpr("0 0 0-24 * * *")
+	if want := "invalid expr 0 0 0-24 * * *: hours: out of range [0, 23]: 0-24"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	// errors.As sees through wrapping, and Unwrap exposes the cause
+	var e CronExpr
+	err = fmt.Errorf("config: %w", e.UnmarshalText([]byte("CRON_TZ=Nowhere/Atlantis * * * * *")))
+	if !errors.As(err, &pe) || pe.Err == nil || errors.Unwrap(pe) != pe.Err {
+		t.Errorf("wrapped: got %v", err)
+	}
+	if ReasonBadIncrement.String() != "bad-increment" {
+		t.Errorf("got %q", ReasonBadIncrement.String())
+	}
+}
+
+func TestCronExprSunday(t *testing.T) {
+	pairs := [][2]string{
+		{"* * * * * 7", "* * * * * 0"},
+		{"0 0 0 * * 7L", "0 0 0 * * 0L"},
+		{"0 0 0 * * 7#1", "0 0 0 * * 0#1"},
+		{"0 0 0 * * 5-7", "0 0 0 * * 0,5,6"},
+		{"0 0 0 * * 1-7/2", "0 0 0 * * 0,1,3,5"},
+		{"0 0 0 * * 0-7", "0 0 0 * * *"},
+	}
+	for _, p := range pairs {
+		a, b := mustParse(t, p[0]), mustParse(t, p[1])
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%q and %q differ: %+v, %+v", p[0], p[1], a, b)
+		}
+	}
+
+	sunday := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, expr := range []string{"* * * * * 7", "* * * * * 0", "* * * * * 5-7"} {
+		e := mustParse(t, expr)
+		if !e.matchDay(sunday) {
+			t.Errorf("%q does not match Sunday", expr)
+		}
+		if e.matchDay(sunday.AddDate(0, 0, 1)) {
+			t.Errorf("%q matches Monday", expr)
+		}
+	}
+
+	for _, expr := range []string{"* * * * * 8", "* * * * * 6-8", "0 0 0 * * 8L", "0 0 0 * * 8#1"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprNextWithin(t *testing.T) {
+	leap := mustParse(t, "0 0 0 29 2 *")
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	if got := leap.Next(from); !got.IsZero() {
+		t.Errorf("Next = %v, want zero time beyond the default horizon", got)
+	}
+	want := time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)
+	if got := leap.NextWithin(from, 5*365*24*time.Hour); !got.Equal(want) {
+		t.Errorf("NextWithin = %v, want %v", got, want)
+	}
+	if got := leap.NextWithin(from, 2*365*24*time.Hour); !got.IsZero() {
+		t.Errorf("NextWithin(2y) = %v, want zero time", got)
+	}
+
+	// still satisfiable only in theory
+	if got := mustParse(t, "0 0 0 31 2 *").NextWithin(from, 5*365*24*time.Hour); !got.IsZero() {
+		t.Errorf("31 February: got %v", got)
+	}
+
+	// the horizon can also be shorter than the default
+	yearly := mustParse(t, "@yearly")
+	from = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	if got := yearly.NextWithin(from, 30*24*time.Hour); !got.IsZero() {
+		t.Errorf("@yearly within 30 days: got %v", got)
+	}
+	if got := yearly.NextWithin(from, 0); !got.Equal(yearly.Next(from)) {
+		t.Errorf("zero horizon: got %v, want %v", got, yearly.Next(from))
+	}
+	every := mustParse(t, "@every 1h")
+	if got := every.NextWithin(from, 30*time.Minute); !got.IsZero() {
+		t.Errorf("@every 1h within 30m: got %v", got)
+	}
+	if got := every.NextWithin(from, time.Hour); !got.Equal(from.Add(time.Hour)) {
+		t.Errorf("@every 1h within 1h: got %v", got)
+	}
+
+	// a year field is not limited by default
+	if got := mustParse(t, "0 0 0 1 1 * 2040").Next(from); !got.Equal(time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("year field: got %v", got)
+	}
+}
+
+func TestCronExprBetween(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// strictly after from, not after to
+	hourly := mustParse(t, "@hourly")
+	times := hourly.Between(from, from.AddDate(0, 0, 1))
+	if len(times) != 24 || !times[0].Equal(from.Add(time.Hour)) || !times[23].Equal(from.AddDate(0, 0, 1)) {
+		t.Errorf("@hourly: got %v", times)
+	}
+	if !reflect.DeepEqual(times, hourly.NextN(from, 24)) {
+		t.Errorf("Between and NextN differ")
+	}
+	if times := hourly.Between(from, from); times != nil {
+		t.Errorf("empty range: got %v", times)
+	}
+	if times := hourly.Between(from, from.Add(-time.Hour)); times != nil {
+		t.Errorf("reversed range: got %v", times)
+	}
+
+	// day-of-month and day-of-week together match either
+	days := mustParse(t, "0 0 0 13 * 5").Between(from, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	var got []int
+	for _, d := range days {
+		got = append(got, d.Day())
+	}
+	if want := []int{6, 13, 20, 27}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dom or dow: got %v, want %v", got, want)
+	}
+
+	// rare schedules are found beyond the default horizon of Next
+	leaps := mustParse(t, "0 0 0 29 2 *").Between(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2037, 1, 1, 0, 0, 0, 0, time.UTC))
+	if len(leaps) != 3 || leaps[0].Year() != 2028 || leaps[2].Year() != 2036 {
+		t.Errorf("leap days: got %v", leaps)
+	}
+
+	every := mustParse(t, "@every 90m").Between(from, from.Add(6*time.Hour))
+	if len(every) != 4 {
+		t.Errorf("@every 90m: got %v", every)
+	}
+
+	// Each stops early and does not walk the whole range
+	n := 0
+	mustParse(t, "* * * * * *").Each(from, from.AddDate(10, 0, 0), func(ts time.Time) bool {
+		n++
+		return n < 1000
+	})
+	if n != 1000 {
+		t.Errorf("Each: called %v times", n)
+	}
+
+	// results are in the location of from
+	sh := time.FixedZone("UTC+8", 8*3600)
+	e, _ := NewCronExprInLocation("0 0 9 * * *", time.UTC)
+	times = e.Between(from.In(sh), from.In(sh).AddDate(0, 0, 2))
+	if len(times) != 2 || times[0].Location() != sh || times[0].Hour() != 17 {
+		t.Errorf("location: got %v", times)
+	}
+}
+
+func TestCronExprNextInclusive(t *testing.T) {
+	e := mustParse(t, "0 0 9 * * *")
+	at := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	cases := []struct {
+		t, want time.Time
+	}{
+		{at, at},
+		{at.Add(500 * time.Millisecond), at},
+		{at.Add(-time.Nanosecond), at},
+		{at.Add(time.Second), at.AddDate(0, 0, 1)},
+	}
+	for _, c := range cases {
+		if got := e.NextInclusive(c.t); !got.Equal(c.want) {
+			t.Errorf("NextInclusive(%v) = %v, want %v", c.t, got, c.want)
+		}
+	}
+
+	// Next stays exclusive
+	if got := e.Next(at); !got.Equal(at.AddDate(0, 0, 1)) {
+		t.Errorf("Next(%v) = %v", at, got)
+	}
+	if got := e.Next(at.Add(500 * time.Millisecond)); !got.Equal(at.AddDate(0, 0, 1)) {
+		t.Errorf("Next(%v) = %v", at.Add(500*time.Millisecond), got)
+	}
+
+	// pinned location, result in the caller's location
+	sh := time.FixedZone("UTC+8", 8*3600)
+	p := e.InLocation(sh)
+	local := time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC)
+	if got := p.NextInclusive(local); !got.Equal(local) || got.Location() != time.UTC {
+		t.Errorf("InLocation: got %v", got)
+	}
+
+	if got := mustParse(t, "@every 1m").NextInclusive(at.Add(time.Millisecond)); !got.Equal(at) {
+		t.Errorf("@every: got %v", got)
+	}
+}
+
+func TestCronExprJitter(t *testing.T) {
+	e, err := NewCronExpr("0 0 4 * * * ~ 300s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := e.WithJitterSource(rand.NewSource(1))
+	b := e.WithJitterSource(rand.NewSource(1))
+
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	delays := make(map[time.Duration]bool)
+	ta, tb := from, from
+	for i := 0; i < 20; i++ {
+		ta, tb = a.Next(ta), b.Next(tb)
+		if !ta.Equal(tb) {
+			t.Fatalf("same source: %v != %v", ta, tb)
+		}
+		match := time.Date(2023, 1, 1+i, 4, 0, 0, 0, time.UTC)
+		d := ta.Sub(match)
+		if d < 0 || d > 300*time.Second {
+			t.Fatalf("occurrence %v: delay %v out of [0, 300s]", i, d)
+		}
+		delays[d] = true
+	}
+	// re-drawn per occurrence
+	if len(delays) < 10 {
+		t.Errorf("only %v distinct delays", len(delays))
+	}
+	// the raw schedule is unaffected
+	if got := e.NextN(from, 1)[0]; !got.Equal(time.Date(2023, 1, 1, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("NextN = %v", got)
+	}
+
+	// never reaches the following occurrence
+	c, _ := NewCronExprWithJitter("* * * * * *", time.Hour)
+	c = c.WithJitterSource(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		if got := c.Next(from); got.Before(from.Add(time.Second)) || !got.Before(from.Add(2*time.Second)) {
+			t.Fatalf("per-second: got %v", got)
+		}
+	}
+	every, _ := NewCronExprWithJitter("@every 1m", time.Hour)
+	if got := every.Next(from); got.Before(from.Add(time.Minute)) || !got.Before(from.Add(2*time.Minute)) {
+		t.Errorf("@every: got %v", got)
+	}
+
+	// NewCronExprWithJitter overrides the suffix
+	d, _ := NewCronExprWithJitter("0 0 4 * * * ~ 300s", 0)
+	if got := d.Next(from); !got.Equal(time.Date(2023, 1, 1, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("no jitter: got %v", got)
+	}
+
+	for _, expr := range []string{"0 0 4 * * * ~", "0 0 4 * * * ~ 5x", "0 0 4 * * * ~ 0s", "0 0 4 * * * ~ -1s", "0 0 4 * * * ~ 1s ~ 2s"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+	if _, err := NewCronExprWithJitter("0 0 4 * * *", -time.Second); err == nil {
+		t.Error("negative jitter: expected error")
+	}
+}
+
+func TestCronExprCache(t *testing.T) {
+	a := mustParse(t, "0 0 4 * * *")
+	if b := mustParse(t, "0 0 4 * * *"); a != b {
+		t.Error("NewCronExpr does not reuse the parsed expression")
+	}
+	if _, err := NewCronExpr("0 0 24 * * *"); err == nil {
+		t.Error("expected error")
+	}
+	// copies do not touch the shared expression
+	if j, _ := NewCronExprWithJitter("0 0 4 * * *", time.Minute); j == a || a.jitter != 0 {
+		t.Error("NewCronExprWithJitter modified the cached expression")
+	}
+
+	c := newCronCache(8)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				expr := fmt.Sprintf("0 %v * * * *", (g+i)%16)
+				e := c.get(expr)
+				if e == nil {
+					e = c.add(expr, mustParse(t, expr))
+				}
+				if e.min != 1<<uint((g+i)%16) {
+					t.Errorf("%q: got minutes %b", expr, e.min)
+					return
+				}
+				if n := c.len(); n > 8 {
+					t.Errorf("cache holds %v expressions, limit 8", n)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// the least recently used expression is dropped first
+	c = newCronCache(2)
+	x, y, z := mustParse(t, "1 * * * * *"), mustParse(t, "2 * * * * *"), mustParse(t, "3 * * * * *")
+	c.add("x", x)
+	c.add("y", y)
+	c.get("x")
+	c.add("z", z)
+	if c.get("y") != nil || c.get("x") != x || c.get("z") != z {
+		t.Error("unexpected eviction order")
+	}
+
+	// concurrent NewCronExpr with the package cache
+	exprs := make([]*CronExpr, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				e, err := NewCronExpr(fmt.Sprintf("0 0 %v * * *", i%24))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if i == 7 {
+					exprs[g] = e
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for _, e := range exprs[1:] {
+		if e != exprs[0] {
+			t.Error("concurrent NewCronExpr returned different expressions")
+			break
+		}
+	}
+}
+
+func TestMustNewCronExpr(t *testing.T) {
+	if e := MustNewCronExpr("@daily"); e.String() != "0 0 0 * * *" {
+		t.Errorf("got %q", e.String())
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected panic")
+		} else if _, ok := r.(*ParseError); !ok {
+			t.Errorf("panic with %T, want *ParseError", r)
+		}
+	}()
+	MustNewCronExpr("0 0 24 * * *")
+}
+
+func TestCronExprWrapAround(t *testing.T) {
+	pairs := [][2]string{
+		{"0 0 22-2 * * *", "0 0 22,23,0,1,2 * * *"},
+		{"0 0 22-2/2 * * *", "0 0 22,0,2 * * *"},
+		{"0 0 21-2/2 * * *", "0 0 21,23,1 * * *"},
+		{"55-5 * * * * *", "55,56,57,58,59,0,1,2,3,4,5 * * * * *"},
+		{"0 50-10/10 * * * *", "0 50,0,10 * * * *"},
+		{"0 0 0 1 NOV-FEB *", "0 0 0 1 11,12,1,2 *"},
+		{"0 0 0 * * 5-1", "0 0 0 * * 5,6,0,1"},
+		{"0 0 0 * * FRI-MON", "0 0 0 * * 5,6,0,1"},
+		{"0 0 0 * * 6-7", "0 0 0 * * 6,0"},
+		{"0 0 0 * * 7-1", "0 0 0 * * 0,1"},
+	}
+	for _, p := range pairs {
+		a, b := mustParse(t, p[0]), mustParse(t, p[1])
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%q: got %q, want %q", p[0], a.String(), b.String())
+		}
+	}
+
+	e := mustParse(t, "0 0 22-2 * * *")
+	from := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	var hours []int
+	for _, next := range e.NextN(from, 5) {
+		hours = append(hours, next.Hour())
+	}
+	if want := []int{22, 23, 0, 1, 2}; !reflect.DeepEqual(hours, want) {
+		t.Errorf("NextN hours: got %v, want %v", hours, want)
+	}
+
+	// day-of-month and year do not wrap
+	for _, expr := range []string{"0 0 0 28-3 * *", "0 0 0 1 1 * 2030-2025", "0 0 25-1 * * *", "0 0 0 * * 6-8"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+
+	// hashed values stay inside a wrapped range
+	for i := 0; i < 50; i++ {
+		h, err := NewCronExprSeeded("0 0 H(22-2) * * *", strings.Repeat("w", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hour := maskValues(h.hour, 0, 23)
+		if len(hour) != 1 || (hour[0] < 22 && hour[0] > 2) {
+			t.Errorf("H(22-2): got %v", hour)
+		}
+	}
+}
+
+func TestCronExprPrev(t *testing.T) {
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"0 * * * *", time.Date(2000, 1, 1, 20, 10, 5, 0, time.UTC), time.Date(2000, 1, 1, 20, 0, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2000, 1, 1, 20, 0, 0, 0, time.UTC), time.Date(2000, 1, 1, 19, 0, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2000, 1, 1, 20, 0, 0, 1, time.UTC), time.Date(2000, 1, 1, 20, 0, 0, 0, time.UTC)},
+		{"30 15 10 * * *", time.Date(2000, 3, 1, 10, 0, 0, 0, time.UTC), time.Date(2000, 2, 29, 10, 15, 30, 0, time.UTC)},
+		{"0 0 0 1 1 *", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 12 L * *", time.Date(2001, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2001, 2, 28, 12, 0, 0, 0, time.UTC)},
+		{"59 59 23 31 12 *", time.Date(2001, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2000, 12, 31, 23, 59, 59, 0, time.UTC)},
+		// out of the one year bound
+		{"0 0 0 29 2 *", time.Date(2003, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 0 0 31 2 *", time.Date(2003, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+
+	for _, c := range cases {
+		if got := mustParse(t, c.expr).Prev(c.from); !got.Equal(c.want) {
+			t.Errorf("%q: Prev(%v) = %v, want %v", c.expr, c.from, got, c.want)
+		}
+	}
+}
+
+func TestCronExprPrevNext(t *testing.T) {
+	exprs := []string{
+		"* * * * * *",
+		"0 */15 9-18 * * 1-5",
+		"0 0 0 L * *",
+		"0 30 6 1W,15W * *",
+		"0 0 9 * * 2#2",
+		"0 0 0 1 JAN,JUL *",
+	}
+
+	r := rand.New(rand.NewSource(1))
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, expr := range exprs {
+		e := mustParse(t, expr)
+		for i := 0; i < 200; i++ {
+			from := base.Add(time.Duration(r.Int63n(int64(3 * 365 * 24 * time.Hour))))
+			prev := e.Prev(from)
+			if !prev.Before(from) {
+				t.Fatalf("%q: Prev(%v) = %v is not before", expr, from, prev)
+			}
+			// nothing matches between prev and from
+			if next := e.Next(prev); next.Before(from) {
+				t.Fatalf("%q: Prev(%v) = %v skips %v", expr, from, prev, next)
+			}
+		}
+	}
+}
+
+func TestCronExprYear(t *testing.T) {
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"0 0 0 1 1 * 2026", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 0 1 1 * 2026", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 0 0 1 1 * 2020-2030/5", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 12 * * * 2030,2040", time.Date(2031, 5, 5, 0, 0, 0, 0, time.UTC), time.Date(2040, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 0 29 2 * *", time.Date(2097, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 0 0 31 2 * *", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 0 0 1 1 * 1999", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+
+	for _, c := range cases {
+		if got := mustParse(t, c.expr).Next(c.from); !got.Equal(c.want) {
+			t.Errorf("%q: Next(%v) = %v, want %v", c.expr, c.from, got, c.want)
+		}
+	}
+
+	prev := mustParse(t, "0 0 0 1 1 * 2026").Prev(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC); !prev.Equal(want) {
+		t.Errorf("Prev = %v, want %v", prev, want)
+	}
+
+	// 6 fields behave as before
+	if !sameMasks(mustParse(t, "0 0 0 1 1 * *"), mustParse(t, "0 0 0 1 1 *")) {
+		t.Error("year field changes the other masks")
+	}
+
+	for _, expr := range []string{"0 0 0 1 1 * 1969", "0 0 0 1 1 * 2100", "0 0 0 1 1 * 2030-2020", "0 0 0 1 1 * 2020 1"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprInLocation(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	from := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)  // 08:00 in Shanghai
+	want := time.Date(2000, 1, 1, 21, 0, 0, 0, time.UTC) // 05:00 the next day in Shanghai
+
+	e, err := NewCronExprInLocation("0 0 5 * * *", shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Next(from); !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("NewCronExprInLocation: Next(%v) = %v, want %v", from, got, want)
+	}
+
+	for _, expr := range []string{"CRON_TZ=Asia/Shanghai 0 0 5 * * *", "TZ=Asia/Shanghai 0 5 * * *", "CRON_TZ=Asia/Shanghai  @daily"} {
+		e := mustParse(t, expr)
+		if expr == "CRON_TZ=Asia/Shanghai  @daily" {
+			want = time.Date(2000, 1, 1, 16, 0, 0, 0, time.UTC)
+		}
+		if got := e.Next(from); !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("%q: Next(%v) = %v, want %v", expr, from, got, want)
+		}
+	}
+
+	if got := mustParse(t, "0 0 5 * * *").InLocation(shanghai).Prev(from); !got.Equal(time.Date(1999, 12, 31, 21, 0, 0, 0, time.UTC)) {
+		t.Errorf("Prev = %v", got)
+	}
+
+	for _, expr := range []string{"CRON_TZ=Nowhere/City 0 0 5 * * *", "CRON_TZ=UTC"} {
+		if _, err := NewCronExpr(expr); err == nil {
+			t.Errorf("NewCronExpr(%q): expected error", expr)
+		}
+	}
+}
+
+func TestCronExprString(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"0 * * * *", "0 0 * * * *"},
+		{"*/15 0-8 * * *", "0 */15 0-8 * * *"},
+		{"0 0 12 * JAN-MAR MON-FRI", "0 0 12 * 1-3 1-5"},
+		{"5/10 1,2,3,7,9-11 * * * *", "5/10 1-3,7,9-11 * * * *"},
+		{"0 0 0 L,15W,LW * 5L,2#2", "0 0 0 L,LW,15W * 5L,2#2"},
+		{"0 0 0 1 1 * 2026", "0 0 0 1 1 * 2026"},
+		{"@daily", "0 0 0 * * *"},
+		{"@every 1h30m", "@every 1h30m0s"},
+		{"CRON_TZ=UTC 0 0 5 * * *", "CRON_TZ=UTC 0 0 5 * * *"},
+	}
+
+	for _, c := range cases {
+		if got := mustParse(t, c.expr).String(); got != c.want {
+			t.Errorf("%q: String() = %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestCronExprRoundTrip(t *testing.T) {
+	exprs := []string{
+		"* * * * * *",
+		"0 */15 9-18 * * 1-5",
+		"1,3,5,7 2-4,8 0/6 1-10/3 FEB-DEC/2 SUN,TUE",
+		"0 0 0 L,1W * 5L,2#2,3",
+		"0 0 0 1 1 * 2020-2030/5,2099",
+		"@every 90s",
+		"0 0 4 * * * ~ 5m0s",
+		"@every 90s ~ 10s",
+	}
+
+	for _, expr := range exprs {
+		e := mustParse(t, expr)
+
+		text, err := e.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := new(CronExpr)
+		if err := c.UnmarshalText(text); err != nil {
+			t.Fatalf("%q: UnmarshalText(%q): %v", expr, text, err)
+		}
+		if !reflect.DeepEqual(e, c) {
+			t.Errorf("%q: round trip through %q changes the expression", expr, text)
+		}
+	}
+
+	var conf struct {
+		Reset *CronExpr
+	}
+	if err := json.Unmarshal([]byte(`{"Reset": "0 0 4 * * *"}`), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := json.Marshal(conf); string(b) != `{"Reset":"0 0 4 * * *"}` {
+		t.Errorf("json.Marshal = %s", b)
+	}
+	if err := json.Unmarshal([]byte(`{"Reset": "0 0 4 * *"}`), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"Reset": "0 0 24 * * *"}`), &conf); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCronExprMatch(t *testing.T) {
+	e := mustParse(t, "* * 0-8 * * *")
+	if !e.Match(time.Date(2000, 1, 1, 8, 59, 59, 999, time.UTC)) {
+		t.Error("08:59:59 should match")
+	}
+	if e.Match(time.Date(2000, 1, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Error("09:00:00 should not match")
+	}
+
+	// day-of-month or day-of-week
+	e = mustParse(t, "0 0 0 1 * 1")
+	if !e.Match(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)) || !e.Match(time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("dom/dow should be OR-ed")
+	}
+	if e.Match(time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Error("2000-01-04 should not match")
+	}
+}
+
+func TestCronExprMatchNext(t *testing.T) {
+	exprs := []string{
+		"* * * * * *",
+		"*/7 * * * * *",
+		"0 */15 9-18 * * 1-5",
+		"0 0 12 1 * 1",
+		"0 0 0 L,15W * *",
+		"0 0 9 * * 2#2,5L",
+		"30 15 10 * JAN,JUL *",
+		"CRON_TZ=Asia/Shanghai 0 0 5 * * *",
+		"CRON_TZ=America/New_York 0 */20 1-3 * * *",
+	}
+
+	r := rand.New(rand.NewSource(1))
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, expr := range exprs {
+		e, err := NewCronExpr(expr)
+		if err != nil {
+			t.Skip(err)
+		}
+		for i := 0; i < 1000; i++ {
+			from := base.Add(time.Duration(r.Int63n(int64(3 * 365 * 24 * time.Hour))))
+			next := e.Next(from)
+			if !e.Match(next) {
+				t.Fatalf("%q: Next(%v) = %v does not match", expr, from, next)
+			}
+			if second := from.Truncate(time.Second).Add(time.Second); e.Match(second) && !next.Equal(second) {
+				t.Fatalf("%q: Next(%v) = %v skips %v", expr, from, next, second)
+			}
+			if e.Match(e.Prev(next).Add(time.Second)) && !e.Prev(next).Add(time.Second).Equal(next) {
+				t.Fatalf("%q: Prev(%v) skips a match", expr, next)
+			}
+		}
+	}
+}
+
+func TestCronExprNextN(t *testing.T) {
+	from := time.Date(2000, 1, 1, 20, 10, 5, 0, time.UTC)
+
+	for _, expr := range []string{"0 * * * *", "*/20 * * * * *", "0 0 0 L * *", "@every 90s", "CRON_TZ=UTC 0 0 5 * * 1-5"} {
+		e := mustParse(t, expr)
+		times := e.NextN(from, 5)
+		if len(times) != 5 {
+			t.Fatalf("%q: NextN returned %v times", expr, len(times))
+		}
+		next := from
+		for i, got := range times {
+			next = e.Next(next)
+			if !got.Equal(next) {
+				t.Errorf("%q: NextN[%v] = %v, want %v", expr, i, got, next)
+			}
+		}
+	}
+
+	// the schedule runs out
+	times := mustParse(t, "0 0 0 1 1 * 2001,2002").NextN(from, 5)
+	if len(times) != 2 || !times[1].Equal(time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("NextN = %v", times)
+	}
+	if times := mustParse(t, "0 0 0 31 2 *").NextN(from, 5); len(times) != 0 {
+		t.Errorf("NextN = %v", times)
+	}
+}
+
+func BenchmarkCronExprNextN(b *testing.B) {
+	e, _ := NewCronExpr("0 */15 9-18 * * 1-5")
+	from := time.Date(2000, 1, 1, 20, 10, 5, 0, time.UTC)
+	for i := 0; i < b.N; i++ {
+		e.NextN(from, 100)
+	}
+}
+
+func BenchmarkCronExprNextLoop(b *testing.B) {
+	e, _ := NewCronExpr("0 */15 9-18 * * 1-5")
+	from := time.Date(2000, 1, 1, 20, 10, 5, 0, time.UTC)
+	for i := 0; i < b.N; i++ {
+		t := from
+		times := make([]time.Time, 0, 100)
+		for j := 0; j < 100; j++ {
+			t = e.Next(t)
+			times = append(times, t)
+		}
+	}
+}
+
+func TestCronExprDescribe(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"0 */15 9-18 * * 1-5", "every 15 minutes between 09:00 and 18:59, Monday through Friday"},
+		{"* * * * * *", "every second"},
+		{"* * * * *", "every minute"},
+		{"*/10 * * * * *", "every 10 seconds"},
+		{"0 0 * * * *", "every hour"},
+		{"0 0 */2 * * *", "every 2 hours"},
+		{"0 30 9 * * *", "at 09:30"},
+		{"15 30 9,12,18 * * *", "at 09:30:15, 12:30:15 and 18:30:15"},
+		{"0 30 * * * *", "at 30 minutes past the hour"},
+		{"0 5/10 * * * *", "every 10 minutes starting at minute 5"},
+		{"0 1,2,3,7 * * * *", "at minutes 1 through 3 and 7"},
+		{"5 * 9-17 * * *", "at second 5 of every minute between 09:00 and 17:59"},
+		{"0 0 0 1 JAN,JUL *", "at 00:00, on day 1 of the month, only in January and July"},
+		{"0 0 12 * JAN-MAR MON,WED,FRI", "at 12:00, only on Monday, Wednesday and Friday, January through March"},
+		{"0 0 0 L * *", "at 00:00, on the last day of the month"},
+		{"0 0 9 15W * 2#2", "at 09:00, on the weekday nearest day 15 of the month or on the second Tuesday of the month"},
+		{"0 0 0 * * 5L", "at 00:00, on the last Friday of the month"},
+		{"0 0 0 1,15 * *", "at 00:00, on days 1 and 15 of the month"},
+		{"0 0 0 1 1 * 2026", "at 00:00, on day 1 of the month, only in January, only in 2026"},
+		{"@every 1h30m", "every 1h30m0s"},
+		{"CRON_TZ=Asia/Shanghai 0 0 5 * * *", "at 05:00 (Asia/Shanghai)"},
+		{"0 0 9-17/2 * * *", "at 09:00, 11:00, 13:00, 15:00 and 17:00"},
+	}
+
+	for _, c := range cases {
+		e, err := NewCronExpr(c.expr)
+		if err != nil {
+			t.Skip(err)
+		}
+		if got := e.Describe(); got != c.want {
+			t.Errorf("%q: Describe() = %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestCronExprIncrement(t *testing.T) {
+	cases := []struct {
+		expr  string
+		field string
+		token string
+	}{
+		{"0 */120 * * * *", "minutes", "*/120"},
+		{"*/61 * * * * *", "seconds", "*/61"},
+		{"0 0 */25 * * *", "hours", "*/25"},
+		{"0 0 0 1/32 * *", "day-of-month", "1/32"},
+		{"0 0 0 * */13 *", "month", "*/13"},
+		{"0 0 0 * * */8", "day-of-week", "*/8"},
+	}
+	for _, c := range cases {
+		_, err := NewCronExpr(c.expr)
+		if err == nil || !strings.Contains(err.Error(), c.field) || !strings.Contains(err.Error(), c.token) {
+			t.Errorf("NewCronExpr(%q): unexpected error %v", c.expr, err)
+		}
+	}
+
+	// accepted, but strict mode rejects a single value
+	cases = []struct {
+		expr  string
+		field string
+		token string
+	}{
+		{"0 5-10/30 * * * *", "minutes", "5-10/30"},
+		{"0 */60 * * * *", "minutes", "*/60"},
+		{"0 0 20/5 * * *", "hours", "20/5"},
+	}
+	for _, c := range cases {
+		if _, err := NewCronExpr(c.expr); err != nil {
+			t.Errorf("NewCronExpr(%q): %v", c.expr, err)
+		}
+		_, err := NewCronExprStrict(c.expr)
+		if err == nil || !strings.Contains(err.Error(), c.field) || !strings.Contains(err.Error(), c.token) {
+			t.Errorf("NewCronExprStrict(%q): unexpected error %v", c.expr, err)
+		}
+	}
+
+	// valid expressions parse identically in both modes
+	for _, expr := range []string{"0 */15 9-18 * * 1-5", "*/30 * * * *", "0 0 0 1-31/15 * *", "0 5-10/5 * * * *", "0 0 0 1 1 * 2020/50"} {
+		e, err := NewCronExprStrict(expr)
+		if err != nil {
+			t.Errorf("NewCronExprStrict(%q): %v", expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(e, mustParse(t, expr)) {
+			t.Errorf("%q parses differently in strict mode", expr)
+		}
+	}
+}
+
+func TestCronExprDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		expr string
+		loc  *time.Location
+		from time.Time
+		want []time.Time
+	}{
+		// 02:30 does not exist, fire at 03:00 EDT
+		{"0 30 2 * * *", ny, utc(2021, 3, 14, 5, 0), []time.Time{utc(2021, 3, 14, 7, 0), utc(2021, 3, 15, 6, 30)}},
+		// the whole hour is skipped, fire only once
+		{"0 * 2 * * *", ny, utc(2021, 3, 14, 6, 59), []time.Time{utc(2021, 3, 14, 7, 0), utc(2021, 3, 15, 6, 0)}},
+		// 01:30 happens twice, fire only on the EDT one
+		{"0 30 1 * * *", ny, utc(2021, 11, 7, 4, 0), []time.Time{utc(2021, 11, 7, 5, 30), utc(2021, 11, 8, 6, 30)}},
+		{"0 */15 * * * *", ny, utc(2021, 11, 7, 5, 40), []time.Time{utc(2021, 11, 7, 5, 45), utc(2021, 11, 7, 7, 0)}},
+		// started inside the repeated hour
+		{"0 50 1 * * *", ny, utc(2021, 11, 7, 6, 40), []time.Time{utc(2021, 11, 8, 6, 50)}},
+		{"0 30 2 * * *", berlin, utc(2021, 3, 27, 23, 0), []time.Time{utc(2021, 3, 28, 1, 0), utc(2021, 3, 29, 0, 30)}},
+		{"0 30 2 * * *", berlin, utc(2021, 10, 30, 22, 0), []time.Time{utc(2021, 10, 31, 0, 30), utc(2021, 11, 1, 1, 30)}},
+		{"0 0 3 * * *", berlin, utc(2021, 10, 30, 22, 0), []time.Time{utc(2021, 10, 31, 2, 0)}},
+	}
+
+	for _, c := range cases {
+		e := mustParse(t, c.expr).InLocation(c.loc)
+		next := c.from
+		for _, want := range c.want {
+			next = e.Next(next)
+			if !next.Equal(want) {
+				t.Errorf("%q in %v: got %v, want %v", c.expr, c.loc, next, want)
+				break
+			}
+			if !e.Match(next) {
+				t.Errorf("%q in %v: %v does not match", c.expr, c.loc, next)
+			}
+		}
+	}
+
+	e := mustParse(t, "0 30 2 * * *").InLocation(ny)
+	if got := e.Prev(utc(2021, 3, 14, 7, 0).Add(30 * time.Second)); !got.Equal(utc(2021, 3, 14, 7, 0)) {
+		t.Errorf("Prev = %v", got)
+	}
+	e = mustParse(t, "0 50 1 * * *").InLocation(ny)
+	if got := e.Prev(utc(2021, 11, 7, 6, 40)); !got.Equal(utc(2021, 11, 7, 5, 50)) {
+		t.Errorf("Prev = %v", got)
+	}
+	if e.Match(utc(2021, 11, 7, 6, 50)) {
+		t.Error("the repeated 01:50 EST should not match")
+	}
+}
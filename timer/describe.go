@@ -0,0 +1,273 @@
+package timer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ordinals = []string{"first", "second", "third", "fourth", "fifth"}
+
+//用英文描述表达式,如"every 15 minutes between 09:00 and 18:59, Monday through Friday"
+func (e *CronExpr) Describe() string {
+	var desc string
+	if e.every > 0 {
+		desc = "every " + e.every.String()
+	} else {
+		parts := []string{e.describeTime()}
+		if d := e.describeDay(); d != "" {
+			parts = append(parts, d)
+		}
+		if e.month != 0x1ffe {
+			parts = append(parts, describeSet(maskValues(e.month, 1, 12), monthName, "in"))
+		}
+		if e.year != nil {
+			var years []int
+			for y := minYear; y <= maxYear; y++ {
+				if e.matchYear(y) {
+					years = append(years, y)
+				}
+			}
+			if len(years) != maxYear-minYear+1 {
+				parts = append(parts, describeSet(years, func(y int) string {
+					return fmt.Sprint(y)
+				}, "in"))
+			}
+		}
+		desc = strings.Join(parts, ", ")
+	}
+
+	if e.jitter > 0 {
+		desc += ", delayed randomly by up to " + e.jitter.String()
+	}
+	if e.loc != nil {
+		desc += " (" + e.loc.String() + ")"
+	}
+	return desc
+}
+
+//时分秒部分
+func (e *CronExpr) describeTime() string {
+	sec := maskValues(e.sec, 0, 59)
+	min := maskValues(e.min, 0, 59)
+	hour := maskValues(e.hour, 0, 23)
+
+	//固定的时分
+	if len(sec) == 1 && len(min) == 1 {
+		if len(hour) <= 6 && !isStep(hour, 23) {
+			return "at " + listValues(hour, func(h int) string {
+				return clock(h, min[0], sec[0])
+			})
+		}
+		if sec[0] == 0 && min[0] == 0 {
+			if incr, ok := stepOf(hour, 23); ok {
+				return describeStep(hour[0], 0, incr, "hour", func(h int) string { return clock(h, 0, 0) })
+			}
+			if q := describeHours(hour); q != "" {
+				return "every hour " + q
+			}
+			return "every hour"
+		}
+		at := fmt.Sprintf("at %v minutes past the hour", min[0])
+		if sec[0] != 0 {
+			at = fmt.Sprintf("at %v minutes and %v seconds past the hour", min[0], sec[0])
+		}
+		if q := describeHours(hour); q != "" {
+			return at + ", " + q
+		}
+		return at
+	}
+
+	var parts []string
+	if len(sec) == 1 && sec[0] == 0 {
+		parts = append(parts, describeUnit(min, 0, 59, "minute"))
+	} else {
+		s := describeUnit(sec, 0, 59, "second")
+		switch {
+		case len(min) == 60:
+			if strings.HasPrefix(s, "at ") {
+				s += " of every minute"
+			}
+		case len(min) == 1:
+			s += fmt.Sprintf(" during minute %v", min[0])
+		default:
+			s += " during minutes " + listValues(min, itoa)
+		}
+		parts = append(parts, s)
+	}
+	if q := describeHours(hour); q != "" {
+		parts = append(parts, q)
+	}
+	return strings.Join(parts, " ")
+}
+
+//小时的限定,所有小时返回空
+func describeHours(hour []int) string {
+	if len(hour) == 24 {
+		return ""
+	}
+	if incr, ok := stepOf(hour, 23); ok {
+		return describeStep(hour[0], 0, incr, "hour", func(h int) string { return clock(h, 0, 0) })
+	}
+	if hour[len(hour)-1]-hour[0] == len(hour)-1 { //连续
+		return fmt.Sprintf("between %v and %v", clock(hour[0], 0, 0), clock(hour[len(hour)-1], 59, 0))
+	}
+	return "during hours " + listValues(hour, func(h int) string { return fmt.Sprintf("%02d", h) })
+}
+
+//秒或分
+func describeUnit(values []int, min int, max int, unit string) string {
+	if len(values) == max-min+1 {
+		return "every " + unit
+	}
+	if incr, ok := stepOf(values, max); ok {
+		return describeStep(values[0], min, incr, unit, func(v int) string {
+			return fmt.Sprintf("%v %v", unit, v)
+		})
+	}
+	if len(values) == 1 {
+		return fmt.Sprintf("at %v %v", unit, values[0])
+	}
+	if values[len(values)-1]-values[0] == len(values)-1 {
+		return fmt.Sprintf("every %v from %v through %v", unit, values[0], values[len(values)-1])
+	}
+	return fmt.Sprintf("at %vs %v", unit, listValues(values, itoa))
+}
+
+func describeStep(start int, min int, incr int, unit string, name func(int) string) string {
+	s := fmt.Sprintf("every %v %vs", incr, unit)
+	if start != min {
+		s += " starting at " + name(start)
+	}
+	return s
+}
+
+//日期部分,day-of-month和day-of-week都没有限定时返回空
+func (e *CronExpr) describeDay() string {
+	var dom, dow []string
+
+	if !e.domAny {
+		if e.dom != 0 {
+			days := maskValues(e.dom, 1, 31)
+			word := "day"
+			if len(days) > 1 {
+				word = "days"
+			}
+			dom = append(dom, fmt.Sprintf("on %v %v of the month", word, listValues(days, itoa)))
+		}
+		if e.lastDom {
+			dom = append(dom, "on the last day of the month")
+		}
+		if e.lastW {
+			dom = append(dom, "on the last weekday of the month")
+		}
+		for n := 1; n <= 31; n++ {
+			if 1<<uint(n)&e.domW != 0 {
+				dom = append(dom, fmt.Sprintf("on the weekday nearest day %v of the month", n))
+			}
+		}
+	}
+
+	if !e.dowAny {
+		if e.dow != 0 {
+			dow = append(dow, describeSet(maskValues(e.dow, 0, 6), weekdayName, "on"))
+		}
+		for d := 0; d <= 6; d++ {
+			if 1<<uint(d)&e.lastDow != 0 {
+				dow = append(dow, "on the last "+weekdayName(d)+" of the month")
+			}
+		}
+		for i := 0; i < 35; i++ {
+			if 1<<uint(i)&e.nthDow != 0 {
+				dow = append(dow, "on the "+ordinals[i/7]+" "+weekdayName(i%7)+" of the month")
+			}
+		}
+	}
+
+	//day-of-month和day-of-week同时限定时任一匹配即可
+	return strings.Join(append(dom, dow...), " or ")
+}
+
+//连续的范围写作"A through B",否则写作"only on A and B"
+func describeSet(values []int, name func(int) string, prep string) string {
+	if len(values) > 2 && values[len(values)-1]-values[0] == len(values)-1 {
+		return name(values[0]) + " through " + name(values[len(values)-1])
+	}
+	return "only " + prep + " " + listValues(values, name)
+}
+
+//列出值,3个以上连续的值写作"a through b"
+func listValues(values []int, name func(int) string) string {
+	var items []string
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		if j-i >= 2 {
+			items = append(items, name(values[i])+" through "+name(values[j]))
+		} else {
+			for k := i; k <= j; k++ {
+				items = append(items, name(values[k]))
+			}
+		}
+		i = j + 1
+	}
+
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+func maskValues(mask uint64, min int, max int) []int {
+	var values []int
+	for i := min; i <= max; i++ {
+		if 1<<uint(i)&mask != 0 {
+			values = append(values, i)
+		}
+	}
+	return values
+}
+
+//是否为增幅大于1、到最大值为止的等差数列
+func stepOf(values []int, max int) (incr int, ok bool) {
+	if len(values) < 2 {
+		return
+	}
+	incr = values[1] - values[0]
+	if incr < 2 || values[len(values)-1]+incr <= max {
+		return
+	}
+	for i := 2; i < len(values); i++ {
+		if values[i]-values[i-1] != incr {
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+func isStep(values []int, max int) bool {
+	_, ok := stepOf(values, max)
+	return ok && len(values) > 2
+}
+
+func clock(h int, m int, s int) string {
+	if s != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", h, m)
+}
+
+func itoa(i int) string {
+	return fmt.Sprint(i)
+}
+
+func monthName(m int) string {
+	return time.Month(m).String()
+}
+
+func weekdayName(d int) string {
+	return time.Weekday(d).String()
+}
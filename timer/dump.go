@@ -0,0 +1,124 @@
+package timer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type TimerKind int
+
+const (
+	KindAfter TimerKind = iota
+	KindCron
+	KindTicker
+)
+
+var timerKindNames = []string{"after", "cron", "ticker"}
+
+func (k TimerKind) String() string {
+	if k < 0 || int(k) >= len(timerKindNames) {
+		return fmt.Sprintf("TimerKind(%d)", int(k))
+	}
+	return timerKindNames[k]
+}
+
+// TimerInfo describes a live timer, see Dispatcher.Dump
+type TimerInfo struct {
+	Name     string
+	Kind     TimerKind
+	Labels   map[string]string
+	Created  time.Time
+	NextTime time.Time // zero if fired and waiting for its callback
+	Fires    uint64
+}
+
+func (info TimerInfo) String() string {
+	name := info.Name
+	if name == "" {
+		name = "-"
+	}
+	s := fmt.Sprintf("%v %v created %v next %v fired %v",
+		info.Kind, name, info.Created.Format(time.RFC3339), info.NextTime.Format(time.RFC3339), info.Fires)
+	if len(info.Labels) > 0 {
+		var labels []string
+		for k, v := range info.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		s += " " + strings.Join(labels, ",")
+	}
+	return s
+}
+
+// SetLabels attaches labels to the timer, shown by Dump. Goroutine safe.
+func (t *Timer) SetLabels(labels map[string]string) {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	t.disp.mu.Lock()
+	t.labels = copied
+	t.disp.mu.Unlock()
+}
+
+// SetLabels attaches labels to the cron, shown by Dump. Goroutine safe.
+func (c *Cron) SetLabels(labels map[string]string) {
+	c.t.SetLabels(labels)
+}
+
+// Dump returns the armed timers, soonest first, and the crons. It takes a
+// snapshot under the dispatcher lock, without calling into the timers.
+// Goroutine safe.
+func (disp *Dispatcher) Dump() []TimerInfo {
+	disp.mu.Lock()
+	var timers []*Timer
+	if disp.wheel != nil {
+		for l := range disp.wheel.buckets {
+			for s := range disp.wheel.buckets[l] {
+				for t := disp.wheel.buckets[l][s].head; t != nil; t = t.next {
+					timers = append(timers, t)
+				}
+			}
+		}
+	} else {
+		timers = append(timers, disp.timers...)
+	}
+	var infos []TimerInfo
+	for _, t := range timers {
+		if t.kind != KindCron {
+			infos = append(infos, t.info())
+		}
+	}
+	// crons may be fired, running or paused
+	for c := range disp.crons {
+		info := c.t.info()
+		if !c.t.armed() {
+			info.NextTime = time.Time{}
+		}
+		info.Fires = c.RunCount()
+		infos = append(infos, info)
+	}
+	disp.mu.Unlock()
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].NextTime.IsZero() != infos[j].NextTime.IsZero() {
+			return !infos[i].NextTime.IsZero()
+		}
+		return infos[i].NextTime.Before(infos[j].NextTime)
+	})
+	return infos
+}
+
+// must be called with disp.mu held
+func (t *Timer) info() TimerInfo {
+	return TimerInfo{
+		Name:     t.name,
+		Kind:     t.kind,
+		Labels:   t.labels,
+		Created:  t.created,
+		NextTime: t.when,
+		Fires:    t.fires,
+	}
+}
@@ -0,0 +1,147 @@
+package timer
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// what a Dispatcher does with due timers when their lane is full
+type FullPolicy int
+
+const (
+	// wait for the consumer, holding back the timers due after it
+	FullBlock FullPolicy = iota
+	// drop the timer waiting longest in the lane to make room
+	FullDropOldest
+	// drop the timer that became due
+	FullDropNewest
+	// keep the timer in an unbounded buffer feeding the lane
+	FullUnbounded
+)
+
+// WithFullPolicy sets what happens to due timers when the lane they go to is
+// full, FullBlock by default. Dropped firings are counted in Stats, crons and
+// tickers whose firing is dropped are armed for their next firing.
+func WithFullPolicy(p FullPolicy) DispatcherOption {
+	return func(disp *Dispatcher) {
+		disp.full = p
+	}
+}
+
+// index of the lane of t in disp.buffers
+func (disp *Dispatcher) laneIndex(t *Timer) int {
+	if disp.Lane(t.prio) == nil {
+		return int(PriorityNormal - PriorityLow)
+	}
+	return int(t.prio - PriorityLow)
+}
+
+// sends due timers to their lanes following disp.full
+func (disp *Dispatcher) send(due []*Timer) {
+	for _, t := range due {
+		c := disp.laneOf(t)
+		switch disp.full {
+		case FullDropNewest:
+			select {
+			case c <- t:
+			default:
+				disp.drop(t)
+			}
+		case FullDropOldest:
+			for sent := false; !sent; {
+				select {
+				case c <- t:
+					sent = true
+				default:
+					select {
+					case old := <-c:
+						disp.drop(old)
+					default:
+					}
+				}
+			}
+		case FullUnbounded:
+			disp.buffer(t, c)
+		default:
+			c <- t
+		}
+	}
+}
+
+// discards a firing, and arms the next one of a cron or a ticker
+func (disp *Dispatcher) drop(t *Timer) {
+	disp.mu.Lock()
+	dropped := disp.remove(t)
+	closed := disp.closed
+	disp.mu.Unlock()
+
+	if dropped {
+		atomic.AddUint64(&disp.dropped, 1)
+		if t.onDrop != nil && !closed {
+			t.onDrop()
+		}
+	}
+}
+
+func (disp *Dispatcher) buffer(t *Timer, c chan *Timer) {
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+
+	i := disp.laneIndex(t)
+	b := &disp.buffers[i]
+	if b.Len() == 0 {
+		select {
+		case c <- t:
+			return
+		default:
+		}
+	}
+	b.PushBack(t)
+	if !disp.pumping[i] && !disp.closed {
+		disp.pumping[i] = true
+		disp.sending.Add(1)
+		go disp.pump(i, c)
+	}
+}
+
+// moves buffered timers to their lane as the consumer makes room
+func (disp *Dispatcher) pump(i int, c chan *Timer) {
+	defer disp.sending.Done()
+	for {
+		disp.mu.Lock()
+		b := &disp.buffers[i]
+		if disp.closed || b.Len() == 0 {
+			disp.pumping[i] = false
+			disp.mu.Unlock()
+			return
+		}
+		t := b.Remove(b.Front()).(*Timer)
+		disp.mu.Unlock()
+
+		c <- t
+	}
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) buffered() int {
+	n := 0
+	for i := range disp.buffers {
+		n += disp.buffers[i].Len()
+	}
+	return n
+}
+
+// the buffered timers, higher lanes first, once the pumps are done
+func (disp *Dispatcher) takeBuffered() []*Timer {
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+
+	var ts []*Timer
+	for i := len(disp.buffers) - 1; i >= 0; i-- {
+		for e := disp.buffers[i].Front(); e != nil; e = e.Next() {
+			ts = append(ts, e.Value.(*Timer))
+		}
+		disp.buffers[i] = list.List{}
+	}
+	return ts
+}
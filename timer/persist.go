@@ -0,0 +1,120 @@
+package timer
+
+import (
+	"errors"
+	"github.com/name5566/leaf/log"
+	"time"
+)
+
+// Store keeps persistent timers across restarts, see AfterFuncPersistent.
+// Its methods are called without the dispatcher lock held.
+type Store interface {
+	Save(id string, fireAt time.Time, payload []byte) error
+	Delete(id string) error
+	LoadAll() ([]StoredTimer, error)
+}
+
+type StoredTimer struct {
+	ID      string
+	FireAt  time.Time
+	Payload []byte
+}
+
+// Restore sets the store of persistent timers, and arms those in it, past
+// due ones fire right away. Their firings call handler with the id and the
+// payload through ChanTimer. Timers already armed with the same id are
+// replaced.
+func (disp *Dispatcher) Restore(store Store, handler func(id string, payload []byte)) error {
+	disp.mu.Lock()
+	disp.store = store
+	disp.onPersistent = handler
+	disp.mu.Unlock()
+
+	entries, err := store.LoadAll()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		disp.persistent(e.ID, e.FireAt, e.Payload)
+	}
+	return nil
+}
+
+// AfterFuncPersistent arms a timer firing at fireAt that is saved to the
+// store set by Restore, so that it survives restarts. It is deleted from the
+// store when it fires or is stopped, not by Close. A timer armed with the
+// same id is replaced.
+func (disp *Dispatcher) AfterFuncPersistent(id string, fireAt time.Time, payload []byte) (*Timer, error) {
+	disp.mu.Lock()
+	store := disp.store
+	disp.mu.Unlock()
+	if store == nil {
+		return nil, errors.New("no timer store, call Restore first")
+	}
+
+	if err := store.Save(id, fireAt, payload); err != nil {
+		return nil, err
+	}
+	return disp.persistent(id, fireAt, payload), nil
+}
+
+func (disp *Dispatcher) persistent(id string, fireAt time.Time, payload []byte) *Timer {
+	t := disp.newTimer(nil)
+	t.pid = id
+	t.payload = payload
+	t.cb = func() {
+		disp.unpersist(t)
+		disp.mu.Lock()
+		handler := disp.onPersistent
+		disp.mu.Unlock()
+		handler(id, payload)
+	}
+
+	disp.mu.Lock()
+	if old := disp.persisted[id]; old != nil {
+		disp.stop(old)
+	}
+	if disp.persisted == nil {
+		disp.persisted = make(map[string]*Timer)
+	}
+	disp.persisted[id] = t
+	disp.at(t, fireAt)
+	disp.mu.Unlock()
+	return t
+}
+
+// deletes t from the store unless it was replaced
+func (disp *Dispatcher) unpersist(t *Timer) {
+	disp.mu.Lock()
+	if disp.persisted[t.pid] != t {
+		disp.mu.Unlock()
+		return
+	}
+	delete(disp.persisted, t.pid)
+	store := disp.store
+	disp.mu.Unlock()
+
+	if err := store.Delete(t.pid); err != nil {
+		log.Error("delete timer %v: %v", t.pid, err)
+	}
+}
+
+// saves the new deadline of a reset timer
+func (disp *Dispatcher) repersist(t *Timer) {
+	disp.mu.Lock()
+	if other := disp.persisted[t.pid]; other != t {
+		if other != nil {
+			disp.stop(other)
+		}
+		if disp.persisted == nil {
+			disp.persisted = make(map[string]*Timer)
+		}
+		disp.persisted[t.pid] = t
+	}
+	store, when := disp.store, t.when
+	disp.mu.Unlock()
+
+	if err := store.Save(t.pid, when, t.payload); err != nil {
+		log.Error("save timer %v: %v", t.pid, err)
+	}
+}
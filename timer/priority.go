@@ -0,0 +1,89 @@
+package timer
+
+import (
+	"sort"
+	"time"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// WithPriorityLanes adds a high and a low priority lane of length l next to
+// ChanTimer, which stays the normal lane. Use Exec to run the timers received
+// from any lane.
+func WithPriorityLanes(l int) DispatcherOption {
+	return func(disp *Dispatcher) {
+		disp.chanHigh = make(chan *Timer, l)
+		disp.chanLow = make(chan *Timer, l)
+	}
+}
+
+// Lane returns the channel of a priority lane, ChanTimer for PriorityNormal.
+// It is nil for the other priorities if the dispatcher has no lanes.
+func (disp *Dispatcher) Lane(p Priority) chan *Timer {
+	switch p {
+	case PriorityHigh:
+		return disp.chanHigh
+	case PriorityLow:
+		return disp.chanLow
+	default:
+		return disp.ChanTimer
+	}
+}
+
+// the lane a timer is sent to
+func (disp *Dispatcher) laneOf(t *Timer) chan *Timer {
+	if c := disp.Lane(t.prio); c != nil {
+		return c
+	}
+	return disp.ChanTimer
+}
+
+// Exec runs the callback of t, received from any lane, after those of the
+// timers already waiting in higher lanes. Without lanes it is t.Cb().
+func (disp *Dispatcher) Exec(t *Timer) {
+	for {
+		h := disp.receiveAbove(t.prio)
+		if h == nil {
+			break
+		}
+		h.Cb()
+	}
+	t.Cb()
+}
+
+// a timer waiting in the highest non-empty lane above p, nil if none
+func (disp *Dispatcher) receiveAbove(p Priority) *Timer {
+	for q := PriorityHigh; q > p; q-- {
+		c := disp.Lane(q)
+		if c == nil {
+			continue
+		}
+		select {
+		case t := <-c:
+			return t
+		default:
+		}
+	}
+	return nil
+}
+
+// send higher priority timers first
+func sortByPriority(due []*Timer) {
+	sort.SliceStable(due, func(i, j int) bool { return due[i].prio > due[j].prio })
+}
+
+// AfterFuncPriority is AfterFunc sending the timer to the lane of p
+func (disp *Dispatcher) AfterFuncPriority(p Priority, d time.Duration, cb func()) *Timer {
+	t := disp.newTimer(cb)
+	t.prio = p
+	disp.mu.Lock()
+	disp.after(t, d)
+	disp.mu.Unlock()
+	return t
+}
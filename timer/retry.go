@@ -0,0 +1,91 @@
+package timer
+
+import (
+	"time"
+)
+
+type RetryOptions struct {
+	InitialDelay time.Duration // before the first retry
+	MaxDelay     time.Duration // 0 for no limit
+	Multiplier   float64       // applied to the delay after each retry, below 1 means 1
+	MaxAttempts  int           // including the first one, 0 for no limit
+	Jitter       time.Duration // random extra delay of every retry, up to Jitter
+}
+
+// Retry
+// Stop and Attempts are goroutine safe
+type Retry struct {
+	t        *Timer
+	opts     RetryOptions
+	delay    time.Duration // before the next retry
+	attempts int
+	stopped  bool
+}
+
+// Retry calls op through ChanTimer right away, then again after growing
+// delays until it returns nil or MaxAttempts is reached. done, if not nil,
+// gets nil on success or the last error, it is not called if the retry is
+// stopped or op panics.
+func (disp *Dispatcher) Retry(opts RetryOptions, op func() error, done func(error)) *Retry {
+	if opts.Multiplier < 1 {
+		opts.Multiplier = 1
+	}
+
+	r := new(Retry)
+	r.opts = opts
+	r.delay = opts.InitialDelay
+	r.t = disp.newTimer(nil)
+	r.t.jitter = opts.Jitter
+	r.t.cb = func() {
+		err := op()
+
+		disp.mu.Lock()
+		if r.stopped {
+			disp.mu.Unlock()
+			return
+		}
+		r.attempts++
+		if err == nil || opts.MaxAttempts > 0 && r.attempts >= opts.MaxAttempts {
+			r.stopped = true
+			disp.mu.Unlock()
+			if done != nil {
+				done(err)
+			}
+			return
+		}
+		disp.after(r.t, r.delay)
+		r.delay = r.next()
+		disp.mu.Unlock()
+	}
+
+	disp.mu.Lock()
+	disp.add(r.t, disp.clock.Now())
+	disp.mu.Unlock()
+	return r
+}
+
+// the delay after r.delay
+func (r *Retry) next() time.Duration {
+	d := time.Duration(float64(r.delay) * r.opts.Multiplier)
+	if r.opts.MaxDelay > 0 && d > r.opts.MaxDelay {
+		d = r.opts.MaxDelay
+	}
+	return d
+}
+
+// Stop cancels the attempts not started yet
+func (r *Retry) Stop() {
+	disp := r.t.disp
+	disp.mu.Lock()
+	r.stopped = true
+	disp.stop(r.t)
+	disp.mu.Unlock()
+}
+
+// Attempts returns how many times op has returned
+func (r *Retry) Attempts() int {
+	disp := r.t.disp
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+	return r.attempts
+}
@@ -0,0 +1,71 @@
+package timer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DispatcherStats is a snapshot of a Dispatcher's counters
+type DispatcherStats struct {
+	Pending int    // armed, not fired yet
+	Queued  int    // fired, callback not run yet
+	Buffer  int    // queued in the FullUnbounded buffer, not in a lane yet
+	Fired   uint64 // sent to ChanTimer
+	Stopped uint64 // stopped before the callback ran
+	Dropped uint64 // fired but discarded by Close or the full policy
+	Skipped uint64 // cron firings skipped by the overrun policy
+	Crons   []CronStats
+}
+
+type CronStats struct {
+	Expr     string
+	NextTime time.Time
+	RunCount uint64
+	Skipped  uint64
+	Paused   bool
+}
+
+func (s DispatcherStats) String() string {
+	return fmt.Sprintf("pending %v, queued %v, fired %v, stopped %v, dropped %v, skipped %v, crons %v",
+		s.Pending, s.Queued, s.Fired, s.Stopped, s.Dropped, s.Skipped, len(s.Crons))
+}
+
+// goroutine safe
+func (disp *Dispatcher) Stats() DispatcherStats {
+	var s DispatcherStats
+	s.Fired = atomic.LoadUint64(&disp.fired)
+	s.Stopped = atomic.LoadUint64(&disp.stopped)
+	s.Dropped = atomic.LoadUint64(&disp.dropped)
+	s.Skipped = atomic.LoadUint64(&disp.skipped)
+
+	disp.mu.Lock()
+	s.Pending = disp.armed()
+	s.Queued = disp.pending - s.Pending
+	s.Buffer = disp.buffered()
+	crons := make([]*Cron, 0, len(disp.crons))
+	for c := range disp.crons {
+		crons = append(crons, c)
+	}
+	disp.mu.Unlock()
+
+	for _, c := range crons {
+		s.Crons = append(s.Crons, CronStats{
+			Expr:     c.cronExpr.String(),
+			NextTime: c.NextTime(),
+			RunCount: c.RunCount(),
+			Skipped:  c.SkipCount(),
+			Paused:   c.Paused(),
+		})
+	}
+	return s
+}
+
+// Waited is how long t has been waiting in ChanTimer or its lane since it
+// last fired, by the clock of the Dispatcher. Goroutine safe
+func (t *Timer) Waited() time.Duration {
+	t.disp.mu.Lock()
+	queued := t.queued
+	t.disp.mu.Unlock()
+	return t.disp.clock.Now().Sub(queued)
+}
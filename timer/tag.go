@@ -0,0 +1,58 @@
+package timer
+
+// a timer or a cron StopTag can stop
+type tagged interface {
+	// must be called with disp.mu held
+	stopTagged()
+}
+
+func (t *Timer) stopTagged() {
+	t.disp.stop(t)
+}
+
+func (c *Cron) stopTagged() {
+	c.stop()
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) tagAdd(tag string, x tagged) {
+	if disp.tags == nil {
+		disp.tags = make(map[string]map[tagged]struct{})
+	}
+	set := disp.tags[tag]
+	if set == nil {
+		set = make(map[tagged]struct{})
+		disp.tags[tag] = set
+	}
+	set[x] = struct{}{}
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) tagRemove(tag string, x tagged) {
+	set := disp.tags[tag]
+	delete(set, x)
+	if len(set) == 0 {
+		delete(disp.tags, tag)
+	}
+}
+
+// CronFuncTagged is CronFunc with a tag, see AfterFuncTagged
+func (disp *Dispatcher) CronFuncTagged(tag string, cronExpr *CronExpr, cb func()) *Cron {
+	return disp.cronFunc("", tag, cronExpr, CronQueue, 1, cb)
+}
+
+// StopTag stops the timers and crons tagged with tag that are armed or whose
+// callback has not run yet, and returns how many were stopped.
+// Goroutine safe.
+func (disp *Dispatcher) StopTag(tag string) int {
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+
+	set := disp.tags[tag]
+	n := len(set)
+	for x := range set {
+		x.stopTagged()
+	}
+	delete(disp.tags, tag)
+	return n
+}
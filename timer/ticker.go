@@ -0,0 +1,84 @@
+package timer
+
+import (
+	"time"
+)
+
+// what a Ticker does with ticks that became due while a callback was running
+type TickerOverrun int
+
+const (
+	// drop the missed ticks and wait for the next tick on the original schedule
+	TickerSkip TickerOverrun = iota
+	// fire every missed tick, back to back, until the ticker catches up
+	TickerCatchUp
+)
+
+// Ticker
+// ticks are anchored to the start time, so long callbacks do not make the
+// schedule drift
+type Ticker struct {
+	t       *Timer
+	d       time.Duration
+	start   time.Time
+	n       int64 // ticks scheduled so far
+	overrun TickerOverrun
+	stopped bool
+}
+
+func (tk *Ticker) Stop() {
+	disp := tk.t.disp
+	disp.mu.Lock()
+	tk.stopped = true
+	disp.stop(tk.t)
+	disp.mu.Unlock()
+}
+
+// schedule the next tick, called after every callback
+func (tk *Ticker) rearm() {
+	disp := tk.t.disp
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+
+	if tk.stopped || tk.t.armed() {
+		return
+	}
+	if tk.overrun == TickerSkip {
+		if elapsed := disp.clock.Now().Sub(tk.start); elapsed >= 0 {
+			tk.n = int64(elapsed/tk.d) + 1
+		}
+	} else {
+		tk.n++
+	}
+	disp.add(tk.t, tk.start.Add(time.Duration(tk.n)*tk.d))
+}
+
+// TickerFunc calls cb every d through ChanTimer, skipping ticks missed by a
+// long callback
+func (disp *Dispatcher) TickerFunc(d time.Duration, cb func()) *Ticker {
+	return disp.TickerFuncOverrun(d, TickerSkip, cb)
+}
+
+func (disp *Dispatcher) TickerFuncOverrun(d time.Duration, overrun TickerOverrun, cb func()) *Ticker {
+	if d <= 0 {
+		panic("non-positive interval for TickerFunc")
+	}
+
+	tk := new(Ticker)
+	tk.d = d
+	tk.overrun = overrun
+	tk.n = 1
+	tk.t = disp.newTimer(nil)
+	tk.t.kind = KindTicker
+	tk.t.onDrop = tk.rearm
+	tk.t.cb = func() {
+		defer tk.rearm()
+		cb()
+	}
+
+	disp.mu.Lock()
+	tk.start = disp.clock.Now()
+	disp.add(tk.t, tk.start.Add(d))
+	disp.mu.Unlock()
+	return tk
+}
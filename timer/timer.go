@@ -1,95 +1,874 @@
 package timer
 
 import (
+	"container/heap"
+	"container/list"
+	"context"
 	"github.com/name5566/leaf/conf"
 	"github.com/name5566/leaf/log"
+	"math/rand"
 	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// one dispatcher per goroutine (goroutine not safe)
+// one dispatcher per goroutine: ChanTimer must be drained by a single
+// goroutine, timers may be armed and stopped from any goroutine
 type Dispatcher struct {
-	ChanTimer chan *Timer
+	ChanTimer chan *Timer // the normal priority lane
+	chanHigh  chan *Timer
+	chanLow   chan *Timer
+
+	clock   Clock
+	mu      sync.Mutex
+	timers  timerHeap    // armed timers, earliest first
+	wheel   *timingWheel // replaces timers if not nil
+	wakeup  ClockTimer   // fires at next, or every tick with a wheel
+	next    time.Time    // zero if wakeup is not armed
+	pending int          // timers whose callback has not run yet
+	closed  bool
+	sending sync.WaitGroup // run and pump goroutines sending to the lanes
+	seq     uint64         // arming order
+
+	store        Store
+	onPersistent func(id string, payload []byte)
+	persisted    map[string]*Timer
+	full         FullPolicy
+	buffers      [3]list.List // FullUnbounded, by lane from low to high
+	pumping      [3]bool
+	crons        map[*Cron]struct{}
+	tags         map[string]map[tagged]struct{}
+	onPanic      func(recovered interface{}, stack []byte)
+	slow         time.Duration // 0 disables onSlow
+	onSlow       func(d time.Duration, name string)
+
+	monotonic  bool
+	maxCatchUp time.Duration
+	rand       *jitterRand // nil for math/rand
+
+	// accessed atomically
+	fired   uint64
+	stopped uint64
+	dropped uint64
+	skipped uint64
+}
+
+type DispatcherOption func(*Dispatcher)
+
+// WithTimingWheel keeps the timers in a hierarchical timing wheel advanced by
+// a single clock timer every tick, instead of a heap and a timer rearmed for
+// the earliest one. Timers fire up to one tick late, Stop is O(1). It suits
+// dispatchers with very many timers, Close must be called to stop ticking.
+func WithTimingWheel(tick time.Duration) DispatcherOption {
+	if tick <= 0 {
+		panic("non-positive tick for WithTimingWheel")
+	}
+	return func(disp *Dispatcher) {
+		disp.wheel = &timingWheel{tick: tick}
+	}
+}
+
+// WithClock makes the dispatcher read the time and wait on clock, such as a
+// FakeClock in tests, instead of RealClock
+func WithClock(clock Clock) DispatcherOption {
+	return func(disp *Dispatcher) {
+		disp.clock = clock
+	}
+}
+
+// WithJitterSource makes AfterFuncJitter draw from src, for reproducible
+// delays in tests
+func WithJitterSource(src rand.Source) DispatcherOption {
+	return func(disp *Dispatcher) {
+		disp.rand = &jitterRand{r: rand.New(src)}
+	}
 }
 
-func NewDispatcher(l int) *Dispatcher {
+// WithMonotonicCron makes crons compute their next firing from the last
+// scheduled one instead of the current time, so steps of the wall clock do
+// not fire them twice. After a backward step a cron waits for the wall clock
+// to pass its last firing. After a forward step the matches stepped over run
+// back to back, subject to the overrun policy, if they are at most maxCatchUp
+// late; older ones are skipped and counted by SkipCount.
+func WithMonotonicCron(maxCatchUp time.Duration) DispatcherOption {
+	return func(disp *Dispatcher) {
+		disp.monotonic = true
+		disp.maxCatchUp = maxCatchUp
+	}
+}
+
+func NewDispatcher(l int, opts ...DispatcherOption) *Dispatcher {
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = RealClock
+	disp.slow = time.Second
+	for _, opt := range opts {
+		opt(disp)
+	}
+	if disp.wheel != nil {
+		disp.wheel.start = disp.clock.Now()
+		disp.wakeup = disp.clock.AfterFunc(disp.wheel.tick, disp.drive)
+	}
 	return disp
 }
 
+// advances the timing wheel every tick
+func (disp *Dispatcher) drive() {
+	disp.run()
+	disp.mu.Lock()
+	if !disp.closed {
+		disp.wakeup.Reset(disp.wheel.tick)
+	}
+	disp.mu.Unlock()
+}
+
+// number of timers armed or fired whose callback has not run yet,
+// stopped timers are not counted
+func (disp *Dispatcher) PendingCount() int {
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+	return disp.pending
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) add(t *Timer, when time.Time) {
+	if disp.closed { // the timer stays stopped
+		return
+	}
+	t.when = when
+	disp.seq++
+	t.seq = disp.seq
+	disp.pending++
+	if t.tag != "" {
+		disp.tagAdd(t.tag, t)
+	}
+	if disp.wheel != nil {
+		disp.wheel.add(t)
+		return
+	}
+	heap.Push(&disp.timers, t)
+	if disp.next.IsZero() || t.when.Before(disp.next) {
+		disp.arm(t.when)
+	}
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) remove(t *Timer) bool {
+	if t.index >= 0 {
+		heap.Remove(&disp.timers, t.index)
+	} else if t.bucket != nil {
+		disp.wheel.remove(t)
+	} else if t.fired {
+		t.fired = false
+	} else {
+		return false
+	}
+	disp.pending--
+	if t.tag != "" {
+		disp.tagRemove(t.tag, t)
+	}
+	return true
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) armed() int {
+	if disp.wheel != nil {
+		return disp.wheel.count
+	}
+	return len(disp.timers)
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) stop(t *Timer) bool {
+	if disp.remove(t) {
+		atomic.AddUint64(&disp.stopped, 1)
+		return true
+	}
+	return false
+}
+
+// the longest the dispatcher sleeps, so that timers set with At notice steps
+// of the wall clock
+const maxWait = time.Minute
+
+// must be called with disp.mu held
+func (disp *Dispatcher) arm(when time.Time) {
+	disp.next = when
+	d := when.Sub(disp.clock.Now())
+	if d > maxWait {
+		d = maxWait
+	}
+	if disp.wakeup == nil {
+		disp.wakeup = disp.clock.AfterFunc(d, disp.run)
+	} else {
+		disp.wakeup.Reset(d)
+	}
+}
+
+// must be called with disp.mu held
+func (disp *Dispatcher) at(t *Timer, when time.Time) {
+	// compared to the wall clock, not the monotonic one
+	disp.add(t, when.Round(0))
+}
+
+// sends due timers to ChanTimer
+func (disp *Dispatcher) run() {
+	disp.mu.Lock()
+	if disp.closed {
+		disp.mu.Unlock()
+		return
+	}
+	now := disp.clock.Now()
+	var due []*Timer
+	if disp.wheel != nil {
+		due = disp.wheel.advance(now)
+		// a tick may hold timers cascaded from several levels
+		sort.SliceStable(due, func(i, j int) bool { return due[i].when.Before(due[j].when) })
+	} else {
+		disp.next = time.Time{}
+		for len(disp.timers) > 0 && !disp.timers[0].when.After(now) {
+			due = append(due, heap.Pop(&disp.timers).(*Timer))
+		}
+		if len(disp.timers) > 0 {
+			disp.arm(disp.timers[0].when)
+		}
+	}
+	for _, t := range due {
+		t.fired = true
+		t.fires++
+		t.queued = now
+	}
+	sortByPriority(due)
+	atomic.AddUint64(&disp.fired, uint64(len(due)))
+	if len(due) == 0 {
+		disp.mu.Unlock()
+		return
+	}
+	disp.sending.Add(1)
+	disp.mu.Unlock()
+
+	disp.send(due)
+	disp.sending.Done()
+}
+
+// Close cancels all armed timers and closes ChanTimer and the priority lanes.
+// Timers already sent to a lane run their callbacks if drain is true and are
+// discarded otherwise, higher lanes first.
+// It must be called by the goroutine draining ChanTimer. Timers created after
+// Close are never armed. Closing twice is a no-op.
+func (disp *Dispatcher) Close(drain bool) {
+	disp.mu.Lock()
+	if disp.closed {
+		disp.mu.Unlock()
+		return
+	}
+	disp.closed = true
+	n := len(disp.timers)
+	for _, t := range disp.timers {
+		t.index = -1
+	}
+	disp.timers = nil
+	if disp.wheel != nil {
+		n = disp.wheel.clear()
+	}
+	disp.pending -= n
+	atomic.AddUint64(&disp.stopped, uint64(n))
+	disp.crons = nil
+	disp.tags = nil
+	if disp.wakeup != nil {
+		disp.wakeup.Stop()
+	}
+	disp.next = time.Time{}
+	disp.mu.Unlock()
+
+	// keep draining until senders blocked on a full lane are done
+	done := make(chan struct{})
+	go func() {
+		disp.sending.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case t := <-disp.chanHigh:
+			disp.flush(t, drain)
+		case t := <-disp.ChanTimer:
+			disp.flush(t, drain)
+		case t := <-disp.chanLow:
+			disp.flush(t, drain)
+		case <-done:
+			for _, p := range []Priority{PriorityHigh, PriorityNormal, PriorityLow} {
+				c := disp.Lane(p)
+				if c == nil {
+					continue
+				}
+				for len(c) > 0 {
+					disp.flush(<-c, drain)
+				}
+				close(c)
+			}
+			for _, t := range disp.takeBuffered() {
+				disp.flush(t, drain)
+			}
+			return
+		}
+	}
+}
+
+func (disp *Dispatcher) flush(t *Timer, drain bool) {
+	if drain {
+		t.Cb()
+		return
+	}
+	disp.mu.Lock()
+	if disp.remove(t) {
+		atomic.AddUint64(&disp.dropped, 1)
+	}
+	disp.mu.Unlock()
+}
+
+// SetPanicHandler sets the function called with the recovered value and the
+// stack when a callback panics, the default logs them. The dispatcher keeps
+// serving other timers, crons and tickers rearm as usual. Goroutine safe.
+func (disp *Dispatcher) SetPanicHandler(h func(recovered interface{}, stack []byte)) {
+	disp.mu.Lock()
+	disp.onPanic = h
+	disp.mu.Unlock()
+}
+
+// SetSlowCallbackHandler makes fn get called with the duration and the name
+// of callbacks running threshold or longer, the default logs callbacks over a
+// second. A threshold of 0 disables it. Goroutine safe.
+func (disp *Dispatcher) SetSlowCallbackHandler(threshold time.Duration, fn func(d time.Duration, name string)) {
+	disp.mu.Lock()
+	disp.slow = threshold
+	disp.onSlow = fn
+	disp.mu.Unlock()
+}
+
+func logSlow(d time.Duration, name string) {
+	if name == "" {
+		name = "unnamed"
+	}
+	log.Release("slow timer callback %v took %v", name, d)
+}
+
+func logPanic(r interface{}, stack []byte) {
+	if conf.LenStackBuf > 0 {
+		log.Error("%v: %s", r, stack)
+	} else {
+		log.Error("%v", r)
+	}
+}
+
 // Timer
+// Stop and Reset are goroutine safe
 type Timer struct {
-	t  *time.Timer
-	cb func()
+	disp   *Dispatcher
+	cb     func()
+	when   time.Time
+	index  int       // position in disp.timers, -1 if not armed
+	fired  bool      // sent to ChanTimer and waits for Cb
+	queued time.Time // when it was last sent to ChanTimer
+	tag    string
+	prio   Priority
+	jitter time.Duration
+	name   string
+	onDrop func() // called when a firing is dropped
+	unbind func() // releases the context registration of AfterFuncCtx
+	seq    uint64
+
+	kind    TimerKind
+	created time.Time
+	labels  map[string]string
+	fires   uint64
+
+	// persistent timers
+	pid     string
+	payload []byte
+
+	// timing wheel
+	bucket     *wheelBucket // nil if not armed
+	prev, next *Timer
+	tick       int64
 }
 
+// must be called with disp.mu held
+func (t *Timer) armed() bool {
+	return t.index >= 0 || t.bucket != nil
+}
+
+// Stop removes the timer from the dispatcher, its callback will not run
 func (t *Timer) Stop() {
-	t.t.Stop()
-	t.cb = nil
+	t.disp.mu.Lock()
+	stopped := t.disp.stop(t)
+	unbind := t.unbind
+	t.disp.mu.Unlock()
+
+	if unbind != nil {
+		unbind()
+	}
+
+	if stopped && t.pid != "" {
+		t.disp.unpersist(t)
+	}
+}
+
+// Reset rearms the timer to fire after d from now, like time.Timer.Reset.
+// It reports whether the timer was active, i.e. it had been armed and its
+// callback had not run yet. A pending firing of the previous arming is dropped.
+func (t *Timer) Reset(d time.Duration) bool {
+	t.disp.mu.Lock()
+	active := t.disp.remove(t)
+	t.disp.after(t, d)
+	t.disp.mu.Unlock()
+
+	if t.pid != "" {
+		t.disp.repersist(t)
+	}
+	return active
 }
 
 func (t *Timer) Cb() {
+	// stopped or rearmed timers may still be in ChanTimer, drop them
+	t.disp.mu.Lock()
+	if !t.fired {
+		t.disp.mu.Unlock()
+		return
+	}
+	t.fired = false
+	t.disp.pending--
+	if t.tag != "" {
+		t.disp.tagRemove(t.tag, t)
+	}
+	cb := t.cb
+	onPanic := t.disp.onPanic
+	slow, onSlow := t.disp.slow, t.disp.onSlow
+	name := t.name
+	t.disp.mu.Unlock()
+
+	var start time.Time
+	if slow > 0 {
+		start = t.disp.clock.Now()
+	}
 	defer func() {
-		t.cb = nil
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
+			n := conf.LenStackBuf
+			if n <= 0 {
+				n = 4096
+			}
+			buf := make([]byte, n)
+			l := runtime.Stack(buf, false)
+			if onPanic == nil {
+				onPanic = logPanic
+			}
+			onPanic(r, buf[:l])
+		}
+		if slow > 0 {
+			if d := t.disp.clock.Now().Sub(start); d >= slow {
+				if onSlow == nil {
+					onSlow = logSlow
+				}
+				onSlow(d, name)
 			}
 		}
 	}()
 
-	if t.cb != nil {
-		t.cb()
+	if cb != nil {
+		cb()
 	}
 }
 
-func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+// must be called with disp.mu held
+func (disp *Dispatcher) after(t *Timer, d time.Duration) {
+	if t.jitter > 0 {
+		d += time.Duration(disp.rand.int63n(int64(t.jitter) + 1))
+	}
+	disp.add(t, disp.clock.Now().Add(d))
+}
+
+func (disp *Dispatcher) newTimer(cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
-		disp.ChanTimer <- t
-	})
+	t.disp = disp
+	t.index = -1
+	t.created = disp.clock.Now()
+	return t
+}
+
+func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+	return disp.AfterFuncTaggedJitter("", d, 0, cb)
+}
+
+// AfterFuncJitter is AfterFunc firing after a random duration between d and
+// d+jitter, drawn again by Reset, so that timers armed together spread out
+func (disp *Dispatcher) AfterFuncJitter(d time.Duration, jitter time.Duration, cb func()) *Timer {
+	return disp.AfterFuncTaggedJitter("", d, jitter, cb)
+}
+
+// AfterFuncTagged is AfterFunc with a tag, StopTag stops all live timers
+// sharing it. An empty tag is no tag.
+func (disp *Dispatcher) AfterFuncTagged(tag string, d time.Duration, cb func()) *Timer {
+	return disp.AfterFuncTaggedJitter(tag, d, 0, cb)
+}
+
+// AfterFuncNamed is AfterFunc with a name for the slow callback handler
+func (disp *Dispatcher) AfterFuncNamed(name string, d time.Duration, cb func()) *Timer {
+	t := disp.newTimer(cb)
+	t.name = name
+	disp.mu.Lock()
+	disp.after(t, d)
+	disp.mu.Unlock()
 	return t
 }
 
+func (disp *Dispatcher) AfterFuncTaggedJitter(tag string, d time.Duration, jitter time.Duration, cb func()) *Timer {
+	t := disp.newTimer(cb)
+	t.tag = tag
+	t.jitter = jitter
+	disp.mu.Lock()
+	disp.after(t, d)
+	disp.mu.Unlock()
+	return t
+}
+
+// At calls cb through ChanTimer at the wall clock instant when, right away if
+// it has passed. Unlike AfterFunc it follows steps of the wall clock, noticed
+// within a minute, except with a timing wheel which counts ticks.
+func (disp *Dispatcher) At(when time.Time, cb func()) *Timer {
+	t := disp.newTimer(cb)
+	disp.mu.Lock()
+	disp.at(t, when)
+	disp.mu.Unlock()
+	return t
+}
+
+// AfterFuncCtx is AfterFunc stopping the timer when ctx is done before it
+// fires. The callback gets ctx and should check ctx.Err(), ctx may be done
+// after the timer fired but before the callback ran. The timer stays bound
+// to ctx until its callback runs, it is stopped or ctx is done, without a
+// goroutine.
+func (disp *Dispatcher) AfterFuncCtx(ctx context.Context, d time.Duration, cb func(ctx context.Context)) *Timer {
+	return disp.AfterFuncCtxJitter(ctx, d, 0, cb)
+}
+
+func (disp *Dispatcher) AfterFuncCtxJitter(ctx context.Context, d time.Duration, jitter time.Duration, cb func(ctx context.Context)) *Timer {
+	t := disp.newTimer(nil)
+	t.jitter = jitter
+	stop := context.AfterFunc(ctx, t.Stop)
+	t.cb = func() {
+		stop()
+		cb(ctx)
+	}
+
+	disp.mu.Lock()
+	t.unbind = func() { stop() }
+	if ctx.Err() == nil {
+		disp.after(t, d)
+	}
+	disp.mu.Unlock()
+	return t
+}
+
+// what a Cron does with firings that become due while its callback runs
+type CronOverrun int
+
+const (
+	// run the missed firings back to back once the callback returns, up to
+	// a backlog, and skip the others
+	CronQueue CronOverrun = iota
+	// skip the missed firings and wait for the next match
+	CronSkip
+	// arm the next firing before the callback runs, whether or not the
+	// previous callback has returned, for callbacks handing work to other
+	// goroutines
+	CronConcurrent
+)
+
 // Cron
+// all methods are goroutine safe
 type Cron struct {
-	t *Timer
+	t        *Timer
+	cronExpr *CronExpr
+	overrun  CronOverrun
+	backlog  int    // CronQueue: most missed firings run in a row
+	late     int    // missed firings run in a row so far
+	runs     uint64 // accessed atomically
+	skips    uint64 // accessed atomically
+	running  bool
+	paused   bool
+	stopped  bool
+	tag      string
 }
 
 func (c *Cron) Stop() {
-	if c.t != nil {
-		c.t.Stop()
+	disp := c.t.disp
+	disp.mu.Lock()
+	c.stop()
+	disp.mu.Unlock()
+}
+
+// must be called with disp.mu held
+func (c *Cron) stop() {
+	disp := c.t.disp
+	c.stopped = true
+	disp.stop(c.t)
+	c.forget()
+}
+
+// must be called with disp.mu held
+func (c *Cron) forget() {
+	disp := c.t.disp
+	delete(disp.crons, c)
+	if c.tag != "" {
+		disp.tagRemove(c.tag, c)
 	}
 }
 
-func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
-	c := new(Cron)
+// NextTime returns when the cron fires next, zero if it is stopped, paused or
+// the expression has no more matches. While a CronQueue or CronSkip callback
+// runs, it is the firing expected once the callback returns.
+func (c *Cron) NextTime() time.Time {
+	disp := c.t.disp
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
 
-	now := time.Now()
-	nextTime := cronExpr.Next(now)
-	if nextTime.IsZero() {
-		return c
+	if c.running && !c.stopped && !c.paused {
+		from := c.t.when
+		if now := disp.clock.Now(); c.overrun == CronSkip && now.After(from) {
+			from = now
+		}
+		return c.cronExpr.Next(from)
 	}
+	if !c.t.armed() && !c.t.fired {
+		return time.Time{}
+	}
+	return c.t.when
+}
+
+// RunCount returns how many times the callback has been called
+func (c *Cron) RunCount() uint64 {
+	return atomic.LoadUint64(&c.runs)
+}
 
-	// callback
-	var cb func()
-	cb = func() {
-		defer _cb()
+// SkipCount returns how many firings the overrun policy skipped
+func (c *Cron) SkipCount() uint64 {
+	return atomic.LoadUint64(&c.skips)
+}
 
-		now := time.Now()
-		nextTime := cronExpr.Next(now)
-		if nextTime.IsZero() {
-			return
+// Pause suspends the cron, firings that become due meanwhile are skipped.
+// Pausing a paused cron is a no-op.
+func (c *Cron) Pause() {
+	disp := c.t.disp
+	disp.mu.Lock()
+	c.pause()
+	disp.mu.Unlock()
+}
+
+// must be called with disp.mu held
+func (c *Cron) pause() {
+	if c.paused || c.stopped {
+		return
+	}
+	c.paused = true
+	c.t.disp.remove(c.t)
+}
+
+// Resume arms a paused cron for the next match after now, the firings missed
+// while paused do not run
+func (c *Cron) Resume() {
+	disp := c.t.disp
+	now := disp.clock.Now()
+	disp.mu.Lock()
+	c.resume(now)
+	disp.mu.Unlock()
+}
+
+// must be called with disp.mu held
+func (c *Cron) resume(now time.Time) {
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	c.late = 0
+	if !c.stopped && !c.running {
+		c.arm(c.cronExpr.Next(now))
+	}
+}
+
+func (c *Cron) Paused() bool {
+	disp := c.t.disp
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+	return c.paused
+}
+
+// must be called with disp.mu held
+func (c *Cron) arm(next time.Time) {
+	if c.stopped || next.IsZero() {
+		c.forget()
+		return
+	}
+	if !c.paused {
+		c.t.disp.at(c.t, next)
+	}
+}
+
+// PauseAll pauses every cron of the dispatcher, see Cron.Pause
+func (disp *Dispatcher) PauseAll() {
+	disp.mu.Lock()
+	for c := range disp.crons {
+		c.pause()
+	}
+	disp.mu.Unlock()
+}
+
+// ResumeAll resumes every paused cron of the dispatcher, see Cron.Resume
+func (disp *Dispatcher) ResumeAll() {
+	now := disp.clock.Now()
+	disp.mu.Lock()
+	for c := range disp.crons {
+		c.resume(now)
+	}
+	disp.mu.Unlock()
+}
+
+// must be called with disp.mu held
+func (c *Cron) skip(n uint64) {
+	if n > 0 {
+		atomic.AddUint64(&c.skips, n)
+		atomic.AddUint64(&c.t.disp.skipped, n)
+	}
+}
+
+// must be called with disp.mu held
+// the match after the last firing, skipping those over maxCatchUp late
+func (c *Cron) following(now time.Time) time.Time {
+	next := c.cronExpr.Next(c.t.when)
+	oldest := now.Add(-c.t.disp.maxCatchUp)
+	var skipped uint64
+	for !next.IsZero() && next.Before(oldest) {
+		skipped++
+		next = c.cronExpr.Next(next)
+	}
+	c.skip(skipped)
+	return next
+}
+
+// arm the timer for the next match after now, or after the last firing in
+// monotonic mode
+func (c *Cron) rearm() {
+	disp := c.t.disp
+	now := disp.clock.Now()
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+
+	if disp.monotonic && !c.t.when.IsZero() {
+		c.arm(c.following(now))
+	} else {
+		c.arm(c.cronExpr.Next(now))
+	}
+}
+
+// arm the timer once the callback returned, following the overrun policy
+func (c *Cron) complete() {
+	disp := c.t.disp
+	now := disp.clock.Now()
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+
+	c.running = false
+	var next time.Time
+	if disp.monotonic {
+		next = c.following(now)
+	} else {
+		next = c.cronExpr.Next(c.t.when)
+	}
+	if c.overrun == CronQueue && !next.IsZero() && !next.After(now) && c.late < c.backlog {
+		c.late++
+	} else {
+		c.late = 0
+		var skipped uint64
+		for !next.IsZero() && !next.After(now) {
+			skipped++
+			next = c.cronExpr.Next(next)
+		}
+		c.skip(skipped)
+	}
+	c.arm(next)
+}
+
+// CronFunc calls cb on every match of cronExpr through ChanTimer, a firing
+// missed by a long callback runs right after it
+func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, cb func()) *Cron {
+	return disp.CronFuncOverrun(cronExpr, CronQueue, 1, cb)
+}
+
+// CronFuncOverrun is CronFunc with an overrun policy, backlog is the most
+// missed firings CronQueue runs in a row and is at least 1
+func (disp *Dispatcher) CronFuncOverrun(cronExpr *CronExpr, overrun CronOverrun, backlog int, _cb func()) *Cron {
+	return disp.cronFunc("", "", cronExpr, overrun, backlog, _cb)
+}
+
+// CronFuncNamed is CronFunc with a name for the slow callback handler
+func (disp *Dispatcher) CronFuncNamed(name string, cronExpr *CronExpr, cb func()) *Cron {
+	return disp.cronFunc(name, "", cronExpr, CronQueue, 1, cb)
+}
+
+// CronFuncInLocation is CronFunc with cronExpr evaluated in loc rather than
+// in the location of the clock, so the schedule does not depend on the TZ of
+// the host
+func (disp *Dispatcher) CronFuncInLocation(cronExpr *CronExpr, loc *time.Location, cb func()) *Cron {
+	return disp.CronFunc(cronExpr.InLocation(loc), cb)
+}
+
+func (disp *Dispatcher) cronFunc(name string, tag string, cronExpr *CronExpr, overrun CronOverrun, backlog int, _cb func()) *Cron {
+	if backlog < 1 {
+		backlog = 1
+	}
+
+	c := new(Cron)
+	c.tag = tag
+	c.cronExpr = cronExpr
+	c.overrun = overrun
+	c.backlog = backlog
+	c.t = disp.newTimer(nil)
+	c.t.kind = KindCron
+	c.t.name = name
+	c.t.onDrop = c.rearm
+
+	if overrun == CronConcurrent {
+		// the next firing is armed before the callback runs
+		c.t.cb = func() {
+			c.rearm()
+			atomic.AddUint64(&c.runs, 1)
+			_cb()
+		}
+	} else {
+		c.t.cb = func() {
+			disp.mu.Lock()
+			c.running = true
+			disp.mu.Unlock()
+			defer c.complete()
+
+			atomic.AddUint64(&c.runs, 1)
+			_cb()
+		}
+	}
+
+	disp.mu.Lock()
+	if !disp.closed {
+		if disp.crons == nil {
+			disp.crons = make(map[*Cron]struct{})
+		}
+		disp.crons[c] = struct{}{}
+		if tag != "" {
+			disp.tagAdd(tag, c)
 		}
-		c.t = disp.AfterFunc(nextTime.Sub(now), cb)
 	}
+	disp.mu.Unlock()
 
-	c.t = disp.AfterFunc(nextTime.Sub(now), cb)
+	c.rearm()
 	return c
 }
@@ -0,0 +1,1451 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//执行分发器中在timeout内到达的所有定时器
+func drain(d *Dispatcher, timeout time.Duration) {
+	for {
+		select {
+		case t := <-d.ChanTimer:
+			t.Cb()
+		case <-time.After(timeout):
+			return
+		}
+	}
+}
+
+func TestTimerReset(t *testing.T) {
+	d := NewDispatcher(10)
+	var n int32
+	cb := func() { atomic.AddInt32(&n, 1) }
+
+	// extend before it fires
+	tm := d.AfterFunc(time.Hour, cb)
+	if !tm.Reset(10 * time.Millisecond) {
+		t.Error("Reset of an armed timer returned false")
+	}
+	drain(d, 100*time.Millisecond)
+	if n != 1 {
+		t.Fatalf("callback ran %v times, want 1", n)
+	}
+
+	// Reset after the callback ran rearms it
+	if tm.Reset(10 * time.Millisecond) {
+		t.Error("Reset after the callback ran returned true")
+	}
+	drain(d, 100*time.Millisecond)
+	if n != 2 {
+		t.Fatalf("callback ran %v times, want 2", n)
+	}
+
+	// fired but not dispatched yet: the queued firing is dropped
+	tm = d.AfterFunc(0, cb)
+	time.Sleep(20 * time.Millisecond)
+	if len(d.ChanTimer) != 1 {
+		t.Fatalf("ChanTimer has %v timers, want 1", len(d.ChanTimer))
+	}
+	if !tm.Reset(30 * time.Millisecond) {
+		t.Error("Reset of a fired but pending timer returned false")
+	}
+	(<-d.ChanTimer).Cb()
+	if n != 2 {
+		t.Fatalf("stale firing ran the callback")
+	}
+	drain(d, 100*time.Millisecond)
+	if n != 3 {
+		t.Fatalf("callback ran %v times, want 3", n)
+	}
+
+	// Reset after Stop rearms it, and reports it inactive
+	tm = d.AfterFunc(time.Hour, cb)
+	tm.Stop()
+	if tm.Reset(10 * time.Millisecond) {
+		t.Error("Reset of a stopped timer returned true")
+	}
+	drain(d, 100*time.Millisecond)
+	if n != 4 {
+		t.Fatalf("callback ran %v times, want 4", n)
+	}
+
+	// Stop after firing drops the pending callback
+	tm = d.AfterFunc(0, cb)
+	time.Sleep(20 * time.Millisecond)
+	tm.Stop()
+	drain(d, 50*time.Millisecond)
+	if n != 4 {
+		t.Fatalf("stopped timer ran the callback")
+	}
+}
+
+func TestTimerResetRace(t *testing.T) {
+	d := NewDispatcher(100)
+	var runs, armings int32
+	tm := d.AfterFunc(time.Millisecond, func() { atomic.AddInt32(&runs, 1) })
+	armings = 1
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case t := <-d.ChanTimer:
+				t.Cb()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				if (g+i)%3 == 0 {
+					tm.Stop()
+				} else {
+					tm.Reset(time.Duration(i%3) * time.Microsecond)
+					atomic.AddInt32(&armings, 1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	tm.Stop()
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+
+	// each arming runs the callback at most once, and nothing runs after Stop
+	before := atomic.LoadInt32(&runs)
+	if before > armings {
+		t.Errorf("callback ran %v times for %v armings", before, armings)
+	}
+	drain(d, 20*time.Millisecond)
+	if runs != before {
+		t.Errorf("callback ran after Stop")
+	}
+}
+
+func TestDispatcherPendingCount(t *testing.T) {
+	d := NewDispatcher(10)
+	timers := make([]*Timer, 1000)
+	for i := range timers {
+		timers[i] = d.AfterFunc(time.Hour, func() {})
+	}
+	for i, tm := range timers {
+		if i%20 != 0 {
+			tm.Stop()
+		}
+	}
+	// cancelled timers are gone from the dispatcher, not just muted
+	if n := d.PendingCount(); n != 50 {
+		t.Errorf("PendingCount = %v, want 50", n)
+	}
+	if n := len(d.timers); n != 50 {
+		t.Errorf("%v timers in the heap, want 50", n)
+	}
+
+	// stopping twice is harmless
+	timers[1].Stop()
+	if n := d.PendingCount(); n != 50 {
+		t.Errorf("PendingCount = %v, want 50", n)
+	}
+
+	// fired timers count until their callback runs
+	var ran bool
+	d.AfterFunc(0, func() { ran = true })
+	time.Sleep(20 * time.Millisecond)
+	if n := d.PendingCount(); n != 51 {
+		t.Errorf("PendingCount = %v, want 51", n)
+	}
+	(<-d.ChanTimer).Cb()
+	if !ran || d.PendingCount() != 50 {
+		t.Errorf("ran = %v, PendingCount = %v", ran, d.PendingCount())
+	}
+
+	for _, tm := range timers {
+		tm.Stop()
+	}
+	if n := d.PendingCount(); n != 0 {
+		t.Errorf("PendingCount = %v, want 0", n)
+	}
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	d := NewDispatcher(10)
+	var order []int
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		d.AfterFunc(time.Duration(i)*10*time.Millisecond, func() { order = append(order, i) })
+	}
+	for len(order) < 3 {
+		(<-d.ChanTimer).Cb()
+	}
+	if order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("order = %v", order)
+	}
+}
+
+// combat timeouts: almost every timer is cancelled before it fires
+func BenchmarkDispatcherCancel(b *testing.B) {
+	d := NewDispatcher(1000)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case t := <-d.ChanTimer:
+				t.Cb()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t := d.AfterFunc(time.Millisecond, func() {})
+		if i%20 != 0 {
+			t.Stop()
+		}
+	}
+	b.StopTimer()
+	close(done)
+}
+
+func TestTickerFunc(t *testing.T) {
+	d := NewDispatcher(10)
+	n := 0
+	var tk *Ticker
+	tk = d.TickerFunc(10*time.Millisecond, func() {
+		n++
+		if n == 3 {
+			tk.Stop()
+		}
+	})
+	drain(d, 100*time.Millisecond)
+	if n != 3 {
+		t.Errorf("ticked %v times, want 3", n)
+	}
+	if d.PendingCount() != 0 {
+		t.Errorf("PendingCount = %v after Stop", d.PendingCount())
+	}
+}
+
+func TestTickerOverrun(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	cases := []struct {
+		overrun TickerOverrun
+		next    time.Duration
+	}{
+		// the first tick runs 70ms, so the tick due at 100ms is missed
+		{TickerSkip, 3 * interval},
+		{TickerCatchUp, 2 * interval},
+	}
+
+	for _, c := range cases {
+		d := NewDispatcher(10)
+		tk := d.TickerFuncOverrun(interval, c.overrun, func() {
+			time.Sleep(70 * time.Millisecond)
+		})
+		if !tk.t.when.Equal(tk.start.Add(interval)) {
+			t.Fatalf("first tick at %v, want %v", tk.t.when.Sub(tk.start), interval)
+		}
+		(<-d.ChanTimer).Cb()
+		// anchored to the start time, whatever the callback duration
+		if got := tk.t.when.Sub(tk.start); got != c.next {
+			t.Errorf("overrun %v: next tick at %v, want %v", c.overrun, got, c.next)
+		}
+		tk.Stop()
+	}
+}
+
+func TestCronHandle(t *testing.T) {
+	d := NewDispatcher(10)
+	c := d.CronFunc(MustNewCronExpr("* * * * * *"), func() {})
+	next := c.NextTime()
+	now := time.Now()
+	if next.Before(now) || next.After(now.Add(time.Second)) || next.Nanosecond() != 0 {
+		t.Errorf("NextTime = %v, now %v", next, now)
+	}
+	if c.RunCount() != 0 {
+		t.Errorf("RunCount = %v before firing", c.RunCount())
+	}
+
+	// readers on other goroutines while the cron rearms
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				c.NextTime()
+				c.RunCount()
+			}
+		}
+	}()
+	(<-d.ChanTimer).Cb()
+	(<-d.ChanTimer).Cb()
+	close(done)
+
+	if c.RunCount() != 2 {
+		t.Errorf("RunCount = %v, want 2", c.RunCount())
+	}
+	if !c.NextTime().After(next.Add(time.Second)) {
+		t.Errorf("NextTime = %v did not advance from %v", c.NextTime(), next)
+	}
+	c.Stop()
+	if !c.NextTime().IsZero() {
+		t.Errorf("NextTime = %v after Stop", c.NextTime())
+	}
+
+	// no more matches
+	e := d.CronFunc(MustNewCronExpr("0 0 0 1 1 * 2000"), func() {})
+	if !e.NextTime().IsZero() {
+		t.Errorf("NextTime = %v for an expired expression", e.NextTime())
+	}
+	e.Stop()
+}
+
+func TestDispatcherClose(t *testing.T) {
+	for _, drain := range []bool{true, false} {
+		d := NewDispatcher(1)
+		var n int32
+		cb := func() { atomic.AddInt32(&n, 1) }
+		// three due timers: one queued, the others wait on the full channel
+		for i := 0; i < 3; i++ {
+			d.AfterFunc(0, cb)
+		}
+		d.AfterFunc(time.Hour, cb)
+		c := d.CronFunc(MustNewCronExpr("@yearly"), cb)
+		time.Sleep(20 * time.Millisecond)
+
+		d.Close(drain)
+		want := int32(0)
+		if drain {
+			want = 3
+		}
+		if n != want {
+			t.Errorf("drain %v: %v callbacks ran, want %v", drain, n, want)
+		}
+		if _, ok := <-d.ChanTimer; ok {
+			t.Errorf("drain %v: ChanTimer is not closed", drain)
+		}
+		if p := d.PendingCount(); p != 0 {
+			t.Errorf("drain %v: PendingCount = %v", drain, p)
+		}
+		if !c.NextTime().IsZero() {
+			t.Errorf("drain %v: cron still armed", drain)
+		}
+
+		// after Close
+		d.Close(drain)
+		tm := d.AfterFunc(0, cb)
+		tm.Reset(0)
+		tm.Stop()
+		if !d.CronFunc(MustNewCronExpr("* * * * * *"), cb).NextTime().IsZero() {
+			t.Errorf("drain %v: cron armed after Close", drain)
+		}
+		d.TickerFunc(time.Millisecond, cb).Stop()
+		time.Sleep(10 * time.Millisecond)
+		if p := d.PendingCount(); p != 0 {
+			t.Errorf("drain %v: PendingCount = %v after Close", drain, p)
+		}
+	}
+}
+
+func TestDispatcherStats(t *testing.T) {
+	d := NewDispatcher(10)
+	for i := 0; i < 10; i++ {
+		tm := d.AfterFunc(time.Hour, func() {})
+		if i < 4 {
+			tm.Stop()
+		}
+	}
+	d.AfterFunc(0, func() {})
+	d.AfterFunc(0, func() {})
+	c := d.CronFunc(MustNewCronExpr("@daily"), func() {})
+	time.Sleep(20 * time.Millisecond)
+
+	s := d.Stats()
+	if s.Pending != 7 || s.Queued != 2 || s.Fired != 2 || s.Stopped != 4 || s.Dropped != 0 {
+		t.Errorf("Stats = %v", s)
+	}
+	if len(s.Crons) != 1 || s.Crons[0].Expr != "0 0 0 * * *" || !s.Crons[0].NextTime.Equal(c.NextTime()) {
+		t.Errorf("Crons = %+v", s.Crons)
+	}
+	if want := "pending 7, queued 2, fired 2, stopped 4, dropped 0, skipped 0, crons 1"; s.String() != want {
+		t.Errorf("String() = %q, want %q", s.String(), want)
+	}
+
+	(<-d.ChanTimer).Cb()
+	c.Stop()
+	d.Close(false)
+	s = d.Stats()
+	if s.Pending != 0 || s.Queued != 0 || s.Stopped != 11 || s.Dropped != 1 || len(s.Crons) != 0 {
+		t.Errorf("after Close: Stats = %v", s)
+	}
+}
+
+func TestTimingWheel(t *testing.T) {
+	d := NewDispatcher(10, WithTimingWheel(time.Millisecond))
+	defer d.Close(false)
+
+	var order []int
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		d.AfterFunc(time.Duration(i)*10*time.Millisecond, func() { order = append(order, i) })
+	}
+	start := time.Now()
+	// beyond the first level, cascaded down before it fires
+	d.AfterFunc(100*time.Millisecond, func() { order = append(order, 4) })
+	stopped := d.AfterFunc(50*time.Millisecond, func() { order = append(order, 0) })
+	stopped.Stop()
+	if n := d.PendingCount(); n != 4 {
+		t.Errorf("PendingCount = %v, want 4", n)
+	}
+
+	for len(order) < 4 {
+		(<-d.ChanTimer).Cb()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("fired after %v, want at least 100ms", elapsed)
+	}
+	if order[0] != 1 || order[1] != 2 || order[2] != 3 || order[3] != 4 {
+		t.Errorf("order = %v", order)
+	}
+	if n := d.PendingCount(); n != 0 {
+		t.Errorf("PendingCount = %v, want 0", n)
+	}
+
+	tm := d.AfterFunc(time.Hour, func() {})
+	if !tm.Reset(time.Millisecond) {
+		t.Error("Reset of an armed timer returned false")
+	}
+	(<-d.ChanTimer).Cb()
+}
+
+func TestTimingWheelLevels(t *testing.T) {
+	w := &timingWheel{tick: time.Millisecond, start: time.Now()}
+	var timers []*Timer
+	// past the last level, waits in its furthest slot
+	far := &Timer{index: -1, when: w.start.Add(time.Duration(1<<31) * time.Millisecond)}
+	w.add(far)
+	if far.bucket != &w.buckets[wheelLevels-1][wheelMask] {
+		t.Error("timer past the last level is not in its furthest slot")
+	}
+	w.remove(far)
+
+	// one timer per level
+	for _, ticks := range []int64{1, 63, 64, 4095, 4096, 1 << 18, 1 << 24, 1<<24 + 1<<18 + 5} {
+		tm := &Timer{index: -1, when: w.start.Add(time.Duration(ticks) * time.Millisecond)}
+		w.add(tm)
+		timers = append(timers, tm)
+	}
+	for _, tm := range timers {
+		due := w.advance(tm.when)
+		if len(due) != 1 || due[0] != tm {
+			t.Fatalf("advance to tick %v: %v timers due", tm.tick, len(due))
+		}
+	}
+	if w.count != 0 {
+		t.Errorf("%v timers left", w.count)
+	}
+}
+
+// 100k armed timers at once, most of them stopped before they fire
+func benchmarkDispatcher100k(b *testing.B, opts ...DispatcherOption) {
+	d := NewDispatcher(1000, opts...)
+	defer d.Close(false)
+
+	timers := make([]*Timer, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range timers {
+			timers[j] = d.AfterFunc(time.Duration(j%1000+1)*time.Millisecond*10, func() {})
+		}
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
+
+func BenchmarkDispatcher100kHeap(b *testing.B) {
+	benchmarkDispatcher100k(b)
+}
+
+func BenchmarkDispatcher100kWheel(b *testing.B) {
+	benchmarkDispatcher100k(b, WithTimingWheel(10*time.Millisecond))
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	d := NewDispatcher(10, WithClock(clock))
+	var fired []string
+	d.AfterFunc(time.Minute, func() { fired = append(fired, "timer") })
+	d.CronFunc(MustNewCronExpr("@daily"), func() { fired = append(fired, "daily") })
+	tk := d.TickerFunc(10*time.Hour, func() { fired = append(fired, "ticker") })
+
+	clock.Advance(59 * time.Second)
+	if len(d.ChanTimer) != 0 {
+		t.Fatalf("%v timers fired early", len(d.ChanTimer))
+	}
+	clock.Advance(time.Second)
+	(<-d.ChanTimer).Cb()
+
+	// 10:00, midnight, 20:00 the next day
+	for i := 0; i < 24; i++ {
+		clock.Advance(time.Hour)
+		for len(d.ChanTimer) > 0 {
+			(<-d.ChanTimer).Cb()
+		}
+	}
+	tk.Stop()
+	want := []string{"timer", "ticker", "daily", "ticker"}
+	if fmt.Sprint(fired) != fmt.Sprint(want) {
+		t.Errorf("fired %v, want %v", fired, want)
+	}
+	if n := d.PendingCount(); n != 1 {
+		t.Errorf("PendingCount = %v, want 1", n)
+	}
+
+	// through the timing wheel
+	w := NewDispatcher(10, WithClock(clock), WithTimingWheel(time.Second))
+	n := 0
+	w.AfterFunc(1500*time.Millisecond, func() { n++ })
+	clock.Advance(time.Second)
+	if len(w.ChanTimer) != 0 {
+		t.Fatal("timer fired early")
+	}
+	clock.Advance(time.Second)
+	(<-w.ChanTimer).Cb()
+	w.Close(false)
+	if n != 1 {
+		t.Errorf("callback ran %v times, want 1", n)
+	}
+}
+
+func TestCronOverrun(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	cases := []struct {
+		overrun CronOverrun
+		backlog int
+		runs    uint64
+		skips   uint64
+	}{
+		// the first callback runs 150 minutes, missing 02:00 and 03:00
+		{CronSkip, 1, 1, 2},
+		{CronQueue, 1, 2, 1},
+		{CronQueue, 5, 3, 0},
+		{CronConcurrent, 1, 2, 0},
+	}
+
+	for _, c := range cases {
+		clock := NewFakeClock(start)
+		d := NewDispatcher(10, WithClock(clock))
+		first := true
+		cron := d.CronFuncOverrun(MustNewCronExpr("0 0 * * * *"), c.overrun, c.backlog, func() {
+			if first {
+				first = false
+				clock.Advance(150 * time.Minute)
+			}
+		})
+		clock.Advance(30 * time.Minute)
+		for len(d.ChanTimer) > 0 {
+			(<-d.ChanTimer).Cb()
+			clock.Advance(0)
+		}
+
+		if cron.RunCount() != c.runs || cron.SkipCount() != c.skips {
+			t.Errorf("overrun %v backlog %v: %v runs and %v skips, want %v and %v",
+				c.overrun, c.backlog, cron.RunCount(), cron.SkipCount(), c.runs, c.skips)
+		}
+		if s := d.Stats(); s.Skipped != c.skips || s.Crons[0].Skipped != c.skips {
+			t.Errorf("overrun %v: Stats = %v", c.overrun, s)
+		}
+		if next := cron.NextTime(); !next.Equal(start.Add(210 * time.Minute)) {
+			t.Errorf("overrun %v: NextTime = %v", c.overrun, next)
+		}
+		cron.Stop()
+	}
+}
+
+func TestStopTag(t *testing.T) {
+	d := NewDispatcher(10)
+	var n int32
+	cb := func() { atomic.AddInt32(&n, 1) }
+	for i := 0; i < 5; i++ {
+		d.AfterFuncTagged("player1", time.Hour, cb)
+	}
+	d.AfterFuncTagged("player1", 0, cb) // fired, waiting in ChanTimer
+	c := d.CronFuncTagged("player1", MustNewCronExpr("@hourly"), cb)
+	d.AfterFuncTagged("player2", time.Hour, cb)
+	time.Sleep(20 * time.Millisecond)
+
+	if got := d.StopTag("player1"); got != 7 {
+		t.Errorf("StopTag = %v, want 7", got)
+	}
+	if !c.NextTime().IsZero() {
+		t.Error("tagged cron still armed")
+	}
+	drain(d, 20*time.Millisecond)
+	if n != 0 {
+		t.Errorf("%v stopped callbacks ran", n)
+	}
+	if got := d.StopTag("player1"); got != 0 {
+		t.Errorf("second StopTag = %v, want 0", got)
+	}
+
+	// timers leave the index when they fire
+	d.AfterFuncTagged("player3", 0, cb)
+	drain(d, 20*time.Millisecond)
+	if n != 1 || len(d.tags) != 1 {
+		t.Errorf("%v callbacks ran, %v tags indexed", n, len(d.tags))
+	}
+	if got := d.StopTag("player2"); got != 1 || len(d.tags) != 0 {
+		t.Errorf("StopTag = %v, %v tags indexed", got, len(d.tags))
+	}
+}
+
+func TestStopTagRace(t *testing.T) {
+	d := NewDispatcher(1)
+	var runs int32
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case t := <-d.ChanTimer:
+				t.Cb()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var stopped int32
+	for i := 0; i < 1000; i++ {
+		d.AfterFuncTagged("race", time.Duration(i%3)*time.Microsecond, func() { atomic.AddInt32(&runs, 1) })
+		if i%10 == 0 {
+			stopped += int32(d.StopTag("race"))
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	stopped += int32(d.StopTag("race"))
+	close(done)
+
+	// every timer either ran once or was stopped
+	if r := atomic.LoadInt32(&runs); r+stopped != 1000 {
+		t.Errorf("%v runs and %v stopped, want 1000 in total", r, stopped)
+	}
+}
+
+func TestAfterFuncCtx(t *testing.T) {
+	d := NewDispatcher(10)
+	var n int32
+	cb := func(ctx context.Context) { atomic.AddInt32(&n, 1) }
+
+	// cancelled before firing
+	ctx, cancel := context.WithCancel(context.Background())
+	d.AfterFuncCtx(ctx, 20*time.Millisecond, cb)
+	cancel()
+	drain(d, 50*time.Millisecond)
+	if n != 0 || d.PendingCount() != 0 {
+		t.Errorf("%v callbacks ran, PendingCount = %v", n, d.PendingCount())
+	}
+
+	// already cancelled
+	d.AfterFuncCtx(ctx, 0, cb)
+	drain(d, 20*time.Millisecond)
+	if n != 0 || d.PendingCount() != 0 {
+		t.Errorf("%v callbacks ran, PendingCount = %v", n, d.PendingCount())
+	}
+
+	// fires, and gets the context
+	ctx, cancel = context.WithCancel(context.WithValue(context.Background(), "k", "v"))
+	var got interface{}
+	d.AfterFuncCtx(ctx, 0, func(ctx context.Context) { got = ctx.Value("k") })
+	(<-d.ChanTimer).Cb()
+	if got != "v" {
+		t.Errorf("callback got value %v", got)
+	}
+
+	// cancelled after firing, before the callback: dropped
+	d.AfterFuncCtx(ctx, 0, cb)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	drain(d, 20*time.Millisecond)
+	if n != 0 {
+		t.Errorf("callback ran after cancel")
+	}
+}
+
+func TestAfterFuncCtxNoLeak(t *testing.T) {
+	d := NewDispatcher(1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		d.AfterFuncCtx(ctx, 0, func(context.Context) {})
+	}
+	for i := 0; i < 1000; i++ {
+		(<-d.ChanTimer).Cb()
+	}
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("%v goroutines, %v before", after, before)
+	}
+}
+
+// a context never done, counting the callbacks registered with context.AfterFunc
+type afterFuncCtx struct {
+	context.Context
+	done       chan struct{}
+	registered int32
+}
+
+func (ctx *afterFuncCtx) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *afterFuncCtx) AfterFunc(f func()) func() bool {
+	atomic.AddInt32(&ctx.registered, 1)
+	var once sync.Once
+	return func() bool {
+		stopped := false
+		once.Do(func() {
+			stopped = true
+			atomic.AddInt32(&ctx.registered, -1)
+		})
+		return stopped
+	}
+}
+
+func TestAfterFuncCtxStop(t *testing.T) {
+	d := NewDispatcher(10)
+	ctx := &afterFuncCtx{Context: context.Background(), done: make(chan struct{})}
+
+	tm := d.AfterFuncCtx(ctx, time.Hour, func(context.Context) {})
+	if n := atomic.LoadInt32(&ctx.registered); n != 1 {
+		t.Fatalf("%v registrations after AfterFuncCtx", n)
+	}
+	tm.Stop()
+	if n := atomic.LoadInt32(&ctx.registered); n != 0 {
+		t.Errorf("%v registrations after Stop", n)
+	}
+
+	d.AfterFuncCtx(ctx, 0, func(context.Context) {})
+	(<-d.ChanTimer).Cb()
+	if n := atomic.LoadInt32(&ctx.registered); n != 0 {
+		t.Errorf("%v registrations after the callback", n)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDispatcher(10, WithClock(clock))
+	var panics []interface{}
+	d.SetPanicHandler(func(r interface{}, stack []byte) {
+		if len(stack) == 0 {
+			t.Error("no stack")
+		}
+		panics = append(panics, r)
+	})
+
+	ran := false
+	d.AfterFunc(time.Second, func() { panic("boom") })
+	d.AfterFunc(2*time.Second, func() { ran = true })
+	clock.Advance(2 * time.Second)
+	(<-d.ChanTimer).Cb()
+	(<-d.ChanTimer).Cb()
+	if !ran || len(panics) != 1 || panics[0] != "boom" {
+		t.Errorf("ran = %v, panics = %v", ran, panics)
+	}
+
+	// crons rearm after a panic, whatever the overrun policy
+	for _, overrun := range []CronOverrun{CronQueue, CronSkip, CronConcurrent} {
+		c := d.CronFuncOverrun(MustNewCronExpr("@hourly"), overrun, 1, func() { panic("cron") })
+		for i := 0; i < 2; i++ {
+			clock.Advance(time.Hour)
+			(<-d.ChanTimer).Cb()
+		}
+		if c.RunCount() != 2 || c.NextTime().IsZero() {
+			t.Errorf("overrun %v: RunCount = %v, NextTime = %v", overrun, c.RunCount(), c.NextTime())
+		}
+		c.Stop()
+	}
+}
+
+func TestMonotonicCron(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	runs := func(d *Dispatcher) {
+		for len(d.ChanTimer) > 0 {
+			(<-d.ChanTimer).Cb()
+		}
+	}
+
+	// the clock steps back 30s between the 01:00 firing and its callback
+	for _, monotonic := range []bool{false, true} {
+		clock := NewFakeClock(start)
+		opts := []DispatcherOption{WithClock(clock)}
+		if monotonic {
+			opts = append(opts, WithMonotonicCron(0))
+		}
+		d := NewDispatcher(10, opts...)
+		c := d.CronFuncOverrun(MustNewCronExpr("@hourly"), CronConcurrent, 1, func() {})
+		clock.Advance(30 * time.Minute)
+		clock.Jump(-30 * time.Second)
+		runs(d)
+		clock.Advance(time.Minute)
+		runs(d)
+
+		want := uint64(1)
+		if !monotonic {
+			want = 2 // 01:00 fired again
+		}
+		if c.RunCount() != want {
+			t.Errorf("monotonic %v: RunCount = %v, want %v", monotonic, c.RunCount(), want)
+		}
+		c.Stop()
+	}
+
+	// the clock steps forward 5h while waiting for 02:00
+	clock := NewFakeClock(start)
+	d := NewDispatcher(10, WithClock(clock), WithMonotonicCron(2*time.Hour))
+	c := d.CronFuncOverrun(MustNewCronExpr("@hourly"), CronConcurrent, 1, func() {})
+	clock.Advance(30 * time.Minute)
+	runs(d)
+	clock.Jump(5 * time.Hour)
+	clock.Advance(time.Hour) // 07:00
+	for len(d.ChanTimer) > 0 {
+		runs(d)
+		clock.Advance(0)
+	}
+	// 01:00, 02:00, then 05:00 through 07:00; 03:00 and 04:00 are too late
+	if c.RunCount() != 5 || c.SkipCount() != 2 {
+		t.Errorf("RunCount = %v, SkipCount = %v", c.RunCount(), c.SkipCount())
+	}
+	if next := c.NextTime(); next.Hour() != 8 {
+		t.Errorf("NextTime = %v", next)
+	}
+}
+
+func TestPriorityLanes(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDispatcher(10, WithClock(clock), WithPriorityLanes(10))
+	var order []string
+	for i := 0; i < 3; i++ {
+		d.AfterFuncPriority(PriorityLow, time.Second, func() { order = append(order, "low") })
+		d.AfterFunc(time.Second, func() { order = append(order, "normal") })
+	}
+	d.AfterFuncPriority(PriorityHigh, 2*time.Second, func() { order = append(order, "high") })
+	clock.Advance(time.Second)
+	if len(d.Lane(PriorityLow)) != 3 || len(d.ChanTimer) != 3 {
+		t.Fatalf("lane lengths %v and %v", len(d.Lane(PriorityLow)), len(d.ChanTimer))
+	}
+
+	// a low timer is received first, but runs after everything above it
+	first := <-d.Lane(PriorityLow)
+	clock.Advance(time.Second)
+	d.Exec(first)
+	want := "[high normal normal normal low]"
+	if fmt.Sprint(order) != want {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	d.Close(true)
+	if len(order) != 7 {
+		t.Errorf("%v callbacks ran after Close", len(order)-5)
+	}
+	for _, p := range []Priority{PriorityHigh, PriorityNormal, PriorityLow} {
+		if _, ok := <-d.Lane(p); ok {
+			t.Errorf("lane %v is not closed", p)
+		}
+	}
+
+	// without lanes everything goes through ChanTimer
+	d = NewDispatcher(10, WithClock(clock))
+	d.AfterFuncPriority(PriorityHigh, 0, func() {})
+	clock.Advance(0)
+	if d.Lane(PriorityHigh) != nil || len(d.ChanTimer) != 1 {
+		t.Errorf("timer not in ChanTimer")
+	}
+	d.Exec(<-d.ChanTimer)
+}
+
+func TestAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	d := NewDispatcher(10, WithClock(clock))
+	var fired []string
+
+	// in the past
+	d.At(now.Add(-time.Hour), func() { fired = append(fired, "past") })
+	clock.Advance(0)
+	if len(d.ChanTimer) != 1 {
+		t.Fatal("a timer in the past did not fire right away")
+	}
+	(<-d.ChanTimer).Cb()
+
+	// in another time zone
+	loc := time.FixedZone("UTC+8", 8*3600)
+	d.At(time.Date(2024, 1, 1, 18, 0, 0, 0, loc), func() { fired = append(fired, "10:00") })
+	tm := d.At(now.Add(3*time.Hour), func() { fired = append(fired, "12:00") })
+
+	// the wall clock steps forward past 10:00
+	clock.Jump(90 * time.Minute)
+	clock.Advance(maxWait)
+	for len(d.ChanTimer) > 0 {
+		(<-d.ChanTimer).Cb()
+	}
+	tm.Stop()
+	if fmt.Sprint(fired) != "[past 10:00]" {
+		t.Errorf("fired %v", fired)
+	}
+}
+
+func TestAfterFuncJitter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	spread := func(seed int64) []time.Duration {
+		clock := NewFakeClock(now)
+		d := NewDispatcher(10, WithClock(clock), WithJitterSource(rand.NewSource(seed)))
+		var delays []time.Duration
+		for i := 0; i < 20; i++ {
+			tm := d.AfterFuncJitter(time.Second, time.Second, func() {})
+			delays = append(delays, tm.when.Sub(now))
+		}
+		tm := d.AfterFuncTaggedJitter("tag", time.Second, time.Second, func() {})
+		tm.Reset(time.Minute)
+		delays = append(delays, tm.when.Sub(now))
+		if d.StopTag("tag") != 1 {
+			t.Error("jittered timer is not tagged")
+		}
+		return delays
+	}
+
+	delays := spread(1)
+	distinct := make(map[time.Duration]bool)
+	for i, delay := range delays[:20] {
+		if delay < time.Second || delay > 2*time.Second {
+			t.Errorf("timer %v fires after %v", i, delay)
+		}
+		distinct[delay] = true
+	}
+	if len(distinct) < 10 {
+		t.Errorf("only %v distinct delays", len(distinct))
+	}
+	// Reset draws a new jitter
+	if last := delays[20]; last < time.Minute || last > time.Minute+time.Second {
+		t.Errorf("reset timer fires after %v", last)
+	}
+	if fmt.Sprint(spread(1)) != fmt.Sprint(delays) {
+		t.Error("the same source gave different delays")
+	}
+}
+
+func TestCronPause(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	d := NewDispatcher(10, WithClock(clock))
+	runs := func() {
+		for len(d.ChanTimer) > 0 {
+			(<-d.ChanTimer).Cb()
+		}
+	}
+	c := d.CronFunc(MustNewCronExpr("@hourly"), func() {})
+	other := d.CronFunc(MustNewCronExpr("0 15 * * * *"), func() {})
+
+	// due but not run yet: the queued firing is dropped too
+	clock.Advance(30 * time.Minute)
+	c.Pause()
+	c.Pause()
+	if !c.Paused() || !c.NextTime().IsZero() {
+		t.Errorf("Paused = %v, NextTime = %v", c.Paused(), c.NextTime())
+	}
+	clock.Advance(3 * time.Hour) // 04:00
+	runs()
+	if c.RunCount() != 0 {
+		t.Errorf("paused cron ran %v times", c.RunCount())
+	}
+
+	// no burst of the missed firings
+	clock.Advance(30 * time.Minute)
+	c.Resume()
+	if c.Paused() || !c.NextTime().Equal(start.Add(270*time.Minute)) {
+		t.Errorf("Paused = %v, NextTime = %v", c.Paused(), c.NextTime())
+	}
+	clock.Advance(30 * time.Minute)
+	runs()
+	if c.RunCount() != 1 {
+		t.Errorf("resumed cron ran %v times, want 1", c.RunCount())
+	}
+
+	d.PauseAll()
+	if s := d.Stats(); !s.Crons[0].Paused || !s.Crons[1].Paused {
+		t.Errorf("Stats = %+v", s.Crons)
+	}
+	runs0 := other.RunCount()
+	clock.Advance(2 * time.Hour)
+	runs()
+	d.ResumeAll()
+	clock.Advance(time.Hour)
+	runs()
+	if c.RunCount() != 2 || other.RunCount() != runs0+1 {
+		t.Errorf("RunCount = %v and %v after ResumeAll", c.RunCount(), other.RunCount())
+	}
+
+	// stopped while paused
+	c.Pause()
+	c.Stop()
+	c.Resume()
+	if !c.NextTime().IsZero() {
+		t.Error("stopped cron armed by Resume")
+	}
+}
+
+func TestSlowCallback(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDispatcher(10, WithClock(clock))
+	var reports []string
+	d.SetSlowCallbackHandler(time.Second, func(d time.Duration, name string) {
+		reports = append(reports, fmt.Sprintf("%v %v", name, d))
+	})
+	work := func(d time.Duration) func() {
+		return func() { clock.Jump(d) }
+	}
+
+	d.AfterFuncNamed("fast", 0, work(999*time.Millisecond))
+	d.AfterFuncNamed("slow", 0, work(2*time.Second))
+	d.AfterFunc(0, func() {
+		clock.Jump(time.Second)
+		panic("slow and panicking")
+	})
+	c := d.CronFuncNamed("cron", MustNewCronExpr("@hourly"), work(time.Minute))
+	clock.Advance(0)
+	for i := 0; i < 3; i++ {
+		(<-d.ChanTimer).Cb()
+	}
+	clock.Advance(time.Hour)
+	(<-d.ChanTimer).Cb()
+	c.Stop()
+
+	if want := "[slow 2s  1s cron 1m0s]"; fmt.Sprint(reports) != want {
+		t.Errorf("reports = %v, want %v", reports, want)
+	}
+
+	d.SetSlowCallbackHandler(0, func(time.Duration, string) { t.Error("disabled handler called") })
+	d.AfterFunc(0, work(time.Hour))
+	clock.Advance(0)
+	(<-d.ChanTimer).Cb()
+}
+
+func TestFullPolicy(t *testing.T) {
+	cases := []struct {
+		policy  FullPolicy
+		ran     string
+		dropped uint64
+	}{
+		{FullBlock, "[0 1 2 3]", 0},
+		{FullDropNewest, "[0 1]", 2},
+		{FullDropOldest, "[2 3]", 2},
+		{FullUnbounded, "[0 1 2 3]", 0},
+	}
+
+	for _, c := range cases {
+		clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		d := NewDispatcher(2, WithClock(clock), WithFullPolicy(c.policy))
+		var ran []int
+		for i := 0; i < 4; i++ {
+			i := i
+			d.AfterFunc(time.Second, func() { ran = append(ran, i) })
+		}
+
+		// the consumer stalls while 4 timers fire into a lane of 2
+		advanced := make(chan struct{})
+		go func() {
+			clock.Advance(time.Second)
+			close(advanced)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		select {
+		case <-advanced:
+			if c.policy == FullBlock {
+				t.Errorf("policy %v: firing did not block", c.policy)
+			}
+		default:
+			if c.policy != FullBlock {
+				t.Errorf("policy %v: firing blocked", c.policy)
+			}
+		}
+		if s := d.Stats(); c.policy == FullUnbounded && s.Buffer != 1 {
+			// one more waits in the pump
+			t.Errorf("policy %v: %v buffered", c.policy, s.Buffer)
+		}
+		drain(d, 20*time.Millisecond)
+		<-advanced
+
+		if fmt.Sprint(ran) != c.ran {
+			t.Errorf("policy %v: ran %v, want %v", c.policy, ran, c.ran)
+		}
+		s := d.Stats()
+		if s.Dropped != c.dropped || s.Queued != 0 || s.Buffer != 0 {
+			t.Errorf("policy %v: Stats = %v", c.policy, s)
+		}
+		if c.policy == FullBlock {
+			continue
+		}
+
+		// a cron whose firing is dropped keeps its schedule
+		d.AfterFunc(0, func() {})
+		d.AfterFunc(0, func() {})
+		cron := d.CronFunc(MustNewCronExpr("* * * * * *"), func() {})
+		tk := d.TickerFunc(time.Second, func() {})
+		clock.Advance(time.Second)
+		drain(d, 20*time.Millisecond)
+		clock.Advance(time.Second)
+		drain(d, 20*time.Millisecond)
+		if cron.NextTime().IsZero() || tk.t.when.IsZero() || (c.policy != FullUnbounded && cron.RunCount() == 0) {
+			t.Errorf("policy %v: cron ran %v times, next at %v", c.policy, cron.RunCount(), cron.NextTime())
+		}
+		cron.Stop()
+		tk.Stop()
+		d.Close(false)
+	}
+}
+
+type memStore struct {
+	mu      sync.Mutex
+	entries map[string]StoredTimer
+}
+
+func (s *memStore) Save(id string, fireAt time.Time, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]StoredTimer)
+	}
+	s.entries[id] = StoredTimer{id, fireAt, payload}
+	return nil
+}
+
+func (s *memStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *memStore) LoadAll() ([]StoredTimer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []StoredTimer
+	for _, e := range s.entries {
+		all = append(all, e)
+	}
+	return all, nil
+}
+
+func TestPersistentTimers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	store := new(memStore)
+	fired := make(map[string]string)
+	handler := func(id string, payload []byte) { fired[id] = string(payload) }
+
+	d := NewDispatcher(10, WithClock(clock))
+	if _, err := d.AfterFuncPersistent("x", now, nil); err == nil {
+		t.Error("no error without a store")
+	}
+	if err := d.Restore(store, handler); err != nil {
+		t.Fatal(err)
+	}
+	d.AfterFuncPersistent("upgrade", now.Add(72*time.Hour), []byte("barracks"))
+	d.AfterFuncPersistent("done", now.Add(time.Hour), []byte("a"))
+	stopped, _ := d.AfterFuncPersistent("stopped", now.Add(time.Hour), nil)
+	d.AfterFuncPersistent("replaced", now.Add(time.Hour), []byte("old"))
+	d.AfterFuncPersistent("replaced", now.Add(2*time.Hour), []byte("new"))
+	stopped.Stop()
+	clock.Advance(time.Hour)
+	(<-d.ChanTimer).Cb()
+	if fired["done"] != "a" || len(fired) != 1 {
+		t.Errorf("fired %v", fired)
+	}
+	if len(store.entries) != 2 || store.entries["replaced"].FireAt != now.Add(2*time.Hour) {
+		t.Errorf("store %v", store.entries)
+	}
+
+	// restart: the upgrade is still pending, the replaced timer is past due
+	d.Close(false)
+	clock.Advance(3 * time.Hour)
+	d = NewDispatcher(10, WithClock(clock))
+	d.Restore(store, handler)
+	d.Restore(store, handler)
+	clock.Advance(0)
+	drain(d, 20*time.Millisecond)
+	if fired["replaced"] != "new" || d.PendingCount() != 1 {
+		t.Errorf("fired %v, PendingCount = %v", fired, d.PendingCount())
+	}
+	clock.Advance(72 * time.Hour)
+	(<-d.ChanTimer).Cb()
+	if fired["upgrade"] != "barracks" || len(store.entries) != 0 {
+		t.Errorf("fired %v, store %v", fired, store.entries)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	d := NewDispatcher(10, WithClock(clock))
+	step := func() {
+		clock.Advance(0)
+		for i := 0; i < 100 && len(d.ChanTimer) == 0; i++ {
+			clock.Advance(time.Second / 2)
+		}
+		(<-d.ChanTimer).Cb()
+	}
+	opts := RetryOptions{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+		Multiplier:   2,
+		MaxAttempts:  10,
+	}
+
+	// fails 4 times
+	var at []time.Duration
+	var result error = errors.New("not done")
+	r := d.Retry(opts, func() error {
+		at = append(at, clock.Now().Sub(start))
+		if len(at) < 5 {
+			return errors.New("fail")
+		}
+		return nil
+	}, func(err error) { result = err })
+	for i := 0; i < 5; i++ {
+		step()
+	}
+	if want := "[0s 1s 3s 7s 12s]"; fmt.Sprint(at) != want {
+		t.Errorf("attempts at %v, want %v", at, want)
+	}
+	if result != nil || r.Attempts() != 5 || d.PendingCount() != 0 {
+		t.Errorf("result %v after %v attempts, PendingCount = %v", result, r.Attempts(), d.PendingCount())
+	}
+
+	// exhausted
+	opts.MaxAttempts = 3
+	n := 0
+	d.Retry(opts, func() error {
+		n++
+		return fmt.Errorf("fail %v", n)
+	}, func(err error) { result = err })
+	for i := 0; i < 3; i++ {
+		step()
+	}
+	if result == nil || result.Error() != "fail 3" || d.PendingCount() != 0 {
+		t.Errorf("result %v, PendingCount = %v", result, d.PendingCount())
+	}
+
+	// stopped between attempts
+	n = 0
+	r = d.Retry(opts, func() error {
+		n++
+		return errors.New("fail")
+	}, func(error) { t.Error("done called after Stop") })
+	step()
+	r.Stop()
+	clock.Advance(time.Minute)
+	if n != 1 || len(d.ChanTimer) != 0 || d.PendingCount() != 0 {
+		t.Errorf("%v attempts, PendingCount = %v", n, d.PendingCount())
+	}
+}
+
+func TestAfterFuncBatch(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	for _, opts := range [][]DispatcherOption{nil, {WithTimingWheel(time.Millisecond)}} {
+		d := NewDispatcher(10, append(opts, WithClock(clock))...)
+		var order []int
+		entries := make([]TimerEntry, 5)
+		for i, delay := range []int{3, 1, 4, 2, 5} {
+			delay := delay
+			entries[i] = TimerEntry{time.Duration(delay) * time.Second, func() { order = append(order, delay) }}
+		}
+		timers := d.AfterFuncBatch(entries)
+		if len(timers) != 5 || d.PendingCount() != 5 {
+			t.Fatalf("%v timers, PendingCount = %v", len(timers), d.PendingCount())
+		}
+		timers[2].Stop() // the one of 4s
+
+		for i := 0; i < 6; i++ {
+			clock.Advance(time.Second)
+			for len(d.ChanTimer) > 0 {
+				(<-d.ChanTimer).Cb()
+			}
+		}
+		if fmt.Sprint(order) != "[1 2 3 5]" {
+			t.Errorf("order = %v", order)
+		}
+		d.Close(false)
+	}
+}
+
+// respawn timers of a map loaded at once
+func benchmarkRespawn(b *testing.B, batch bool, opts ...DispatcherOption) {
+	d := NewDispatcher(10, opts...)
+	defer d.Close(false)
+
+	entries := make([]TimerEntry, 50000)
+	for i := range entries {
+		entries[i] = TimerEntry{time.Duration(i%600+60) * time.Second, func() {}}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var timers []*Timer
+		if batch {
+			timers = d.AfterFuncBatch(entries)
+		} else {
+			timers = make([]*Timer, len(entries))
+			for j, e := range entries {
+				timers[j] = d.AfterFunc(e.Delay, e.Cb)
+			}
+		}
+		b.StopTimer()
+		for _, t := range timers {
+			t.Stop()
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkRespawnLoop(b *testing.B) {
+	benchmarkRespawn(b, false)
+}
+
+func BenchmarkRespawnBatch(b *testing.B) {
+	benchmarkRespawn(b, true)
+}
+
+func BenchmarkRespawnLoopWheel(b *testing.B) {
+	benchmarkRespawn(b, false, WithTimingWheel(10*time.Millisecond))
+}
+
+func BenchmarkRespawnBatchWheel(b *testing.B) {
+	benchmarkRespawn(b, true, WithTimingWheel(10*time.Millisecond))
+}
+
+func TestDump(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	d := NewDispatcher(10, WithClock(clock))
+
+	a := d.AfterFuncNamed("save", 2*time.Minute, func() {})
+	a.SetLabels(map[string]string{"player": "42"})
+	d.AfterFunc(time.Minute, func() {})
+	expr, err := NewCronExpr("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.CronFuncNamed("reset", expr, func() {})
+
+	clock.Advance(time.Hour)
+	drain(d, time.Second)
+
+	infos := d.Dump()
+	if len(infos) != 1 {
+		t.Fatalf("got %v entries, want 1", len(infos))
+	}
+	if info := infos[0]; info.Name != "reset" || info.Kind != KindCron || info.Fires != 1 ||
+		!info.Created.Equal(start) || !info.NextTime.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("unexpected %v", info)
+	}
+
+	b := d.AfterFuncNamed("save", time.Minute, func() {})
+	b.SetLabels(map[string]string{"player": "7"})
+	infos = d.Dump()
+	if len(infos) != 2 || infos[0].Kind != KindAfter || infos[0].Labels["player"] != "7" {
+		t.Fatalf("unexpected %v", infos)
+	}
+	if s := infos[0].String(); s != "after save created 2024-01-01T01:00:00Z next 2024-01-01T01:01:00Z fired 0 player=7" {
+		t.Errorf("unexpected %q", s)
+	}
+
+	c.Stop()
+	b.Stop()
+	if infos := d.Dump(); len(infos) != 0 {
+		t.Errorf("unexpected %v", infos)
+	}
+}
+
+func TestDumpWheel(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDispatcher(10, WithClock(clock), WithTimingWheel(10*time.Millisecond))
+	defer d.Close(false)
+
+	d.AfterFuncNamed("b", 2*time.Second, func() {})
+	d.AfterFuncNamed("a", time.Second, func() {})
+	d.TickerFunc(time.Hour, func() {})
+	infos := d.Dump()
+	if len(infos) != 3 || infos[0].Name != "a" || infos[1].Name != "b" || infos[2].Kind != KindTicker {
+		t.Errorf("unexpected %v", infos)
+	}
+}
+
+func TestCronFuncInLocation(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	newYork := time.FixedZone("EST", -5*3600)
+	expr, err := NewCronExpr("0 0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the host zone is the clock's, make it neither of the two
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("X", 3*3600))
+	fire := func(loc *time.Location) time.Time {
+		clock := NewFakeClock(start)
+		d := NewDispatcher(10, WithClock(clock))
+		defer d.Close(false)
+		var fired time.Time
+		d.CronFuncInLocation(expr, loc, func() { fired = clock.Now() })
+		// the fake clock sends from Advance, on the hour
+		for i := 0; i < 48 && fired.IsZero(); i++ {
+			clock.Advance(time.Hour)
+			select {
+			case t := <-d.ChanTimer:
+				t.Cb()
+			default:
+			}
+		}
+		return fired.UTC()
+	}
+
+	a := fire(shanghai)
+	b := fire(newYork)
+	if !a.Equal(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)) || !b.Equal(time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("fired at %v and %v", a, b)
+	}
+	if b.Sub(a) != 13*time.Hour {
+		t.Errorf("got %v apart, want the 13h zone offset", b.Sub(a))
+	}
+}
+
+func TestWaited(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDispatcher(10, WithClock(clock))
+	d.AfterFunc(time.Second, func() {})
+	clock.Advance(time.Second)
+	clock.Advance(3 * time.Second) // not consumed meanwhile
+	tm := <-d.ChanTimer
+	if w := tm.Waited(); w != 3*time.Second {
+		t.Errorf("Waited = %v, want 3s", w)
+	}
+	tm.Cb()
+}
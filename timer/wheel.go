@@ -0,0 +1,147 @@
+package timer
+
+import (
+	"time"
+)
+
+// hierarchical timing wheel, 5 levels of 64 slots, about 124 days at a 10ms
+// tick. Later timers wait in the last level and are cascaded again.
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 5
+)
+
+type wheelBucket struct {
+	head *Timer
+	tail *Timer
+}
+
+func (b *wheelBucket) push(t *Timer) {
+	t.bucket = b
+	t.prev = b.tail
+	t.next = nil
+	if b.tail != nil {
+		b.tail.next = t
+	} else {
+		b.head = t
+	}
+	b.tail = t
+}
+
+func (b *wheelBucket) unlink(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		b.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	} else {
+		b.tail = t.prev
+	}
+	t.bucket = nil
+	t.prev = nil
+	t.next = nil
+}
+
+// take all timers out of the bucket
+func (b *wheelBucket) take() *Timer {
+	head := b.head
+	b.head = nil
+	b.tail = nil
+	return head
+}
+
+// all methods must be called with disp.mu held
+type timingWheel struct {
+	tick    time.Duration
+	start   time.Time
+	cur     int64 // ticks processed since start
+	count   int
+	buckets [wheelLevels][wheelSize]wheelBucket
+}
+
+// timers fire at the first tick not before when, never in the current tick
+func (w *timingWheel) add(t *Timer) {
+	e := int64((t.when.Sub(w.start) + w.tick - 1) / w.tick)
+	if e <= w.cur {
+		e = w.cur + 1
+	}
+	t.tick = e
+	w.place(t)
+	w.count++
+}
+
+func (w *timingWheel) place(t *Timer) {
+	delta := t.tick - w.cur
+	for l := uint(0); l < wheelLevels; l++ {
+		if delta < 1<<(wheelBits*(l+1)) {
+			w.buckets[l][(t.tick>>(wheelBits*l))&wheelMask].push(t)
+			return
+		}
+	}
+	// beyond the last level, wait in its furthest slot
+	l := uint(wheelLevels - 1)
+	w.buckets[l][((w.cur>>(wheelBits*l))+wheelMask)&wheelMask].push(t)
+}
+
+// O(1)
+func (w *timingWheel) remove(t *Timer) {
+	t.bucket.unlink(t)
+	w.count--
+}
+
+// advance to now and return due timers in firing order
+func (w *timingWheel) advance(now time.Time) (due []*Timer) {
+	target := int64(now.Sub(w.start) / w.tick)
+	for w.cur < target {
+		if w.count == 0 {
+			w.cur = target
+			break
+		}
+		w.cur++
+
+		// move timers of the slots just entered down to lower levels
+		for l := uint(1); l < wheelLevels; l++ {
+			if w.cur&(1<<(wheelBits*l)-1) != 0 {
+				break
+			}
+			t := w.buckets[l][(w.cur>>(wheelBits*l))&wheelMask].take()
+			for t != nil {
+				next := t.next
+				t.bucket, t.prev, t.next = nil, nil, nil
+				w.place(t)
+				t = next
+			}
+		}
+
+		t := w.buckets[0][w.cur&wheelMask].take()
+		for t != nil {
+			next := t.next
+			t.bucket, t.prev, t.next = nil, nil, nil
+			w.count--
+			due = append(due, t)
+			t = next
+		}
+	}
+	return
+}
+
+// remove every timer, returns how many there were
+func (w *timingWheel) clear() int {
+	n := w.count
+	for l := range w.buckets {
+		for s := range w.buckets[l] {
+			t := w.buckets[l][s].take()
+			for t != nil {
+				next := t.next
+				t.bucket, t.prev, t.next = nil, nil, nil
+				t = next
+			}
+		}
+	}
+	w.count = 0
+	return n
+}